@@ -0,0 +1,192 @@
+package cycletls
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alt-Svc (RFC 7838) support used by Options.EnableAltSvc. Like Chrome, the
+// first request to an origin goes out over TCP; once the origin advertises
+// h3 via Alt-Svc, later requests switch to QUIC until the advertisement
+// expires. A failed QUIC attempt falls back to TCP and marks the alternative
+// broken for a while so we don't keep paying for the failed handshake.
+
+const (
+	// defaultAltSvcMaxAge is the freshness lifetime when ma is omitted (RFC 7838 section 3.1)
+	defaultAltSvcMaxAge = 24 * time.Hour
+	// altSvcBrokenDelay is how long a failed alternative is skipped, doubled on every repeat failure
+	altSvcBrokenDelay = 5 * time.Minute
+	// maxAltSvcBrokenDelay caps the exponential backoff for broken alternatives
+	maxAltSvcBrokenDelay = 48 * time.Hour
+)
+
+// altSvcEntry is a cached h3 alternative for an origin
+type altSvcEntry struct {
+	Port        string
+	Expires     time.Time
+	BrokenUntil time.Time
+	Failures    int
+}
+
+// altSvcCache stores h3 alternatives per origin ("host:port")
+type altSvcCache struct {
+	mu      sync.Mutex
+	entries map[string]*altSvcEntry
+}
+
+// Global Alt-Svc cache shared by all clients, like the client pool
+var globalAltSvcCache = newAltSvcCache()
+
+func newAltSvcCache() *altSvcCache {
+	return &altSvcCache{entries: make(map[string]*altSvcEntry)}
+}
+
+// lookup returns the usable h3 alternative for origin, if any
+func (c *altSvcCache) lookup(origin string, now time.Time) (altSvcEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[origin]
+	if !ok {
+		return altSvcEntry{}, false
+	}
+	if now.After(entry.Expires) {
+		delete(c.entries, origin)
+		return altSvcEntry{}, false
+	}
+	if now.Before(entry.BrokenUntil) {
+		return altSvcEntry{}, false
+	}
+	return *entry, true
+}
+
+// update records the Alt-Svc header values received from origin
+func (c *altSvcCache) update(origin string, values []string, now time.Time) {
+	if len(values) == 0 {
+		return
+	}
+	_, originPort, err := net.SplitHostPort(origin)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, value := range values {
+		if strings.TrimSpace(value) == "clear" {
+			delete(c.entries, origin)
+			return
+		}
+		for _, alt := range parseAltSvc(value) {
+			// Only h3 on the origin's own host and port is used, since the
+			// HTTP/3 transport always dials the request authority
+			if alt.Protocol != "h3" || alt.Host != "" || alt.Port != originPort {
+				continue
+			}
+			c.addLocked(origin, alt.Port, now.Add(alt.MaxAge))
+			return
+		}
+	}
+}
+
+// advertise records an h3 alternative learned outside of an Alt-Svc header,
+// such as from an HTTPS DNS record
+func (c *altSvcCache) advertise(origin string, expires time.Time) {
+	_, port, err := net.SplitHostPort(origin)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Don't shorten a longer lived Alt-Svc advertisement
+	if entry, ok := c.entries[origin]; ok && entry.Expires.After(expires) {
+		return
+	}
+	c.addLocked(origin, port, expires)
+}
+
+func (c *altSvcCache) addLocked(origin, port string, expires time.Time) {
+	entry, ok := c.entries[origin]
+	if !ok {
+		entry = &altSvcEntry{}
+		c.entries[origin] = entry
+	}
+	entry.Port = port
+	entry.Expires = expires
+}
+
+// markBroken records a failed attempt to use the alternative for origin
+func (c *altSvcCache) markBroken(origin string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[origin]
+	if !ok {
+		return
+	}
+	delay := altSvcBrokenDelay << entry.Failures
+	if delay <= 0 || delay > maxAltSvcBrokenDelay {
+		delay = maxAltSvcBrokenDelay
+	}
+	entry.Failures++
+	entry.BrokenUntil = now.Add(delay)
+}
+
+// markWorking resets the failure backoff after a successful attempt
+func (c *altSvcCache) markWorking(origin string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[origin]; ok {
+		entry.Failures = 0
+		entry.BrokenUntil = time.Time{}
+	}
+}
+
+// altSvcAlternative is a single alternative from an Alt-Svc header
+type altSvcAlternative struct {
+	Protocol string
+	Host     string
+	Port     string
+	MaxAge   time.Duration
+}
+
+// parseAltSvc parses an Alt-Svc header value such as
+// `h3=":443"; ma=86400, h3-29=":443"; ma=86400`
+func parseAltSvc(value string) []altSvcAlternative {
+	var alts []altSvcAlternative
+	for _, item := range strings.Split(value, ",") {
+		params := strings.Split(item, ";")
+		protocol, authority, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !ok {
+			continue
+		}
+		host, port, err := net.SplitHostPort(strings.Trim(authority, `"`))
+		if err != nil {
+			continue
+		}
+
+		alt := altSvcAlternative{
+			Protocol: strings.TrimSpace(protocol),
+			Host:     host,
+			Port:     port,
+			MaxAge:   defaultAltSvcMaxAge,
+		}
+		for _, param := range params[1:] {
+			key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "ma") {
+				if seconds, err := strconv.Atoi(strings.Trim(val, `"`)); err == nil && seconds >= 0 {
+					alt.MaxAge = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+		alts = append(alts, alt)
+	}
+	return alts
+}
@@ -0,0 +1,57 @@
+package cycletls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAltSvc(t *testing.T) {
+	alts := parseAltSvc(`h3=":443"; ma=3600, h3-29="alt.example.com:8443", h2=":443"; persist=1`)
+	if len(alts) != 3 {
+		t.Fatalf("expected 3 alternatives, got %d", len(alts))
+	}
+	if alts[0].Protocol != "h3" || alts[0].Host != "" || alts[0].Port != "443" || alts[0].MaxAge != time.Hour {
+		t.Errorf("unexpected first alternative: %+v", alts[0])
+	}
+	if alts[1].Host != "alt.example.com" || alts[1].Port != "8443" || alts[1].MaxAge != defaultAltSvcMaxAge {
+		t.Errorf("unexpected second alternative: %+v", alts[1])
+	}
+}
+
+func TestAltSvcCache(t *testing.T) {
+	cache := newAltSvcCache()
+	now := time.Now()
+	origin := "example.com:443"
+
+	// Alternatives on another port are not usable by the HTTP/3 transport
+	cache.update(origin, []string{`h3=":8443"`}, now)
+	if _, ok := cache.lookup(origin, now); ok {
+		t.Fatal("expected alternative on a different port to be ignored")
+	}
+
+	cache.update(origin, []string{`h3=":443"; ma=60`}, now)
+	if _, ok := cache.lookup(origin, now); !ok {
+		t.Fatal("expected h3 alternative to be cached")
+	}
+	if _, ok := cache.lookup(origin, now.Add(2*time.Minute)); ok {
+		t.Error("expected alternative to expire after max-age")
+	}
+
+	cache.update(origin, []string{`h3=":443"`}, now)
+	cache.markBroken(origin, now)
+	if _, ok := cache.lookup(origin, now.Add(time.Minute)); ok {
+		t.Error("expected broken alternative to be skipped")
+	}
+	if _, ok := cache.lookup(origin, now.Add(altSvcBrokenDelay+time.Second)); !ok {
+		t.Error("expected alternative to be retried after the broken delay")
+	}
+	cache.markBroken(origin, now)
+	if _, ok := cache.lookup(origin, now.Add(altSvcBrokenDelay+time.Second)); ok {
+		t.Error("expected broken delay to double on repeat failure")
+	}
+
+	cache.update(origin, []string{"clear"}, now)
+	if _, ok := cache.lookup(origin, now.Add(time.Hour*100)); ok {
+		t.Error("expected clear to remove the alternative")
+	}
+}
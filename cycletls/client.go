@@ -3,6 +3,7 @@ package cycletls
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	fhttp "github.com/Danny-Dasilva/fhttp"
 	"sync"
@@ -36,12 +37,26 @@ var (
 type Browser struct {
 	// TLS fingerprinting options
 	JA3              string
-	JA4r             string // JA4 raw format with explicit cipher/extension values
+	JA4r             string   // JA4 raw format with explicit cipher/extension values
+	ClientHello      string   // Hex-encoded captured ClientHello, takes precedence over JA3/JA4r
+	TLSSpec          *TLSSpec // Full ClientHello description, takes precedence over JA3/JA4r
 	HTTP2Fingerprint string
+	HTTP3Fingerprint string // SETTINGS and pseudo-header order for HTTP/3
 	QUICFingerprint  string
 	USpec            *uquic.QUICSpec // UQuic QUIC specification for HTTP3 fingerprinting
 	DisableGrease    bool
 
+	// Without a USpec or QUICFingerprint, dial HTTP/3 with the QUIC spec
+	// QUICSpecForUserAgent picks, instead of quic-go's ClientHello
+	QUICFromUserAgent bool
+
+	// ClientHello shaping applied to JA3/JA4r specs
+	RandomizeExtensionOrder bool              // Shuffle extensions per connection like Chrome 110+
+	ExtensionOrderSeed      int64             // Fixed seed for a deterministic order, 0 for random
+	ExtensionData           map[string]string // Hex payloads by extension ID, for unknown or overridden extensions
+	StrictExtensions        bool              // Fail on unknown extension IDs instead of sending them empty
+	GreaseMode              string            // "auto", "on" or "off"; overrides the user agent based GREASE decision
+
 	// Browser identification
 	UserAgent string
 
@@ -51,6 +66,11 @@ type Browser struct {
 	InsecureSkipVerify bool
 	ForceHTTP1         bool
 	ForceHTTP3         bool
+	IPFamily           string // "auto" (default), "ipv4" or "ipv6"
+	LocalAddr          string // Local IP address to bind outgoing connections to
+	EnableAltSvc       bool   // Switch to HTTP/3 once the origin advertises it via Alt-Svc
+	EnableHTTPSRR      bool   // Consult HTTPS DNS records for ALPN, port, address hints and ECH
+	DNSServer          string // DNS server for lookups, system resolver when empty
 
 	// TLS 1.3 specific options
 	TLS13AutoRetry bool
@@ -101,6 +121,8 @@ func clientBuilder(browser Browser, dialer proxy.ContextDialer, timeout int, dis
 	//if disableRedirect is set to true httpclient will not redirect
 	if disableRedirect {
 		client.CheckRedirect = disabledRedirect
+	} else {
+		client.CheckRedirect = checkRedirect
 	}
 	return client
 }
@@ -164,12 +186,29 @@ func generateClientKey(browser Browser, timeout int, disableRedirect bool, proxy
 		cookieStr += fmt.Sprintf("|cookie:%s=%s", cookie.Name, cookie.Value)
 	}
 
+	tlsSpecStr := ""
+	if browser.TLSSpec != nil {
+		if data, err := json.Marshal(browser.TLSSpec); err == nil {
+			tlsSpecStr = string(data)
+		}
+	}
+
 	// Create a hash of the configuration that affects connection behavior
-	configStr := fmt.Sprintf("ja3:%s|ja4r:%s|http2:%s|quic:%s|ua:%s|sni:%s|proxy:%s|timeout:%d|redirect:%t|skipverify:%t|forcehttp1:%t|forcehttp3:%t%s",
+	configStr := fmt.Sprintf("ja3:%s|ja4r:%s|clienthello:%s|tlsspec:%s|extshuffle:%t:%d|extdata:%v|strictext:%t|grease:%s:%t|http2:%s|http3:%s|quic:%s:%t|ua:%s|sni:%s|proxy:%s|timeout:%d|redirect:%t|skipverify:%t|forcehttp1:%t|forcehttp3:%t|ipfamily:%s|localaddr:%s|altsvc:%t|httpsrr:%t|dns:%s%s",
 		browser.JA3,
 		browser.JA4r,
+		browser.ClientHello,
+		tlsSpecStr,
+		browser.RandomizeExtensionOrder,
+		browser.ExtensionOrderSeed,
+		browser.ExtensionData,
+		browser.StrictExtensions,
+		browser.GreaseMode,
+		browser.DisableGrease,
 		browser.HTTP2Fingerprint,
+		browser.HTTP3Fingerprint,
 		browser.QUICFingerprint,
+		browser.QUICFromUserAgent,
 		browser.UserAgent,
 		browser.ServerName,
 		proxyURL,
@@ -178,6 +217,11 @@ func generateClientKey(browser Browser, timeout int, disableRedirect bool, proxy
 		browser.InsecureSkipVerify,
 		browser.ForceHTTP1,
 		browser.ForceHTTP3,
+		browser.IPFamily,
+		browser.LocalAddr,
+		browser.EnableAltSvc,
+		browser.EnableHTTPSRR,
+		browser.DNSServer,
 		cookieStr,
 	)
 
@@ -242,8 +286,11 @@ func getOrCreateClient(browser Browser, timeout int, disableRedirect bool, userA
 func createNewClient(browser Browser, timeout int, disableRedirect bool, userAgent string, proxyURL ...string) (fhttp.Client, error) {
 	var dialer proxy.ContextDialer
 	if len(proxyURL) > 0 && len(proxyURL[0]) > 0 {
+		// HTTPS records describe origins, and the proxy is dialed as given
+		proxyBrowser := browser
+		proxyBrowser.EnableHTTPSRR = false
 		var err error
-		dialer, err = newConnectDialer(proxyURL[0], userAgent)
+		dialer, err = newConnectDialer(proxyURL[0], userAgent, newDirectDialer(proxyBrowser))
 		if err != nil {
 			return fhttp.Client{
 				Timeout:       time.Duration(timeout) * time.Second,
@@ -251,7 +298,7 @@ func createNewClient(browser Browser, timeout int, disableRedirect bool, userAge
 			}, err
 		}
 	} else {
-		dialer = proxy.Direct
+		dialer = newDirectDialer(browser)
 	}
 
 	return clientBuilder(browser, dialer, timeout, disableRedirect), nil
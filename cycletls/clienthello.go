@@ -0,0 +1,446 @@
+package cycletls
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// Raw ClientHello import. JA3 and JA4r strings are lossy, so specs built from
+// them fall back to genMap defaults for signature algorithms, key shares, ALPN
+// and extension payloads. A captured ClientHello carries all of those, so a
+// spec built from one reproduces the browser byte for byte (apart from the
+// per-connection random, session ID and key share values).
+
+const (
+	tlsRecordTypeHandshake       = 0x16
+	tlsHandshakeTypeClientHello  = 0x01
+	tlsRecordHeaderLen           = 5
+	tlsHandshakeHeaderLen        = 4
+	maxClientHelloHandshakeBytes = 1 << 16
+)
+
+var errNoClientHello = errors.New("no TLS ClientHello found")
+
+// SpecFromClientHelloBytes parses a captured ClientHello into a ClientHelloSpec.
+// raw may be one or more TLS records (as seen on the wire) or the bare
+// handshake message. Extensions uTLS does not know are kept as-is.
+func SpecFromClientHelloBytes(raw []byte) (*utls.ClientHelloSpec, error) {
+	record, err := clientHelloRecord(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprinter := &utls.Fingerprinter{AllowBluntMimicry: true}
+	spec, err := fingerprinter.FingerprintClientHello(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ClientHello: %w", err)
+	}
+	spec.GetSessionID = sha256.Sum256
+	return spec, nil
+}
+
+// ClientHelloHexToSpec parses a hex-encoded ClientHello (Options.ClientHello)
+// into a ClientHelloSpec, optionally restricting ALPN to HTTP/1.1.
+// Whitespace and colons in the hex string are ignored.
+func ClientHelloHexToSpec(clientHello string, forceHTTP1 bool) (*utls.ClientHelloSpec, error) {
+	raw, err := hex.DecodeString(strings.NewReplacer(" ", "", "\n", "", "\r", "", "\t", "", ":", "").Replace(clientHello))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ClientHello hex: %w", err)
+	}
+	spec, err := SpecFromClientHelloBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// force http1
+	if forceHTTP1 {
+		forceHTTP1ALPN(spec)
+	}
+	return spec, nil
+}
+
+// forceHTTP1ALPN restricts the ALPN extension of spec to HTTP/1.1
+func forceHTTP1ALPN(spec *utls.ClientHelloSpec) {
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = []string{"http/1.1"}
+		}
+	}
+}
+
+// SpecFromPcap reads a pcap or pcapng capture and returns the spec of the
+// first TLS ClientHello sent over TCP
+func SpecFromPcap(path string) (*utls.ClientHelloSpec, error) {
+	raw, err := ClientHelloFromPcap(path)
+	if err != nil {
+		return nil, err
+	}
+	return SpecFromClientHelloBytes(raw)
+}
+
+// ClientHelloFromPcap returns the raw bytes of the first TLS ClientHello
+// sent over TCP in a pcap or pcapng capture. TCP segments are reassembled, so
+// large ClientHellos (such as Chrome's with a post-quantum key share) that
+// span several packets are returned whole. The result can be hex-encoded
+// and passed as Options.ClientHello.
+func ClientHelloFromPcap(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	packets, err := readCapture(data)
+	if err != nil {
+		return nil, err
+	}
+
+	streams := make(map[tcpFlow]*tcpStream)
+	var order []tcpFlow
+	for _, packet := range packets {
+		flow, seq, payload, ok := parseTCPPacket(packet.linkType, packet.data)
+		if !ok || len(payload) == 0 {
+			continue
+		}
+		stream, ok := streams[flow]
+		if !ok {
+			stream = &tcpStream{segments: make(map[uint32][]byte)}
+			streams[flow] = stream
+			order = append(order, flow)
+		}
+		if !stream.started && isClientHelloStart(payload) {
+			stream.started = true
+			stream.start = seq
+		}
+		if _, dup := stream.segments[seq]; !dup {
+			stream.segments[seq] = payload
+		}
+	}
+
+	for _, flow := range order {
+		stream := streams[flow]
+		if !stream.started {
+			continue
+		}
+		if raw, err := clientHelloRecord(stream.assemble()); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, errNoClientHello
+}
+
+// clientHelloRecord normalizes a captured ClientHello into a single TLS
+// record, joining handshake fragments split across several records
+func clientHelloRecord(raw []byte) ([]byte, error) {
+	var handshake []byte
+	switch {
+	case len(raw) > 0 && raw[0] == tlsHandshakeTypeClientHello:
+		handshake = raw
+	case len(raw) >= tlsRecordHeaderLen && raw[0] == tlsRecordTypeHandshake:
+		for rest := raw; len(rest) >= tlsRecordHeaderLen && rest[0] == tlsRecordTypeHandshake; {
+			length := int(binary.BigEndian.Uint16(rest[3:5]))
+			if len(rest) < tlsRecordHeaderLen+length {
+				return nil, io.ErrUnexpectedEOF
+			}
+			handshake = append(handshake, rest[tlsRecordHeaderLen:tlsRecordHeaderLen+length]...)
+			rest = rest[tlsRecordHeaderLen+length:]
+			if handshakeComplete(handshake) {
+				break
+			}
+		}
+	default:
+		return nil, errNoClientHello
+	}
+
+	if len(handshake) < tlsHandshakeHeaderLen || handshake[0] != tlsHandshakeTypeClientHello {
+		return nil, errNoClientHello
+	}
+	if !handshakeComplete(handshake) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+	handshake = handshake[:tlsHandshakeHeaderLen+length]
+	if len(handshake) > maxClientHelloHandshakeBytes-1 {
+		return nil, errors.New("ClientHello too large")
+	}
+
+	record := make([]byte, tlsRecordHeaderLen, tlsRecordHeaderLen+len(handshake))
+	record[0] = tlsRecordTypeHandshake
+	record[1], record[2] = 0x03, 0x01
+	binary.BigEndian.PutUint16(record[3:], uint16(len(handshake)))
+	return append(record, handshake...), nil
+}
+
+func handshakeComplete(handshake []byte) bool {
+	if len(handshake) < tlsHandshakeHeaderLen {
+		return false
+	}
+	length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+	return len(handshake) >= tlsHandshakeHeaderLen+length
+}
+
+func isClientHelloStart(payload []byte) bool {
+	return len(payload) > tlsRecordHeaderLen &&
+		payload[0] == tlsRecordTypeHandshake &&
+		payload[1] == 0x03 &&
+		payload[tlsRecordHeaderLen] == tlsHandshakeTypeClientHello
+}
+
+// tcpFlow identifies one direction of a TCP connection
+type tcpFlow struct {
+	src, dst         string
+	srcPort, dstPort uint16
+}
+
+// tcpStream collects the segments of one direction of a TCP connection
+type tcpStream struct {
+	segments map[uint32][]byte
+	started  bool
+	start    uint32
+}
+
+// assemble returns the contiguous bytes following the ClientHello start
+func (s *tcpStream) assemble() []byte {
+	seqs := make([]uint32, 0, len(s.segments))
+	for seq := range s.segments {
+		// Offsets relative to the start handle sequence number wraparound
+		if seq-s.start < 1<<31 {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i]-s.start < seqs[j]-s.start })
+
+	var out []byte
+	next := s.start
+	for _, seq := range seqs {
+		segment := s.segments[seq]
+		offset := seq - next
+		if offset >= 1<<31 {
+			// Retransmission overlapping data we already have
+			overlap := next - seq
+			if int(overlap) >= len(segment) {
+				continue
+			}
+			segment = segment[overlap:]
+		} else if offset > 0 {
+			break // gap in the capture
+		}
+		out = append(out, segment...)
+		next += uint32(len(segment))
+	}
+	return out
+}
+
+// capturedPacket is a single packet read from a capture file
+type capturedPacket struct {
+	linkType uint32
+	data     []byte
+}
+
+// Link types understood by parseTCPPacket
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRawBSD   = 12
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+	linkTypeLoop     = 108
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+// readCapture splits a pcap or pcapng file into packets
+func readCapture(data []byte) ([]capturedPacket, error) {
+	if len(data) < 4 {
+		return nil, errors.New("capture file too short")
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		return readPcap(data, binary.LittleEndian)
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		return readPcap(data, binary.BigEndian)
+	case 0x0a0d0d0a:
+		return readPcapng(data)
+	}
+	return nil, errors.New("unrecognized capture format, expected pcap or pcapng")
+}
+
+func readPcap(data []byte, order binary.ByteOrder) ([]capturedPacket, error) {
+	if len(data) < 24 {
+		return nil, errors.New("truncated pcap header")
+	}
+	linkType := order.Uint32(data[20:24]) & 0x0fffffff
+	var packets []capturedPacket
+	for off := 24; off+16 <= len(data); {
+		capLen := int(order.Uint32(data[off+8:]))
+		off += 16
+		if off+capLen > len(data) {
+			break // truncated final packet
+		}
+		packets = append(packets, capturedPacket{linkType: linkType, data: data[off : off+capLen]})
+		off += capLen
+	}
+	return packets, nil
+}
+
+func readPcapng(data []byte) ([]capturedPacket, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	var linkTypes []uint32
+	var packets []capturedPacket
+	for off := 0; off+12 <= len(data); {
+		blockType := order.Uint32(data[off:])
+		if blockType == 0x0a0d0d0a {
+			// Section header block, the byte order magic decides endianness
+			if binary.LittleEndian.Uint32(data[off+8:]) == 0x1a2b3c4d {
+				order = binary.LittleEndian
+			} else {
+				order = binary.BigEndian
+			}
+			linkTypes = nil
+		}
+		blockLen := int(order.Uint32(data[off+4:]))
+		if blockLen < 12 || off+blockLen > len(data) {
+			break
+		}
+		body := data[off+8 : off+blockLen-4]
+
+		switch blockType {
+		case 0x00000001: // Interface description block
+			if len(body) >= 2 {
+				linkTypes = append(linkTypes, uint32(order.Uint16(body)))
+			}
+		case 0x00000006: // Enhanced packet block
+			if len(body) >= 20 {
+				iface := int(order.Uint32(body))
+				capLen := int(order.Uint32(body[12:]))
+				if iface < len(linkTypes) && 20+capLen <= len(body) {
+					packets = append(packets, capturedPacket{linkType: linkTypes[iface], data: body[20 : 20+capLen]})
+				}
+			}
+		case 0x00000003: // Simple packet block, always interface 0
+			if len(body) >= 4 && len(linkTypes) > 0 {
+				packets = append(packets, capturedPacket{linkType: linkTypes[0], data: body[4:]})
+			}
+		}
+		off += blockLen
+	}
+	return packets, nil
+}
+
+// parseTCPPacket extracts the flow, sequence number and payload of a TCP packet
+func parseTCPPacket(linkType uint32, data []byte) (flow tcpFlow, seq uint32, payload []byte, ok bool) {
+	var etherType uint16
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return
+		}
+		etherType = binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		// Skip 802.1Q / 802.1ad VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return
+		}
+		etherType = binary.BigEndian.Uint16(data[14:])
+		data = data[16:]
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			return
+		}
+		etherType = binary.BigEndian.Uint16(data)
+		data = data[20:]
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return
+		}
+		data = data[4:]
+		etherType = ipEtherType(data)
+	case linkTypeRaw, linkTypeRawBSD, linkTypeIPv4, linkTypeIPv6:
+		etherType = ipEtherType(data)
+	default:
+		return
+	}
+
+	var segment []byte
+	switch etherType {
+	case 0x0800: // IPv4
+		if len(data) < 20 {
+			return
+		}
+		headerLen := int(data[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(data[2:]))
+		if data[9] != 6 || headerLen < 20 || totalLen < headerLen || len(data) < headerLen {
+			return
+		}
+		if totalLen > len(data) {
+			totalLen = len(data)
+		}
+		flow.src = fmt.Sprintf("%d.%d.%d.%d", data[12], data[13], data[14], data[15])
+		flow.dst = fmt.Sprintf("%d.%d.%d.%d", data[16], data[17], data[18], data[19])
+		segment = data[headerLen:totalLen]
+	case 0x86dd: // IPv6
+		if len(data) < 40 {
+			return
+		}
+		next := data[6]
+		payloadLen := int(binary.BigEndian.Uint16(data[4:]))
+		flow.src = hex.EncodeToString(data[8:24])
+		flow.dst = hex.EncodeToString(data[24:40])
+		segment = data[40:]
+		if payloadLen < len(segment) {
+			segment = segment[:payloadLen]
+		}
+		// Skip hop-by-hop, routing and destination options headers
+		for (next == 0 || next == 43 || next == 60) && len(segment) >= 8 {
+			extLen := (int(segment[1]) + 1) * 8
+			if extLen > len(segment) {
+				return
+			}
+			next = segment[0]
+			segment = segment[extLen:]
+		}
+		if next != 6 {
+			return
+		}
+	default:
+		return
+	}
+
+	if len(segment) < 20 {
+		return
+	}
+	dataOffset := int(segment[12]>>4) * 4
+	if dataOffset < 20 || dataOffset > len(segment) {
+		return
+	}
+	flow.srcPort = binary.BigEndian.Uint16(segment)
+	flow.dstPort = binary.BigEndian.Uint16(segment[2:])
+	return flow, binary.BigEndian.Uint32(segment[4:]), segment[dataOffset:], true
+}
+
+// ipEtherType infers the EtherType of a raw IP packet from its version nibble
+func ipEtherType(data []byte) uint16 {
+	if len(data) == 0 {
+		return 0
+	}
+	switch data[0] >> 4 {
+	case 4:
+		return 0x0800
+	case 6:
+		return 0x86dd
+	}
+	return 0
+}
@@ -0,0 +1,208 @@
+package cycletls
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	http "github.com/Danny-Dasilva/fhttp"
+)
+
+// User-Agent client hints (https://wicg.github.io/ua-client-hints/). Like
+// Chromium, CycleTLS sends the low entropy hints on every request to a
+// secure origin. The high entropy ones are only sent to origins that asked
+// for them with Accept-CH, which Options.Session remembers across requests.
+
+// lowEntropyClientHints are sent without being asked for
+var lowEntropyClientHints = []string{"sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform"}
+
+// minClientHintsVersion is the first Chromium version to send client hints
+// by default
+const minClientHintsVersion = 89
+
+// sendsClientHints reports whether ua's browser sends client hints
+func (ua UserAgent) sendsClientHints() bool {
+	return ua.Chromium && majorVersion(ua.ChromiumVersion) >= minClientHintsVersion
+}
+
+// clientHint returns ua's value for the client hint header name
+func (ua UserAgent) clientHint(name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case "sec-ch-ua":
+		return ua.brandList(false), true
+	case "sec-ch-ua-full-version-list":
+		return ua.brandList(true), true
+	case "sec-ch-ua-full-version":
+		return strconv.Quote(ua.BrandVersion), true
+	case "sec-ch-ua-mobile":
+		return structuredBoolean(ua.Mobile), true
+	case "sec-ch-ua-platform":
+		return strconv.Quote(ua.Platform), true
+	case "sec-ch-ua-platform-version":
+		return strconv.Quote(ua.PlatformVersion), true
+	case "sec-ch-ua-arch":
+		return strconv.Quote(ua.Arch), true
+	case "sec-ch-ua-bitness":
+		return strconv.Quote(ua.Bitness), true
+	case "sec-ch-ua-model":
+		return strconv.Quote(ua.Model), true
+	case "sec-ch-ua-wow64":
+		return structuredBoolean(ua.WOW64), true
+	}
+	return "", false
+}
+
+// brandList builds the sec-ch-ua brand list the way Chromium does, with the
+// GREASE brand and the order both picked by the major version. full lists
+// full versions, as sec-ch-ua-full-version-list does.
+func (ua UserAgent) brandList(full bool) string {
+	seed := majorVersion(ua.ChromiumVersion)
+	greaseChars := []string{" ", "(", ":", "-", ".", "/", ")", ";", "=", "?", "_"}
+	greaseVersions := []string{"8", "99", "24"}
+	orders := [][3]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+
+	version := func(v string) string {
+		if full {
+			return fullVersion(v)
+		}
+		return strconv.Itoa(majorVersion(v))
+	}
+	greaseVersion := greaseVersions[seed%len(greaseVersions)]
+	if full {
+		greaseVersion += ".0.0.0"
+	}
+	brands := [3]string{
+		fmt.Sprintf(`"Not%sA%sBrand";v="%s"`, greaseChars[seed%len(greaseChars)], greaseChars[(seed+1)%len(greaseChars)], greaseVersion),
+		fmt.Sprintf(`"Chromium";v="%s"`, version(ua.ChromiumVersion)),
+		fmt.Sprintf(`"%s";v="%s"`, ua.Brand, version(ua.BrandVersion)),
+	}
+	var list [3]string
+	for i, position := range orders[seed%len(orders)] {
+		list[position] = brands[i]
+	}
+	return strings.Join(list[:], ", ")
+}
+
+func structuredBoolean(b bool) string {
+	if b {
+		return "?1"
+	}
+	return "?0"
+}
+
+// applyClientHints adds the client hints options' user agent sends to
+// options.URL to options.Headers. Hints the caller set are kept.
+func applyClientHints(options *Options) {
+	ua := parseUserAgent(options.UserAgent)
+	if !ua.sendsClientHints() {
+		return
+	}
+	u, err := url.Parse(options.URL)
+	if err != nil || !potentiallyTrustworthy(u) {
+		return
+	}
+
+	names := append([]string{}, lowEntropyClientHints...)
+	names = append(names, globalClientHintCache.lookup(options.Session, clientHintOrigin(u))...)
+	var hints [][2]string
+	for _, name := range names {
+		if value, ok := ua.clientHint(name); ok {
+			hints = append(hints, [2]string{name, value})
+		}
+	}
+	addDefaultHeaders(options, hints)
+}
+
+// recordAcceptCH remembers the client hints resp's origin asks for in
+// options.Session
+func recordAcceptCH(options Options, resp *http.Response) {
+	if options.Session == "" || resp == nil || resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+	if !potentiallyTrustworthy(resp.Request.URL) {
+		return
+	}
+	globalClientHintCache.update(options.Session, clientHintOrigin(resp.Request.URL), resp.Header.Values("Accept-CH"))
+}
+
+// potentiallyTrustworthy reports whether browsers treat u as a secure
+// context, the only origins they send client hints to
+func potentiallyTrustworthy(u *url.URL) bool {
+	switch strings.ToLower(u.Scheme) {
+	case "https", "wss":
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func clientHintOrigin(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// clientHintCache stores the client hints origins asked for, per session
+type clientHintCache struct {
+	mu       sync.Mutex
+	sessions map[string]map[string][]string // session, then origin, to hint names
+}
+
+// Global client hint cache shared by all clients, like the Alt-Svc cache
+var globalClientHintCache = newClientHintCache()
+
+func newClientHintCache() *clientHintCache {
+	return &clientHintCache{sessions: make(map[string]map[string][]string)}
+}
+
+// lookup returns the hints origin asked for in session
+func (c *clientHintCache) lookup(session, origin string) []string {
+	if session == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessions[session][origin]
+}
+
+// update records the Accept-CH header values received from origin. Like in
+// browsers, they replace what the origin asked for before, and an empty
+// Accept-CH forgets it; responses without one change nothing.
+func (c *clientHintCache) update(session, origin string, values []string) {
+	if session == "" || values == nil {
+		return
+	}
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	origins, ok := c.sessions[session]
+	if !ok {
+		origins = make(map[string][]string)
+		c.sessions[session] = origins
+	}
+	if len(names) == 0 {
+		delete(origins, origin)
+		return
+	}
+	origins[origin] = names
+}
+
+// clear forgets every session
+func (c *clientHintCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions = make(map[string]map[string][]string)
+}
@@ -0,0 +1,400 @@
+package cycletls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IP family preferences accepted by Options.IPFamily
+const (
+	IPFamilyAuto = "auto"
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+// Happy Eyeballs v2 (RFC 8305) timings, using the values recommended by the RFC
+const (
+	// resolutionDelay is how long to wait for AAAA records once A records have arrived
+	resolutionDelay = 50 * time.Millisecond
+	// connectionAttemptDelay is how long an attempt may run before the next one is started
+	connectionAttemptDelay = 250 * time.Millisecond
+)
+
+// happyEyeballsDialer is the direct dialer used when no proxy is configured.
+// It resolves A and AAAA records concurrently and races connection attempts
+// across the returned addresses the way browsers do, instead of trying each
+// address in turn and waiting out a full timeout on a broken IPv6 route.
+type happyEyeballsDialer struct {
+	// IPFamily restricts dials to "ipv4" or "ipv6"; empty or "auto" races both
+	IPFamily string
+	// LocalAddr is the local IP address outgoing connections are bound to
+	LocalAddr string
+	// Resolver is used for name lookups, net.DefaultResolver when nil
+	Resolver *net.Resolver
+	// AttemptDelay overrides connectionAttemptDelay when non-zero
+	AttemptDelay time.Duration
+	// HTTPSRR, when set, supplies the port and address hints of HTTPS records
+	HTTPSRR *httpsRRResolver
+}
+
+// newDirectDialer creates the direct dialer for the given browser configuration
+func newDirectDialer(browser Browser) *happyEyeballsDialer {
+	dialer := &happyEyeballsDialer{
+		IPFamily:  browser.IPFamily,
+		LocalAddr: browser.LocalAddr,
+		Resolver:  newDNSResolver(browser.DNSServer),
+	}
+	if browser.EnableHTTPSRR {
+		dialer.HTTPSRR = getHTTPSRRResolver(browser.DNSServer)
+	}
+	return dialer
+}
+
+// Dial connects to the address on the named network
+func (d *happyEyeballsDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the address on the named network using the provided context
+func (d *happyEyeballsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	localIP, err := parseLocalAddr(d.LocalAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	port, hints := d.httpsEndpoint(ctx, host, port)
+	var resolved time.Time
+	batches := timeResolve(d.resolve(ctx, host, localIP, hints), func() { resolved = time.Now() })
+	conn, err := raceConnect(ctx, batches, d.attemptDelay(), func(ctx context.Context, ip net.IP) (net.Conn, error) {
+		dialer := &net.Dialer{}
+		if localIP != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: localIP}
+		}
+		return dialer.DialContext(ctx, tcpNetwork(network, ip), net.JoinHostPort(ip.String(), port))
+	}, func(conn net.Conn) {
+		_ = conn.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A connection means a batch was received, so resolved is set
+	timings := timingsFromContext(ctx)
+	timings.add(phaseDNS, resolved.Sub(start))
+	timings.add(phaseConnect, time.Since(resolved))
+	return conn, nil
+}
+
+// httpsEndpoint applies the port and address hints from the host's HTTPS
+// record. Records only describe the https scheme, so other ports are left alone.
+func (d *happyEyeballsDialer) httpsEndpoint(ctx context.Context, host, port string) (string, []net.IP) {
+	if d.HTTPSRR == nil || port != "443" {
+		return port, nil
+	}
+	record := d.HTTPSRR.lookup(ctx, host)
+	if record == nil {
+		return port, nil
+	}
+	if record.Port != 0 {
+		port = strconv.Itoa(int(record.Port))
+	}
+	return port, record.Hints()
+}
+
+func (d *happyEyeballsDialer) attemptDelay() time.Duration {
+	if d.AttemptDelay > 0 {
+		return d.AttemptDelay
+	}
+	return connectionAttemptDelay
+}
+
+// resolve looks up host and streams batches of candidate addresses on the
+// returned channel, which is closed once every lookup has finished. AAAA
+// results are delivered as soon as they arrive; A results are held back for
+// resolutionDelay so IPv6 gets a head start when both families are usable.
+// Address hints from an HTTPS record are tried before any lookup completes.
+func (d *happyEyeballsDialer) resolve(ctx context.Context, host string, localIP net.IP, hints []net.IP) <-chan addrBatch {
+	out := make(chan addrBatch, 3)
+	wantV4, wantV6, err := allowedFamilies(d.IPFamily, localIP)
+	if err != nil {
+		out <- addrBatch{err: err}
+		close(out)
+		return out
+	}
+
+	var usableHints []net.IP
+	for _, ip := range hints {
+		if (ip.To4() != nil && wantV4) || (ip.To4() == nil && wantV6) {
+			usableHints = append(usableHints, ip)
+		}
+	}
+	if len(usableHints) > 0 {
+		out <- addrBatch{ips: usableHints}
+	}
+
+	// Literal addresses skip resolution entirely
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		if (ip.To4() != nil && !wantV4) || (ip.To4() == nil && !wantV6) {
+			out <- addrBatch{err: fmt.Errorf("address %s does not match IP family %q", ip, d.IPFamily)}
+		} else {
+			out <- addrBatch{ips: []net.IP{ip}}
+		}
+		close(out)
+		return out
+	}
+
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	lookups := 0
+	results := make(chan addrBatch, 2)
+	lookup := func(network string) {
+		lookups++
+		go func() {
+			ips, err := resolver.LookupIP(ctx, network, host)
+			results <- addrBatch{ips: ips, err: err, v6: network == "ip6"}
+		}()
+	}
+	if wantV6 {
+		lookup("ip6")
+	}
+	if wantV4 {
+		lookup("ip4")
+	}
+
+	go func() {
+		defer close(out)
+		var held *addrBatch
+		var delay <-chan time.Time
+		v6Done := !wantV6
+		for lookups > 0 || held != nil {
+			select {
+			case res := <-results:
+				lookups--
+				if res.v6 {
+					v6Done = true
+					out <- res
+					if held != nil {
+						out <- *held
+						held, delay = nil, nil
+					}
+				} else if v6Done || len(res.ips) == 0 {
+					out <- res
+				} else {
+					held = &res
+					delay = time.After(resolutionDelay)
+				}
+			case <-delay:
+				out <- *held
+				held, delay = nil, nil
+			}
+		}
+	}()
+	return out
+}
+
+// addrBatch is one set of lookup results fed into raceConnect
+type addrBatch struct {
+	ips []net.IP
+	err error
+	v6  bool
+}
+
+// raceConnect runs the connection racing half of Happy Eyeballs v2. Addresses
+// are attempted in interleaved family order, starting a new attempt whenever
+// the previous one fails or has been running for delay. The first attempt to
+// succeed wins; every other attempt is cancelled and any late winners are
+// passed to discard. Addresses that arrive after the delay has already run
+// out are attempted straight away rather than waiting on the current attempt.
+func raceConnect[C any](ctx context.Context, batches <-chan addrBatch, delay time.Duration, dial func(context.Context, net.IP) (C, error), discard func(C)) (C, error) {
+	var zero C
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn C
+		err  error
+	}
+	results := make(chan result)
+	var queue []net.IP
+	var errs []error
+	seen := make(map[string]bool)
+	inflight := 0
+	// elapsed records that the delay ran out with nothing left to start
+	elapsed := false
+
+	timer := time.NewTimer(delay)
+	timer.Stop()
+	defer timer.Stop()
+
+	start := func() {
+		ip := queue[0]
+		queue = queue[1:]
+		inflight++
+		elapsed = false
+		go func() {
+			conn, err := dial(ctx, ip)
+			results <- result{conn, err}
+		}()
+		timer.Reset(delay)
+	}
+
+	// Once we return, hand any attempts still in flight to discard
+	drain := func(n int) {
+		go func() {
+			for ; n > 0; n-- {
+				if res := <-results; res.err == nil {
+					discard(res.conn)
+				}
+			}
+		}()
+	}
+
+	for {
+		if inflight == 0 && len(queue) > 0 {
+			start()
+		}
+		if inflight == 0 && len(queue) == 0 && batches == nil {
+			if len(errs) == 0 {
+				return zero, errors.New("no addresses to dial")
+			}
+			return zero, errors.Join(errs...)
+		}
+
+		select {
+		case batch, ok := <-batches:
+			if !ok {
+				batches = nil
+				continue
+			}
+			if batch.err != nil {
+				errs = append(errs, batch.err)
+			}
+			queue = interleaveAddrs(queue, batch.ips, seen)
+			if elapsed && len(queue) > 0 {
+				start()
+			}
+		case res := <-results:
+			inflight--
+			if res.err == nil {
+				cancel()
+				drain(inflight)
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+			if len(queue) > 0 {
+				start()
+			}
+		case <-timer.C:
+			if len(queue) > 0 {
+				start()
+			} else {
+				elapsed = true
+			}
+		case <-ctx.Done():
+			drain(inflight)
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// interleaveAddrs merges ips into the pending queue, alternating between
+// address families and starting with IPv6 as RFC 8305 section 4 describes.
+// Addresses already queued or attempted are not added twice.
+func interleaveAddrs(queue []net.IP, ips []net.IP, seen map[string]bool) []net.IP {
+	var v6, v4 []net.IP
+	for _, ip := range queue {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	for _, ip := range ips {
+		if seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	merged := make([]net.IP, 0, len(v6)+len(v4))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			merged = append(merged, v6[i])
+		}
+		if i < len(v4) {
+			merged = append(merged, v4[i])
+		}
+	}
+	return merged
+}
+
+// allowedFamilies reports which address families a dial may use given the
+// requested IP family and local address
+func allowedFamilies(family string, localIP net.IP) (v4 bool, v6 bool, err error) {
+	switch strings.ToLower(family) {
+	case "", IPFamilyAuto:
+		v4, v6 = true, true
+	case IPFamilyIPv4:
+		v4 = true
+	case IPFamilyIPv6:
+		v6 = true
+	default:
+		return false, false, fmt.Errorf("invalid IP family %q, expected auto, ipv4 or ipv6", family)
+	}
+
+	// A bound local address can only reach peers of its own family
+	if localIP != nil {
+		if localIP.To4() != nil {
+			v6 = false
+		} else {
+			v4 = false
+		}
+		if !v4 && !v6 {
+			return false, false, fmt.Errorf("local address %s does not match IP family %q", localIP, family)
+		}
+	}
+	return v4, v6, nil
+}
+
+// parseLocalAddr parses an optional local IP address, with or without a port
+func parseLocalAddr(addr string) (net.IP, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid local address %q", addr)
+	}
+	return ip, nil
+}
+
+// tcpNetwork pins a "tcp" dial to the family of the target address
+func tcpNetwork(network string, ip net.IP) string {
+	if network != "tcp" {
+		return network
+	}
+	if ip.To4() != nil {
+		return "tcp4"
+	}
+	return "tcp6"
+}
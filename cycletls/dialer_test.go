@@ -0,0 +1,137 @@
+package cycletls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInterleaveAddrs(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.2"),
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("192.0.2.3"),
+		net.ParseIP("2001:db8::2"),
+	}
+	want := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3"}
+
+	got := interleaveAddrs(nil, append(ips, net.ParseIP("192.0.2.1")), make(map[string]bool))
+	if len(got) != len(want) {
+		t.Fatalf("expected %d addresses, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("address %d: expected %s, got %s", i, want[i], got[i])
+		}
+	}
+}
+
+// A stalled first address must not hold up the dial past the attempt delay
+func TestRaceConnect_StalledAddressLoses(t *testing.T) {
+	batches := make(chan addrBatch, 1)
+	batches <- addrBatch{ips: []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}}
+	close(batches)
+
+	start := time.Now()
+	got, err := raceConnect(context.Background(), batches, 20*time.Millisecond, func(ctx context.Context, ip net.IP) (string, error) {
+		if ip.To4() == nil {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return ip.String(), nil
+	}, func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "192.0.2.1" {
+		t.Errorf("expected IPv4 attempt to win, got %s", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("race took too long: %v", elapsed)
+	}
+}
+
+func TestRaceConnect_LateAddressesStartAfterDelay(t *testing.T) {
+	batches := make(chan addrBatch)
+	go func() {
+		batches <- addrBatch{ips: []net.IP{net.ParseIP("2001:db8::1")}, v6: true}
+		// The IPv4 answer only turns up once the attempt delay has run out
+		time.Sleep(100 * time.Millisecond)
+		batches <- addrBatch{ips: []net.IP{net.ParseIP("192.0.2.1")}}
+		close(batches)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	got, err := raceConnect(ctx, batches, 20*time.Millisecond, func(ctx context.Context, ip net.IP) (string, error) {
+		if ip.To4() == nil {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return ip.String(), nil
+	}, func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "192.0.2.1" {
+		t.Errorf("expected IPv4 attempt to win, got %s", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("late IPv4 address waited on the stalled IPv6 attempt: %v", elapsed)
+	}
+}
+
+func TestRaceConnect_AllFail(t *testing.T) {
+	batches := make(chan addrBatch, 1)
+	batches <- addrBatch{ips: []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")}}
+	close(batches)
+
+	errRefused := errors.New("refused")
+	_, err := raceConnect(context.Background(), batches, time.Second, func(ctx context.Context, ip net.IP) (string, error) {
+		return "", errRefused
+	}, func(string) {})
+	if !errors.Is(err, errRefused) {
+		t.Fatalf("expected joined dial errors, got %v", err)
+	}
+}
+
+func TestHappyEyeballsDialer_IPFamily(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("IPv4 loopback unavailable: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	dialer := &happyEyeballsDialer{IPFamily: IPFamilyIPv4, LocalAddr: "127.0.0.1"}
+	conn, err := dialer.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("ipv4 dial failed: %v", err)
+	}
+	if local := conn.LocalAddr().(*net.TCPAddr); !local.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("expected connection bound to 127.0.0.1, got %s", local.IP)
+	}
+	conn.Close()
+
+	dialer = &happyEyeballsDialer{IPFamily: IPFamilyIPv6}
+	if _, err := dialer.DialContext(context.Background(), "tcp", ln.Addr().String()); err == nil {
+		t.Error("expected ipv6-only dial to an IPv4 literal to fail")
+	}
+
+	dialer = &happyEyeballsDialer{IPFamily: "ipv5"}
+	if _, err := dialer.DialContext(context.Background(), "tcp", ln.Addr().String()); err == nil {
+		t.Error("expected invalid IP family to be rejected")
+	}
+}
@@ -0,0 +1,481 @@
+package cycletls
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// HTTPS resource record support (RFC 9460). Chrome consults these records
+// before connecting to learn which ALPN protocols the origin supports, which
+// port and addresses to use, and the ECH configuration to encrypt the
+// ClientHello with. x/net/dns/dnsmessage has no SVCB support, so the RDATA is
+// parsed here from the raw resource.
+
+// dnsTypeHTTPS is the HTTPS resource record type
+const dnsTypeHTTPS = dnsmessage.Type(65)
+
+// SvcParamKeys used by HTTPS records
+const (
+	svcParamALPN          = 1
+	svcParamNoDefaultALPN = 2
+	svcParamPort          = 3
+	svcParamIPv4Hint      = 4
+	svcParamECH           = 5
+	svcParamIPv6Hint      = 6
+)
+
+const (
+	// httpsRRTimeout bounds a single HTTPS record query
+	httpsRRTimeout = 2 * time.Second
+	// httpsRRNegativeTTL is how long missing records and lookup failures are cached
+	httpsRRNegativeTTL = 5 * time.Minute
+)
+
+// HTTPSRecord is a parsed HTTPS resource record
+type HTTPSRecord struct {
+	Priority      uint16
+	Target        string
+	ALPN          []string
+	NoDefaultALPN bool
+	Port          uint16
+	IPv4Hint      []net.IP
+	IPv6Hint      []net.IP
+	ECHConfigList []byte
+	TTL           uint32
+}
+
+// SupportsALPN reports whether the record advertises the given protocol
+func (r HTTPSRecord) SupportsALPN(protocol string) bool {
+	for _, p := range r.ALPN {
+		if p == protocol {
+			return true
+		}
+	}
+	// http/1.1 is implied unless no-default-alpn is present
+	return protocol == "http/1.1" && !r.NoDefaultALPN
+}
+
+// Hints returns the address hints of the record
+func (r HTTPSRecord) Hints() []net.IP {
+	return append(append([]net.IP{}, r.IPv6Hint...), r.IPv4Hint...)
+}
+
+// LookupHTTPSRecords queries the HTTPS records for host. dnsServer is the
+// address of the DNS server to ask; when empty the first nameserver from
+// /etc/resolv.conf, or systemd-resolved's, is used, and where there is none,
+// as on Windows, dnsServer is required. AliasMode records are followed once and the
+// ServiceMode records are returned in priority order.
+func LookupHTTPSRecords(ctx context.Context, host, dnsServer string) ([]HTTPSRecord, error) {
+	server, err := dnsServerAddr(dnsServer)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := queryHTTPS(ctx, server, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Priority == 0 && record.Target != "." && record.Target != "" {
+			// AliasMode, the service is published under another name
+			return queryHTTPS(ctx, server, record.Target)
+		}
+	}
+	return records, nil
+}
+
+// httpsRRResolver caches HTTPS record lookups for one DNS server
+type httpsRRResolver struct {
+	server string
+
+	mu    sync.Mutex
+	cache map[string]httpsRRCacheEntry
+}
+
+type httpsRRCacheEntry struct {
+	record  *HTTPSRecord
+	expires time.Time
+}
+
+// Global HTTPS record resolvers keyed by DNS server, shared by all clients
+var (
+	httpsRRResolvers      = make(map[string]*httpsRRResolver)
+	httpsRRResolversMutex sync.Mutex
+)
+
+// getHTTPSRRResolver returns the shared resolver for the given DNS server
+func getHTTPSRRResolver(server string) *httpsRRResolver {
+	httpsRRResolversMutex.Lock()
+	defer httpsRRResolversMutex.Unlock()
+
+	resolver, ok := httpsRRResolvers[server]
+	if !ok {
+		resolver = &httpsRRResolver{
+			server: server,
+			cache:  make(map[string]httpsRRCacheEntry),
+		}
+		httpsRRResolvers[server] = resolver
+	}
+	return resolver
+}
+
+// lookup returns the HTTPS record describing host itself, or nil if there is
+// none. Records pointing at a different target name are skipped since the
+// dialers always connect to the request host.
+func (r *httpsRRResolver) lookup(ctx context.Context, host string) *HTTPSRecord {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	entry, ok := r.cache[host]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.record
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, httpsRRTimeout)
+	defer cancel()
+
+	entry = httpsRRCacheEntry{expires: time.Now().Add(httpsRRNegativeTTL)}
+	records, err := LookupHTTPSRecords(ctx, host, r.server)
+	if err == nil {
+		for i := range records {
+			target := strings.TrimSuffix(strings.ToLower(records[i].Target), ".")
+			if records[i].Priority > 0 && (target == "" || target == host) {
+				entry.record = &records[i]
+				entry.expires = time.Now().Add(time.Duration(records[i].TTL) * time.Second)
+				break
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.cache[host] = entry
+	r.mu.Unlock()
+	return entry.record
+}
+
+// updateECH replaces the cached ECH configuration for host, used when the
+// server rejects ECH and hands back retry configs
+func (r *httpsRRResolver) updateECH(host string, configList []byte) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.cache[host]; ok && entry.record != nil {
+		record := *entry.record
+		record.ECHConfigList = configList
+		entry.record = &record
+		r.cache[host] = entry
+	}
+}
+
+// queryHTTPS sends a single HTTPS query to server, retrying over TCP if the
+// UDP answer was truncated
+func queryHTTPS(ctx context.Context, server, host string) ([]HTTPSRecord, error) {
+	name, err := dnsmessage.NewName(dnsFQDN(host))
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	query, err := buildDNSQuery(id, name, dnsTypeHTTPS)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := exchangeDNS(ctx, "udp", server, query)
+	if err != nil {
+		return nil, err
+	}
+	var parser dnsmessage.Parser
+	header, err := parser.Start(resp)
+	if err != nil {
+		return nil, err
+	}
+	if header.Truncated {
+		if resp, err = exchangeDNS(ctx, "tcp", server, query); err != nil {
+			return nil, err
+		}
+		if header, err = parser.Start(resp); err != nil {
+			return nil, err
+		}
+	}
+	if header.ID != id {
+		return nil, errors.New("dns response id mismatch")
+	}
+	if header.RCode == dnsmessage.RCodeNameError {
+		return nil, nil
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("dns query for %s failed: %s", host, header.RCode)
+	}
+
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	var records []HTTPSRecord
+	for {
+		h, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Type != dnsTypeHTTPS {
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		body, err := parser.UnknownResource()
+		if err != nil {
+			return nil, err
+		}
+		record, err := parseSVCBRData(body.Data)
+		if err != nil {
+			return nil, err
+		}
+		record.TTL = h.TTL
+		records = append(records, record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+	return records, nil
+}
+
+func buildDNSQuery(id uint16, name dnsmessage.Name, qtype dnsmessage.Type) ([]byte, error) {
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return builder.Finish()
+}
+
+// exchangeDNS sends query to server and returns the raw response
+func exchangeDNS(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	// DNS over TCP prefixes each message with its length
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	var length [2]byte
+	if _, err := io.ReadFull(reader, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(reader, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// parseSVCBRData parses SVCB/HTTPS RDATA (RFC 9460 section 2.2)
+func parseSVCBRData(data []byte) (HTTPSRecord, error) {
+	var record HTTPSRecord
+	errMalformed := errors.New("malformed HTTPS record")
+	if len(data) < 3 {
+		return record, errMalformed
+	}
+	record.Priority = binary.BigEndian.Uint16(data)
+
+	// TargetName is an uncompressed domain name
+	off := 2
+	var labels []string
+	for {
+		if off >= len(data) {
+			return record, errMalformed
+		}
+		length := int(data[off])
+		off++
+		if length == 0 {
+			break
+		}
+		if length > 63 || off+length > len(data) {
+			return record, errMalformed
+		}
+		labels = append(labels, string(data[off:off+length]))
+		off += length
+	}
+	record.Target = strings.Join(labels, ".") + "."
+
+	for off < len(data) {
+		if off+4 > len(data) {
+			return record, errMalformed
+		}
+		key := binary.BigEndian.Uint16(data[off:])
+		length := int(binary.BigEndian.Uint16(data[off+2:]))
+		off += 4
+		if off+length > len(data) {
+			return record, errMalformed
+		}
+		value := data[off : off+length]
+		off += length
+
+		switch key {
+		case svcParamALPN:
+			for i := 0; i < len(value); {
+				n := int(value[i])
+				if i+1+n > len(value) {
+					return record, errMalformed
+				}
+				record.ALPN = append(record.ALPN, string(value[i+1:i+1+n]))
+				i += 1 + n
+			}
+		case svcParamNoDefaultALPN:
+			record.NoDefaultALPN = true
+		case svcParamPort:
+			if len(value) != 2 {
+				return record, errMalformed
+			}
+			record.Port = binary.BigEndian.Uint16(value)
+		case svcParamIPv4Hint:
+			if len(value)%4 != 0 {
+				return record, errMalformed
+			}
+			for i := 0; i < len(value); i += 4 {
+				record.IPv4Hint = append(record.IPv4Hint, net.IP(append([]byte{}, value[i:i+4]...)))
+			}
+		case svcParamECH:
+			record.ECHConfigList = append([]byte{}, value...)
+		case svcParamIPv6Hint:
+			if len(value)%16 != 0 {
+				return record, errMalformed
+			}
+			for i := 0; i < len(value); i += 16 {
+				record.IPv6Hint = append(record.IPv6Hint, net.IP(append([]byte{}, value[i:i+16]...)))
+			}
+		}
+	}
+	return record, nil
+}
+
+// dnsServerAddr normalizes a DNS server address, defaulting to the system nameserver
+func dnsServerAddr(server string) (string, error) {
+	if server == "" {
+		server = systemNameserver()
+		if server == "" {
+			return "", errNoNameserver
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	return server, nil
+}
+
+// errNoNameserver fails HTTPS record lookups without a DNS server to ask,
+// as on Windows, which has no resolv.conf
+var errNoNameserver = errors.New("no system DNS server found, set dnsServer to look up HTTPS records")
+
+// resolvConfPaths are read in turn for the system nameserver. systemd-resolved
+// keeps the upstream servers in its own file, used when /etc/resolv.conf is
+// missing or lists none.
+var resolvConfPaths = []string{
+	"/etc/resolv.conf",
+	"/run/systemd/resolve/stub-resolv.conf",
+	"/run/systemd/resolve/resolv.conf",
+}
+
+// systemNameserver returns the first nameserver of the system's resolv.conf,
+// or "" when there is none
+func systemNameserver() string {
+	for _, path := range resolvConfPaths {
+		if server := resolvConfNameserver(path); server != "" {
+			return server
+		}
+	}
+	return ""
+}
+
+// resolvConfNameserver returns the first nameserver listed in the resolv.conf
+// at path
+func resolvConfNameserver(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// newDNSResolver returns a resolver that sends all queries to server, or nil
+// to use the system resolver
+func newDNSResolver(server string) *net.Resolver {
+	if server == "" {
+		return nil
+	}
+	addr, err := dnsServerAddr(server)
+	if err != nil {
+		return nil
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+func dnsFQDN(host string) string {
+	if strings.HasSuffix(host, ".") {
+		return host
+	}
+	return host + "."
+}
@@ -0,0 +1,159 @@
+package cycletls
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// buildHTTPSRData encodes a ServiceMode HTTPS record for the owner name
+func buildHTTPSRData(alpn []string, port uint16, ipv4 net.IP, ech []byte) []byte {
+	var rdata bytes.Buffer
+	_ = binary.Write(&rdata, binary.BigEndian, uint16(1)) // priority
+	rdata.WriteByte(0)                                    // target "."
+
+	param := func(key uint16, value []byte) {
+		_ = binary.Write(&rdata, binary.BigEndian, key)
+		_ = binary.Write(&rdata, binary.BigEndian, uint16(len(value)))
+		rdata.Write(value)
+	}
+	var alpnValue []byte
+	for _, p := range alpn {
+		alpnValue = append(append(alpnValue, byte(len(p))), p...)
+	}
+	param(svcParamALPN, alpnValue)
+	param(svcParamPort, binary.BigEndian.AppendUint16(nil, port))
+	param(svcParamIPv4Hint, ipv4.To4())
+	param(svcParamECH, ech)
+	return rdata.Bytes()
+}
+
+// startDNSStandIn serves the given HTTPS RDATA for every HTTPS query and
+// empty answers for everything else
+func startDNSStandIn(t *testing.T, rdata []byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on UDP loopback: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var parser dnsmessage.Parser
+			header, err := parser.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			question, err := parser.Question()
+			if err != nil {
+				continue
+			}
+
+			builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, RecursionAvailable: true})
+			_ = builder.StartQuestions()
+			_ = builder.Question(question)
+			_ = builder.StartAnswers()
+			if question.Type == dnsTypeHTTPS {
+				_ = builder.UnknownResource(dnsmessage.ResourceHeader{
+					Name:  question.Name,
+					Type:  dnsTypeHTTPS,
+					Class: dnsmessage.ClassINET,
+					TTL:   300,
+				}, dnsmessage.UnknownResource{Type: dnsTypeHTTPS, Data: rdata})
+			}
+			resp, err := builder.Finish()
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestLookupHTTPSRecords(t *testing.T) {
+	ech := []byte{0x00, 0x04, 0xfe, 0x0d, 0x00, 0x00}
+	server := startDNSStandIn(t, buildHTTPSRData([]string{"h3", "h2"}, 8443, net.ParseIP("192.0.2.7"), ech))
+
+	records, err := LookupHTTPSRecords(context.Background(), "example.test", server)
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	record := records[0]
+	if !record.SupportsALPN("h3") || !record.SupportsALPN("h2") || !record.SupportsALPN("http/1.1") {
+		t.Errorf("unexpected ALPN: %v", record.ALPN)
+	}
+	if record.Port != 8443 || record.Target != "." || record.TTL != 300 {
+		t.Errorf("unexpected record: %+v", record)
+	}
+	if len(record.IPv4Hint) != 1 || !record.IPv4Hint[0].Equal(net.ParseIP("192.0.2.7")) {
+		t.Errorf("unexpected ipv4hint: %v", record.IPv4Hint)
+	}
+	if !bytes.Equal(record.ECHConfigList, ech) {
+		t.Errorf("unexpected ECH config: %x", record.ECHConfigList)
+	}
+}
+
+// The dialer should reach a host that only exists through its HTTPS record hints
+func TestHappyEyeballsDialer_HTTPSRecordHints(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("IPv4 loopback unavailable: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	server := startDNSStandIn(t, buildHTTPSRData([]string{"h2"}, uint16(port), net.ParseIP("127.0.0.1"), nil))
+
+	dialer := newDirectDialer(Browser{EnableHTTPSRR: true, DNSServer: server})
+	conn, err := dialer.DialContext(context.Background(), "tcp", "svc.example.test:443")
+	if err != nil {
+		t.Fatalf("dial via HTTPS record failed: %v", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != ln.Addr().String() {
+		t.Errorf("expected connection to %s, got %s", ln.Addr(), conn.RemoteAddr())
+	}
+}
+
+// Proxied clients must not look up HTTPS records, which would reveal the
+// hosts they visit to the DNS server
+func TestNewRoundTripper_ProxiedSkipsHTTPSRecords(t *testing.T) {
+	browser := Browser{EnableHTTPSRR: true, DNSServer: "127.0.0.1:53"}
+
+	direct := newRoundTripper(browser).(*roundTripper)
+	if direct.httpsRR == nil {
+		t.Fatal("expected a direct client to look up HTTPS records")
+	}
+
+	dialer, err := newConnectDialer("http://127.0.0.1:8080", "", newDirectDialer(browser))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxied := newRoundTripper(browser, dialer).(*roundTripper)
+	if proxied.httpsRR != nil {
+		t.Fatal("expected a proxied client to skip HTTPS records")
+	}
+}
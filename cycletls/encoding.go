@@ -0,0 +1,296 @@
+package cycletls
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMaxWindow is the largest zstd window accepted, matching the 8 MB limit
+// browsers apply to zstd Content-Encoding (RFC 9659)
+const zstdMaxWindow = 8 << 20
+
+// parseContentEncoding flattens Content-Encoding header values into the list
+// of codings in the order they were applied, dropping identity
+func parseContentEncoding(values []string) []string {
+	var codings []string
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" || coding == "identity" {
+				continue
+			}
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// decodeSniffSize is how much of a streamed body is decoded before any of it
+// is sent, so a body that is not in its Content-Encoding can still be sent as
+// received
+const decodeSniffSize = 32 << 10
+
+// newResponseBodyReader builds the body pipeline of the WS_PORT dispatcher:
+// the raw body is capped at maxResponseBytes, Content-Encoding is decoded as
+// it streams, and the decoded output is capped at maxDecompressedBytes.
+// Limits <= 0 are unlimited and unsupported encodings are passed through
+// untouched. Like readResponseBody, a body whose first decodeSniffSize bytes
+// fail to decode is returned as received.
+func newResponseBodyReader(body io.ReadCloser, encoding []string, maxResponseBytes, maxDecompressedBytes int64) io.ReadCloser {
+	if maxResponseBytes > 0 {
+		body = &limitedBody{ReadCloser: body, limit: maxResponseBytes}
+	}
+	raw := &rawBody{ReadCloser: body, keep: true}
+	if decoded, err := NewDecodingReader(raw, encoding); err == nil && decoded != io.ReadCloser(raw) {
+		body = &sniffingReader{raw: raw, decoded: decoded}
+	}
+	if maxDecompressedBytes > 0 {
+		body = &limitedBody{ReadCloser: body, limit: maxDecompressedBytes, decompressed: true}
+	}
+	return body
+}
+
+// sniffingReader decodes the start of a body before returning any of it,
+// falling back to the raw bytes when that fails
+type sniffingReader struct {
+	raw     *rawBody
+	decoded io.ReadCloser
+	reader  io.Reader // Set once the start has been decoded
+	err     error
+}
+
+func (s *sniffingReader) Read(p []byte) (int, error) {
+	if s.reader == nil && s.err == nil {
+		s.sniff()
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.reader.Read(p)
+}
+
+// sniff decodes until decodeSniffSize bytes went in or came out, the end of
+// the body or an error
+func (s *sniffingReader) sniff() {
+	var out bytes.Buffer
+	buf := make([]byte, 8192)
+	for s.raw.buf.Len() < decodeSniffSize && out.Len() < decodeSniffSize {
+		n, err := s.decoded.Read(buf)
+		out.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if s.raw.err != nil {
+				s.err = s.raw.err
+				return
+			}
+			// Not in its Content-Encoding: send the body as received
+			s.raw.keep = false
+			s.reader = io.MultiReader(bytes.NewReader(s.raw.buf.Bytes()), s.raw)
+			return
+		}
+	}
+	s.raw.keep = false
+	s.raw.buf = bytes.Buffer{}
+	s.reader = io.MultiReader(&out, s.decoded)
+}
+
+// Close closes the decoders and the body
+func (s *sniffingReader) Close() error {
+	return s.decoded.Close()
+}
+
+// readResponseBody reads a whole body for Do, with the limits of
+// newResponseBodyReader. A body that fails to decode is returned as received,
+// the way DecompressBody does, so only the size limits and errors reading the
+// response itself fail.
+func readResponseBody(body io.ReadCloser, encoding []string, maxResponseBytes, maxDecompressedBytes int64) ([]byte, error) {
+	if maxResponseBytes > 0 {
+		body = &limitedBody{ReadCloser: body, limit: maxResponseBytes}
+	}
+	raw := &rawBody{ReadCloser: body}
+	reader, err := NewDecodingReader(raw, encoding)
+	if err != nil {
+		reader = raw
+	}
+	raw.keep = reader != io.ReadCloser(raw)
+	if maxDecompressedBytes > 0 {
+		reader = &limitedBody{ReadCloser: reader, limit: maxDecompressedBytes, decompressed: true}
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(reader)
+	var tooLarge *ResponseTooLargeError
+	if err == nil || errors.As(err, &tooLarge) {
+		return decoded, err
+	}
+	if raw.err != nil {
+		return nil, raw.err
+	}
+
+	// Decoding failed: read the rest of the body as is
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return nil, err
+	}
+	return raw.buf.Bytes(), nil
+}
+
+// rawBody keeps a copy of the bytes read from a body being decoded, and the
+// error reading it failed with
+type rawBody struct {
+	io.ReadCloser
+	keep bool
+	buf  bytes.Buffer
+	err  error
+}
+
+func (r *rawBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.keep {
+		r.buf.Write(p[:n])
+	}
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// limitedBody fails with a ResponseTooLargeError once more than limit bytes have been read
+type limitedBody struct {
+	io.ReadCloser
+	limit        int64
+	decompressed bool
+	read         int64
+	err          error
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	// Read at most one byte past the limit so an exact fit still reaches EOF
+	if remaining := l.limit - l.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		l.err = &ResponseTooLargeError{Limit: l.limit, Decompressed: l.decompressed}
+		return n - int(l.read-l.limit), l.err
+	}
+	return n, err
+}
+
+// NewDecodingReader wraps body so that reads return the decoded content.
+// Stacked encodings such as "gzip, br" are undone in reverse order of
+// application. Decoding is streaming: nothing is read from body until the
+// first Read, so an error from this function means one of the encodings is
+// unsupported and body can still be used as is.
+func NewDecodingReader(body io.ReadCloser, encoding []string) (io.ReadCloser, error) {
+	codings := parseContentEncoding(encoding)
+	for _, coding := range codings {
+		if !isSupportedEncoding(coding) {
+			return nil, fmt.Errorf("unsupported content encoding %q", coding)
+		}
+	}
+	if len(codings) == 0 {
+		return body, nil
+	}
+
+	dr := &decodingReader{body: body}
+	var reader io.Reader = body
+	for i := len(codings) - 1; i >= 0; i-- {
+		reader = &lazyDecoder{coding: codings[i], src: reader, owner: dr}
+	}
+	dr.reader = reader
+	return dr, nil
+}
+
+func isSupportedEncoding(coding string) bool {
+	switch coding {
+	case "gzip", "x-gzip", "deflate", "br", "brotli", "zstd":
+		return true
+	}
+	return false
+}
+
+// decodingReader is the decoded view of a response body
+type decodingReader struct {
+	body    io.ReadCloser
+	reader  io.Reader
+	closers []io.Closer
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	return d.reader.Read(p)
+}
+
+// Close closes every decoder and then the underlying body
+func (d *decodingReader) Close() error {
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		_ = d.closers[i].Close()
+	}
+	return d.body.Close()
+}
+
+// lazyDecoder creates its decoder on first Read, since gzip and zlib read
+// their headers as soon as they are constructed
+type lazyDecoder struct {
+	coding  string
+	src     io.Reader
+	owner   *decodingReader
+	decoder io.Reader
+	err     error
+}
+
+func (l *lazyDecoder) Read(p []byte) (int, error) {
+	if l.decoder == nil && l.err == nil {
+		l.decoder, l.err = newDecoder(l.coding, l.src)
+		if closer, ok := l.decoder.(io.Closer); ok && l.err == nil {
+			l.owner.closers = append(l.owner.closers, closer)
+		}
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return l.decoder.Read(p)
+}
+
+// newDecoder returns a reader decoding a single content coding
+func newDecoder(coding string, src io.Reader) (io.Reader, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(src)
+	case "deflate":
+		// "deflate" should be zlib wrapped, but some servers send raw deflate
+		buffered := bufio.NewReader(src)
+		header, err := buffered.Peek(2)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	case "br", "brotli":
+		return brotli.NewReader(src), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(src, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", coding)
+}
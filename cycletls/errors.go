@@ -2,6 +2,7 @@ package cycletls
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
@@ -54,6 +55,12 @@ func parseError(err error) (errormessage errorMessage) {
 	httpError := string(err.Error())
 	//todo - clean this up
 
+	// Check for oversized responses (should return 413)
+	var tooLarge *ResponseTooLargeError
+	if errors.As(err, &tooLarge) {
+		return errorMessage{StatusCode: 413, debugger: fmt.Sprintf("%#v\n", err), ErrorMsg: err.Error(), Op: "size"}
+	}
+
 	// Check for TLS certificate errors (should return 495)
 	if strings.Contains(httpError, "uTlsConn.Handshake() error") ||
 		strings.Contains(httpError, "tls: failed to verify certificate") ||
@@ -122,3 +129,18 @@ func raiseExtensionError(info string) *errExtensionNotExist {
 		Context: info,
 	}
 }
+
+// ResponseTooLargeError is returned while reading a response body that
+// exceeds Options.MaxResponseBytes (bytes received on the wire) or
+// Options.MaxDecompressedBytes (bytes after Content-Encoding decoding)
+type ResponseTooLargeError struct {
+	Limit        int64
+	Decompressed bool
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	if e.Decompressed {
+		return fmt.Sprintf("decompressed response body exceeds limit of %d bytes", e.Limit)
+	}
+	return fmt.Sprintf("response body exceeds limit of %d bytes", e.Limit)
+}
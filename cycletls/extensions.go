@@ -1,6 +1,9 @@
 package cycletls
 
 import (
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	utls "github.com/refraction-networking/utls"
@@ -293,25 +296,31 @@ func IsGREASEValue(extID uint16) bool {
 
 // CreateExtensionFromID creates an appropriate extension for the given ID
 func CreateExtensionFromID(extID uint16, tlsVersion uint16, components *JA4RComponents, disableGrease bool, serverName string) utls.TLSExtension {
+	mode := GreaseAuto
+	if disableGrease {
+		mode = GreaseOff
+	}
+	ext, _ := createExtensionFromID(extID, tlsVersion, components, mode, serverName)
+	return ext
+}
+
+// createExtensionFromID is CreateExtensionFromID, also reporting whether extID
+// is a known extension rather than an empty generic placeholder. GreaseOn adds
+// GREASE to supported_groups, supported_versions and key_share.
+func createExtensionFromID(extID uint16, tlsVersion uint16, components *JA4RComponents, grease GreaseMode, serverName string) (utls.TLSExtension, bool) {
 	switch extID {
 	case 0x0000: // Server Name Indication
 		return &utls.SNIExtension{
 			ServerName: serverName,
-		}
+		}, true
 	case 0x0005: // Status Request
-		return &utls.StatusRequestExtension{}
+		return &utls.StatusRequestExtension{}, true
 	case 0x000a: // Supported Groups (Elliptic Curves)
-		curves := []utls.CurveID{utls.X25519, utls.CurveP256, utls.CurveP384}
-		// Add post-quantum curves if supported
-		if tlsVersion == utls.VersionTLS13 {
-			// Add X25519MLKEM768 if supported by uTLS version
-			curves = append([]utls.CurveID{utls.X25519}, curves...)
-		}
-		return &utls.SupportedCurvesExtension{Curves: curves}
+		return &utls.SupportedCurvesExtension{Curves: ja4rGroups(tlsVersion, grease == GreaseOn)}, true
 	case 0x000b: // EC Point Formats
 		return &utls.SupportedPointsExtension{
 			SupportedPoints: []byte{0}, // uncompressed
-		}
+		}, true
 	case 0x000d: // Signature Algorithms
 		sigSchemes := []utls.SignatureScheme{}
 		if components != nil {
@@ -340,7 +349,7 @@ func CreateExtensionFromID(extID uint16, tlsVersion uint16, components *JA4RComp
 		}
 		return &utls.SignatureAlgorithmsExtension{
 			SupportedSignatureAlgorithms: sigSchemes,
-		}
+		}, true
 	case 0x0010: // ALPN
 		alpnProtocols := []string{"h2", "http/1.1"}
 		if components != nil {
@@ -357,17 +366,17 @@ func CreateExtensionFromID(extID uint16, tlsVersion uint16, components *JA4RComp
 
 		return &utls.ALPNExtension{
 			AlpnProtocols: alpnProtocols,
-		}
+		}, true
 	case 0x0012: // Signed Certificate Timestamp
-		return &utls.SCTExtension{}
+		return &utls.SCTExtension{}, true
 	case 0x0017: // Extended Master Secret
-		return &utls.ExtendedMasterSecretExtension{}
+		return &utls.ExtendedMasterSecretExtension{}, true
 	case 0x001b: // Compress Certificate
 		return NewCustomCompressCertificateExtension(extID, []utls.CertCompressionAlgo{
 			utls.CertCompressionBrotli,
-		})
+		}), true
 	case 0x001c: // Record Size Limit
-		return NewCustomRecordSizeLimitExtension(extID, 0x4001)
+		return NewCustomRecordSizeLimitExtension(extID, 0x4001), true
 	case 0x0022: // Delegated Credentials - PROBLEMATIC EXTENSION
 		// This extension causes connection resets with some servers (like peet.ws)
 		// Instead of implementing the complex RFC format, use a simpler fallback
@@ -375,57 +384,158 @@ func CreateExtensionFromID(extID uint16, tlsVersion uint16, components *JA4RComp
 		return &utls.GenericExtension{
 			Id:   extID,
 			Data: []byte{0x00, 0x04, 0x04, 0x03, 0x08, 0x04}, // Minimal valid data
-		}
+		}, true
 	case 0x0023: // Session Ticket
-		return &utls.SessionTicketExtension{}
+		return &utls.SessionTicketExtension{}, true
 	case 0x002b: // Supported Versions
+		var versions []uint16
 		if tlsVersion == utls.VersionTLS13 {
-			return &utls.SupportedVersionsExtension{
-				Versions: []uint16{utls.VersionTLS13, utls.VersionTLS12},
-			}
+			versions = []uint16{utls.VersionTLS13, utls.VersionTLS12}
 		} else if tlsVersion == utls.VersionTLS12 {
-			return &utls.SupportedVersionsExtension{
-				Versions: []uint16{utls.VersionTLS12, utls.VersionTLS11},
-			}
+			versions = []uint16{utls.VersionTLS12, utls.VersionTLS11}
+		} else {
+			return nil, true
 		}
-		return nil
+		if grease == GreaseOn {
+			versions = append([]uint16{utls.GREASE_PLACEHOLDER}, versions...)
+		}
+		return &utls.SupportedVersionsExtension{Versions: versions}, true
 	case 0x002d: // PSK Key Exchange Modes
 		return &utls.PSKKeyExchangeModesExtension{
 			Modes: []uint8{utls.PskModeDHE},
-		}
+		}, true
 	case 0x0033: // Key Share
 		if tlsVersion == utls.VersionTLS13 {
-			keyShares := []utls.KeyShare{
-				{Group: utls.X25519},
-				{Group: utls.CurveP256},
-			}
-			// Add post-quantum key share if supported
 			return &utls.KeyShareExtension{
-				KeyShares: keyShares,
-			}
+				KeyShares: keySharesForGroups(ja4rGroups(tlsVersion, grease == GreaseOn), false),
+			}, true
 		}
-		return nil
+		return nil, true
 	case 0x4469: // Old ALPS (ApplicationSettings) - 17513
-		return NewCustomApplicationSettingsExtension(extID, []string{"h2"})
+		return NewCustomApplicationSettingsExtension(extID, []string{"h2"}), true
 	case 0x44cd: // New ALPS (ApplicationSettings) - 17613
-		return NewCustomApplicationSettingsExtension(extID, []string{"h2"})
+		return NewCustomApplicationSettingsExtension(extID, []string{"h2"}), true
 	case 0x6399: // X25519Kyber768Draft00 (Post-Quantum) - 25497
-		return NewCustomPostQuantumExtension(extID, 0x6399)
+		return NewCustomPostQuantumExtension(extID, 0x6399), true
 	case 0xfe0d: // Encrypted Client Hello (ECH) - 65037
-		return NewCustomECHExtension(extID)
+		return NewCustomECHExtension(extID), true
 	case 0xff01: // Renegotiation Info - 65281
 		return &utls.RenegotiationInfoExtension{
 			Renegotiation: utls.RenegotiateOnceAsClient,
-		}
+		}, true
 	default:
 		// Handle GREASE values
-		if IsGREASEValue(extID) && !disableGrease {
-			return NewCustomGREASEExtension(extID)
+		if IsGREASEValue(extID) && grease != GreaseOff {
+			return NewCustomGREASEExtension(extID), true
 		}
 		// Unknown extensions: preserve as generic with original ID
 		return &utls.GenericExtension{
 			Id:   extID,
 			Data: []byte{}, // Empty data for unknown extensions
+		}, IsGREASEValue(extID)
+	}
+}
+
+// ja4rGroups returns the supported groups sent for JA4r fingerprints, which
+// do not record groups. TLS 1.3 leads with X25519MLKEM768 like current browsers,
+// preceded by GREASE when grease is set.
+func ja4rGroups(tlsVersion uint16, grease bool) []utls.CurveID {
+	groups := []utls.CurveID{utls.X25519, utls.CurveP256, utls.CurveP384}
+	if tlsVersion == utls.VersionTLS13 {
+		groups = append([]utls.CurveID{utls.X25519MLKEM768}, groups...)
+	}
+	if grease {
+		groups = append([]utls.CurveID{utls.CurveID(utls.GREASE_PLACEHOLDER)}, groups...)
+	}
+	return groups
+}
+
+// Hybrid post-quantum groups uTLS has no constants for
+const (
+	curveSecP256r1MLKEM768  = utls.CurveID(0x11eb) // 4587
+	curveSecP384r1MLKEM1024 = utls.CurveID(0x11ed) // 4589
+)
+
+// isPostQuantumGroup reports whether group is a hybrid post-quantum group
+func isPostQuantumGroup(group utls.CurveID) bool {
+	switch group {
+	case utls.X25519MLKEM768, utls.X25519Kyber768Draft00, curveSecP256r1MLKEM768, curveSecP384r1MLKEM1024:
+		return true
+	}
+	return false
+}
+
+// hybridKeyShareData returns a fresh key share for the hybrid groups uTLS
+// cannot generate one for: the ECDH point followed by the ML-KEM
+// encapsulation key. uTLS cannot finish their key exchange either, so the
+// handshake relies on the server picking another share. Other groups get nil
+// and are left to uTLS.
+func hybridKeyShareData(group utls.CurveID) []byte {
+	var curve ecdh.Curve
+	var encapsulationKey []byte
+	switch group {
+	case curveSecP256r1MLKEM768:
+		key, err := mlkem.GenerateKey768()
+		if err != nil {
+			return nil
+		}
+		curve, encapsulationKey = ecdh.P256(), key.EncapsulationKey().Bytes()
+	case curveSecP384r1MLKEM1024:
+		key, err := mlkem.GenerateKey1024()
+		if err != nil {
+			return nil
+		}
+		curve, encapsulationKey = ecdh.P384(), key.EncapsulationKey().Bytes()
+	default:
+		return nil
+	}
+	ecdhKey, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	return append(ecdhKey.PublicKey().Bytes(), encapsulationKey...)
+}
+
+// keySharesForGroups picks the key shares a browser sends for its supported
+// groups: GREASE when offered, the first hybrid post-quantum group, and X25519
+// (or the first classical group uTLS can generate). withP256 adds a P-256
+// share when offered, as Firefox does. Key data is generated per connection
+// at the right size for each group, by uTLS or hybridKeyShareData.
+func keySharesForGroups(groups []utls.CurveID, withP256 bool) []utls.KeyShare {
+	var shares []utls.KeyShare
+	var pq, classical utls.CurveID
+	offersP256 := false
+	for _, group := range groups {
+		switch {
+		case IsGREASEValue(uint16(group)):
+			if len(shares) == 0 {
+				shares = append(shares, utls.KeyShare{Group: utls.CurveID(utls.GREASE_PLACEHOLDER), Data: []byte{0}})
+			}
+		case isPostQuantumGroup(group):
+			if pq == 0 {
+				pq = group
+			}
+		case group == utls.X25519:
+			classical = group
+		case group == utls.CurveP256 || group == utls.CurveP384 || group == utls.CurveP521:
+			if group == utls.CurveP256 {
+				offersP256 = true
+			}
+			if classical == 0 {
+				classical = group
+			}
 		}
 	}
+
+	if pq != 0 {
+		shares = append(shares, utls.KeyShare{Group: pq, Data: hybridKeyShareData(pq)})
+	}
+	if classical == 0 {
+		classical = utls.X25519
+	}
+	shares = append(shares, utls.KeyShare{Group: classical})
+	if withP256 && offersP256 && classical != utls.CurveP256 {
+		shares = append(shares, utls.KeyShare{Group: utls.CurveP256})
+	}
+	return shares
 }
@@ -0,0 +1,180 @@
+package cycletls
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	http "github.com/Danny-Dasilva/fhttp"
+	"github.com/Danny-Dasilva/fhttp/http2/hpack"
+	utls "github.com/refraction-networking/utls"
+)
+
+// maxRecordedPreface caps how much of an HTTP/2 connection is buffered while
+// waiting for the first HEADERS frame
+const maxRecordedPreface = 64 << 10
+
+var http2ClientPreface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+// fingerprintConn is a TLS connection that remembers the fingerprints of the
+// ClientHello it sent and of the HTTP/2 frames that open the connection
+type fingerprintConn struct {
+	*utls.UConn
+
+	mu       sync.Mutex
+	tls      Fingerprints
+	preface  []byte // written bytes until the first HEADERS frame is complete
+	akamai   string
+	recorded bool
+}
+
+// newFingerprintConn wraps a handshaken connection
+func newFingerprintConn(conn *utls.UConn) *fingerprintConn {
+	c := &fingerprintConn{UConn: conn}
+	if conn.HandshakeState.Hello != nil {
+		// Best effort: a ClientHello we cannot parse leaves the fingerprints empty
+		c.tls, _ = ClientHelloFingerprints(conn.HandshakeState.Hello.Raw)
+	}
+	// Only HTTP/2 has frames worth recording
+	c.recorded = conn.ConnectionState().NegotiatedProtocol != "h2"
+	return c
+}
+
+func (c *fingerprintConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if !c.recorded {
+		c.preface = append(c.preface, b...)
+		if akamai, done := parseAkamai(c.preface); done || len(c.preface) > maxRecordedPreface {
+			c.akamai, c.recorded, c.preface = akamai, true, nil
+		}
+	}
+	c.mu.Unlock()
+	return c.UConn.Write(b)
+}
+
+// fingerprints returns the connection's TLS and HTTP/2 fingerprints
+func (c *fingerprintConn) fingerprints() Fingerprints {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fp := c.tls
+	if c.akamai != "" {
+		fp.Akamai = c.akamai
+		fp.AkamaiHash = md5Hex(c.akamai)
+	}
+	return fp
+}
+
+// parseAkamai reads the Akamai HTTP/2 fingerprint from the start of a client
+// connection: SETTINGS|WINDOW_UPDATE|PRIORITY|pseudo-header order. It reports
+// done once the first HEADERS block is complete.
+func parseAkamai(data []byte) (string, bool) {
+	if !bytes.HasPrefix(data, http2ClientPreface) {
+		return "", len(data) >= len(http2ClientPreface)
+	}
+	data = data[len(http2ClientPreface):]
+
+	var settings, priorities []string
+	windowUpdate := "00"
+	var headerBlock []byte
+	for len(data) >= 9 {
+		length := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		frameType, flags := data[3], data[4]
+		streamID := binary.BigEndian.Uint32(data[5:9]) & 0x7fffffff
+		if len(data) < 9+length {
+			return "", false
+		}
+		payload := data[9 : 9+length]
+		data = data[9+length:]
+
+		switch frameType {
+		case 0x4: // SETTINGS
+			if flags&0x1 != 0 || settings != nil {
+				continue
+			}
+			for ; len(payload) >= 6; payload = payload[6:] {
+				settings = append(settings, fmt.Sprintf("%d:%d", binary.BigEndian.Uint16(payload), binary.BigEndian.Uint32(payload[2:])))
+			}
+		case 0x8: // WINDOW_UPDATE
+			if streamID == 0 && len(payload) == 4 {
+				windowUpdate = fmt.Sprint(binary.BigEndian.Uint32(payload) & 0x7fffffff)
+			}
+		case 0x2: // PRIORITY
+			if len(payload) == 5 {
+				priorities = append(priorities, priorityString(streamID, payload))
+			}
+		case 0x1, 0x9: // HEADERS, CONTINUATION
+			if frameType == 0x1 {
+				if flags&0x8 != 0 && len(payload) > 0 { // PADDED
+					padding := int(payload[0])
+					payload = payload[1:]
+					if padding <= len(payload) {
+						payload = payload[:len(payload)-padding]
+					}
+				}
+				if flags&0x20 != 0 && len(payload) >= 5 { // PRIORITY
+					payload = payload[5:]
+				}
+			}
+			headerBlock = append(headerBlock, payload...)
+			if flags&0x4 == 0 { // END_HEADERS
+				continue
+			}
+			priority := "0"
+			if len(priorities) > 0 {
+				priority = strings.Join(priorities, ",")
+			}
+			return strings.Join([]string{strings.Join(settings, ";"), windowUpdate, priority, pseudoHeaderOrder(headerBlock)}, "|"), true
+		}
+	}
+	return "", false
+}
+
+// priorityString formats a PRIORITY frame as stream:exclusive:dependency:weight
+func priorityString(streamID uint32, payload []byte) string {
+	dependency := binary.BigEndian.Uint32(payload)
+	exclusive := dependency >> 31
+	return fmt.Sprintf("%d:%d:%d:%d", streamID, exclusive, dependency&0x7fffffff, int(payload[4])+1)
+}
+
+// pseudoHeaderOrder decodes a header block and returns its pseudo-headers as
+// Akamai letters, e.g. m,a,s,p
+func pseudoHeaderOrder(block []byte) string {
+	var letters []string
+	decoder := hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+		if strings.HasPrefix(f.Name, ":") && len(f.Name) > 1 {
+			letters = append(letters, f.Name[1:2])
+		}
+	})
+	_, _ = decoder.Write(block)
+	return strings.Join(letters, ",")
+}
+
+type fingerprintsKey struct{}
+
+// WithFingerprints returns a context that, used for a request sent through a
+// CycleTLS transport, collects the fingerprints the request went out with.
+// The result is filled in when the response arrives.
+func WithFingerprints(ctx context.Context) (context.Context, *Fingerprints) {
+	fp := &Fingerprints{}
+	return context.WithValue(ctx, fingerprintsKey{}, fp), fp
+}
+
+// recordFingerprints fills the Fingerprints attached to req's context from
+// the connection the request used, and tells a HAR capture about it
+func recordFingerprints(req *http.Request, resp *http.Response, conn interface{}) {
+	recordHARConnection(req, conn)
+	fp, ok := req.Context().Value(fingerprintsKey{}).(*Fingerprints)
+	if !ok {
+		return
+	}
+	switch c := conn.(type) {
+	case *fingerprintConn:
+		*fp = c.fingerprints()
+	case *http3ClientConn:
+		fp.HTTP3 = c.fingerprint.String()
+	}
+	fp.JA4H = JA4H(req, resp.ProtoMajor)
+}
@@ -0,0 +1,899 @@
+package cycletls
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	http "github.com/Danny-Dasilva/fhttp"
+	"github.com/Danny-Dasilva/fhttp/httptrace"
+	utls "github.com/refraction-networking/utls"
+)
+
+// DefaultHARMaxBodySize is how much of each body a new HARRecorder keeps
+const DefaultHARMaxBodySize = 1 << 20
+
+// HARRecorder collects the requests sent through it as HAR 1.2 entries, the
+// format browser devtools import and export, so traffic can be shared and
+// replayed exactly as it went out. Attach one to a client with
+// WithHARRecorder or to a session with SetSessionHARRecorder; Options.HARFile
+// appends to a file instead. Every request of a redirect chain is an entry.
+type HARRecorder struct {
+	// Body bytes kept per request and response. Longer bodies are cut and
+	// marked _truncated, 0 keeps none and a negative size keeps them whole.
+	MaxBodySize int64
+
+	mu      sync.Mutex
+	entries []HAREntry
+	file    *harFile // Appended to instead of entries, for Options.HARFile
+}
+
+// NewHARRecorder returns an empty recorder keeping DefaultHARMaxBodySize
+// bytes of each body
+func NewHARRecorder() *HARRecorder {
+	return &HARRecorder{MaxBodySize: DefaultHARMaxBodySize}
+}
+
+// HAR returns the entries recorded so far
+func (r *HARRecorder) HAR() HAR {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.har()
+}
+
+func (r *HARRecorder) har() HAR {
+	return HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "CycleTLS", Version: moduleVersion()},
+		Entries: append([]HAREntry{}, r.entries...),
+	}}
+}
+
+// WriteTo writes the entries recorded so far to w as HAR JSON
+func (r *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(r.HAR(), "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// WriteFile writes the entries recorded so far to the file at path
+func (r *HARRecorder) WriteFile(path string) error {
+	return writeHAR(path, r.HAR())
+}
+
+// Reset forgets every entry
+func (r *HARRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+}
+
+// add records exchanges, appending them to the recorder's file if it has one
+func (r *HARRecorder) add(exchanges []*harExchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, x := range exchanges {
+		entry := x.entry(r.MaxBodySize)
+		if r.file == nil {
+			r.entries = append(r.entries, entry)
+		} else if err := r.file.append(entry); err != nil {
+			logger(nil).Warn("Failed to write HAR file", "path", r.file.path, "err", err)
+		}
+	}
+}
+
+// writeHAR replaces the file at path, so readers never see half of it. HARs
+// hold cookies and credentials, so only the owner can read it.
+func writeHAR(path string, har HAR) error {
+	data, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// harFileEnd closes the document of a HAR file after its last entry
+const harFileEnd = "\n]}}\n"
+
+// harFile is a HAR document entries are appended to one per line, without
+// keeping them. The end of the document is written after every entry and
+// overwritten by the next one, so the file is complete between requests.
+type harFile struct {
+	path    string
+	f       *os.File // nil once closed
+	end     int64    // Offset of harFileEnd
+	entries int
+}
+
+// openHARFile starts an empty HAR document at name, inside dir unless dir
+// is empty. Only the owner can read it.
+func openHARFile(dir, name string) (*harFile, error) {
+	var f *os.File
+	var err error
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if dir == "" {
+		f, err = os.OpenFile(name, flag, 0o600)
+	} else {
+		// A root keeps symbolic links from leading out of dir
+		var root *os.Root
+		if root, err = os.OpenRoot(dir); err != nil {
+			return nil, err
+		}
+		defer root.Close()
+		f, err = root.OpenFile(name, flag, 0o600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// An existing file keeps its mode when truncated
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return nil, err
+	}
+	head, err := json.Marshal(HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "CycleTLS", Version: moduleVersion()},
+		Entries: []HAREntry{},
+	}})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	head = head[:len(head)-len("]}}")]
+	if _, err := f.Write(append(head, harFileEnd...)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &harFile{path: f.Name(), f: f, end: int64(len(head))}, nil
+}
+
+// append writes entry over the end of the document, and the end after it
+func (h *harFile) append(entry HAREntry) error {
+	if h.f == nil {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	sep := "\n"
+	if h.entries > 0 {
+		sep = ",\n"
+	}
+	data = append([]byte(sep), data...)
+	if _, err := h.f.WriteAt(append(data, harFileEnd...), h.end); err != nil {
+		return err
+	}
+	h.end += int64(len(data))
+	h.entries++
+	return nil
+}
+
+func (h *harFile) close() error {
+	if h.f == nil {
+		return nil
+	}
+	err := h.f.Close()
+	h.f = nil
+	return err
+}
+
+// moduleVersion is the CycleTLS version the binary was built with
+func moduleVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/Danny-Dasilva/CycleTLS/cycletls" {
+				return dep.Version
+			}
+		}
+	}
+	return "(devel)"
+}
+
+// HAR is a HAR 1.2 document
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog holds the recorded entries, oldest first
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+// HARCreator names the program that wrote the HAR
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is one request and its response. TLS and Error are custom fields.
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // Milliseconds, the sum of Timings
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	TLS             *HARTLS     `json:"_tls,omitempty"`
+	Error           string      `json:"_error,omitempty"` // Why the request failed, with a response status of 0
+}
+
+// HARRequest lists headers in the order they were sent, pseudo-headers
+// included on HTTP/2 and HTTP/3
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"` // Always -1, the size is not known
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARResponse lists headers sorted by name, as the transports do not keep
+// the order they arrived in
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"` // Always -1, the size is not known
+	BodySize    int64          `json:"bodySize"`    // Content-Length, -1 without one
+}
+
+// HARNameValue is a header or query parameter
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARCookie is a cookie sent with a request or set by a response
+type HARCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly"`
+	Secure   bool       `json:"secure"`
+}
+
+// HARPostData is a request body
+type HARPostData struct {
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text"`
+	Encoding  string `json:"_encoding,omitempty"` // base64 for binary bodies
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+// HARContent is the response body after Content-Encoding is decoded
+type HARContent struct {
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text,omitempty"`
+	Encoding  string `json:"encoding,omitempty"` // base64 for binary bodies
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+// HARTimings are in milliseconds, -1 for phases the request skipped. Connect
+// includes the proxy handshake and SSL, the TLS or QUIC handshake.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// HARTLS describes the connection a request went out on and the
+// fingerprints of the ClientHello sent, which HTTP/3 does not record
+type HARTLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipherSuite"`
+	ALPN        string `json:"alpn,omitempty"`
+	JA3         string `json:"ja3,omitempty"`
+	JA3Hash     string `json:"ja3Hash,omitempty"`
+	JA4         string `json:"ja4,omitempty"`
+	JA4R        string `json:"ja4r,omitempty"`
+}
+
+// WithHARRecorder records every request the client's Do sends into r
+func WithHARRecorder(r *HARRecorder) Option {
+	return func(client *CycleTLS) {
+		client.har = r
+	}
+}
+
+// HARDirEnv is the environment variable naming the directory the WS_PORT
+// server records harFile paths into. Without it or WithHARDir the server
+// rejects requests with a harFile.
+const HARDirEnv = "CYCLETLS_HAR_DIR"
+
+// WithHARDir lets the client's WS_PORT server record harFile paths into dir,
+// instead of the directory HARDirEnv names. Paths must be relative and stay
+// inside it. Options.HARFile is not restricted for Do.
+func WithHARDir(dir string) Option {
+	return func(client *CycleTLS) {
+		client.harDir = dir
+	}
+}
+
+// harDirectory is the directory the client's WS_PORT server records HAR
+// files into, empty when it does not
+func (client CycleTLS) harDirectory() string {
+	if client.harDir != "" {
+		return client.harDir
+	}
+	return os.Getenv(HARDirEnv)
+}
+
+// checkHARFile returns why the WS_PORT server cannot record into name with
+// the HAR directory dir, nil when it can
+func checkHARFile(dir, name string) error {
+	if dir == "" {
+		return fmt.Errorf("harFile needs %s set to the directory HAR files are written to", HARDirEnv)
+	}
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("harFile %q is not a relative path inside %s", name, HARDirEnv)
+	}
+	return nil
+}
+
+// harRegistry holds the recorders of sessions and of HAR files
+type harRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*HARRecorder
+	files    map[string]*HARRecorder // By harFileKey
+}
+
+// Global HAR recorders shared by all clients and the WS_PORT server
+var globalHARRegistry = &harRegistry{
+	sessions: make(map[string]*HARRecorder),
+	files:    make(map[string]*HARRecorder),
+}
+
+// SetSessionHARRecorder records every request made with Options.Session set
+// to session into r, from Do and the WS_PORT server alike. A nil r stops
+// recording the session.
+func SetSessionHARRecorder(session string, r *HARRecorder) {
+	globalHARRegistry.mu.Lock()
+	defer globalHARRegistry.mu.Unlock()
+	if r == nil {
+		delete(globalHARRegistry.sessions, session)
+		return
+	}
+	globalHARRegistry.sessions[session] = r
+}
+
+// harFileKey is the registry key of the HAR file at path, the same for every
+// spelling of the path
+func harFileKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// CloseHARFile stops recording into the HAR file at path, closing it and
+// releasing its recorder. Later requests with the path start the file over.
+func CloseHARFile(path string) error {
+	key := harFileKey(path)
+	globalHARRegistry.mu.Lock()
+	r := globalHARRegistry.files[key]
+	delete(globalHARRegistry.files, key)
+	globalHARRegistry.mu.Unlock()
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.close()
+}
+
+// harRecorders returns the recorders a request with options goes to: the
+// client's, its session's and its HAR file's, opening the file the first
+// time it is used
+func harRecorders(client *HARRecorder, options Options) ([]*HARRecorder, error) {
+	var recorders []*HARRecorder
+	if client != nil {
+		recorders = append(recorders, client)
+	}
+	globalHARRegistry.mu.Lock()
+	defer globalHARRegistry.mu.Unlock()
+	if r := globalHARRegistry.sessions[options.Session]; r != nil && options.Session != "" && r != client {
+		recorders = append(recorders, r)
+	}
+	if options.HARFile != "" {
+		key := harFileKey(filepath.Join(options.harDir, options.HARFile))
+		r := globalHARRegistry.files[key]
+		if r == nil {
+			file, err := openHARFile(options.harDir, options.HARFile)
+			if err != nil {
+				return nil, err
+			}
+			r = NewHARRecorder()
+			r.file = file
+			globalHARRegistry.files[key] = r
+		}
+		recorders = append(recorders, r)
+	}
+	return recorders, nil
+}
+
+// harCapture records a request's exchanges, one per request of its redirect
+// chain, and adds them to its recorders once the final body has been read.
+// Its methods do nothing on a nil capture, so requests without recorders
+// are not captured.
+type harCapture struct {
+	recorders []*HARRecorder
+	maxBody   int64 // The largest MaxBodySize of recorders, negative for no limit
+
+	mu        sync.Mutex
+	exchanges []*harExchange
+}
+
+type harCaptureKey struct{}
+
+// withHARCapture returns a context that captures the request it is used for
+// into recorders. Without recorders it returns ctx and a nil capture.
+func withHARCapture(ctx context.Context, recorders []*HARRecorder) (context.Context, *harCapture) {
+	if len(recorders) == 0 {
+		return ctx, nil
+	}
+	c := &harCapture{recorders: recorders}
+	for _, r := range recorders {
+		if r.MaxBodySize < 0 || c.maxBody < 0 {
+			c.maxBody = -1
+		} else if r.MaxBodySize > c.maxBody {
+			c.maxBody = r.MaxBodySize
+		}
+	}
+	return context.WithValue(ctx, harCaptureKey{}, c), c
+}
+
+func harCaptureFromContext(ctx context.Context) *harCapture {
+	c, _ := ctx.Value(harCaptureKey{}).(*harCapture)
+	return c
+}
+
+// roundTrip sends req with next, recording the exchange
+func (c *harCapture) roundTrip(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	x := &harExchange{
+		started: time.Now(),
+		before:  timingsFromContext(req.Context()).phases(),
+		method:  req.Method,
+		url:     req.URL.String(),
+		reqSize: req.ContentLength,
+		reqBody: harBody{max: c.maxBody},
+		body:    harBody{max: c.maxBody},
+	}
+	if c.maxBody != 0 && req.GetBody != nil && req.ContentLength != 0 {
+		if body, err := req.GetBody(); err == nil {
+			var r io.Reader = body
+			if c.maxBody > 0 {
+				r = io.LimitReader(body, c.maxBody+1)
+			}
+			_, _ = io.Copy(&x.reqBody, r)
+			body.Close()
+		}
+	}
+	c.mu.Lock()
+	c.exchanges = append(c.exchanges, x)
+	c.mu.Unlock()
+
+	ctx := context.WithValue(req.Context(), harExchangeKey{}, x)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaderField: x.wroteHeaderField,
+		WroteHeaders:     x.wroteHeaders,
+	})
+	req = req.WithContext(ctx)
+	resp, err := next(req)
+	x.responded(req, resp, err)
+	return resp, err
+}
+
+// write records a chunk of the final response's decoded body
+func (c *harCapture) write(p []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.exchanges) == 0 {
+		return
+	}
+	x := c.exchanges[len(c.exchanges)-1]
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	_, _ = x.body.Write(p)
+}
+
+// finish ends the capture once the final body has been read, or with the
+// error that ended the request, and adds its exchanges to the recorders
+func (c *harCapture) finish(err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	exchanges := c.exchanges
+	c.exchanges = nil
+	c.mu.Unlock()
+	if len(exchanges) == 0 {
+		return
+	}
+	x := exchanges[len(exchanges)-1]
+	x.mu.Lock()
+	x.finished = time.Now()
+	if err != nil && x.err == nil {
+		x.err = err
+	}
+	x.mu.Unlock()
+	for _, r := range c.recorders {
+		r.add(exchanges)
+	}
+}
+
+// harBody keeps the first max bytes written to it, all of them when max is
+// negative, and counts the rest
+type harBody struct {
+	max  int64
+	data []byte
+	size int64
+}
+
+func (b *harBody) Write(p []byte) (int, error) {
+	b.size += int64(len(p))
+	keep := p
+	if b.max >= 0 {
+		if room := b.max - int64(len(b.data)); room < int64(len(keep)) {
+			keep = keep[:max(room, 0)]
+		}
+	}
+	b.data = append(b.data, keep...)
+	return len(p), nil
+}
+
+// harExchange is one request of a captured chain and its response
+type harExchange struct {
+	mu sync.Mutex
+
+	started time.Time
+	before  Timings // The request's phase times when the exchange started
+	method  string
+	url     string
+	headers [][2]string // As written on the wire
+	written bool        // The headers are complete
+	reqSize int64
+	reqBody harBody
+
+	tls      *HARTLS
+	serverIP string
+
+	received    time.Time
+	phases      Timings // Phase times the exchange added
+	status      int
+	statusText  string
+	proto       string
+	respHeader  http.Header
+	cookies     []*http.Cookie
+	redirectURL string
+	respSize    int64
+	body        harBody
+
+	finished time.Time
+	err      error
+}
+
+type harExchangeKey struct{}
+
+func (x *harExchange) wroteHeaderField(name string, values []string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	// A request retried on another protocol writes its headers again
+	if x.written {
+		x.headers, x.written = nil, false
+	}
+	for _, v := range values {
+		x.headers = append(x.headers, [2]string{name, v})
+	}
+}
+
+func (x *harExchange) wroteHeaders() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.written = true
+}
+
+// recordHARConnection tells the exchange req belongs to, if it is captured,
+// which connection the request went out on
+func recordHARConnection(req *http.Request, conn interface{}) {
+	x, ok := req.Context().Value(harExchangeKey{}).(*harExchange)
+	if !ok {
+		return
+	}
+	tlsInfo, remote := connectionInfo(conn)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.tls = tlsInfo
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		x.serverIP = host
+	}
+}
+
+// connectionInfo returns the TLS details and remote address of a connection
+// recordFingerprints is given
+func connectionInfo(conn interface{}) (*HARTLS, string) {
+	var remote string
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		remote = c.RemoteAddr().String()
+	}
+	switch c := conn.(type) {
+	case *fingerprintConn:
+		state := c.ConnectionState()
+		fp := c.fingerprints()
+		return &HARTLS{
+			Version:     utls.VersionName(state.Version),
+			CipherSuite: utls.CipherSuiteName(state.CipherSuite),
+			ALPN:        state.NegotiatedProtocol,
+			JA3:         fp.JA3,
+			JA3Hash:     fp.JA3Hash,
+			JA4:         fp.JA4,
+			JA4R:        fp.JA4R,
+		}, remote
+	case *http3ClientConn:
+		if state, addr, ok := quicConnectionState(c.conn.QuicConn); ok {
+			return &HARTLS{
+				Version:     utls.VersionName(state.Version),
+				CipherSuite: utls.CipherSuiteName(state.CipherSuite),
+				ALPN:        state.NegotiatedProtocol,
+			}, addr.String()
+		}
+	}
+	return nil, remote
+}
+
+// responded records req's response, or the error it failed with
+func (x *harExchange) responded(req *http.Request, resp *http.Response, err error) {
+	after := timingsFromContext(req.Context()).phases()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.received = time.Now()
+	x.phases = Timings{
+		DNS:          after.DNS - x.before.DNS,
+		Connect:      after.Connect - x.before.Connect,
+		ProxyConnect: after.ProxyConnect - x.before.ProxyConnect,
+		TLS:          after.TLS - x.before.TLS,
+	}
+	if len(x.headers) == 0 {
+		x.headers = harRequestHeaders(req.Header)
+	}
+	if err != nil {
+		x.err = err
+		return
+	}
+	x.status = resp.StatusCode
+	x.statusText = strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" ")
+	if x.statusText == resp.Status || x.statusText == "" {
+		x.statusText = http.StatusText(resp.StatusCode)
+	}
+	x.proto = resp.Proto
+	x.respHeader = resp.Header.Clone()
+	x.cookies = resp.Cookies()
+	if location, err := resp.Location(); err == nil {
+		x.redirectURL = location.String()
+	}
+	x.respSize = resp.ContentLength
+}
+
+// harRequestHeaders lists header in the order it would be sent, for requests
+// that failed before their headers were written
+func harRequestHeaders(header http.Header) [][2]string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range header[http.HeaderOrderKey] {
+		for key := range header {
+			if strings.EqualFold(key, name) && !seen[key] {
+				seen[key] = true
+				names = append(names, key)
+			}
+		}
+	}
+	var rest []string
+	for key := range header {
+		if !seen[key] && key != http.HeaderOrderKey && key != http.PHeaderOrderKey {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	var headers [][2]string
+	for _, name := range append(names, rest...) {
+		for _, v := range header[name] {
+			headers = append(headers, [2]string{name, v})
+		}
+	}
+	return headers
+}
+
+// entry converts the exchange to a HAR entry keeping maxBody bytes of each
+// body
+func (x *harExchange) entry(maxBody int64) HAREntry {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	request := HARRequest{
+		Method:      x.method,
+		URL:         x.url,
+		HTTPVersion: x.proto,
+		Cookies:     []HARCookie{},
+		Headers:     []HARNameValue{},
+		QueryString: harQueryString(x.url),
+		HeadersSize: -1,
+		BodySize:    x.reqSize,
+	}
+	var cookieHeader http.Header
+	for _, h := range x.headers {
+		request.Headers = append(request.Headers, HARNameValue{Name: h[0], Value: h[1]})
+		if strings.EqualFold(h[0], "cookie") {
+			if cookieHeader == nil {
+				cookieHeader = http.Header{}
+			}
+			cookieHeader.Add("Cookie", h[1])
+		}
+	}
+	for _, c := range (&http.Request{Header: cookieHeader}).Cookies() {
+		request.Cookies = append(request.Cookies, HARCookie{Name: c.Name, Value: c.Value})
+	}
+	if x.reqSize != 0 {
+		postData := &HARPostData{MimeType: headerValue(x.headers, "content-type")}
+		postData.Text, postData.Encoding, postData.Truncated = harText(x.reqBody, maxBody)
+		request.PostData = postData
+	}
+
+	response := HARResponse{
+		Status:      x.status,
+		StatusText:  x.statusText,
+		HTTPVersion: x.proto,
+		Cookies:     []HARCookie{},
+		Headers:     []HARNameValue{},
+		Content:     HARContent{Size: x.body.size, MimeType: x.respHeader.Get("Content-Type")},
+		RedirectURL: x.redirectURL,
+		HeadersSize: -1,
+		BodySize:    x.respSize,
+	}
+	names := make([]string, 0, len(x.respHeader))
+	for name := range x.respHeader {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range x.respHeader[name] {
+			response.Headers = append(response.Headers, HARNameValue{Name: name, Value: v})
+		}
+	}
+	for _, c := range x.cookies {
+		cookie := HARCookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain, HTTPOnly: c.HttpOnly, Secure: c.Secure}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			cookie.Expires = &expires
+		}
+		response.Cookies = append(response.Cookies, cookie)
+	}
+	response.Content.Text, response.Content.Encoding, response.Content.Truncated = harText(x.body, maxBody)
+
+	entry := HAREntry{
+		StartedDateTime: x.started,
+		Request:         request,
+		Response:        response,
+		Timings:         x.timings(),
+		ServerIPAddress: x.serverIP,
+		TLS:             x.tls,
+	}
+	if x.err != nil {
+		entry.Error = x.err.Error()
+	}
+	for _, t := range []float64{entry.Timings.DNS, entry.Timings.Connect, entry.Timings.Send, entry.Timings.Wait, entry.Timings.Receive} {
+		entry.Time += max(t, 0)
+	}
+	return entry
+}
+
+// timings splits the exchange's time into HAR phases. Waiting runs from the
+// end of the dial until the response headers arrived, and receiving until
+// the final body was read.
+func (x *harExchange) timings() HARTimings {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	t := HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	dial := x.phases.DNS + x.phases.Connect + x.phases.ProxyConnect + x.phases.TLS
+	if dial > 0 {
+		t.DNS = ms(x.phases.DNS)
+		t.Connect = ms(x.phases.Connect + x.phases.ProxyConnect + x.phases.TLS)
+		if x.phases.TLS > 0 {
+			t.SSL = ms(x.phases.TLS)
+		}
+	}
+	t.Wait = ms(max(x.received.Sub(x.started)-dial, 0))
+	if !x.finished.IsZero() && x.err == nil {
+		t.Receive = ms(x.finished.Sub(x.received))
+	}
+	return t
+}
+
+// harText returns up to maxBody bytes of body as text, base64 encoded when
+// it is not UTF-8, and whether it was cut short
+func harText(body harBody, maxBody int64) (text, encoding string, truncated bool) {
+	data := body.data
+	if maxBody >= 0 && int64(len(data)) > maxBody {
+		data = data[:maxBody]
+	}
+	truncated = int64(len(data)) < body.size
+	if len(data) == 0 {
+		return "", "", truncated
+	}
+	if utf8.Valid(data) {
+		return string(data), "", truncated
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64", truncated
+}
+
+// harQueryString lists rawURL's query parameters in the order they appear
+func harQueryString(rawURL string) []HARNameValue {
+	params := []HARNameValue{}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return params
+	}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		params = append(params, HARNameValue{Name: name, Value: value})
+	}
+	return params
+}
+
+// headerValue returns the first value of name in headers
+func headerValue(headers [][2]string, name string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h[0], name) {
+			return h[1]
+		}
+	}
+	return ""
+}
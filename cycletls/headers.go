@@ -0,0 +1,235 @@
+package cycletls
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	http "github.com/Danny-Dasilva/fhttp"
+)
+
+// defaultHeaderOrder is the master order for Options.Headers when no
+// HeaderOrder is given. Headers it does not list are sent after it.
+var defaultHeaderOrder = []string{
+	"host",
+	"connection",
+	"cache-control",
+	"device-memory",
+	"viewport-width",
+	"rtt",
+	"downlink",
+	"ect",
+	"sec-ch-ua",
+	"sec-ch-ua-mobile",
+	"sec-ch-ua-full-version",
+	"sec-ch-ua-full-version-list",
+	"sec-ch-ua-arch",
+	"sec-ch-ua-bitness",
+	"sec-ch-ua-platform",
+	"sec-ch-ua-platform-version",
+	"sec-ch-ua-model",
+	"sec-ch-ua-wow64",
+	"upgrade-insecure-requests",
+	"user-agent",
+	"accept",
+	"x-requested-with",
+	"sec-fetch-site",
+	"sec-fetch-mode",
+	"sec-fetch-user",
+	"sec-fetch-dest",
+	"referer",
+	"accept-encoding",
+	"accept-language",
+	"cookie",
+}
+
+// requestHeaders flattens options' headers into the list they are sent in.
+// OrderedHeaders come first, exactly as given. Headers entries follow unless
+// OrderedHeaders already names them, ordered by masterOrder; entries
+// masterOrder does not list are returned separately, as they have no place
+// in the order and are sent after every ordered header.
+func requestHeaders(options Options, masterOrder []string) (ordered, unordered [][2]string) {
+	seen := make(map[string]bool)
+	for _, h := range options.OrderedHeaders {
+		seen[strings.ToLower(h[0])] = true
+		ordered = append(ordered, h)
+	}
+
+	rank := make(map[string]int)
+	for i, name := range masterOrder {
+		if _, ok := rank[strings.ToLower(name)]; !ok {
+			rank[strings.ToLower(name)] = i
+		}
+	}
+	var ranked [][2]string
+	for name, value := range options.Headers {
+		switch lower := strings.ToLower(name); {
+		case seen[lower]:
+		case masterOrder != nil && hasRank(rank, lower):
+			ranked = append(ranked, [2]string{http.CanonicalHeaderKey(name), value})
+		default:
+			unordered = append(unordered, [2]string{http.CanonicalHeaderKey(name), value})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return rank[strings.ToLower(ranked[i][0])] < rank[strings.ToLower(ranked[j][0])]
+	})
+	sort.Slice(unordered, func(i, j int) bool { return unordered[i][0] < unordered[j][0] })
+	return append(ordered, ranked...), unordered
+}
+
+func hasRank(rank map[string]int, name string) bool {
+	_, ok := rank[name]
+	return ok
+}
+
+// setRequestHeaders writes options' headers onto req, ordered with
+// http.HeaderOrderKey as requestHeaders lists them. The values of a name are
+// grouped under one key in the order they were given, which is how every
+// protocol sends them; HTTP/2 and HTTP/3 lowercase the names. Keys are
+// canonical so Get and Del still find them, and the list req's context carries
+// gives HTTP/1.1 the casing to send the ordered names with.
+func setRequestHeaders(req *http.Request, options Options, masterOrder []string) {
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	ordered, unordered := requestHeaders(options, masterOrder)
+	var order []string
+	var lines [][2]string
+	for _, h := range ordered {
+		lower := strings.ToLower(h[0])
+		if lower == "content-length" {
+			continue
+		}
+		key := http.CanonicalHeaderKey(lower)
+		if _, ok := req.Header[key]; !ok {
+			order = append(order, lower)
+		}
+		req.Header[key] = append(req.Header[key], h[1])
+		lines = append(lines, h)
+	}
+	for _, h := range unordered {
+		if !strings.EqualFold(h[0], "content-length") {
+			req.Header.Set(h[0], h[1])
+		}
+	}
+	if len(order) > 0 {
+		req.Header[http.HeaderOrderKey] = order
+		*req = *req.WithContext(context.WithValue(req.Context(), headerLinesKey{}, lines))
+	}
+}
+
+type headerLinesKey struct{}
+
+// headerLinesFromContext returns the ordered headers setRequestHeaders put on
+// ctx, as they were given
+func headerLinesFromContext(ctx context.Context) [][2]string {
+	lines, _ := ctx.Value(headerLinesKey{}).([][2]string)
+	return lines
+}
+
+// http1CanonicalHeaders are the names fhttp's HTTP/1.1 transport looks up
+// under their canonical key, to add its own Host or handle Connection, Expect
+// and Transfer-Encoding, so they keep that key whatever casing they were given
+var http1CanonicalHeaders = map[string]bool{"host": true, "connection": true, "expect": true, "transfer-encoding": true}
+
+// http1HeaderCasing maps the lowercased names of the ordered headers lines to
+// the header map key fhttp's HTTP/1.1 writer sends them under, as it writes
+// keys as they are: the casing of their first line, or the canonical key for
+// names the transport reads itself.
+func http1HeaderCasing(lines [][2]string) map[string]string {
+	casing := make(map[string]string)
+	for _, l := range lines {
+		lower := strings.ToLower(l[0])
+		if _, ok := casing[lower]; ok {
+			continue
+		}
+		casing[lower] = l[0]
+		// The transport also adds a User-Agent unless one is set as
+		// "User-Agent" or "user-agent"
+		if http1CanonicalHeaders[lower] || (lower == "user-agent" && l[0] != lower) {
+			casing[lower] = http.CanonicalHeaderKey(lower)
+		}
+	}
+	return casing
+}
+
+// http1Header returns header with the ordered headers lines moved to the keys
+// http1HeaderCasing gives them. The values of a name repeated in lines go out
+// together, where its first line is in the header order.
+func http1Header(header http.Header, lines [][2]string) http.Header {
+	if len(lines) == 0 {
+		return header
+	}
+	out := header.Clone()
+	for lower, key := range http1HeaderCasing(lines) {
+		canonical := http.CanonicalHeaderKey(lower)
+		if values, ok := out[canonical]; ok && key != canonical {
+			delete(out, canonical)
+			out[key] = values
+		}
+	}
+	return out
+}
+
+// masterHeaderOrder is Options.HeaderOrder, or the default order without one.
+// A RequestMode with a Firefox or Safari user agent uses the order that
+// browser sends.
+func masterHeaderOrder(options Options) []string {
+	if len(options.HeaderOrder) > 0 {
+		return options.HeaderOrder
+	}
+	if options.RequestMode != "" {
+		switch headerProfile(parseUserAgent(options.UserAgent).UserAgent) {
+		case firefox:
+			return firefoxHeaderOrder
+		case safari:
+			return safariHeaderOrder
+		}
+	}
+	return defaultHeaderOrder
+}
+
+// setUserAgent sets the User-Agent from Options.UserAgent unless
+// OrderedHeaders sends one itself
+func setUserAgent(req *http.Request, options Options) {
+	for _, h := range options.OrderedHeaders {
+		if strings.EqualFold(h[0], "user-agent") {
+			return
+		}
+	}
+	req.Header.Set("user-agent", options.UserAgent)
+}
+
+// hasHeader reports whether options set name, in Headers or OrderedHeaders
+func hasHeader(options Options, name string) bool {
+	for k := range options.Headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	for _, h := range options.OrderedHeaders {
+		if strings.EqualFold(h[0], name) {
+			return true
+		}
+	}
+	return false
+}
+
+// addDefaultHeaders adds headers to options.Headers unless options already
+// set them, in Headers or OrderedHeaders
+func addDefaultHeaders(options *Options, headers [][2]string) {
+	if len(headers) == 0 {
+		return
+	}
+	merged := make(map[string]string, len(options.Headers)+len(headers))
+	for name, value := range options.Headers {
+		merged[name] = value
+	}
+	for _, h := range headers {
+		if !hasHeader(*options, h[0]) {
+			merged[h[0]] = h[1]
+		}
+	}
+	options.Headers = merged
+}
@@ -7,6 +7,12 @@ import (
 	http2 "github.com/Danny-Dasilva/fhttp/http2"
 )
 
+// HTTP/2 fingerprints of the browsers fhttp has no preset for
+const (
+	safariHTTP2Fingerprint = "2:0,4:4194304,3:100|10485760|0|m,s,p,a"
+	iosHTTP2Fingerprint    = "2:0,4:2097152,3:100|10485760|0|m,s,p,a"
+)
+
 // HTTP2Fingerprint represents an HTTP/2 client fingerprint
 type HTTP2Fingerprint struct {
 	Settings         []http2.Setting
@@ -102,10 +108,40 @@ func (f *HTTP2Fingerprint) String() string {
 
 // Apply configures the HTTP/2 connection with the specified fingerprint
 func (f *HTTP2Fingerprint) Apply(conn *http2.Transport) {
-	// Set HTTP/2 settings
+	// Set HTTP/2 settings. fhttp replaces Settings with its navigator preset
+	// when it dials, so they are also passed as HTTP2Settings, which it
+	// sends as given along with the connection window update.
 	conn.Settings = f.Settings
+	conn.HTTP2Settings = &http2.HTTP2Settings{
+		Settings:       f.Settings,
+		ConnectionFlow: int(f.StreamDependency),
+	}
 
 	// Set priority and weight parameters
 	// Note: Currently dummy implementation as utls/http2 doesn't expose these directly
 	// In a real implementation, this would configure the priority tree
 }
+
+// newHTTP2Transport returns the HTTP/2 transport for rt's TLS connections,
+// shaped by rt.HTTP2Fingerprint or, without one, by the user agent's browser
+func (rt *roundTripper) newHTTP2Transport() (*http2.Transport, error) {
+	parsedUserAgent := parseUserAgent(rt.UserAgent)
+	transport := &http2.Transport{
+		DialTLS:     rt.dialTLSHTTP2,
+		PushHandler: &http2.DefaultPushHandler{},
+		Navigator:   parsedUserAgent.navigator(),
+	}
+
+	fingerprint := rt.HTTP2Fingerprint
+	if fingerprint == "" {
+		fingerprint = parsedUserAgent.http2Fingerprint()
+	}
+	if fingerprint != "" {
+		h2Fingerprint, err := NewHTTP2Fingerprint(fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse HTTP/2 fingerprint: %v", err)
+		}
+		h2Fingerprint.Apply(transport)
+	}
+	return transport, nil
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
 	uquic "github.com/refraction-networking/uquic"
+	utls "github.com/refraction-networking/utls"
 )
 
 // HTTP3Transport represents an HTTP/3 transport with customizable settings
@@ -400,6 +401,19 @@ type HTTP3Connection struct {
 	IsUQuic  bool // Flag to indicate if this is a UQuic connection
 }
 
+// Close closes the QUIC connection and its UDP socket
+func (c *HTTP3Connection) Close() {
+	switch conn := c.QuicConn.(type) {
+	case *quic.Conn:
+		_ = conn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeNoError), "")
+	case uquic.EarlyConnection:
+		_ = conn.CloseWithError(uquic.ApplicationErrorCode(http3.ErrCodeNoError), "")
+	}
+	if c.RawConn != nil {
+		c.RawConn.Close()
+	}
+}
+
 // http3Dial establishes a UDP connection for HTTP/3 with proxy support
 func (rt *roundTripper) http3Dial(ctx context.Context, remoteAddr, port string, proxys ...string) (net.PacketConn, error) {
 	// If proxies are provided, handle proxy dialing
@@ -409,8 +423,16 @@ func (rt *roundTripper) http3Dial(ctx context.Context, remoteAddr, port string,
 		return nil, fmt.Errorf("HTTP/3 proxy support not yet implemented")
 	}
 
-	// Direct UDP connection
-	conn, err := net.ListenPacket("udp", "")
+	// Direct UDP connection, bound to the configured local address if any
+	localAddr := ""
+	localIP, err := parseLocalAddr(rt.LocalAddr)
+	if err != nil {
+		return nil, err
+	}
+	if localIP != nil {
+		localAddr = net.JoinHostPort(localIP.String(), "0")
+	}
+	conn, err := net.ListenPacket("udp", localAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create UDP packet connection: %w", err)
 	}
@@ -420,12 +442,6 @@ func (rt *roundTripper) http3Dial(ctx context.Context, remoteAddr, port string,
 
 // ghttp3Dial performs standard HTTP/3 dialing using the standard QUIC implementation
 func (rt *roundTripper) ghttp3Dial(ctx context.Context, remoteAddr, port string, proxys ...string) (*HTTP3Connection, error) {
-	// Establish UDP connection
-	udpConn, err := rt.http3Dial(ctx, remoteAddr, port, proxys...)
-	if err != nil {
-		return nil, err
-	}
-
 	// Configure TLS - use crypto/tls.Config for standard QUIC (matches reference implementation)
 	var tlsConfig *tls.Config
 	if rt.TLSConfig != nil {
@@ -439,37 +455,15 @@ func (rt *roundTripper) ghttp3Dial(ctx context.Context, remoteAddr, port string,
 		tlsConfig = &tls.Config{}
 	}
 	tlsConfig.NextProtos = []string{http3.NextProtoH3}
+	if rt.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
 	if rt.ServerName != "" {
 		tlsConfig.ServerName = rt.ServerName
 	} else {
 		tlsConfig.ServerName = remoteAddr
 	}
 
-	// Resolve remote address
-	remoteHost := remoteAddr
-	if net.ParseIP(remoteAddr) == nil {
-		// If remoteAddr is not an IP, resolve it
-		ips, err := net.LookupIP(remoteAddr)
-		if err != nil {
-			udpConn.Close()
-			return nil, fmt.Errorf("failed to resolve host %s: %w", remoteAddr, err)
-		}
-		if len(ips) == 0 {
-			udpConn.Close()
-			return nil, fmt.Errorf("no IP addresses found for host %s", remoteAddr)
-		}
-		// Use the first IP address
-		remoteHost = ips[0].String()
-	}
-
-	// Convert port to integer
-	portInt := 443
-	if port != "" {
-		if p, err := net.LookupPort("tcp", port); err == nil {
-			portInt = p
-		}
-	}
-
 	// Configure QUIC - conditional setup like reference implementation
 	var quicConfig *quic.Config
 	// TODO: Add support for rt.UquicConfig when it's available
@@ -491,71 +485,38 @@ func (rt *roundTripper) ghttp3Dial(ctx context.Context, remoteAddr, port string,
 		}
 	}
 
-	// Establish QUIC connection
-	remoteUDPAddr := &net.UDPAddr{
-		IP:   net.ParseIP(remoteHost),
-		Port: portInt,
-	}
-
-	quicConn, err := quic.DialEarly(ctx, udpConn, remoteUDPAddr, tlsConfig, quicConfig)
+	// Establish QUIC connection, racing the resolved addresses
+	conn, err := rt.raceQUIC(ctx, remoteAddr, port, proxys, func(ctx context.Context, udpConn net.PacketConn, addr *net.UDPAddr) (interface{}, func(), error) {
+		quicConn, err := quic.DialEarly(ctx, udpConn, addr, tlsConfig, quicConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		return quicConn, func() { _ = quicConn.CloseWithError(0, "") }, nil
+	})
 	if err != nil {
-		udpConn.Close()
 		return nil, fmt.Errorf("failed to establish QUIC connection: %w", err)
 	}
-
-	return &HTTP3Connection{
-		QuicConn: quicConn,
-		RawConn:  udpConn,
-		Proxys:   proxys,
-		IsUQuic:  false,
-	}, nil
+	conn.IsUQuic = false
+	return conn, nil
 }
 
 // uhttp3Dial performs HTTP/3 dialing using UQuic for QUIC fingerprinting
 func (rt *roundTripper) uhttp3Dial(ctx context.Context, spec *uquic.QUICSpec, remoteAddr, port string, proxys ...string) (*HTTP3Connection, error) {
-	// Establish UDP connection
-	udpConn, err := rt.http3Dial(ctx, remoteAddr, port, proxys...)
-	if err != nil {
-		return nil, err
-	}
-
 	// Configure TLS with uTLS config - use utls.Config directly (matches reference implementation)
-	if rt.TLSConfig == nil {
-		return nil, fmt.Errorf("TLS config is required for UQuic HTTP/3")
+	tlsConfig := &utls.Config{}
+	if rt.TLSConfig != nil {
+		tlsConfig = rt.TLSConfig.Clone()
 	}
-	tlsConfig := rt.TLSConfig.Clone()
 	tlsConfig.NextProtos = []string{http3.NextProtoH3}
+	if rt.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
 	if rt.ServerName != "" {
 		tlsConfig.ServerName = rt.ServerName
 	} else {
 		tlsConfig.ServerName = remoteAddr
 	}
 
-	// Resolve remote address
-	remoteHost := remoteAddr
-	if net.ParseIP(remoteAddr) == nil {
-		// If remoteAddr is not an IP, resolve it
-		ips, err := net.LookupIP(remoteAddr)
-		if err != nil {
-			udpConn.Close()
-			return nil, fmt.Errorf("failed to resolve host %s: %w", remoteAddr, err)
-		}
-		if len(ips) == 0 {
-			udpConn.Close()
-			return nil, fmt.Errorf("no IP addresses found for host %s", remoteAddr)
-		}
-		// Use the first IP address
-		remoteHost = ips[0].String()
-	}
-
-	// Convert port to integer
-	portInt := 443
-	if port != "" {
-		if p, err := net.LookupPort("tcp", port); err == nil {
-			portInt = p
-		}
-	}
-
 	// Configure UQuic - conditional setup like reference implementation
 	var uquicConfig *uquic.Config
 	// TODO: Add support for rt.UquicConfig when it's available
@@ -564,30 +525,94 @@ func (rt *roundTripper) uhttp3Dial(ctx context.Context, spec *uquic.QUICSpec, re
 		uquicConfig = &uquic.Config{}
 	}
 
-	// Create UQuic transport
-	uTransport := &uquic.UTransport{
-		Transport: &uquic.Transport{
-			Conn: udpConn,
-		},
-		QUICSpec: spec,
+	// Establish QUIC connection with UQuic, racing the resolved addresses
+	conn, err := rt.raceQUIC(ctx, remoteAddr, port, proxys, func(ctx context.Context, udpConn net.PacketConn, addr *net.UDPAddr) (interface{}, func(), error) {
+		// Create UQuic transport
+		uTransport := &uquic.UTransport{
+			Transport: &uquic.Transport{
+				Conn: udpConn,
+			},
+			QUICSpec: spec,
+		}
+		quicConn, err := uTransport.DialEarly(ctx, addr, tlsConfig, uquicConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		return quicConn, func() { _ = quicConn.CloseWithError(0, "") }, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to establish UQuic connection: %w", err)
 	}
+	conn.IsUQuic = true
+	return conn, nil
+}
 
-	// Establish QUIC connection with UQuic
-	remoteUDPAddr := &net.UDPAddr{
-		IP:   net.ParseIP(remoteHost),
-		Port: portInt,
+// raceQUIC resolves remoteAddr and races QUIC handshakes across the resolved
+// addresses using Happy Eyeballs. Each attempt gets its own UDP socket so the
+// losing attempts can be torn down without disturbing the winner.
+func (rt *roundTripper) raceQUIC(ctx context.Context, remoteAddr, port string, proxys []string, handshake func(context.Context, net.PacketConn, *net.UDPAddr) (interface{}, func(), error)) (*HTTP3Connection, error) {
+	localIP, err := parseLocalAddr(rt.LocalAddr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &happyEyeballsDialer{
+		IPFamily:  rt.IPFamily,
+		LocalAddr: rt.LocalAddr,
+		Resolver:  newDNSResolver(rt.DNSServer),
+		HTTPSRR:   rt.httpsRR,
 	}
+	start := time.Now()
+	port, hints := dialer.httpsEndpoint(ctx, remoteAddr, port)
 
-	quicConn, err := uTransport.DialEarly(ctx, remoteUDPAddr, tlsConfig, uquicConfig)
-	if err != nil {
-		udpConn.Close()
-		return nil, fmt.Errorf("failed to establish UQuic connection: %w", err)
+	// Convert port to integer
+	portInt := 443
+	if port != "" {
+		if p, err := net.LookupPort("udp", port); err == nil {
+			portInt = p
+		}
 	}
 
-	return &HTTP3Connection{
-		QuicConn: quicConn,
-		RawConn:  udpConn,
-		Proxys:   proxys,
-		IsUQuic:  true,
-	}, nil
+	type attempt struct {
+		conn  *HTTP3Connection
+		close func()
+	}
+	var resolved time.Time
+	batches := timeResolve(dialer.resolve(ctx, remoteAddr, localIP, hints), func() { resolved = time.Now() })
+	res, err := raceConnect(ctx, batches, dialer.attemptDelay(), func(ctx context.Context, ip net.IP) (attempt, error) {
+		udpConn, err := rt.http3Dial(ctx, remoteAddr, port, proxys...)
+		if err != nil {
+			return attempt{}, err
+		}
+		quicConn, closeConn, err := handshake(ctx, udpConn, &net.UDPAddr{IP: ip, Port: portInt})
+		if err != nil {
+			udpConn.Close()
+			return attempt{}, err
+		}
+		return attempt{
+			conn: &HTTP3Connection{
+				QuicConn: quicConn,
+				RawConn:  udpConn,
+				Proxys:   proxys,
+			},
+			close: func() {
+				closeConn()
+				udpConn.Close()
+			},
+		}, nil
+	}, func(a attempt) {
+		a.close()
+	})
+	hooks := extensionsFromContext(ctx)
+	hooks.dial(ctx, "udp", net.JoinHostPort(remoteAddr, port), err)
+	if err != nil {
+		return nil, err
+	}
+	if state, _, ok := quicConnectionState(res.conn.QuicConn); ok {
+		hooks.tlsHandshake(ctx, state, nil)
+	}
+	// QUIC has no TCP connect, the handshake follows resolution
+	timings := timingsFromContext(ctx)
+	timings.add(phaseDNS, resolved.Sub(start))
+	timings.add(phaseTLS, time.Since(resolved))
+	return res.conn, nil
 }
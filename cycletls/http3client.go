@@ -0,0 +1,525 @@
+package cycletls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	http "github.com/Danny-Dasilva/fhttp"
+	"github.com/Danny-Dasilva/fhttp/httptrace"
+	"github.com/quic-go/qpack"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+	uquic "github.com/refraction-networking/uquic"
+	"golang.org/x/net/http/httpguts"
+)
+
+// HTTP/3 frame and unidirectional stream types (RFC 9114, RFC 9204)
+const (
+	http3FrameData     = 0x0
+	http3FrameHeaders  = 0x1
+	http3FrameSettings = 0x4
+
+	http3StreamControl      = 0x0
+	http3StreamQPACKEncoder = 0x2
+	http3StreamQPACKDecoder = 0x3
+)
+
+// quicStream is a bidirectional stream of either QUIC implementation
+type quicStream struct {
+	io.Reader
+	io.WriteCloser
+	id     uint64
+	cancel func() // Aborts both directions
+}
+
+// quicConn is the part of a quic-go or uquic connection HTTP/3 needs
+type quicConn struct {
+	ctx             context.Context
+	openStream      func(context.Context) (*quicStream, error)
+	openUniStream   func() (io.Writer, error)
+	acceptUniStream func(context.Context) (io.Reader, error)
+}
+
+func newQUICConn(conn interface{}) (*quicConn, error) {
+	switch c := conn.(type) {
+	case *quic.Conn:
+		return &quicConn{
+			ctx: c.Context(),
+			openStream: func(ctx context.Context) (*quicStream, error) {
+				str, err := c.OpenStreamSync(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return &quicStream{Reader: str, WriteCloser: str, id: uint64(str.StreamID()), cancel: func() {
+					str.CancelRead(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+					str.CancelWrite(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+				}}, nil
+			},
+			openUniStream: func() (io.Writer, error) {
+				str, err := c.OpenUniStream()
+				if err != nil {
+					return nil, err
+				}
+				return str, nil
+			},
+			acceptUniStream: func(ctx context.Context) (io.Reader, error) {
+				str, err := c.AcceptUniStream(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return str, nil
+			},
+		}, nil
+	case uquic.EarlyConnection:
+		return &quicConn{
+			ctx: c.Context(),
+			openStream: func(ctx context.Context) (*quicStream, error) {
+				str, err := c.OpenStreamSync(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return &quicStream{Reader: str, WriteCloser: str, id: uint64(str.StreamID()), cancel: func() {
+					str.CancelRead(uquic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+					str.CancelWrite(uquic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+				}}, nil
+			},
+			openUniStream: func() (io.Writer, error) {
+				return c.OpenUniStream()
+			},
+			acceptUniStream: func(ctx context.Context) (io.Reader, error) {
+				return c.AcceptUniStream(ctx)
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported QUIC connection type %T", conn)
+}
+
+// http3ClientConn sends requests over an established QUIC connection with
+// the SETTINGS, header order and pseudo-header order of an HTTP3Fingerprint.
+// quic-go's http3 package encodes headers in map order, so the request side
+// of HTTP/3 is written here.
+type http3ClientConn struct {
+	conn        *HTTP3Connection
+	quic        *quicConn
+	fingerprint *HTTP3Fingerprint
+	decoder     *qpackDecoder
+
+	maxFieldSectionSize uint64 // From our SETTINGS, 0 for no limit
+}
+
+// newHTTP3ClientConn opens the control stream with the fingerprint's
+// SETTINGS, then the QPACK encoder and decoder streams, in the order
+// browsers open them
+func newHTTP3ClientConn(conn *HTTP3Connection, fingerprint *HTTP3Fingerprint) (*http3ClientConn, error) {
+	qc, err := newQUICConn(conn.QuicConn)
+	if err != nil {
+		return nil, err
+	}
+	capacity, _ := fingerprint.setting(http3SettingQPACKMaxTableCapacity)
+	c := &http3ClientConn{
+		conn:        conn,
+		quic:        qc,
+		fingerprint: fingerprint,
+		decoder:     newQPACKDecoder(capacity),
+	}
+	c.maxFieldSectionSize, _ = fingerprint.setting(http3SettingMaxFieldSectionSize)
+
+	control, err := qc.openUniStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open HTTP/3 control stream: %w", err)
+	}
+	if _, err := control.Write(fingerprint.appendSettingsFrame(quicvarint.Append(nil, http3StreamControl))); err != nil {
+		return nil, fmt.Errorf("failed to send HTTP/3 SETTINGS: %w", err)
+	}
+	encoder, err := qc.openUniStream()
+	if err == nil {
+		_, err = encoder.Write(quicvarint.Append(nil, http3StreamQPACKEncoder))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open QPACK encoder stream: %w", err)
+	}
+	decoder, err := qc.openUniStream()
+	if err == nil {
+		_, err = decoder.Write(quicvarint.Append(nil, http3StreamQPACKDecoder))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open QPACK decoder stream: %w", err)
+	}
+	c.decoder.out = decoder
+
+	go c.acceptUniStreams()
+	return c, nil
+}
+
+// acceptUniStreams reads the server's unidirectional streams. Only the QPACK
+// encoder stream matters; the control and decoder streams are drained, and
+// push streams never come since no MAX_PUSH_ID is sent.
+func (c *http3ClientConn) acceptUniStreams() {
+	for {
+		str, err := c.quic.acceptUniStream(c.quic.ctx)
+		if err != nil {
+			c.decoder.fail(err)
+			return
+		}
+		go func() {
+			r := bufio.NewReader(str)
+			streamType, err := quicvarint.Read(r)
+			if err != nil {
+				return
+			}
+			if streamType == http3StreamQPACKEncoder {
+				c.decoder.readEncoderStream(r)
+				return
+			}
+			_, _ = io.Copy(io.Discard, r)
+		}()
+	}
+}
+
+// roundTrip sends req on a new request stream and reads the response
+// headers. The response body closes the connection.
+func (c *http3ClientConn) roundTrip(req *http.Request) (*http.Response, error) {
+	fields, err := c.headerFields(req)
+	if err != nil {
+		return nil, err
+	}
+	var block bytes.Buffer
+	encoder := qpack.NewEncoder(&block)
+	trace := httptrace.ContextClientTrace(req.Context())
+	for _, field := range fields {
+		if err := encoder.WriteField(field); err != nil {
+			return nil, err
+		}
+		if trace != nil && trace.WroteHeaderField != nil {
+			trace.WroteHeaderField(field.Name, []string{field.Value})
+		}
+	}
+	if trace != nil && trace.WroteHeaders != nil {
+		trace.WroteHeaders()
+	}
+
+	str, err := c.quic.openStream(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open HTTP/3 request stream: %w", err)
+	}
+	stop := context.AfterFunc(req.Context(), str.cancel)
+	fail := func(err error) (*http.Response, error) {
+		stop()
+		str.cancel()
+		return nil, err
+	}
+
+	frame := quicvarint.Append(nil, http3FrameHeaders)
+	frame = quicvarint.Append(frame, uint64(block.Len()))
+	if _, err := str.Write(append(frame, block.Bytes()...)); err != nil {
+		return fail(err)
+	}
+	if err := writeHTTP3Body(str, req.Body); err != nil {
+		return fail(err)
+	}
+	if err := str.Close(); err != nil {
+		return fail(err)
+	}
+
+	resp, err := c.readResponse(req, str, stop)
+	if err != nil {
+		return fail(err)
+	}
+	return resp, nil
+}
+
+// headerFields lists req's header fields as they are encoded: pseudo-headers
+// in PHeaderOrderKey order, or the fingerprint's without one, then headers
+// in HeaderOrderKey order, following the rules the HTTP/2 transport applies
+func (c *http3ClientConn) headerFields(req *http.Request) ([]qpack.HeaderField, error) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if h := req.Header.Get("Host"); h != "" {
+		host = h
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	pseudo := map[string]string{
+		":authority": host,
+		":method":    method,
+		":path":      req.URL.RequestURI(),
+		":scheme":    req.URL.Scheme,
+	}
+
+	order := req.Header[http.PHeaderOrderKey]
+	if len(order) == 0 {
+		order = c.fingerprint.pseudoHeaders()
+	}
+	var fields []qpack.HeaderField
+	for _, name := range append(slices.Clone(order), ":method", ":scheme", ":authority", ":path") {
+		value, ok := pseudo[name]
+		if !ok {
+			continue
+		}
+		if method == http.MethodConnect && (name == ":path" || name == ":scheme") {
+			continue
+		}
+		fields = append(fields, qpack.HeaderField{Name: name, Value: value})
+		delete(pseudo, name)
+	}
+
+	header := req.Header.Clone()
+	for name := range header {
+		if strings.EqualFold(name, "content-length") {
+			delete(header, name)
+		}
+	}
+	if req.ContentLength > 0 {
+		header["content-length"] = []string{strconv.FormatInt(req.ContentLength, 10)}
+	}
+
+	exclude := map[string]bool{http.HeaderOrderKey: true, http.PHeaderOrderKey: true}
+	var kvs []http.HeaderKeyValues
+	if headerOrder, ok := header[http.HeaderOrderKey]; ok {
+		order := make(map[string]int)
+		for i, v := range headerOrder {
+			order[v] = i
+		}
+		kvs, _ = header.SortedKeyValuesBy(order, exclude)
+	} else {
+		kvs, _ = header.SortedKeyValues(exclude)
+	}
+
+	for _, kv := range kvs {
+		name := strings.ToLower(kv.Key)
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("invalid HTTP header name %q", kv.Key)
+		}
+		values := kv.Values
+		switch name {
+		case "host", "connection", "proxy-connection", "transfer-encoding", "upgrade", "keep-alive":
+			// Host is :authority, and HTTP/3 has no connection-specific fields
+			continue
+		case "user-agent":
+			if len(values) > 1 {
+				values = values[:1]
+			}
+		case "cookie":
+			// Split into one field per pair, as RFC 9114 section 4.2.1 allows
+			var pairs []string
+			for _, v := range values {
+				for _, pair := range strings.Split(v, ";") {
+					if pair = strings.TrimSpace(pair); pair != "" {
+						pairs = append(pairs, pair)
+					}
+				}
+			}
+			values = pairs
+		}
+		for _, value := range values {
+			if !httpguts.ValidHeaderFieldValue(value) {
+				return nil, fmt.Errorf("invalid HTTP header value %q for header %q", value, kv.Key)
+			}
+			fields = append(fields, qpack.HeaderField{Name: name, Value: value})
+		}
+	}
+	return fields, nil
+}
+
+// writeHTTP3Body sends body as DATA frames
+func writeHTTP3Body(w io.Writer, body io.ReadCloser) error {
+	if body == nil || body == http.NoBody {
+		return nil
+	}
+	defer body.Close()
+	buf := make([]byte, 16<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			frame := quicvarint.Append(nil, http3FrameData)
+			frame = quicvarint.Append(frame, uint64(n))
+			if _, werr := w.Write(append(frame, buf[:n]...)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readHTTP3FrameHeader reads a frame's type and length
+func readHTTP3FrameHeader(r io.ByteReader) (frameType, length uint64, err error) {
+	if frameType, err = quicvarint.Read(r); err != nil {
+		return 0, 0, err
+	}
+	if length, err = quicvarint.Read(r); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return frameType, length, err
+}
+
+// readFieldSection reads and decodes the field section of a HEADERS frame
+func (c *http3ClientConn) readFieldSection(ctx context.Context, r *bufio.Reader, str *quicStream, length uint64) ([]qpack.HeaderField, error) {
+	if c.maxFieldSectionSize > 0 && length > c.maxFieldSectionSize {
+		return nil, fmt.Errorf("http3: HEADERS frame of %d bytes exceeds the advertised %d", length, c.maxFieldSectionSize)
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return nil, fmt.Errorf("http3: failed to read HEADERS frame: %w", err)
+	}
+	return c.decoder.decodeFieldSection(ctx, str.id, block)
+}
+
+// readResponse reads frames up to the final response's HEADERS, skipping
+// interim responses and frames of unknown types
+func (c *http3ClientConn) readResponse(req *http.Request, str *quicStream, stop func() bool) (*http.Response, error) {
+	r := bufio.NewReader(str)
+	for {
+		frameType, length, err := readHTTP3FrameHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("http3: failed to read response: %w", err)
+		}
+		switch frameType {
+		case http3FrameHeaders:
+			fields, err := c.readFieldSection(req.Context(), r, str, length)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := newHTTP3Response(req, fields)
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode >= 100 && resp.StatusCode < 200 {
+				continue
+			}
+			resp.Body = &http3Body{conn: c, r: r, str: str, resp: resp, ctx: req.Context(), stop: stop}
+			return resp, nil
+		case http3FrameData:
+			return nil, errors.New("http3: DATA frame before the response HEADERS")
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+				return nil, fmt.Errorf("http3: failed to read response: %w", err)
+			}
+		}
+	}
+}
+
+// newHTTP3Response builds the response from its header fields
+func newHTTP3Response(req *http.Request, fields []qpack.HeaderField) (*http.Response, error) {
+	resp := &http.Response{
+		Proto:         "HTTP/3.0",
+		ProtoMajor:    3,
+		Header:        http.Header{},
+		ContentLength: -1,
+		Request:       req,
+	}
+	for _, field := range fields {
+		if field.IsPseudo() {
+			if field.Name != ":status" {
+				return nil, fmt.Errorf("http3: unexpected pseudo-header %s in response", field.Name)
+			}
+			status, err := strconv.Atoi(field.Value)
+			if err != nil || len(field.Value) != 3 {
+				return nil, fmt.Errorf("http3: invalid status %q", field.Value)
+			}
+			resp.StatusCode = status
+			resp.Status = field.Value + " " + http.StatusText(status)
+			continue
+		}
+		resp.Header.Add(field.Name, field.Value)
+	}
+	if resp.StatusCode == 0 {
+		return nil, errors.New("http3: response has no :status")
+	}
+	if cl := resp.Header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			resp.ContentLength = n
+		}
+	}
+	if req.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		resp.ContentLength = 0
+	}
+	return resp, nil
+}
+
+// http3Body reads the DATA frames of a response. Trailers, sent as a final
+// HEADERS frame, are added to the response. Closing it closes the
+// connection, which carries this one request.
+type http3Body struct {
+	conn *http3ClientConn
+	r    *bufio.Reader
+	str  *quicStream
+	resp *http.Response
+	ctx  context.Context
+	stop func() bool
+
+	remaining uint64 // Left in the current DATA frame
+	err       error
+	closeOnce sync.Once
+}
+
+func (b *http3Body) Read(p []byte) (int, error) {
+	for b.remaining == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		frameType, length, err := readHTTP3FrameHeader(b.r)
+		if err != nil {
+			b.err = err
+			continue
+		}
+		switch frameType {
+		case http3FrameData:
+			b.remaining = length
+		case http3FrameHeaders:
+			fields, err := b.conn.readFieldSection(b.ctx, b.r, b.str, length)
+			if err != nil {
+				b.err = err
+				continue
+			}
+			b.resp.Trailer = http.Header{}
+			for _, field := range fields {
+				b.resp.Trailer.Add(field.Name, field.Value)
+			}
+			b.err = io.EOF
+		default:
+			if _, err := io.CopyN(io.Discard, b.r, int64(length)); err != nil {
+				b.err = err
+			}
+		}
+	}
+	if uint64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= uint64(n)
+	if err == io.EOF && b.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		b.err = err
+		return n, err
+	}
+	return n, nil
+}
+
+func (b *http3Body) Close() error {
+	b.closeOnce.Do(func() {
+		b.stop()
+		b.str.cancel()
+		b.conn.conn.Close()
+	})
+	return nil
+}
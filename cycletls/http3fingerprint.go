@@ -0,0 +1,164 @@
+package cycletls
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// HTTP/3 SETTINGS parameters CycleTLS acts on
+const (
+	http3SettingQPACKMaxTableCapacity = 0x1
+	http3SettingMaxFieldSectionSize   = 0x6
+	http3SettingQPACKBlockedStreams   = 0x7
+)
+
+// SETTINGS frames browsers send, used when no HTTP/3 fingerprint gives one
+const (
+	chromeHTTP3Settings = "1:65536;6:262144;7:100;51:1;GREASE"
+	safariHTTP3Settings = "1:16383;7:100;GREASE"
+)
+
+// HTTP3Setting is one parameter of the HTTP/3 SETTINGS frame
+type HTTP3Setting struct {
+	ID     uint64
+	Val    uint64
+	Grease bool // A reserved ID and value, drawn again for every connection
+}
+
+// HTTP3Fingerprint represents the HTTP layer of an HTTP/3 client fingerprint
+type HTTP3Fingerprint struct {
+	Settings          []HTTP3Setting
+	PseudoHeaderOrder []string // m, a, s and p as in HTTP2Fingerprint
+}
+
+// NewHTTP3Fingerprint creates a new HTTP3Fingerprint from string format
+// Format: settings|pseudoHeaderOrder
+// Example: "1:65536;6:262144;7:100;51:1;GREASE|m,a,s,p"
+// Settings are sent in the order given; GREASE stands for a reserved setting.
+// The pseudo-header order may be left empty to follow the user agent.
+func NewHTTP3Fingerprint(fingerprint string) (*HTTP3Fingerprint, error) {
+	parts := strings.Split(fingerprint, "|")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid HTTP/3 fingerprint format: expected at most 2 parts, got %d", len(parts))
+	}
+
+	fp := &HTTP3Fingerprint{}
+	if parts[0] != "" {
+		separator := ","
+		if strings.Contains(parts[0], ";") {
+			separator = ";"
+		}
+		seen := make(map[uint64]bool)
+		for _, setting := range strings.Split(parts[0], separator) {
+			if strings.EqualFold(setting, "GREASE") {
+				fp.Settings = append(fp.Settings, HTTP3Setting{Grease: true})
+				continue
+			}
+			id, val, ok := strings.Cut(setting, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid setting format: %s - expected ID:VALUE or GREASE", setting)
+			}
+			s := HTTP3Setting{}
+			var err error
+			if s.ID, err = strconv.ParseUint(id, 10, 62); err != nil {
+				return nil, fmt.Errorf("invalid setting ID: %s", setting)
+			}
+			if s.Val, err = strconv.ParseUint(val, 10, 62); err != nil {
+				return nil, fmt.Errorf("invalid setting value: %s", setting)
+			}
+			// RFC 9114 reserves the HTTP/2 setting IDs 0x0 and 0x2 to 0x5
+			if s.ID == 0 || (s.ID >= 2 && s.ID <= 5) {
+				return nil, fmt.Errorf("setting %d is reserved for HTTP/2 and not allowed in HTTP/3", s.ID)
+			}
+			if seen[s.ID] {
+				return nil, fmt.Errorf("duplicate setting %d", s.ID)
+			}
+			seen[s.ID] = true
+			fp.Settings = append(fp.Settings, s)
+		}
+	}
+
+	if len(parts) == 2 && parts[1] != "" {
+		for _, letter := range strings.Split(parts[1], ",") {
+			if _, ok := pseudoHeaderLetters[letter]; !ok {
+				return nil, fmt.Errorf("unknown pseudo-header %q in pseudo-header order, expected m, a, s or p", letter)
+			}
+			fp.PseudoHeaderOrder = append(fp.PseudoHeaderOrder, letter)
+		}
+	}
+	return fp, nil
+}
+
+// String returns the string representation of the HTTP/3 fingerprint
+func (f *HTTP3Fingerprint) String() string {
+	settings := make([]string, len(f.Settings))
+	for i, s := range f.Settings {
+		if s.Grease {
+			settings[i] = "GREASE"
+		} else {
+			settings[i] = fmt.Sprintf("%d:%d", s.ID, s.Val)
+		}
+	}
+	return strings.Join(settings, ";") + "|" + strings.Join(f.PseudoHeaderOrder, ",")
+}
+
+// setting returns the value f sends for id
+func (f *HTTP3Fingerprint) setting(id uint64) (uint64, bool) {
+	for _, s := range f.Settings {
+		if !s.Grease && s.ID == id {
+			return s.Val, true
+		}
+	}
+	return 0, false
+}
+
+// pseudoHeaders returns the pseudo-header order as header names
+func (f *HTTP3Fingerprint) pseudoHeaders() []string {
+	headers := make([]string, len(f.PseudoHeaderOrder))
+	for i, letter := range f.PseudoHeaderOrder {
+		headers[i] = pseudoHeaderLetters[letter]
+	}
+	return headers
+}
+
+// appendSettingsFrame appends the SETTINGS frame f describes to b
+func (f *HTTP3Fingerprint) appendSettingsFrame(b []byte) []byte {
+	var payload []byte
+	for _, s := range f.Settings {
+		id, val := s.ID, s.Val
+		if s.Grease {
+			// Reserved IDs are 0x1f * N + 0x21
+			id = 0x1f*uint64(rand.Int63n(1<<32)) + 0x21
+			val = uint64(rand.Int63n(1 << 32))
+		}
+		payload = quicvarint.Append(payload, id)
+		payload = quicvarint.Append(payload, val)
+	}
+	b = quicvarint.Append(b, http3FrameSettings)
+	b = quicvarint.Append(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+// http3Fingerprint parses rt.HTTP3Fingerprint. The settings and the
+// pseudo-header order of the user agent's browser fill in what it leaves out.
+func (rt *roundTripper) http3Fingerprint() (*HTTP3Fingerprint, error) {
+	fp, err := NewHTTP3Fingerprint(rt.HTTP3Fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTTP/3 fingerprint: %v", err)
+	}
+	parsedUserAgent := parseUserAgent(rt.UserAgent)
+	if len(fp.Settings) == 0 {
+		defaults, _ := NewHTTP3Fingerprint(parsedUserAgent.http3Settings())
+		fp.Settings = defaults.Settings
+	}
+	if len(fp.PseudoHeaderOrder) == 0 {
+		for _, header := range parsedUserAgent.HeaderOrder {
+			fp.PseudoHeaderOrder = append(fp.PseudoHeaderOrder, header[1:2])
+		}
+	}
+	return fp, nil
+}
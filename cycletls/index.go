@@ -5,12 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	nhttp "net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
@@ -53,18 +57,43 @@ type Cookie struct {
 
 // Options sets CycleTLS client options
 type Options struct {
-	URL       string            `json:"url"`
-	Method    string            `json:"method"`
-	Headers   map[string]string `json:"headers"`
-	Body      string            `json:"body"`
-	BodyBytes []byte            `json:"bodyBytes"` // New field for binary request data
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+
+	// Headers sent in this order, duplicates included and grouped per name;
+	// names keep the casing of their first entry on HTTP/1.1 and are
+	// lowercased on HTTP/2 and HTTP/3. Takes precedence over Headers entries
+	// with the same name.
+	OrderedHeaders [][2]string `json:"orderedHeaders"`
+	Body           string      `json:"body"`
+	BodyBytes      []byte      `json:"bodyBytes"` // New field for binary request data
 
 	// TLS fingerprinting options
-	Ja3              string `json:"ja3"`
-	Ja4r             string `json:"ja4r"` // JA4 raw format with explicit cipher/extension values
-	HTTP2Fingerprint string `json:"http2Fingerprint"`
-	QUICFingerprint  string `json:"quicFingerprint"`
-	DisableGrease    bool   `json:"disableGrease"` // Disable GREASE for exact JA4 matching
+	Ja3              string   `json:"ja3"`
+	Ja4r             string   `json:"ja4r"`        // JA4 raw format with explicit cipher/extension values
+	Ja4              string   `json:"ja4"`         // Hashed JA4, looked up in the known JA4_r database when Ja4r is empty
+	ClientHello      string   `json:"clientHello"` // Hex-encoded captured ClientHello, replayed byte for byte
+	TLSSpec          *TLSSpec `json:"tlsSpec"`     // Full ClientHello description with every extension and its parameters
+	HTTP2Fingerprint string   `json:"http2Fingerprint"`
+	HTTP3Fingerprint string   `json:"http3Fingerprint"` // HTTP/3 SETTINGS and pseudo-header order
+	QUICFingerprint  string   `json:"quicFingerprint"`
+	DisableGrease    bool     `json:"disableGrease"` // Disable GREASE for exact JA4 matching
+
+	// QUIC ClientHello for HTTP/3 when QUICFingerprint is empty
+	QUICFromUserAgent bool `json:"quicFromUserAgent"` // Send the QUIC ClientHello of the user agent's browser instead of quic-go's
+
+	// ClientHello shaping for JA3/JA4r fingerprints
+	RandomizeExtensionOrder bool              `json:"randomizeExtensionOrder"` // Shuffle extensions per connection like Chrome 110+
+	ExtensionOrderSeed      int64             `json:"extensionOrderSeed"`      // Fixed seed for a deterministic order, 0 for random
+	ExtensionData           map[string]string `json:"extensionData"`           // Hex payloads by extension ID, for unknown or overridden extensions
+	StrictExtensions        bool              `json:"strictExtensions"`        // Fail on unknown extension IDs instead of sending them empty
+	GreaseMode              string            `json:"greaseMode"`              // "auto", "on" or "off"; overrides the user agent based GREASE decision
+
+	// HTTP fingerprinting options
+	Ja4h        string `json:"ja4h"`        // JA4H or raw JA4H_r target for the method, version, cookie, referer, language and header order; a request with another method or header count fails
+	RequestMode string `json:"requestMode"` // "document", "xhr", "fetch" or "image"; adds the browser's default headers for that kind of request
+	Session     string `json:"session"`     // Requests in the same named session remember the client hints origins ask for with Accept-CH
 
 	// Browser identification
 	UserAgent string `json:"userAgent"`
@@ -78,17 +107,41 @@ type Options struct {
 	HeaderOrder        []string `json:"headerOrder"`
 	OrderAsProvided    bool     `json:"orderAsProvided"` //TODO
 	InsecureSkipVerify bool     `json:"insecureSkipVerify"`
+	IPFamily           string   `json:"ipFamily"`      // "auto" (default), "ipv4" or "ipv6"
+	LocalAddr          string   `json:"localAddr"`     // Local IP address to bind outgoing connections to
+	EnableHTTPSRR      bool     `json:"enableHttpsRR"` // Consult HTTPS DNS records for ALPN, port, address hints and ECH
+	DNSServer          string   `json:"dnsServer"`     // DNS server ("host:port") for lookups, system resolver when empty
+
+	// Redirect policy, when DisableRedirect is not set
+	MaxRedirects        int  `json:"maxRedirects"`        // Redirects to follow before failing, 10 when 0 and none when negative
+	SameHostRedirects   bool `json:"sameHostRedirects"`   // Stop at redirects to another host, returning the redirect
+	SameSchemeRedirects bool `json:"sameSchemeRedirects"` // Stop at redirects to another scheme, returning the redirect
 
 	// Protocol options
-	ForceHTTP1 bool   `json:"forceHTTP1"`
-	ForceHTTP3 bool   `json:"forceHTTP3"`
-	Protocol   string `json:"protocol"` // "http1", "http2", "http3", "websocket", "sse"
+	ForceHTTP1   bool   `json:"forceHTTP1"`
+	ForceHTTP3   bool   `json:"forceHTTP3"`
+	Protocol     string `json:"protocol"`     // "http1", "http2", "http3", "websocket", "sse"
+	EnableAltSvc bool   `json:"enableAltSvc"` // Upgrade to HTTP/3 after the origin advertises it via Alt-Svc
 
 	// TLS 1.3 specific options
 	TLS13AutoRetry bool `json:"tls13AutoRetry"` // Automatically retry with TLS 1.3 compatible curves (default: true)
 
 	// Connection reuse options
 	EnableConnectionReuse bool `json:"enableConnectionReuse"` // Enable connection reuse across requests (default: true)
+
+	// Response size limits, 0 means unlimited
+	MaxResponseBytes     int64 `json:"maxResponseBytes"`     // Maximum body bytes received on the wire
+	MaxDecompressedBytes int64 `json:"maxDecompressedBytes"` // Maximum body bytes after decompression
+
+	// Send the request's Timings in a "timings" frame before "end" over WS_PORT
+	Timings bool `json:"timings"`
+
+	// Append the request to a HAR file at this path, started over the first
+	// time it is used or after CloseHARFile. Over WS_PORT the path is
+	// relative to the server's HAR directory, see HARDirEnv.
+	HARFile string `json:"harFile"`
+
+	harDir string // The WS_PORT server's HAR directory HARFile is inside
 }
 
 type cycleTLSRequest struct {
@@ -103,6 +156,13 @@ type fullRequest struct {
 	options   cycleTLSRequest
 	sseClient *SSEClient       // For SSE connections
 	wsClient  *WebSocketClient // For WebSocket connections
+
+	fingerprints *Fingerprints    // Filled in once the response arrives
+	timings      *timingRecorder  // Times the request's phases
+	har          *harCapture      // Records the request for HAR recorders
+	extensions   *extensions      // The middleware and hooks the request runs through
+	redirects    *redirectTracker // Applies the redirect policy and records the chain
+	log          *slog.Logger     // Logs with the request's ID
 }
 
 // CycleTLS creates full request and response
@@ -110,6 +170,12 @@ type CycleTLS struct {
 	ReqChan    chan fullRequest
 	RespChan   chan Response // V1 default: chan Response for backward compatibility
 	RespChanV2 chan []byte   `json:"-"` // V2 performance: chan []byte for opt-in users
+
+	har        *HARRecorder // Records every request Do sends, set by WithHARRecorder
+	harDir     string       // Set by WithHARDir, HARDirEnv when empty
+	middleware []Middleware // Set by WithMiddleware
+	hooks      []Hooks      // Set by WithHooks
+	logger     *slog.Logger // Set by WithLogHandler, the package's logger when nil
 }
 
 // Option configures a CycleTLS client
@@ -127,19 +193,38 @@ func WithRawBytes() Option {
 
 var activeRequests = make(map[string]context.CancelFunc)
 var activeRequestsMutex sync.Mutex
-var debugLogger = log.New(os.Stdout, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile)
 
-// ready Request
-func processRequest(request cycleTLSRequest) (result fullRequest) {
-	ctx, cancel := context.WithCancel(context.Background())
+// ready Request, sent through ext's middleware and hooks. Errors are the
+// request's own, such as an invalid URL or proxy.
+func processRequest(request cycleTLSRequest, ext *extensions) (result fullRequest, err error) {
+	ja4h, err := applyJA4HOptions(&request.Options)
+	if err != nil {
+		return fullRequest{}, err
+	}
+	if err := resolveJA4(&request.Options); err != nil {
+		return fullRequest{}, err
+	}
+	if err := applyRequestMode(&request.Options); err != nil {
+		return fullRequest{}, err
+	}
+	applyClientHints(&request.Options)
 
 	var browser = Browser{
 		// TLS fingerprinting options
-		JA3:              request.Options.Ja3,
-		JA4r:             request.Options.Ja4r,
-		HTTP2Fingerprint: request.Options.HTTP2Fingerprint,
-		QUICFingerprint:  request.Options.QUICFingerprint,
-		DisableGrease:    request.Options.DisableGrease,
+		JA3:                     request.Options.Ja3,
+		JA4r:                    request.Options.Ja4r,
+		ClientHello:             request.Options.ClientHello,
+		TLSSpec:                 request.Options.TLSSpec,
+		HTTP2Fingerprint:        request.Options.HTTP2Fingerprint,
+		HTTP3Fingerprint:        request.Options.HTTP3Fingerprint,
+		QUICFingerprint:         request.Options.QUICFingerprint,
+		QUICFromUserAgent:       request.Options.QUICFromUserAgent,
+		DisableGrease:           request.Options.DisableGrease,
+		RandomizeExtensionOrder: request.Options.RandomizeExtensionOrder,
+		ExtensionOrderSeed:      request.Options.ExtensionOrderSeed,
+		ExtensionData:           request.Options.ExtensionData,
+		StrictExtensions:        request.Options.StrictExtensions,
+		GreaseMode:              request.Options.GreaseMode,
 
 		// Browser identification
 		UserAgent: request.Options.UserAgent,
@@ -148,8 +233,13 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 		ServerName:         request.Options.ServerName,
 		Cookies:            request.Options.Cookies,
 		InsecureSkipVerify: request.Options.InsecureSkipVerify,
+		IPFamily:           request.Options.IPFamily,
+		LocalAddr:          request.Options.LocalAddr,
+		EnableHTTPSRR:      request.Options.EnableHTTPSRR,
+		DNSServer:          request.Options.DNSServer,
 		ForceHTTP1:         request.Options.ForceHTTP1,
 		ForceHTTP3:         request.Options.ForceHTTP3,
+		EnableAltSvc:       request.Options.EnableAltSvc,
 
 		// TLS 1.3 specific options
 		TLS13AutoRetry: request.Options.TLS13AutoRetry,
@@ -168,7 +258,7 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 	} else if request.Options.Protocol == "http3" || request.Options.ForceHTTP3 {
 		// HTTP/3 requests are handled separately and will be implemented later
 		// HTTP/3 requests are now supported
-		return dispatchHTTP3Request(request)
+		return dispatchHTTP3Request(request, ja4h, ext)
 	}
 
 	// Default to true for connection reuse
@@ -187,7 +277,7 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 		request.Options.Proxy,
 	)
 	if err != nil {
-		log.Fatal(err)
+		return fullRequest{}, err
 	}
 
 	// Handle both string body and byte body
@@ -197,114 +287,71 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 	} else {
 		bodyReader = strings.NewReader(request.Options.Body)
 	}
-	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(request.Options.Method), request.Options.URL, bodyReader)
+	ctx, cancel := context.WithCancel(context.Background())
+	fingerprintCtx, fingerprints := WithFingerprints(ctx)
+	fingerprintCtx, timings := withTimings(fingerprintCtx)
+	recorders, err := harRecorders(nil, request.Options)
 	if err != nil {
-		log.Fatal(err)
-	}
-	headerorder := []string{}
-	//master header order, all your headers will be ordered based on this list and anything extra will be appended to the end
-	//if your site has any custom headers, see the header order chrome uses and then add those headers to this list
-	if len(request.Options.HeaderOrder) > 0 {
-		//lowercase headers
-		for _, v := range request.Options.HeaderOrder {
-			lowercasekey := strings.ToLower(v)
-			headerorder = append(headerorder, lowercasekey)
-		}
-	} else {
-		headerorder = append(headerorder,
-			"host",
-			"connection",
-			"cache-control",
-			"device-memory",
-			"viewport-width",
-			"rtt",
-			"downlink",
-			"ect",
-			"sec-ch-ua",
-			"sec-ch-ua-mobile",
-			"sec-ch-ua-full-version",
-			"sec-ch-ua-arch",
-			"sec-ch-ua-platform",
-			"sec-ch-ua-platform-version",
-			"sec-ch-ua-model",
-			"upgrade-insecure-requests",
-			"user-agent",
-			"accept",
-			"sec-fetch-site",
-			"sec-fetch-mode",
-			"sec-fetch-user",
-			"sec-fetch-dest",
-			"referer",
-			"accept-encoding",
-			"accept-language",
-			"cookie",
-		)
-	}
-
-	headermap := make(map[string]string)
-	//TODO: Shorten this
-	headerorderkey := []string{}
-	for _, key := range headerorder {
-		for k, v := range request.Options.Headers {
-			lowercasekey := strings.ToLower(k)
-			if key == lowercasekey {
-				headermap[k] = v
-				headerorderkey = append(headerorderkey, lowercasekey)
-			}
-		}
-
+		cancel()
+		return fullRequest{}, err
+	}
+	fingerprintCtx, har := withHARCapture(fingerprintCtx, recorders)
+	fingerprintCtx = withExtensions(fingerprintCtx, ext)
+	fingerprintCtx, redirects := withRedirects(fingerprintCtx, request.Options)
+	req, err := http.NewRequestWithContext(fingerprintCtx, strings.ToUpper(request.Options.Method), request.Options.URL, bodyReader)
+	if err != nil {
+		cancel()
+		return fullRequest{}, err
 	}
 	headerOrder := parseUserAgent(request.Options.UserAgent).HeaderOrder
 
 	//ordering the pseudo headers and our normal headers
-	req.Header = http.Header{
-		http.HeaderOrderKey: headerorderkey,
-	}
+	req.Header = http.Header{}
 	// Only set PHeaderOrderKey for HTTP/2, not HTTP/3
 	// HTTP/3 requests are handled by dispatchHTTP3Request() which doesn't reach this code
 	if !request.Options.ForceHTTP3 && request.Options.Protocol != "http3" {
 		req.Header[http.PHeaderOrderKey] = headerOrder
 	}
-	//set our Host header
-	u, err := url.Parse(request.Options.URL)
-	if err != nil {
-		panic(err)
-	}
-
-	//append our normal headers
-	for k, v := range request.Options.Headers {
-		if k != "Content-Length" {
-			req.Header.Set(k, v)
-		}
-	}
+	//append our normal headers, ordered by the master header order
+	setRequestHeaders(req, request.Options, masterHeaderOrder(request.Options))
 
 	// Respect user-provided Host header for domain fronting; otherwise default to URL host
-	if _, ok := request.Options.Headers["Host"]; !ok {
-		if _, ok := request.Options.Headers["host"]; !ok {
-			req.Header.Set("Host", u.Host)
-		}
+	if !hasHeader(request.Options, "Host") {
+		req.Header.Set("Host", req.URL.Host)
+	}
+	setUserAgent(req, request.Options)
+	if err := shapeJA4HRequest(req, ja4h); err != nil {
+		cancel()
+		return fullRequest{}, err
 	}
-	req.Header.Set("user-agent", request.Options.UserAgent)
 
 	activeRequestsMutex.Lock()
 	activeRequests[request.RequestID] = cancel
 	activeRequestsMutex.Unlock()
 
-	return fullRequest{req: req, client: client, options: request}
+	return fullRequest{req: req, client: client, options: request, fingerprints: fingerprints, timings: timings, har: har, extensions: ext, redirects: redirects}, nil
 }
 
-// dispatchHTTP3Request handles HTTP/3 specific request processing
-func dispatchHTTP3Request(request cycleTLSRequest) (result fullRequest) {
-	ctx, cancel := context.WithCancel(context.Background())
-
+// dispatchHTTP3Request handles HTTP/3 specific request processing, with the
+// JA4H target processRequest already applied to the options
+func dispatchHTTP3Request(request cycleTLSRequest, ja4h *JA4HComponents, ext *extensions) (result fullRequest, err error) {
 	// Create browser configuration for HTTP/3
 	var browser = Browser{
 		// TLS fingerprinting options
-		JA3:              request.Options.Ja3,
-		JA4r:             request.Options.Ja4r,
-		HTTP2Fingerprint: request.Options.HTTP2Fingerprint,
-		QUICFingerprint:  request.Options.QUICFingerprint,
-		DisableGrease:    request.Options.DisableGrease,
+		JA3:                     request.Options.Ja3,
+		JA4r:                    request.Options.Ja4r,
+		ClientHello:             request.Options.ClientHello,
+		TLSSpec:                 request.Options.TLSSpec,
+		HTTP2Fingerprint:        request.Options.HTTP2Fingerprint,
+		HTTP3Fingerprint:        request.Options.HTTP3Fingerprint,
+		QUICFingerprint:         request.Options.QUICFingerprint,
+		QUICFromUserAgent:       request.Options.QUICFromUserAgent,
+		DisableGrease:           request.Options.DisableGrease,
+		RandomizeExtensionOrder: request.Options.RandomizeExtensionOrder,
+		ExtensionOrderSeed:      request.Options.ExtensionOrderSeed,
+		ExtensionData:           request.Options.ExtensionData,
+		StrictExtensions:        request.Options.StrictExtensions,
+		GreaseMode:              request.Options.GreaseMode,
 
 		// Browser identification
 		UserAgent: request.Options.UserAgent,
@@ -313,6 +360,10 @@ func dispatchHTTP3Request(request cycleTLSRequest) (result fullRequest) {
 		ServerName:         request.Options.ServerName,
 		Cookies:            request.Options.Cookies,
 		InsecureSkipVerify: request.Options.InsecureSkipVerify,
+		IPFamily:           request.Options.IPFamily,
+		LocalAddr:          request.Options.LocalAddr,
+		EnableHTTPSRR:      request.Options.EnableHTTPSRR,
+		DNSServer:          request.Options.DNSServer,
 		ForceHTTP1:         false, // Force HTTP/3
 		ForceHTTP3:         true,  // Force HTTP/3
 
@@ -339,7 +390,7 @@ func dispatchHTTP3Request(request cycleTLSRequest) (result fullRequest) {
 		request.Options.Proxy,
 	)
 	if err != nil {
-		log.Fatal(err)
+		return fullRequest{}, err
 	}
 
 	// Handle both string body and byte body
@@ -349,50 +400,60 @@ func dispatchHTTP3Request(request cycleTLSRequest) (result fullRequest) {
 	} else {
 		bodyReader = strings.NewReader(request.Options.Body)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, timings := withTimings(ctx)
+	recorders, err := harRecorders(nil, request.Options)
+	if err != nil {
+		cancel()
+		return fullRequest{}, err
+	}
+	ctx, har := withHARCapture(ctx, recorders)
+	ctx = withExtensions(ctx, ext)
+	ctx, redirects := withRedirects(ctx, request.Options)
 	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(request.Options.Method), request.Options.URL, bodyReader)
 	if err != nil {
-		log.Fatal(err)
+		cancel()
+		return fullRequest{}, err
 	}
 
 	// Set headers for HTTP/3 request
-	for k, v := range request.Options.Headers {
-		if k != "Content-Length" {
-			req.Header.Set(k, v)
-		}
-	}
+	setRequestHeaders(req, request.Options, masterHeaderOrder(request.Options))
 
-	// Parse URL for Host header
-	u, err := url.Parse(request.Options.URL)
-	if err != nil {
-		panic(err)
-	}
 	// Respect user-provided Host header for domain fronting; otherwise default to URL host
-	if _, ok := request.Options.Headers["Host"]; !ok {
-		if _, ok := request.Options.Headers["host"]; !ok {
-			req.Header.Set("Host", u.Host)
-		}
+	if !hasHeader(request.Options, "Host") {
+		req.Header.Set("Host", req.URL.Host)
+	}
+	setUserAgent(req, request.Options)
+	if err := shapeJA4HRequest(req, ja4h); err != nil {
+		cancel()
+		return fullRequest{}, err
 	}
-	req.Header.Set("user-agent", request.Options.UserAgent)
 
 	activeRequestsMutex.Lock()
 	activeRequests[request.RequestID] = cancel
 	activeRequestsMutex.Unlock()
 
-	return fullRequest{req: req, client: client, options: request}
+	return fullRequest{req: req, client: client, options: request, timings: timings, har: har, extensions: ext, redirects: redirects}, nil
 }
 
 // dispatchSSERequest handles SSE specific request processing
-func dispatchSSERequest(request cycleTLSRequest) (result fullRequest) {
-	ctx, cancel := context.WithCancel(context.Background())
+func dispatchSSERequest(request cycleTLSRequest) (result fullRequest, err error) {
 
 	// Create browser configuration for SSE
 	var browser = Browser{
 		// TLS fingerprinting options
-		JA3:              request.Options.Ja3,
-		JA4r:             request.Options.Ja4r,
-		HTTP2Fingerprint: request.Options.HTTP2Fingerprint,
-		QUICFingerprint:  request.Options.QUICFingerprint,
-		DisableGrease:    request.Options.DisableGrease,
+		JA3:                     request.Options.Ja3,
+		JA4r:                    request.Options.Ja4r,
+		ClientHello:             request.Options.ClientHello,
+		TLSSpec:                 request.Options.TLSSpec,
+		HTTP2Fingerprint:        request.Options.HTTP2Fingerprint,
+		QUICFingerprint:         request.Options.QUICFingerprint,
+		DisableGrease:           request.Options.DisableGrease,
+		RandomizeExtensionOrder: request.Options.RandomizeExtensionOrder,
+		ExtensionOrderSeed:      request.Options.ExtensionOrderSeed,
+		ExtensionData:           request.Options.ExtensionData,
+		StrictExtensions:        request.Options.StrictExtensions,
+		GreaseMode:              request.Options.GreaseMode,
 
 		// Browser identification
 		UserAgent: request.Options.UserAgent,
@@ -401,6 +462,10 @@ func dispatchSSERequest(request cycleTLSRequest) (result fullRequest) {
 		ServerName:         request.Options.ServerName,
 		Cookies:            request.Options.Cookies,
 		InsecureSkipVerify: request.Options.InsecureSkipVerify,
+		IPFamily:           request.Options.IPFamily,
+		LocalAddr:          request.Options.LocalAddr,
+		EnableHTTPSRR:      request.Options.EnableHTTPSRR,
+		DNSServer:          request.Options.DNSServer,
 		ForceHTTP1:         request.Options.ForceHTTP1,
 		ForceHTTP3:         request.Options.ForceHTTP3,
 
@@ -427,7 +492,7 @@ func dispatchSSERequest(request cycleTLSRequest) (result fullRequest) {
 		request.Options.Proxy,
 	)
 	if err != nil {
-		log.Fatal(err)
+		return fullRequest{}, err
 	}
 
 	// Prepare headers for SSE
@@ -440,9 +505,11 @@ func dispatchSSERequest(request cycleTLSRequest) (result fullRequest) {
 	sseClient := NewSSEClient(&client, headers)
 
 	// Create a placeholder request for consistency
+	ctx, cancel := context.WithCancel(context.Background())
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, request.Options.URL, nil)
 	if err != nil {
-		log.Fatal(err)
+		cancel()
+		return fullRequest{}, err
 	}
 
 	activeRequestsMutex.Lock()
@@ -454,21 +521,27 @@ func dispatchSSERequest(request cycleTLSRequest) (result fullRequest) {
 		client:    client,
 		options:   request,
 		sseClient: sseClient,
-	}
+	}, nil
 }
 
 // dispatchWebSocketRequest handles WebSocket specific request processing
-func dispatchWebSocketRequest(request cycleTLSRequest) (result fullRequest) {
-	ctx, cancel := context.WithCancel(context.Background())
+func dispatchWebSocketRequest(request cycleTLSRequest) (result fullRequest, err error) {
 
 	// Create browser configuration for WebSocket
 	var browser = Browser{
 		// TLS fingerprinting options
-		JA3:              request.Options.Ja3,
-		JA4r:             request.Options.Ja4r,
-		HTTP2Fingerprint: request.Options.HTTP2Fingerprint,
-		QUICFingerprint:  request.Options.QUICFingerprint,
-		DisableGrease:    request.Options.DisableGrease,
+		JA3:                     request.Options.Ja3,
+		JA4r:                    request.Options.Ja4r,
+		ClientHello:             request.Options.ClientHello,
+		TLSSpec:                 request.Options.TLSSpec,
+		HTTP2Fingerprint:        request.Options.HTTP2Fingerprint,
+		QUICFingerprint:         request.Options.QUICFingerprint,
+		DisableGrease:           request.Options.DisableGrease,
+		RandomizeExtensionOrder: request.Options.RandomizeExtensionOrder,
+		ExtensionOrderSeed:      request.Options.ExtensionOrderSeed,
+		ExtensionData:           request.Options.ExtensionData,
+		StrictExtensions:        request.Options.StrictExtensions,
+		GreaseMode:              request.Options.GreaseMode,
 
 		// Browser identification
 		UserAgent: request.Options.UserAgent,
@@ -476,6 +549,10 @@ func dispatchWebSocketRequest(request cycleTLSRequest) (result fullRequest) {
 		// Connection options
 		Cookies:            request.Options.Cookies,
 		InsecureSkipVerify: request.Options.InsecureSkipVerify,
+		IPFamily:           request.Options.IPFamily,
+		LocalAddr:          request.Options.LocalAddr,
+		EnableHTTPSRR:      request.Options.EnableHTTPSRR,
+		DNSServer:          request.Options.DNSServer,
 		ForceHTTP1:         request.Options.ForceHTTP1,
 		ForceHTTP3:         false, // WebSocket doesn't support HTTP/3
 
@@ -503,9 +580,11 @@ func dispatchWebSocketRequest(request cycleTLSRequest) (result fullRequest) {
 	wsClient := NewWebSocketClient(tlsConfig, convertedHeaders)
 
 	// Create a placeholder request for consistency
+	ctx, cancel := context.WithCancel(context.Background())
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, request.Options.URL, nil)
 	if err != nil {
-		log.Fatal(err)
+		cancel()
+		return fullRequest{}, err
 	}
 
 	activeRequestsMutex.Lock()
@@ -517,7 +596,7 @@ func dispatchWebSocketRequest(request cycleTLSRequest) (result fullRequest) {
 		client:   http.Client{}, // Empty client as WebSocket uses its own dialer
 		options:  request,
 		wsClient: wsClient,
-	}
+	}, nil
 }
 
 // // Queue queues request in worker pool
@@ -640,6 +719,8 @@ func dispatcherAsync(res fullRequest, chanWrite chan []byte) {
 	resp, err := res.client.Do(res.req)
 
 	if err != nil {
+		res.har.finish(err)
+		res.extensions.fail(res.req, err)
 		parsedError := parseError(err)
 
 		{
@@ -669,6 +750,7 @@ func dispatcherAsync(res fullRequest, chanWrite chan []byte) {
 	}
 
 	defer resp.Body.Close()
+	recordAcceptCH(res.options.Options, resp)
 
 	// Update finalUrl if redirect occurred
 	if resp != nil && resp.Request != nil && resp.Request.URL != nil {
@@ -718,9 +800,38 @@ func dispatcherAsync(res fullRequest, chanWrite chan []byte) {
 			}
 		}
 
+		// Fingerprints the request went out with, as JSON, when recorded. An
+		// empty string stands in for them when only redirects follow.
+		var fingerprints []byte
+		if res.fingerprints != nil && res.fingerprints.JA4H != "" {
+			fingerprints, _ = json.Marshal(res.fingerprints)
+		}
+		redirects := res.redirects.list()
+		if len(fingerprints) > 0 || len(redirects) > 0 {
+			b.WriteByte(byte(len(fingerprints) >> 8))
+			b.WriteByte(byte(len(fingerprints)))
+			b.Write(fingerprints)
+		}
+
+		// Redirects followed on the way, as JSON with a 32-bit length
+		if len(redirects) > 0 {
+			if data, err := json.Marshal(redirects); err == nil {
+				b.WriteByte(byte(len(data) >> 24))
+				b.WriteByte(byte(len(data) >> 16))
+				b.WriteByte(byte(len(data) >> 8))
+				b.WriteByte(byte(len(data)))
+				b.Write(data)
+			}
+		}
+
 		chanWrite <- b.Bytes()
 	}
 
+	// Decode Content-Encoding chunk by chunk as the body streams, enforcing the size limits
+	body := newResponseBodyReader(resp.Body, resp.Header["Content-Encoding"], res.options.Options.MaxResponseBytes, res.options.Options.MaxDecompressedBytes)
+	defer body.Close()
+
+	var bodyErr error
 	{
 		bufferSize := 8192
 		chunkBuffer := make([]byte, bufferSize)
@@ -729,20 +840,21 @@ func dispatcherAsync(res fullRequest, chanWrite chan []byte) {
 		for {
 			select {
 			case <-res.req.Context().Done():
-				debugLogger.Printf("Request %s was canceled during processing", res.options.RequestID)
+				res.log.Debug("Request was canceled during processing")
 				break loop
 
 			default:
-				n, err := resp.Body.Read(chunkBuffer)
+				n, err := body.Read(chunkBuffer)
+				res.har.write(chunkBuffer[:n])
 
 				if res.req.Context().Err() != nil {
-					debugLogger.Printf("Request %s was canceled during body read", res.options.RequestID)
+					res.log.Debug("Request was canceled during body read")
 					break loop
 				}
 
 				if err != nil && err != io.EOF {
-					// Log to stdout instead of stderr to avoid process restart
-					debugLogger.Printf("Read error: %s", err.Error())
+					bodyErr = err
+					res.log.Warn("Response body read failed", "err", err)
 
 					// Send error frame before breaking
 					parsedError := parseError(err)
@@ -820,6 +932,32 @@ func dispatcherAsync(res fullRequest, chanWrite chan []byte) {
 		}
 	}
 
+	if bodyErr == nil {
+		bodyErr = res.req.Context().Err()
+	}
+	res.har.finish(bodyErr)
+	res.extensions.fail(res.req, bodyErr)
+
+	// Timings, as JSON, once the body has been read
+	if res.options.Options.Timings {
+		if data, err := json.Marshal(res.timings.finish()); err == nil {
+			var b bytes.Buffer
+			requestIDLength := len(res.options.RequestID)
+
+			b.WriteByte(byte(requestIDLength >> 8))
+			b.WriteByte(byte(requestIDLength))
+			b.WriteString(res.options.RequestID)
+			b.WriteByte(0)
+			b.WriteByte(7)
+			b.WriteString("timings")
+			b.WriteByte(byte(len(data) >> 8))
+			b.WriteByte(byte(len(data)))
+			b.Write(data)
+
+			chanWrite <- b.Bytes()
+		}
+	}
+
 	{
 		var b bytes.Buffer
 		requestIDLength := len(res.options.RequestID)
@@ -919,21 +1057,22 @@ func dispatchSSEAsync(res fullRequest, chanWrite chan []byte) {
 	}
 
 	// Read SSE events
+loop:
 	for {
 		select {
 		case <-res.req.Context().Done():
-			debugLogger.Printf("SSE request %s was canceled", res.options.RequestID)
-			break
+			res.log.Debug("SSE request was canceled")
+			break loop
 
 		default:
 			event, err := sseResp.NextEvent()
 			if err != nil {
 				if err == io.EOF {
 					// Normal end of stream
-					break
+					break loop
 				}
-				debugLogger.Printf("SSE read error: %s", err.Error())
-				break
+				res.log.Warn("SSE read failed", "err", err)
+				break loop
 			}
 
 			if event == nil {
@@ -950,7 +1089,7 @@ func dispatchSSEAsync(res fullRequest, chanWrite chan []byte) {
 
 			eventBytes, err := json.Marshal(eventData)
 			if err != nil {
-				debugLogger.Printf("SSE event marshal error: %s", err.Error())
+				res.log.Warn("SSE event marshal failed", "err", err)
 				continue
 			}
 
@@ -1118,15 +1257,16 @@ func dispatchWebSocketAsync(res fullRequest, chanWrite chan []byte) {
 	if res.options.Options.Body != "" {
 		err := conn.WriteMessage(websocket.TextMessage, []byte(res.options.Options.Body))
 		if err != nil {
-			debugLogger.Printf("WebSocket write error: %s", err.Error())
+			res.log.Warn("WebSocket write failed", "err", err)
 		}
 	}
 
 	// Read WebSocket messages
+loop:
 	for {
 		select {
 		case <-res.req.Context().Done():
-			debugLogger.Printf("WebSocket request %s was canceled", res.options.RequestID)
+			res.log.Debug("WebSocket request was canceled")
 			return
 
 		default:
@@ -1134,9 +1274,9 @@ func dispatchWebSocketAsync(res fullRequest, chanWrite chan []byte) {
 			if err != nil {
 				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 					// Normal close
-					break
+					break loop
 				}
-				debugLogger.Printf("WebSocket read error: %s", err.Error())
+				res.log.Warn("WebSocket read failed", "err", err)
 				return
 			}
 
@@ -1149,7 +1289,7 @@ func dispatchWebSocketAsync(res fullRequest, chanWrite chan []byte) {
 
 			msgBytes, err := json.Marshal(msgData)
 			if err != nil {
-				debugLogger.Printf("WebSocket message marshal error: %s", err.Error())
+				res.log.Warn("WebSocket message marshal failed", "err", err)
 				continue
 			}
 
@@ -1190,31 +1330,58 @@ func dispatchWebSocketAsync(res fullRequest, chanWrite chan []byte) {
 	}
 }
 
-func writeSocket(chanWrite chan []byte, wsSocket *websocket.Conn) {
+func writeSocket(chanWrite chan []byte, wsSocket *websocket.Conn, log *slog.Logger) {
 	for buf := range chanWrite {
 		err := wsSocket.WriteMessage(websocket.BinaryMessage, buf)
 
 		if err != nil {
-			log.Print("Socket WriteMessage Failed" + err.Error())
+			log.Error("Socket write failed", "err", err)
 			continue
 		}
 	}
 }
 
-func readSocket(chanRead chan fullRequest, wsSocket *websocket.Conn) {
+// diagnosticsFrame reports fingerprint diagnostics as a 400 error frame
+func diagnosticsFrame(requestID string, diags []Diagnostic) []byte {
+	lines := make([]string, len(diags))
+	for i, d := range diags {
+		lines[i] = d.String()
+	}
+	return errorFrame(requestID, 400, "Invalid fingerprint options-> \n"+strings.Join(lines, "\n"))
+}
+
+// errorFrame ends a request with an error frame
+func errorFrame(requestID string, statusCode int, message string) []byte {
+	var b bytes.Buffer
+	b.WriteByte(byte(len(requestID) >> 8))
+	b.WriteByte(byte(len(requestID)))
+	b.WriteString(requestID)
+	b.WriteByte(0)
+	b.WriteByte(5)
+	b.WriteString("error")
+	b.WriteByte(byte(statusCode >> 8))
+	b.WriteByte(byte(statusCode))
+	b.WriteByte(byte(len(message) >> 8))
+	b.WriteByte(byte(len(message)))
+	b.WriteString(message)
+	return b.Bytes()
+}
+
+func readSocket(chanRead chan fullRequest, chanWrite chan []byte, wsSocket *websocket.Conn, ext *extensions, harDir string, log *slog.Logger) {
 	for {
 		_, message, err := wsSocket.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				return
 			}
-			log.Print("Socket Error", err)
+			log.Error("Socket read failed", "err", err)
 			return
 		}
 		var baseMessage map[string]interface{}
 		if err := json.Unmarshal(message, &baseMessage); err != nil {
-			log.Print("Unmarshal Error", err)
-			return
+			// Without a request ID there is no request to fail
+			log.Warn("Dropped a message that is not JSON", "err", err)
+			continue
 		}
 		if action, ok := baseMessage["action"]; ok {
 			if action == "exit" {
@@ -1233,24 +1400,77 @@ func readSocket(chanRead chan fullRequest, wsSocket *websocket.Conn) {
 				activeRequestsMutex.Unlock()
 				continue
 			}
+			if action == "closeHarFile" {
+				name, _ := baseMessage["harFile"].(string)
+				if checkHARFile(harDir, name) == nil {
+					if err := CloseHARFile(filepath.Join(harDir, name)); err != nil {
+						log.Warn("Failed to close HAR file", "path", name, "err", err)
+					}
+				}
+				continue
+			}
 		}
 		// (If there was no "action" field, process as usual)
 		request := new(cycleTLSRequest)
 		if err := json.Unmarshal(message, &request); err != nil {
-			log.Print("Unmarshal Error", err)
-			return
+			requestID, _ := baseMessage["requestId"].(string)
+			requestLog := log.With("requestId", requestID)
+			requestLog.Warn("Invalid request", "err", err)
+			chanWrite <- errorFrame(requestID, 400, "Invalid request-> \n"+err.Error())
+			continue
+		}
+		requestLog := log.With("requestId", request.RequestID)
+		// Only write HAR files inside the HAR directory, as anything that can
+		// reach the port can send requests
+		if request.Options.HARFile != "" {
+			if err := checkHARFile(harDir, request.Options.HARFile); err != nil {
+				requestLog.Warn("Invalid request", "err", err)
+				chanWrite <- errorFrame(request.RequestID, 400, "Invalid request-> \n"+err.Error())
+				continue
+			}
+			request.Options.harDir = harDir
 		}
-		chanRead <- processRequest(*request)
+		// Reject broken fingerprints before dialing
+		if diags := ValidateFingerprint(request.Options); len(diags) > 0 {
+			if HasErrors(diags) {
+				requestLog.Warn("Invalid fingerprint options", "diagnostics", len(diags))
+				chanWrite <- diagnosticsFrame(request.RequestID, diags)
+				continue
+			}
+			for _, d := range diags {
+				requestLog.Warn("Fingerprint", "diagnostic", d.String())
+			}
+		}
+		res, err := processRequest(*request, ext)
+		if err != nil {
+			requestLog.Warn("Invalid request", "err", err)
+			chanWrite <- errorFrame(request.RequestID, 400, "Invalid request-> \n"+err.Error())
+			continue
+		}
+		res.log = requestLog
+		chanRead <- res
 	}
 }
 
 // Worker
 func readProcess(chanRead chan fullRequest, chanWrite chan []byte) {
 	for request := range chanRead {
-		go dispatcherAsync(request, chanWrite)
+		go dispatch(request, chanWrite)
 	}
 }
 
+// dispatch sends the request, failing it rather than the server when it
+// panics
+func dispatch(res fullRequest, chanWrite chan []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			res.log.Error("Request panicked", "panic", r, "stack", string(debug.Stack()))
+			chanWrite <- errorFrame(res.options.RequestID, 500, fmt.Sprintf("Request failed-> \n%v", r))
+		}
+	}()
+	dispatcherAsync(res, chanWrite)
+}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -1258,42 +1478,47 @@ var upgrader = websocket.Upgrader{
 
 // WSEndpoint exports the main cycletls function as we websocket connection that clients can connect to
 func WSEndpoint(w nhttp.ResponseWriter, r *nhttp.Request) {
-	upgrader.CheckOrigin = func(r *nhttp.Request) bool { return true }
+	CycleTLS{}.ServeHTTP(w, r)
+}
 
+// ServeHTTP serves the WS_PORT protocol like WSEndpoint, sending requests
+// through the client's middleware and hooks
+func (client CycleTLS) ServeHTTP(w nhttp.ResponseWriter, r *nhttp.Request) {
 	// upgrade this connection to a WebSocket
 	// connection
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		//Golang Received a non-standard request to this port, printing request
 		var data map[string]interface{}
+		log := logger(client.logger)
 		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			log.Print("Invalid Request: Body Read Error" + err.Error())
+			log.Warn("Invalid Request: Body Read Error", "err", err)
 		}
 		err = json.Unmarshal(bodyBytes, &data)
 		if err != nil {
-			log.Print("Invalid Request: Json Conversion failed ")
+			log.Warn("Invalid Request: Json Conversion failed", "err", err)
 		}
 		body, err := PrettyStruct(data)
 		if err != nil {
-			log.Print("Invalid Request:", err)
+			log.Warn("Invalid Request", "err", err)
 		}
 		headers, err := PrettyStruct(r.Header)
 		if err != nil {
-			log.Fatal(err)
+			log.Warn("Invalid Request", "err", err)
 		}
-		log.Println(headers)
-		log.Println(body)
+		log.Warn("Received a request that is not a WebSocket upgrade", "headers", headers, "body", body)
 
 	} else {
 		chanRead := make(chan fullRequest)
 		chanWrite := make(chan []byte)
+		log := logger(client.logger)
 
-		go readSocket(chanRead, ws)
+		go readSocket(chanRead, chanWrite, ws, client.extensions(), client.harDirectory(), log)
 		go readProcess(chanRead, chanWrite)
 
 		// Run as main thread
-		writeSocket(chanWrite, ws)
+		writeSocket(chanWrite, ws, log)
 	}
 }
 
@@ -1325,6 +1550,15 @@ type Response struct {
 	Headers   map[string]string `json:"headers"`
 	Cookies   []*nhttp.Cookie   `json:"cookies"`
 	FinalUrl  string            `json:"finalUrl"`
+
+	// Fingerprints the request was sent with, nil when not recorded
+	Fingerprints *Fingerprints `json:"fingerprints,omitempty"`
+
+	// Where the request's time went, nil when not recorded
+	Timings *Timings `json:"timings,omitempty"`
+
+	// Redirects followed on the way to this response, in order
+	Redirects []Redirect `json:"redirects,omitempty"`
 }
 
 // JSONBody parses the response body as JSON
@@ -1372,22 +1606,53 @@ func (client CycleTLS) Close() {
 	}
 	// Clear all connections from the global pool
 	clearAllConnections()
+	globalClientHintCache.clear()
 }
 
 // Do creates a single HTTP request for integration tests
 func (client CycleTLS) Do(URL string, options Options, Method string) (Response, error) {
+	options.Method = Method
+	if options.URL == "" {
+		options.URL = URL
+	}
+	ja4h, err := applyJA4HOptions(&options)
+	if err != nil {
+		return Response{}, err
+	}
+	if err := resolveJA4(&options); err != nil {
+		return Response{}, err
+	}
+	if err := applyRequestMode(&options); err != nil {
+		return Response{}, err
+	}
+	applyClientHints(&options)
+
 	// Create browser from options
 	browser := Browser{
-		JA3:                options.Ja3,
-		JA4r:               options.Ja4r,
-		HTTP2Fingerprint:   options.HTTP2Fingerprint,
-		QUICFingerprint:    options.QUICFingerprint,
-		UserAgent:          options.UserAgent,
-		Cookies:            options.Cookies,
-		InsecureSkipVerify: options.InsecureSkipVerify,
-		ForceHTTP1:         options.ForceHTTP1,
-		ForceHTTP3:         options.ForceHTTP3,
-		HeaderOrder:        options.HeaderOrder,
+		JA3:                     options.Ja3,
+		JA4r:                    options.Ja4r,
+		ClientHello:             options.ClientHello,
+		TLSSpec:                 options.TLSSpec,
+		HTTP2Fingerprint:        options.HTTP2Fingerprint,
+		HTTP3Fingerprint:        options.HTTP3Fingerprint,
+		QUICFingerprint:         options.QUICFingerprint,
+		QUICFromUserAgent:       options.QUICFromUserAgent,
+		UserAgent:               options.UserAgent,
+		RandomizeExtensionOrder: options.RandomizeExtensionOrder,
+		ExtensionOrderSeed:      options.ExtensionOrderSeed,
+		ExtensionData:           options.ExtensionData,
+		StrictExtensions:        options.StrictExtensions,
+		GreaseMode:              options.GreaseMode,
+		Cookies:                 options.Cookies,
+		InsecureSkipVerify:      options.InsecureSkipVerify,
+		IPFamily:                options.IPFamily,
+		LocalAddr:               options.LocalAddr,
+		EnableHTTPSRR:           options.EnableHTTPSRR,
+		DNSServer:               options.DNSServer,
+		ForceHTTP1:              options.ForceHTTP1,
+		ForceHTTP3:              options.ForceHTTP3,
+		EnableAltSvc:            options.EnableAltSvc,
+		HeaderOrder:             options.HeaderOrder,
 	}
 
 	// Note: Don't automatically set HeaderOrder from UserAgent here as it can interfere with connection management
@@ -1420,7 +1685,16 @@ func (client CycleTLS) Do(URL string, options Options, Method string) (Response,
 	} else {
 		bodyReader = strings.NewReader(options.Body)
 	}
-	req, err := http.NewRequest(Method, URL, bodyReader)
+	ctx, fingerprints := WithFingerprints(context.Background())
+	ctx, timings := withTimings(ctx)
+	recorders, err := harRecorders(client.har, options)
+	if err != nil {
+		return Response{}, err
+	}
+	ctx, har := withHARCapture(ctx, recorders)
+	ctx = withExtensions(ctx, client.extensions())
+	ctx, redirects := withRedirects(ctx, options)
+	req, err := http.NewRequestWithContext(ctx, options.Method, URL, bodyReader)
 	if err != nil {
 		return Response{}, err
 	}
@@ -1434,35 +1708,41 @@ func (client CycleTLS) Do(URL string, options Options, Method string) (Response,
 		req.Header[http.PHeaderOrderKey] = headerOrder
 	}
 
-	// Set headers
-	for k, v := range options.Headers {
-		req.Header.Set(k, v)
+	// Set headers, in order only when OrderedHeaders or RequestMode asks for it
+	var masterOrder []string
+	if options.RequestMode != "" {
+		masterOrder = masterHeaderOrder(options)
+	}
+	setRequestHeaders(req, options, masterOrder)
+	if err := shapeJA4HRequest(req, ja4h); err != nil {
+		return Response{}, err
 	}
 
 	// Make request
 	resp, err := httpClient.Do(req)
 	if err != nil {
+		har.finish(err)
+		client.extensions().fail(req, err)
 		parsedError := parseError(err)
 		return Response{
-			Status: parsedError.StatusCode,
-			Body:   parsedError.ErrorMsg + " -> " + err.Error(),
+			Status:    parsedError.StatusCode,
+			Body:      parsedError.ErrorMsg + " -> " + err.Error(),
+			Timings:   timings.finish(),
+			Redirects: redirects.list(),
 		}, nil
 	}
 	defer resp.Body.Close()
+	recordAcceptCH(options, resp)
 
-	// Read body
-	bodyBytes, err := io.ReadAll(resp.Body)
+	// Read body, decompressing automatically (axios-style) as it streams
+	bodyBytes, err := readResponseBody(resp.Body, resp.Header["Content-Encoding"], options.MaxResponseBytes, options.MaxDecompressedBytes)
+	har.write(bodyBytes)
+	har.finish(err)
+	client.extensions().fail(req, err)
 	if err != nil {
 		return Response{}, err
 	}
-
-	// Automatic decompression (axios-style) - check Content-Encoding header
-	encoding := resp.Header["Content-Encoding"]
-	content := resp.Header["Content-Type"]
-	if len(encoding) > 0 {
-		// Automatically decompress the body like axios does
-		bodyBytes = DecompressBody(bodyBytes, encoding, content)
-	}
+	timingsResult := timings.finish()
 
 	// Convert headers
 	headers := make(map[string]string)
@@ -1478,32 +1758,19 @@ func (client CycleTLS) Do(URL string, options Options, Method string) (Response,
 		finalUrl = resp.Request.URL.String()
 	}
 
-	// Convert fhttp cookies to net/http cookies
-	var netCookies []*nhttp.Cookie
-	for _, cookie := range resp.Cookies() {
-		netCookie := &nhttp.Cookie{
-			Name:       cookie.Name,
-			Value:      cookie.Value,
-			Path:       cookie.Path,
-			Domain:     cookie.Domain,
-			Expires:    cookie.Expires,
-			RawExpires: cookie.RawExpires,
-			MaxAge:     cookie.MaxAge,
-			Secure:     cookie.Secure,
-			HttpOnly:   cookie.HttpOnly,
-			SameSite:   nhttp.SameSite(cookie.SameSite),
-			Raw:        cookie.Raw,
-			Unparsed:   cookie.Unparsed,
-		}
-		netCookies = append(netCookies, netCookie)
+	if fingerprints.JA4H == "" {
+		fingerprints = nil
 	}
 
 	return Response{
-		Status:    resp.StatusCode,
-		Body:      string(bodyBytes),
-		BodyBytes: bodyBytes, // Provide raw bytes for binary data
-		Headers:   headers,
-		Cookies:   netCookies,
-		FinalUrl:  finalUrl,
+		Status:       resp.StatusCode,
+		Body:         string(bodyBytes),
+		BodyBytes:    bodyBytes, // Provide raw bytes for binary data
+		Headers:      headers,
+		Cookies:      netCookies(resp.Cookies()),
+		FinalUrl:     finalUrl,
+		Fingerprints: fingerprints,
+		Timings:      timingsResult,
+		Redirects:    redirects.list(),
 	}, nil
 }
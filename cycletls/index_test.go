@@ -0,0 +1,23 @@
+package cycletls
+
+import "testing"
+
+func TestProcessRequest_ReturnsOptionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+	}{
+		{"ja4h", Options{Ja4h: "zz11nn05enus_e8a4ba4b5d9c_000000000000_000000000000", Method: "GET"}},
+		{"ja4h method", Options{Ja4h: "po11nn05enus_e8a4ba4b5d9c_000000000000_000000000000", Method: "GET"}},
+		{"ja4", Options{Ja4: "t13d0000h2_000000000000_000000000000"}},
+		{"requestMode", Options{RequestMode: "video"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.URL = "https://example.com/"
+			if _, err := processRequest(cycleTLSRequest{RequestID: "1", Options: tt.options}, nil); err == nil {
+				t.Error("expected an error for the invalid option")
+			}
+		})
+	}
+}
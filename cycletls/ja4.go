@@ -1,140 +1,436 @@
 package cycletls
 
 import (
+	"crypto/md5"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"net/http"
-	"net/textproto"
+	"net"
+	nhttp "net/http"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 
+	http "github.com/Danny-Dasilva/fhttp"
 	http2 "github.com/Danny-Dasilva/fhttp/http2"
+	utls "github.com/refraction-networking/utls"
 )
 
-// JA4 is a TLS client fingerprinting technique that captures both TLS and HTTP fingerprints
-// Format: <TLS version><TLS ciphers hash>_<TLS extensions hash>_<HTTP headers hash>_<User-Agent hash>
-// Example: t13d_cd89_1952_bb99
+// Fingerprints are the client fingerprints of a request, computed from the
+// ClientHello and HTTP/2 frames the connection actually sent.
+// JA4 and JA4H follow the FoxIO specification, e.g. t13d1516h2_8daaf6152771_e5627efa2ab1.
+type Fingerprints struct {
+	JA3        string `json:"ja3"`
+	JA3Hash    string `json:"ja3Hash"` // MD5 of JA3
+	JA4        string `json:"ja4"`
+	JA4R       string `json:"ja4r"` // JA4 with the raw, unhashed lists
+	JA4H       string `json:"ja4h"`
+	Akamai     string `json:"akamai,omitempty"`     // HTTP/2 only: SETTINGS|WINDOW_UPDATE|PRIORITY|pseudo-header order
+	AkamaiHash string `json:"akamaiHash,omitempty"` // MD5 of Akamai
+	HTTP3      string `json:"http3,omitempty"`      // HTTP/3 only: SETTINGS|pseudo-header order, as HTTP3Fingerprint takes it
+}
 
-// GenerateJA4 generates a JA4 fingerprint from the TLS handshake and HTTP headers
-func GenerateJA4(tlsVersion uint16, cipherSuites []uint16, extensions []uint16, headers http.Header, userAgent string) string {
-	// Step 1: TLS version
-	tlsVersionStr := getTLSVersionString(tlsVersion)
+// clientHelloInfo holds the ClientHello fields the TLS fingerprints use
+type clientHelloInfo struct {
+	version           uint16
+	cipherSuites      []uint16
+	extensions        []uint16
+	groups            []uint16
+	pointFormats      []uint8
+	signatureSchemes  []uint16
+	supportedVersions []uint16
+	alpn              []string
+}
 
-	// Step 2: Cipher suites hash (first 1 character)
-	cipherHash := hashCipherSuites(cipherSuites)
+// ClientHelloFingerprints computes the JA3, JA3 hash, JA4 and JA4_r of a raw
+// ClientHello, with or without its TLS record header
+func ClientHelloFingerprints(raw []byte) (Fingerprints, error) {
+	hello, err := parseClientHelloInfo(raw)
+	if err != nil {
+		return Fingerprints{}, err
+	}
+	ja3 := hello.ja3()
+	return Fingerprints{
+		JA3:     ja3,
+		JA3Hash: md5Hex(ja3),
+		JA4:     hello.ja4(false),
+		JA4R:    hello.ja4(true),
+	}, nil
+}
 
-	// Step 3: Extensions hash (first 4 characters)
-	extensionsHash := hashExtensions(extensions)
+// SpecFingerprints computes the TLS fingerprints of the ClientHello spec
+// produces, serialized the way a connection would send it
+func SpecFingerprints(spec *utls.ClientHelloSpec, serverName string) (Fingerprints, error) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
 
-	// Step 4: HTTP headers hash (first 4 characters)
-	headersHash := hashHeaders(headers)
+	uconn := utls.UClient(client, &utls.Config{ServerName: serverName, InsecureSkipVerify: true}, utls.HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		return Fingerprints{}, err
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		return Fingerprints{}, err
+	}
+	return ClientHelloFingerprints(uconn.HandshakeState.Hello.Raw)
+}
 
-	// Step 5: User Agent hash (first 4 characters)
-	uaHash := hashUserAgent(userAgent)
+// parseClientHelloInfo reads the fingerprinted fields of a ClientHello
+func parseClientHelloInfo(raw []byte) (*clientHelloInfo, error) {
+	if len(raw) > 5 && raw[0] == 0x16 {
+		raw = raw[5:] // TLS record header
+	}
+	if len(raw) < 4 || raw[0] != 0x01 {
+		return nil, errors.New("not a ClientHello handshake message")
+	}
+	r := &byteReader{data: raw[4:]}
 
-	// Format: <TLS version><Cipher hash>_<Extensions hash>_<Headers hash>_<UA hash>
-	// JA4 format: t13d_cd89_1952_bb99 (19 chars total)
-	return fmt.Sprintf("%s%s_%s_%s_%s", tlsVersionStr, cipherHash[:1], extensionsHash[:4], headersHash[:4], uaHash[:4])
+	hello := &clientHelloInfo{}
+	hello.version = r.u16()
+	r.skip(32) // random
+	r.skip(int(r.u8()))
+	ciphers := r.bytes(int(r.u16()))
+	for len(ciphers) >= 2 {
+		hello.cipherSuites = append(hello.cipherSuites, binary.BigEndian.Uint16(ciphers))
+		ciphers = ciphers[2:]
+	}
+	r.skip(int(r.u8())) // compression methods
+	exts := &byteReader{data: r.bytes(int(r.u16()))}
+	if r.err {
+		return nil, errors.New("truncated ClientHello")
+	}
+
+	for len(exts.data) > 0 {
+		id := exts.u16()
+		data := &byteReader{data: exts.bytes(int(exts.u16()))}
+		if exts.err {
+			return nil, errors.New("truncated ClientHello extension")
+		}
+		hello.extensions = append(hello.extensions, id)
+		switch id {
+		case 0x000a: // supported_groups
+			hello.groups = data.u16List(int(data.u16()))
+		case 0x000b: // ec_point_formats
+			hello.pointFormats = data.bytes(int(data.u8()))
+		case 0x000d: // signature_algorithms
+			hello.signatureSchemes = data.u16List(int(data.u16()))
+		case 0x002b: // supported_versions
+			hello.supportedVersions = data.u16List(int(data.u8()))
+		case 0x0010: // application_layer_protocol_negotiation
+			list := &byteReader{data: data.bytes(int(data.u16()))}
+			for len(list.data) > 0 && !list.err {
+				hello.alpn = append(hello.alpn, string(list.bytes(int(list.u8()))))
+			}
+		}
+	}
+	return hello, nil
 }
 
-// GenerateJA4HTTP generates a JA4 HTTP fingerprint from HTTP headers only
-func GenerateJA4HTTP(headers http.Header, userAgent string) string {
-	// Step 1: Headers hash (first 4 characters)
-	headersHash := hashHeaders(headers)
+// ja3 formats the JA3 string: version,ciphers,extensions,groups,point formats
+// in decimal with GREASE values removed
+func (h *clientHelloInfo) ja3() string {
+	formats := make([]uint16, len(h.pointFormats))
+	for i, f := range h.pointFormats {
+		formats[i] = uint16(f)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		joinUint16(withoutGREASE(h.cipherSuites), "-", "%d"),
+		joinUint16(withoutGREASE(h.extensions), "-", "%d"),
+		joinUint16(withoutGREASE(h.groups), "-", "%d"),
+		joinUint16(formats, "-", "%d"),
+	}, ",")
+}
 
-	// Step 2: User Agent hash (first 4 characters)
-	uaHash := hashUserAgent(userAgent)
+// ja4 formats JA4, or JA4_r with the sorted lists left unhashed when raw is set
+func (h *clientHelloInfo) ja4(raw bool) string {
+	ciphers := withoutGREASE(h.cipherSuites)
+	extensions := withoutGREASE(h.extensions)
+
+	sni := "i"
+	var hashedExtensions []uint16
+	for _, ext := range extensions {
+		switch ext {
+		case 0x0000:
+			sni = "d"
+		case 0x0010:
+		default:
+			hashedExtensions = append(hashedExtensions, ext)
+		}
+	}
 
-	// Format: <Headers hash>_<UA hash>
-	return fmt.Sprintf("%s_%s", headersHash[:4], uaHash[:4])
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(h), sni, min(len(ciphers), 99), min(len(extensions), 99), ja4ALPN(h.alpn))
+
+	sortedCiphers := joinUint16(sortedUint16(ciphers), ",", "%04x")
+	sortedExtensions := joinUint16(sortedUint16(hashedExtensions), ",", "%04x")
+	if len(h.signatureSchemes) > 0 {
+		sortedExtensions += "_" + joinUint16(h.signatureSchemes, ",", "%04x")
+	}
+	if raw {
+		return a + "_" + sortedCiphers + "_" + sortedExtensions
+	}
+	return a + "_" + ja4Hash(sortedCiphers, len(ciphers) == 0) + "_" + ja4Hash(sortedExtensions, len(hashedExtensions) == 0)
 }
 
-// GenerateJA4H2 generates a JA4 HTTP/2 fingerprint from HTTP/2 settings
-func GenerateJA4H2(settings []http2.Setting, streamDependency uint32, exclusive bool, priorityOrder []string) string {
-	// Format settings as key:value pairs
-	settingsStrs := make([]string, 0, len(settings))
-	for _, s := range settings {
-		settingsStrs = append(settingsStrs, fmt.Sprintf("%d:%d", s.ID, s.Val))
+// ja4Version is the highest non-GREASE supported version, or the legacy version
+func ja4Version(h *clientHelloInfo) string {
+	version := h.version
+	if versions := withoutGREASE(h.supportedVersions); len(versions) > 0 {
+		version = slices.Max(versions)
+	}
+	switch version {
+	case utls.VersionTLS13:
+		return "13"
+	case utls.VersionTLS12:
+		return "12"
+	case utls.VersionTLS11:
+		return "11"
+	case utls.VersionTLS10:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+// ja4ALPN is the first and last character of the first ALPN protocol, "00"
+// without ALPN, or the outer hex digits when they are not alphanumeric
+func ja4ALPN(alpn []string) string {
+	if len(alpn) == 0 || alpn[0] == "" {
+		return "00"
+	}
+	first, last := alpn[0][0], alpn[0][len(alpn[0])-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
 	}
+	encoded := hex.EncodeToString([]byte(alpn[0]))
+	return string([]byte{encoded[0], encoded[len(encoded)-1]})
+}
 
-	// Join settings with commas
-	settingsStr := strings.Join(settingsStrs, ",")
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
 
-	// Calculate priority details
-	exclusiveFlag := 0
-	if exclusive {
-		exclusiveFlag = 1
+// ja4Hash is the truncated SHA-256 JA4 uses, all zeros for an empty list
+func ja4Hash(s string, empty bool) string {
+	if empty {
+		return "000000000000"
 	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
 
-	// Combine priority elements with a comma
-	priorityStr := strings.Join(priorityOrder, ",")
+// JA4H computes the FoxIO JA4H fingerprint of req as sent over HTTP/protoMajor:
+// method, version, cookie and referer flags, header count and language,
+// then hashes of the header names in send order and of the cookies
+func JA4H(req *http.Request, protoMajor int) string {
+	var names []string
 
-	// Final format: settings|streamDependency|exclusive|priorityOrder
-	return fmt.Sprintf("%s|%d|%d|%s", settingsStr, streamDependency, exclusiveFlag, priorityStr)
+	// Same ordering the fhttp transports use
+	exclude := map[string]bool{http.HeaderOrderKey: true, http.PHeaderOrderKey: true}
+	var kvs []http.HeaderKeyValues
+	if headerOrder, ok := req.Header[http.HeaderOrderKey]; ok {
+		order := make(map[string]int)
+		for i, v := range headerOrder {
+			order[v] = i
+		}
+		kvs, _ = req.Header.SortedKeyValuesBy(order, exclude)
+	} else {
+		kvs, _ = req.Header.SortedKeyValues(exclude)
+	}
+
+	// HTTP/1.1 sends ordered headers with the casing they were given
+	var casing map[string]string
+	if protoMajor < 2 {
+		casing = http1HeaderCasing(headerLinesFromContext(req.Context()))
+	}
+
+	hasCookie, hasReferer := "n", "n"
+	var cookies []string
+	var language string
+	for _, kv := range kvs {
+		name := kv.Key
+		if protoMajor >= 2 {
+			name = strings.ToLower(name)
+		} else if given := casing[strings.ToLower(name)]; given != "" {
+			name = given
+		}
+		switch strings.ToLower(name) {
+		case "host":
+			// HTTP/1.1 writes it from the header map, HTTP/2 as :authority
+			if protoMajor >= 2 {
+				continue
+			}
+		case "cookie":
+			hasCookie = "c"
+			for _, v := range kv.Values {
+				for _, c := range strings.Split(v, ";") {
+					if c = strings.TrimSpace(c); c != "" {
+						cookies = append(cookies, c)
+					}
+				}
+			}
+			continue
+		case "referer":
+			hasReferer = "r"
+			continue
+		case "accept-language":
+			// Looked up by name, as HTTP/1.1 keys keep the casing they were given
+			if language == "" && len(kv.Values) > 0 {
+				language = kv.Values[0]
+			}
+		}
+		names = append(names, name)
+	}
+
+	lang := ja4hLanguage(language)
+
+	version := map[int]string{1: "11", 2: "20", 3: "30"}[protoMajor]
+	if version == "" {
+		version = "10"
+	}
+	a := fmt.Sprintf("%s%s%s%s%02d%s", ja4hMethod(req.Method), version, hasCookie, hasReferer, min(len(names), 99), lang)
+
+	sort.Strings(cookies)
+	cookieNames := make([]string, len(cookies))
+	for i, c := range cookies {
+		cookieNames[i], _, _ = strings.Cut(c, "=")
+	}
+	sort.Strings(cookieNames)
+
+	return a + "_" + ja4Hash(strings.Join(names, ","), len(names) == 0) +
+		"_" + ja4Hash(strings.Join(cookieNames, ","), len(cookies) == 0) +
+		"_" + ja4Hash(strings.Join(cookies, ","), len(cookies) == 0)
 }
 
-// Helper functions
+// GenerateJA4 returns the JA4 fingerprint of a ClientHello offering the given
+// TLS version, cipher suites and extensions, the remaining fields filled in
+// for userAgent. JA4 covers only the ClientHello, so headers are unused.
+//
+// Deprecated: use SpecFingerprints, or ClientHelloFingerprints on a captured
+// ClientHello, which also give JA3 and JA4_r. Use JA4H for headers.
+func GenerateJA4(tlsVersion uint16, cipherSuites []uint16, extensions []uint16, headers nhttp.Header, userAgent string) string {
+	ja3 := fmt.Sprintf("%d,%s,%s,,", tlsVersion, joinUint16(cipherSuites, "-", "%d"), joinUint16(extensions, "-", "%d"))
+	spec, err := StringToSpec(ja3, userAgent, false)
+	if err != nil {
+		return ""
+	}
+	fp, err := SpecFingerprints(spec, "example.com")
+	if err != nil {
+		return ""
+	}
+	return fp.JA4
+}
 
-func getTLSVersionString(version uint16) string {
-	switch version {
-	case 0x0301:
-		return "t10"
-	case 0x0302:
-		return "t11"
-	case 0x0303:
-		return "t12"
-	case 0x0304:
-		return "t13"
-	default:
-		return "t??"
+// GenerateJA4HTTP returns the JA4H fingerprint of an HTTP/1.1 GET request
+// sending headers, with userAgent as its User-Agent when headers has none
+//
+// Deprecated: use JA4H, which takes the request and HTTP version.
+func GenerateJA4HTTP(headers nhttp.Header, userAgent string) string {
+	req := &http.Request{Method: http.MethodGet, Header: http.Header(headers.Clone())}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if req.Header.Get("User-Agent") == "" && userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
 	}
+	return JA4H(req, 1)
 }
 
-func hashCipherSuites(ciphers []uint16) string {
-	// Sort and join cipher suites
-	cipherStrings := make([]string, len(ciphers))
-	for i, cipher := range ciphers {
-		cipherStrings[i] = fmt.Sprintf("%04x", cipher)
+// GenerateJA4H2 returns the Akamai HTTP/2 fingerprint of a connection opening
+// with settings and sending its pseudo-headers in priorityOrder, given as
+// names (":method") or letters ("m"). The HEADERS frame priority is not part
+// of the fingerprint, so streamDependency and exclusive are unused.
+//
+// Deprecated: use WithFingerprints, which reports the Akamai fingerprint a
+// request was sent with.
+func GenerateJA4H2(settings []http2.Setting, streamDependency uint32, exclusive bool, priorityOrder []string) string {
+	settingStrs := make([]string, len(settings))
+	for i, setting := range settings {
+		settingStrs[i] = fmt.Sprintf("%d:%d", setting.ID, setting.Val)
+	}
+	letters := make([]string, 0, len(priorityOrder))
+	for _, name := range priorityOrder {
+		if name = strings.TrimPrefix(name, ":"); name != "" {
+			letters = append(letters, name[:1])
+		}
+	}
+	return strings.Join([]string{strings.Join(settingStrs, ";"), "00", "0", strings.Join(letters, ",")}, "|")
+}
+
+// Helper functions
+
+func withoutGREASE(values []uint16) []uint16 {
+	out := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !IsGREASEValue(v) {
+			out = append(out, v)
+		}
 	}
-	sort.Strings(cipherStrings)
+	return out
+}
 
-	// Hash the joined string
-	h := sha256.New()
-	h.Write([]byte(strings.Join(cipherStrings, "")))
-	return hex.EncodeToString(h.Sum(nil))
+func sortedUint16(values []uint16) []uint16 {
+	out := append([]uint16(nil), values...)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
 }
 
-func hashExtensions(extensions []uint16) string {
-	// Sort and join extensions
-	extStrings := make([]string, len(extensions))
-	for i, ext := range extensions {
-		extStrings[i] = fmt.Sprintf("%04x", ext)
+func joinUint16(values []uint16, sep, format string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf(format, v)
 	}
-	sort.Strings(extStrings)
+	return strings.Join(parts, sep)
+}
+
+// byteReader reads big-endian TLS fields, setting err instead of panicking
+// on truncated input
+type byteReader struct {
+	data []byte
+	err  bool
+}
 
-	// Hash the joined string
-	h := sha256.New()
-	h.Write([]byte(strings.Join(extStrings, "")))
-	return hex.EncodeToString(h.Sum(nil))
+func (r *byteReader) bytes(n int) []byte {
+	if n > len(r.data) {
+		r.data, r.err = nil, true
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
 }
 
-func hashHeaders(headers http.Header) string {
-	// Get header names and sort them
-	headerNames := make([]string, 0, len(headers))
-	for name := range headers {
-		headerNames = append(headerNames, textproto.CanonicalMIMEHeaderKey(name))
+func (r *byteReader) skip(n int) { r.bytes(n) }
+
+func (r *byteReader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
 	}
-	sort.Strings(headerNames)
+	return 0
+}
 
-	// Join header names
-	h := sha256.New()
-	h.Write([]byte(strings.Join(headerNames, ",")))
-	return hex.EncodeToString(h.Sum(nil))
+func (r *byteReader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
 }
 
-func hashUserAgent(userAgent string) string {
-	h := sha256.New()
-	h.Write([]byte(userAgent))
-	return hex.EncodeToString(h.Sum(nil))
+func (r *byteReader) u16List(n int) []uint16 {
+	data := r.bytes(n)
+	values := make([]uint16, 0, len(data)/2)
+	for len(data) >= 2 {
+		values = append(values, binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	return values
 }
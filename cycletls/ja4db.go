@@ -0,0 +1,141 @@
+package cycletls
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownJA4 is returned when a hashed JA4 has no known JA4_r
+var ErrUnknownJA4 = errors.New("unknown JA4 fingerprint")
+
+// KnownJA4 is a raw JA4_r fingerprint in the JA4 lookup database
+type KnownJA4 struct {
+	Client string // Browser the ClientHello was captured from
+	JA4    string // Hashed form, computed from JA4r
+	JA4r   string
+}
+
+// bundledJA4r are the raw fingerprints shipped with CycleTLS, in FoxIO form:
+// SNI and ALPN are left out of the extension list
+var bundledJA4r = []struct{ client, ja4r string }{
+	{"Chrome 133+ (ALPS 17613, ECH)", "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0017,001b,0023,002b,002d,0033,44cd,fe0d,ff01_0403,0804,0401,0503,0805,0501,0806,0601"},
+	{"Chrome 117-132 (ALPS 17513, ECH)", "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0017,001b,0023,002b,002d,0033,4469,fe0d,ff01_0403,0804,0401,0503,0805,0501,0806,0601"},
+	{"Chrome (FoxIO reference, ALPS 17513, padding)", "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_0403,0804,0401,0503,0805,0501,0806,0601"},
+	{"Firefox 141", "t13d1717h2_002f,0035,009c,009d,1301,1302,1303,c009,c00a,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0017,001b,001c,0022,0023,002b,002d,0033,fe0d,ff01_0403,0503,0603,0804,0805,0806,0401,0501,0601,0203,0201"},
+}
+
+var (
+	ja4DB     map[string]KnownJA4
+	ja4DBOnce sync.Once
+	ja4DBMu   sync.RWMutex
+)
+
+func loadJA4DB() {
+	ja4DBOnce.Do(func() {
+		ja4DB = make(map[string]KnownJA4)
+		for _, entry := range bundledJA4r {
+			// The bundled entries are checked by the tests
+			_ = addKnownJA4(entry.client, entry.ja4r)
+		}
+	})
+}
+
+// addKnownJA4 indexes ja4r by its hash, keeping the first entry on a collision
+func addKnownJA4(client, ja4r string) error {
+	ja4, err := JA4FromJA4r(ja4r)
+	if err != nil {
+		return err
+	}
+	ja4DBMu.Lock()
+	defer ja4DBMu.Unlock()
+	if _, ok := ja4DB[ja4]; !ok {
+		ja4DB[ja4] = KnownJA4{Client: client, JA4: ja4, JA4r: ja4r}
+	}
+	return nil
+}
+
+// RegisterJA4r adds a captured JA4_r to the database LookupJA4 searches, so
+// fingerprints seen in logs can be reproduced from their hash
+func RegisterJA4r(client, ja4r string) error {
+	if _, err := ParseJA4RString(ja4r); err != nil {
+		return err
+	}
+	loadJA4DB()
+	return addKnownJA4(client, ja4r)
+}
+
+// LookupJA4 finds the known JA4_r whose hash is ja4
+func LookupJA4(ja4 string) (KnownJA4, error) {
+	loadJA4DB()
+	ja4DBMu.RLock()
+	defer ja4DBMu.RUnlock()
+	known, ok := ja4DB[strings.ToLower(ja4)]
+	if !ok {
+		return KnownJA4{}, fmt.Errorf("%w %s: no JA4_r in the database hashes to it, pass the raw form in Ja4r or add it with RegisterJA4r", ErrUnknownJA4, ja4)
+	}
+	return known, nil
+}
+
+// KnownJA4s lists the database, sorted by JA4
+func KnownJA4s() []KnownJA4 {
+	loadJA4DB()
+	ja4DBMu.RLock()
+	defer ja4DBMu.RUnlock()
+	known := make([]KnownJA4, 0, len(ja4DB))
+	for _, k := range ja4DB {
+		known = append(known, k)
+	}
+	slices.SortFunc(known, func(a, b KnownJA4) int { return strings.Compare(a.JA4, b.JA4) })
+	return known
+}
+
+// resolveJA4 fills in Ja4r from a hashed Ja4, which only applies when no raw
+// JA4_r is given
+func resolveJA4(options *Options) error {
+	if options.Ja4 == "" || options.Ja4r != "" {
+		return nil
+	}
+	known, err := LookupJA4(options.Ja4)
+	if err != nil {
+		return err
+	}
+	options.Ja4r = known.JA4r
+	return nil
+}
+
+// JA4FromJA4r hashes a JA4_r string into its JA4 fingerprint. Cipher suites
+// and extensions are re-sorted, and SNI and ALPN are left out of the
+// extension hash as JA4 specifies.
+func JA4FromJA4r(ja4r string) (string, error) {
+	parts := strings.Split(strings.ToLower(ja4r), "_")
+	if len(parts) < 3 || len(parts) > 4 {
+		return "", errors.New("invalid JA4_r string: expected 3 or 4 parts separated by underscores")
+	}
+
+	ciphers := ja4rList(parts[1])
+	var extensions []string
+	for _, ext := range ja4rList(parts[2]) {
+		if ext != "0000" && ext != "0010" {
+			extensions = append(extensions, ext)
+		}
+	}
+	hashedExtensions := strings.Join(extensions, ",")
+	if len(parts) == 4 && parts[3] != "" {
+		hashedExtensions += "_" + parts[3]
+	}
+	return parts[0] + "_" + ja4Hash(strings.Join(ciphers, ","), len(ciphers) == 0) +
+		"_" + ja4Hash(hashedExtensions, len(extensions) == 0), nil
+}
+
+// ja4rList splits and sorts a comma separated JA4_r hex list
+func ja4rList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	values := strings.Split(s, ",")
+	slices.Sort(values)
+	return values
+}
@@ -0,0 +1,154 @@
+package cycletls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	http "github.com/Danny-Dasilva/fhttp"
+)
+
+// ja4hMethods maps JA4H method codes back to the methods they abbreviate
+var ja4hMethods = map[string]string{
+	"ge": http.MethodGet,
+	"po": http.MethodPost,
+	"pu": http.MethodPut,
+	"de": http.MethodDelete,
+	"he": http.MethodHead,
+	"op": http.MethodOptions,
+	"pa": http.MethodPatch,
+	"co": http.MethodConnect,
+	"tr": http.MethodTrace,
+}
+
+// applyJA4HOptions adapts options to their JA4H target before the client is
+// built: the HTTP version picks the protocol, the method code fills in a
+// missing method and a cookie flag of "n" drops the configured cookies. A
+// method the code does not abbreviate is an error rather than a different
+// fingerprint. It returns nil when no JA4H is set.
+func applyJA4HOptions(options *Options) (*JA4HComponents, error) {
+	if options.Ja4h == "" {
+		return nil, nil
+	}
+	components, err := ParseJA4HString(options.Ja4h)
+	if err != nil {
+		return nil, err
+	}
+
+	switch components.HTTPVersion {
+	case "10", "11":
+		options.ForceHTTP1 = true
+	case "30":
+		options.ForceHTTP3 = true
+	}
+	if method, ok := ja4hMethods[components.Method]; ok && options.Method == "" {
+		options.Method = method
+	} else if code := ja4hMethod(options.Method); code != components.Method {
+		return nil, fmt.Errorf("ja4h method code %q does not match the %s request", components.Method, strings.ToUpper(options.Method))
+	}
+	if components.HeaderCount >= 0 && !components.HasCookie {
+		options.Cookies = nil
+	}
+	return components, nil
+}
+
+// shapeJA4HRequest makes req's headers match a JA4H target: Cookie and
+// Referer follow their flags, Accept-Language follows the language code and
+// a raw JA4H_r header list sets the header order. Values the target needs
+// but the request does not have, such as cookies, are left to the caller,
+// and a request sending another number of headers than the target counts
+// fails rather than sending a different fingerprint.
+func shapeJA4HRequest(req *http.Request, components *JA4HComponents) error {
+	if components == nil {
+		return nil
+	}
+
+	// The flags are only known when the first section is complete
+	if components.HeaderCount >= 0 {
+		if !components.HasCookie {
+			req.Header.Del("Cookie")
+		}
+		if !components.HasReferer {
+			req.Header.Del("Referer")
+		}
+	}
+
+	switch components.Language {
+	case "":
+	case "0000":
+		req.Header.Del("Accept-Language")
+	default:
+		if ja4hLanguage(req.Header.Get("Accept-Language")) != components.Language {
+			req.Header.Set("Accept-Language", acceptLanguage(components.Language))
+		}
+	}
+
+	if len(components.HeaderNames) > 0 {
+		req.Header[http.HeaderOrderKey] = ja4hHeaderOrder(components.HeaderNames, req.Header[http.HeaderOrderKey])
+	}
+
+	if components.HeaderCount >= 0 {
+		if count := ja4hSentHeaders(req, components.HTTPVersion); count != components.HeaderCount {
+			return fmt.Errorf("ja4h counts %d headers but the request sends %d", components.HeaderCount, count)
+		}
+	}
+	return nil
+}
+
+// ja4hSentHeaders is the header count of the JA4H req will be sent with over
+// version, once the transport has added the User-Agent and the Host
+func ja4hSentHeaders(req *http.Request, version string) int {
+	sent := *req
+	sent.Header = req.Header.Clone()
+	for _, name := range []string{"User-Agent", "Host"} {
+		if _, ok := sent.Header[name]; !ok {
+			sent.Header[name] = []string{""}
+		}
+	}
+	protoMajor := map[string]int{"20": 2, "30": 3}[version]
+	count, _ := strconv.Atoi(JA4H(&sent, max(protoMajor, 1))[6:8])
+	return count
+}
+
+// ja4hMethod is the JA4H code of method, "ge" for an empty one
+func ja4hMethod(method string) string {
+	if method == "" {
+		method = http.MethodGet
+	}
+	return (strings.ToLower(method) + "  ")[:2]
+}
+
+// ja4hHeaderOrder puts the JA4H_r header names first, lowercased the way
+// the fhttp transports look them up, then any remaining entries of the
+// previous order such as Cookie and Referer, which JA4H_r does not list
+func ja4hHeaderOrder(names, previous []string) []string {
+	order := make([]string, 0, len(names)+len(previous))
+	seen := make(map[string]bool)
+	for _, name := range append(names, previous...) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		order = append(order, name)
+	}
+	return order
+}
+
+// ja4hLanguage is the JA4H code for an Accept-Language value: the first
+// language without dashes, lowercased and padded or cut to four characters
+func ja4hLanguage(value string) string {
+	lang := strings.ToLower(strings.NewReplacer("-", "", ";", ",").Replace(value))
+	lang = strings.Split(lang, ",")[0]
+	return (lang + "0000")[:4]
+}
+
+// acceptLanguage turns a JA4H language code back into a header value,
+// e.g. "enus" into "en-US" and "fr00" into "fr"
+func acceptLanguage(code string) string {
+	lang, region := code[:2], strings.TrimRight(code[2:], "0")
+	if region == "" {
+		return lang
+	}
+	return lang + "-" + strings.ToUpper(region)
+}
@@ -0,0 +1,58 @@
+package cycletls
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+)
+
+// LogLevelEnv is the environment variable setting the level of the default
+// logger: "debug", "info" (the default), "warn" or "error"
+const LogLevelEnv = "CYCLETLS_LOG_LEVEL"
+
+var defaultLogger atomic.Pointer[slog.Logger]
+
+func init() {
+	defaultLogger.Store(slog.New(defaultLogHandler()))
+}
+
+// defaultLogHandler writes text to stdout, which the JavaScript client
+// prints, where lines on stderr can make it restart the process
+func defaultLogHandler() slog.Handler {
+	level := slog.LevelInfo
+	if name, ok := os.LookupEnv(LogLevelEnv); ok {
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			level = slog.LevelInfo
+		}
+	}
+	return slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+}
+
+// SetLogHandler sends the package's logs to h, those of clients without a
+// handler of their own and of the WS_PORT server included. A nil h discards
+// them.
+func SetLogHandler(h slog.Handler) {
+	if h == nil {
+		h = slog.DiscardHandler
+	}
+	defaultLogger.Store(slog.New(h))
+}
+
+// WithLogHandler sends the logs of the client's requests to h instead of the
+// package's handler. A nil h discards them.
+func WithLogHandler(h slog.Handler) Option {
+	return func(client *CycleTLS) {
+		if h == nil {
+			h = slog.DiscardHandler
+		}
+		client.logger = slog.New(h)
+	}
+}
+
+// logger returns l, or the package's logger when l is nil
+func logger(l *slog.Logger) *slog.Logger {
+	if l != nil {
+		return l
+	}
+	return defaultLogger.Load()
+}
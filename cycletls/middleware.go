@@ -0,0 +1,199 @@
+package cycletls
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	http "github.com/Danny-Dasilva/fhttp"
+	"github.com/quic-go/quic-go"
+	uquic "github.com/refraction-networking/uquic"
+	utls "github.com/refraction-networking/utls"
+)
+
+// RoundTripFunc sends one request and returns its response, like an
+// http.RoundTripper. Every request of a redirect chain is sent on its own.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+// Middleware wraps the RoundTripFunc that sends a client's requests, to sign
+// or change them on the way out, look at their responses, or answer them
+// itself. It sees requests before cookies, the user agent and the header
+// order are applied, so headers it adds go after the ordered ones unless it
+// lists them in http.HeaderOrderKey.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// Hooks are called as a client's requests go out. Any of them may be nil.
+// Dial and handshake hooks run on dialing goroutines, so hooks must be safe
+// for concurrent use.
+type Hooks struct {
+	// OnDial is called once a connection to addr, through the proxy if there
+	// is one, is dialed or has failed to be. QUIC dials have network "udp"
+	// and include their handshake.
+	OnDial func(ctx context.Context, network, addr string, err error)
+
+	// OnTLSHandshake is called after every TLS handshake, retries with
+	// another ClientHello and QUIC handshakes included
+	OnTLSHandshake func(ctx context.Context, state utls.ConnectionState, err error)
+
+	// OnRequest is called before each request of a chain is sent
+	OnRequest func(req *http.Request)
+
+	// OnResponse is called once each response's headers have arrived
+	OnResponse func(req *http.Request, resp *http.Response)
+
+	// OnRedirect is called before following a redirect to req. Returning an
+	// error stops there, and the request fails with it.
+	OnRedirect func(req *http.Request, via []*http.Request) error
+
+	// OnError is called once when a request fails, while it is sent or while
+	// its body is read
+	OnError func(req *http.Request, err error)
+}
+
+// WithMiddleware adds middleware to the client's requests. The first one
+// added is the outermost, seeing requests first and responses last.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(client *CycleTLS) {
+		client.middleware = append(client.middleware, middleware...)
+	}
+}
+
+// WithHooks adds hooks to the client's requests. Hooks added more than once
+// are all called, in the order they were added.
+func WithHooks(hooks Hooks) Option {
+	return func(client *CycleTLS) {
+		client.hooks = append(client.hooks, hooks)
+	}
+}
+
+// extensions are the middleware and hooks of the client a request is sent
+// by. Its methods do nothing on a nil extensions, so clients without any
+// cost nothing.
+type extensions struct {
+	middleware []Middleware
+	hooks      []Hooks
+}
+
+type extensionsKey struct{}
+
+// extensions returns the client's middleware and hooks, nil without any
+func (client CycleTLS) extensions() *extensions {
+	if len(client.middleware) == 0 && len(client.hooks) == 0 {
+		return nil
+	}
+	return &extensions{middleware: client.middleware, hooks: client.hooks}
+}
+
+// withExtensions returns a context whose requests run through e
+func withExtensions(ctx context.Context, e *extensions) context.Context {
+	if e == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, extensionsKey{}, e)
+}
+
+func extensionsFromContext(ctx context.Context) *extensions {
+	e, _ := ctx.Value(extensionsKey{}).(*extensions)
+	return e
+}
+
+// roundTrip sends req with next, through the middleware and the request and
+// response hooks
+func (e *extensions) roundTrip(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+	if e == nil {
+		return next(req)
+	}
+	send := func(req *http.Request) (*http.Response, error) {
+		for _, h := range e.hooks {
+			if h.OnRequest != nil {
+				h.OnRequest(req)
+			}
+		}
+		resp, err := next(req)
+		if err == nil {
+			for _, h := range e.hooks {
+				if h.OnResponse != nil {
+					h.OnResponse(req, resp)
+				}
+			}
+		}
+		return resp, err
+	}
+	for i := len(e.middleware) - 1; i >= 0; i-- {
+		send = e.middleware[i](send)
+	}
+	return send(req)
+}
+
+func (e *extensions) dial(ctx context.Context, network, addr string, err error) {
+	if e == nil {
+		return
+	}
+	for _, h := range e.hooks {
+		if h.OnDial != nil {
+			h.OnDial(ctx, network, addr, err)
+		}
+	}
+}
+
+func (e *extensions) tlsHandshake(ctx context.Context, state utls.ConnectionState, err error) {
+	if e == nil {
+		return
+	}
+	for _, h := range e.hooks {
+		if h.OnTLSHandshake != nil {
+			h.OnTLSHandshake(ctx, state, err)
+		}
+	}
+}
+
+func (e *extensions) redirect(req *http.Request, via []*http.Request) error {
+	if e == nil {
+		return nil
+	}
+	for _, h := range e.hooks {
+		if h.OnRedirect != nil {
+			if err := h.OnRedirect(req, via); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *extensions) fail(req *http.Request, err error) {
+	if e == nil || err == nil {
+		return
+	}
+	for _, h := range e.hooks {
+		if h.OnError != nil {
+			h.OnError(req, err)
+		}
+	}
+}
+
+// quicConnectionState returns the TLS state and remote address of a QUIC
+// connection from either QUIC stack
+func quicConnectionState(conn interface{}) (utls.ConnectionState, net.Addr, bool) {
+	switch q := conn.(type) {
+	case *quic.Conn:
+		return utlsConnectionState(q.ConnectionState().TLS), q.RemoteAddr(), true
+	case uquic.EarlyConnection:
+		return q.ConnectionState().TLS, q.RemoteAddr(), true
+	}
+	return utls.ConnectionState{}, nil, false
+}
+
+// utlsConnectionState converts quic-go's crypto/tls state for hooks
+func utlsConnectionState(state tls.ConnectionState) utls.ConnectionState {
+	return utls.ConnectionState{
+		Version:            state.Version,
+		HandshakeComplete:  state.HandshakeComplete,
+		DidResume:          state.DidResume,
+		CipherSuite:        state.CipherSuite,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ServerName:         state.ServerName,
+		PeerCertificates:   state.PeerCertificates,
+		VerifiedChains:     state.VerifiedChains,
+	}
+}
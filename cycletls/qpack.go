@@ -0,0 +1,458 @@
+package cycletls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Danny-Dasilva/fhttp/http2/hpack"
+	"github.com/quic-go/qpack"
+)
+
+// errQPACKDecompression is the QPACK_DECOMPRESSION_FAILED condition
+var errQPACKDecompression = errors.New("qpack: decompression failed")
+
+// maxQPACKString caps a single name or value, well over any sane header
+const maxQPACKString = 1 << 20
+
+var (
+	qpackStaticOnce  sync.Once
+	qpackStaticTable []qpack.HeaderField
+)
+
+// qpackStatic returns entry i of the QPACK static table. The qpack package
+// does not export the table, so it is read back through its decoder once.
+func qpackStatic(i uint64) (qpack.HeaderField, bool) {
+	qpackStaticOnce.Do(func() {
+		decoder := qpack.NewDecoder(nil)
+		for index := uint64(0); ; index++ {
+			fields, err := decoder.DecodeFull(appendPrefixInt([]byte{0, 0}, 0xc0, 6, index))
+			if err != nil || len(fields) != 1 {
+				return
+			}
+			qpackStaticTable = append(qpackStaticTable, fields[0])
+		}
+	})
+	if i >= uint64(len(qpackStaticTable)) {
+		return qpack.HeaderField{}, false
+	}
+	return qpackStaticTable[i], true
+}
+
+// qpackDecoder decodes QPACK field sections with the dynamic table the
+// server builds over its encoder stream, which the qpack package leaves out.
+// Requests are still encoded with the static table only.
+type qpackDecoder struct {
+	maxCapacity uint64 // SETTINGS_QPACK_MAX_TABLE_CAPACITY as advertised
+
+	mu            sync.Mutex
+	capacity      uint64
+	size          uint64
+	entries       []qpack.HeaderField // Oldest first
+	evicted       uint64              // Absolute index of entries[0]
+	knownReceived uint64              // Inserts the server knows were received
+	changed       chan struct{}       // Closed and replaced on every insert
+	err           error               // Set once the encoder stream fails
+
+	out io.Writer // Our decoder stream, nil when there is none
+}
+
+func newQPACKDecoder(maxCapacity uint64) *qpackDecoder {
+	return &qpackDecoder{maxCapacity: maxCapacity, changed: make(chan struct{})}
+}
+
+// inserted is the total number of dynamic table insertions
+func (d *qpackDecoder) inserted() uint64 {
+	return d.evicted + uint64(len(d.entries))
+}
+
+// fail stops decoding that waits on the dynamic table
+func (d *qpackDecoder) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = err
+		close(d.changed)
+		d.changed = make(chan struct{})
+	}
+}
+
+// readEncoderStream applies the server's encoder instructions until the
+// stream ends, acknowledging the inserts whenever it catches up
+func (d *qpackDecoder) readEncoderStream(r *bufio.Reader) {
+	for {
+		err := d.readEncoderInstruction(r)
+		if err == nil && r.Buffered() == 0 {
+			err = d.acknowledgeInserts()
+		}
+		if err != nil {
+			d.fail(err)
+			return
+		}
+	}
+}
+
+func (d *qpackDecoder) readEncoderInstruction(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case b&0x80 != 0: // Insert with name reference
+		index, err := readPrefixInt(r, b, 6)
+		if err != nil {
+			return err
+		}
+		value, err := readQPACKValue(r)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var name qpack.HeaderField
+		var ok bool
+		if b&0x40 != 0 {
+			name, ok = qpackStatic(index)
+		} else if index < d.inserted() {
+			name, ok = d.entry(d.inserted() - 1 - index)
+		}
+		if !ok {
+			return fmt.Errorf("%w: invalid name reference %d on the encoder stream", errQPACKDecompression, index)
+		}
+		return d.insert(qpack.HeaderField{Name: name.Name, Value: value})
+	case b&0x40 != 0: // Insert with literal name
+		name, err := readQPACKString(r, b, 5)
+		if err != nil {
+			return err
+		}
+		value, err := readQPACKValue(r)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.insert(qpack.HeaderField{Name: name, Value: value})
+	case b&0x20 != 0: // Set dynamic table capacity
+		capacity, err := readPrefixInt(r, b, 5)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if capacity > d.maxCapacity {
+			return fmt.Errorf("%w: table capacity %d exceeds the advertised %d", errQPACKDecompression, capacity, d.maxCapacity)
+		}
+		d.capacity = capacity
+		d.evict(capacity)
+		return nil
+	default: // Duplicate
+		index, err := readPrefixInt(r, b, 5)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var field qpack.HeaderField
+		var ok bool
+		if index < d.inserted() {
+			field, ok = d.entry(d.inserted() - 1 - index)
+		}
+		if !ok {
+			return fmt.Errorf("%w: invalid duplicate of entry %d", errQPACKDecompression, index)
+		}
+		return d.insert(field)
+	}
+}
+
+// entry looks up an absolute dynamic table index. d.mu must be held.
+func (d *qpackDecoder) entry(abs uint64) (qpack.HeaderField, bool) {
+	if abs < d.evicted || abs >= d.inserted() {
+		return qpack.HeaderField{}, false
+	}
+	return d.entries[abs-d.evicted], true
+}
+
+func qpackEntrySize(f qpack.HeaderField) uint64 {
+	return uint64(len(f.Name)+len(f.Value)) + 32
+}
+
+// insert adds f to the dynamic table. d.mu must be held.
+func (d *qpackDecoder) insert(f qpack.HeaderField) error {
+	size := qpackEntrySize(f)
+	if size > d.capacity {
+		return fmt.Errorf("%w: entry of %d bytes exceeds the table capacity %d", errQPACKDecompression, size, d.capacity)
+	}
+	d.evict(d.capacity - size)
+	d.entries = append(d.entries, f)
+	d.size += size
+	close(d.changed)
+	d.changed = make(chan struct{})
+	return nil
+}
+
+// evict drops the oldest entries until the table fits in limit. d.mu must
+// be held.
+func (d *qpackDecoder) evict(limit uint64) {
+	for d.size > limit {
+		d.size -= qpackEntrySize(d.entries[0])
+		d.entries = d.entries[1:]
+		d.evicted++
+	}
+}
+
+// acknowledgeInserts sends an Insert Count Increment for inserts the server
+// has not seen acknowledged yet
+func (d *qpackDecoder) acknowledgeInserts() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.out == nil || d.inserted() == d.knownReceived {
+		return nil
+	}
+	increment := d.inserted() - d.knownReceived
+	d.knownReceived = d.inserted()
+	_, err := d.out.Write(appendPrefixInt(nil, 0x00, 6, increment))
+	return err
+}
+
+// decodeFieldSection decodes the field section of a HEADERS frame received
+// on streamID, waiting for the inserts it depends on, and acknowledges it
+// when it referenced the dynamic table
+func (d *qpackDecoder) decodeFieldSection(ctx context.Context, streamID uint64, block []byte) ([]qpack.HeaderField, error) {
+	r := bytes.NewReader(block)
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("%w: empty field section", errQPACKDecompression)
+	}
+	encodedInsertCount, err := readPrefixInt(r, b, 8)
+	if err != nil {
+		return nil, err
+	}
+	b, err = r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("%w: truncated field section prefix", errQPACKDecompression)
+	}
+	deltaBase, err := readPrefixInt(r, b, 7)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	requiredInsertCount, err := d.requiredInsertCount(encodedInsertCount)
+	if err != nil {
+		return nil, err
+	}
+	base := requiredInsertCount + deltaBase
+	if b&0x80 != 0 {
+		if deltaBase >= requiredInsertCount {
+			return nil, fmt.Errorf("%w: negative base", errQPACKDecompression)
+		}
+		base = requiredInsertCount - deltaBase - 1
+	}
+
+	// Blocked until the encoder stream delivers the entries
+	for d.inserted() < requiredInsertCount {
+		if d.err != nil {
+			return nil, fmt.Errorf("%w: encoder stream: %v", errQPACKDecompression, d.err)
+		}
+		changed := d.changed
+		d.mu.Unlock()
+		select {
+		case <-changed:
+			d.mu.Lock()
+		case <-ctx.Done():
+			d.mu.Lock()
+			return nil, ctx.Err()
+		}
+	}
+
+	lookup := func(static bool, index uint64) (qpack.HeaderField, error) {
+		if static {
+			if field, ok := qpackStatic(index); ok {
+				return field, nil
+			}
+		} else if field, ok := d.entry(index); ok && index < requiredInsertCount {
+			return field, nil
+		}
+		return qpack.HeaderField{}, fmt.Errorf("%w: invalid index %d", errQPACKDecompression, index)
+	}
+	relative := func(index uint64) uint64 {
+		if index >= base {
+			return requiredInsertCount // Out of range, rejected by lookup
+		}
+		return base - 1 - index
+	}
+
+	var fields []qpack.HeaderField
+	for r.Len() > 0 {
+		b, _ := r.ReadByte()
+		var field qpack.HeaderField
+		switch {
+		case b&0x80 != 0: // Indexed field line
+			index, err := readPrefixInt(r, b, 6)
+			if err != nil {
+				return nil, err
+			}
+			static := b&0x40 != 0
+			if !static {
+				index = relative(index)
+			}
+			if field, err = lookup(static, index); err != nil {
+				return nil, err
+			}
+		case b&0x40 != 0: // Literal field line with name reference
+			index, err := readPrefixInt(r, b, 4)
+			if err != nil {
+				return nil, err
+			}
+			static := b&0x10 != 0
+			if !static {
+				index = relative(index)
+			}
+			if field, err = lookup(static, index); err != nil {
+				return nil, err
+			}
+			if field.Value, err = readQPACKValue(r); err != nil {
+				return nil, err
+			}
+		case b&0x20 != 0: // Literal field line with literal name
+			name, err := readQPACKString(r, b, 3)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readQPACKValue(r)
+			if err != nil {
+				return nil, err
+			}
+			field = qpack.HeaderField{Name: name, Value: value}
+		case b&0x10 != 0: // Indexed field line with post-base index
+			index, err := readPrefixInt(r, b, 4)
+			if err != nil {
+				return nil, err
+			}
+			if field, err = lookup(false, base+index); err != nil {
+				return nil, err
+			}
+		default: // Literal field line with post-base name reference
+			index, err := readPrefixInt(r, b, 3)
+			if err != nil {
+				return nil, err
+			}
+			if field, err = lookup(false, base+index); err != nil {
+				return nil, err
+			}
+			if field.Value, err = readQPACKValue(r); err != nil {
+				return nil, err
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	if requiredInsertCount > 0 && d.out != nil {
+		if requiredInsertCount > d.knownReceived {
+			d.knownReceived = requiredInsertCount
+		}
+		if _, err := d.out.Write(appendPrefixInt(nil, 0x80, 7, streamID)); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// requiredInsertCount decodes the Required Insert Count of a field section
+// prefix as RFC 9204 section 4.5.1.1 describes. d.mu must be held.
+func (d *qpackDecoder) requiredInsertCount(encoded uint64) (uint64, error) {
+	if encoded == 0 {
+		return 0, nil
+	}
+	maxEntries := d.maxCapacity / 32
+	fullRange := 2 * maxEntries
+	if encoded > fullRange {
+		return 0, fmt.Errorf("%w: invalid required insert count", errQPACKDecompression)
+	}
+	maxValue := d.inserted() + maxEntries
+	count := maxValue/fullRange*fullRange + encoded - 1
+	if count > maxValue {
+		if count <= fullRange {
+			return 0, fmt.Errorf("%w: invalid required insert count", errQPACKDecompression)
+		}
+		count -= fullRange
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("%w: invalid required insert count", errQPACKDecompression)
+	}
+	return count, nil
+}
+
+type qpackReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readPrefixInt reads an integer with an n-bit prefix, the rest of whose
+// first byte b is already read
+func readPrefixInt(r io.ByteReader, b byte, n uint8) (uint64, error) {
+	limit := uint64(1)<<n - 1
+	i := uint64(b) & limit
+	if i < limit {
+		return i, nil
+	}
+	for shift := uint(0); shift < 63; shift += 7 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("%w: truncated integer", errQPACKDecompression)
+		}
+		i += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: integer overflow", errQPACKDecompression)
+}
+
+// appendPrefixInt appends i with an n-bit prefix, the first byte carrying
+// the flags in first
+func appendPrefixInt(dst []byte, first byte, n uint8, i uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if i < limit {
+		return append(dst, first|byte(i))
+	}
+	dst = append(dst, first|byte(limit))
+	for i -= limit; i >= 0x80; i >>= 7 {
+		dst = append(dst, byte(i&0x7f)|0x80)
+	}
+	return append(dst, byte(i))
+}
+
+// readQPACKString reads a string whose length has an n-bit prefix in b,
+// preceded by the Huffman flag
+func readQPACKString(r qpackReader, b byte, n uint8) (string, error) {
+	length, err := readPrefixInt(r, b, n)
+	if err != nil {
+		return "", err
+	}
+	if length > maxQPACKString {
+		return "", fmt.Errorf("%w: string of %d bytes is too long", errQPACKDecompression, length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", fmt.Errorf("%w: truncated string", errQPACKDecompression)
+	}
+	if b&(1<<n) != 0 {
+		return hpack.HuffmanDecodeToString(buf)
+	}
+	return string(buf), nil
+}
+
+// readQPACKValue reads a value string, which has a 7-bit length prefix
+func readQPACKValue(r qpackReader) (string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return "", fmt.Errorf("%w: missing value", errQPACKDecompression)
+	}
+	return readQPACKString(r, b, 7)
+}
@@ -0,0 +1,149 @@
+package cycletls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/quic-go/qpack"
+)
+
+// qpackStaticIndex finds the static table entry for name and value
+func qpackStaticIndex(t *testing.T, name, value string) uint64 {
+	t.Helper()
+	for i := uint64(0); ; i++ {
+		field, ok := qpackStatic(i)
+		if !ok {
+			t.Fatalf("%s: %s is not in the static table", name, value)
+		}
+		if field.Name == name && field.Value == value {
+			return i
+		}
+	}
+}
+
+func appendQPACKString(b []byte, first byte, n uint8, s string) []byte {
+	return append(appendPrefixInt(b, first, n, uint64(len(s))), s...)
+}
+
+func TestQPACKDecoder_DynamicTable(t *testing.T) {
+	var out bytes.Buffer
+	d := newQPACKDecoder(4096)
+	d.out = &out
+
+	// Field section with Required Insert Count 2 and Base 2:
+	// dynamic entry 1, dynamic entry 0's name with a new value, :status 200
+	section := appendPrefixInt(nil, 0, 8, 2%(2*4096/32)+1)
+	section = appendPrefixInt(section, 0, 7, 0)
+	section = appendPrefixInt(section, 0x80, 6, 0)
+	section = appendPrefixInt(section, 0x40, 4, 1)
+	section = appendQPACKString(section, 0, 7, "v2")
+	section = appendPrefixInt(section, 0xc0, 6, qpackStaticIndex(t, ":status", "200"))
+
+	type result struct {
+		fields []qpack.HeaderField
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		fields, err := d.decodeFieldSection(context.Background(), 4, section)
+		done <- result{fields, err}
+	}()
+
+	// The section is blocked until the encoder stream inserts its entries
+	select {
+	case <-done:
+		t.Fatal("decoded before the referenced entries were inserted")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	encoder := appendPrefixInt(nil, 0x20, 5, 4096)
+	encoder = appendQPACKString(encoder, 0x40, 5, "x-custom")
+	encoder = appendQPACKString(encoder, 0, 7, "v1")
+	encoder = appendPrefixInt(encoder, 0xc0, 6, qpackStaticIndex(t, "content-type", "text/plain;charset=utf-8"))
+	encoder = appendQPACKString(encoder, 0, 7, "text/html")
+	go d.readEncoderStream(bufio.NewReader(bytes.NewReader(encoder)))
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	want := []qpack.HeaderField{
+		{Name: "content-type", Value: "text/html"},
+		{Name: "x-custom", Value: "v2"},
+		{Name: ":status", Value: "200"},
+	}
+	if len(res.fields) != len(want) {
+		t.Fatalf("got %v, want %v", res.fields, want)
+	}
+	for i := range want {
+		if res.fields[i] != want[i] {
+			t.Errorf("field %d: got %v, want %v", i, res.fields[i], want[i])
+		}
+	}
+
+	// A Section Acknowledgment for stream 4, and the two inserts counted as
+	// received once, whether by it or an Insert Count Increment first
+	time.Sleep(20 * time.Millisecond)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch got := out.Bytes(); {
+	case bytes.Equal(got, []byte{0x84}), bytes.Equal(got, []byte{0x02, 0x84}):
+	default:
+		t.Errorf("expected a Section Acknowledgment for stream 4, got %x", got)
+	}
+}
+
+func TestQPACKDecoder_Eviction(t *testing.T) {
+	d := newQPACKDecoder(100)
+	encoder := appendPrefixInt(nil, 0x20, 5, 100)
+	encoder = appendQPACKString(encoder, 0x40, 5, "a")
+	encoder = appendQPACKString(encoder, 0, 7, "1")
+	encoder = appendQPACKString(encoder, 0x40, 5, "b")
+	encoder = appendQPACKString(encoder, 0, 7, "2")
+	encoder = appendQPACKString(encoder, 0x40, 5, "c")
+	encoder = appendQPACKString(encoder, 0, 7, "3")
+	d.readEncoderStream(bufio.NewReader(bytes.NewReader(encoder)))
+
+	// Each entry takes 34 bytes, so only the last two fit
+	if _, ok := d.entry(0); ok {
+		t.Error("expected the oldest entry to be evicted")
+	}
+	if field, ok := d.entry(2); !ok || field.Name != "c" {
+		t.Errorf("expected entry 2 to be c, got %v", field)
+	}
+
+	// A capacity above the advertised one is an error
+	d = newQPACKDecoder(100)
+	d.readEncoderStream(bufio.NewReader(bytes.NewReader(appendPrefixInt(nil, 0x20, 5, 200))))
+	if !errors.Is(d.err, errQPACKDecompression) {
+		t.Errorf("expected a decompression error, got %v", d.err)
+	}
+}
+
+func TestQPACKDecoder_StaticOnly(t *testing.T) {
+	// Field sections from qpack's encoder, which only uses the static table
+	var block bytes.Buffer
+	encoder := qpack.NewEncoder(&block)
+	fields := []qpack.HeaderField{{Name: ":status", Value: "404"}, {Name: "server", Value: "test"}, {Name: "x-a", Value: "b"}}
+	for _, f := range fields {
+		if err := encoder.WriteField(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := newQPACKDecoder(0).decodeFieldSection(context.Background(), 0, block.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(fields) {
+		t.Fatalf("got %v, want %v", got, fields)
+	}
+	for i := range fields {
+		if got[i] != fields[i] {
+			t.Errorf("field %d: got %v, want %v", i, got[i], fields[i])
+		}
+	}
+}
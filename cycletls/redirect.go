@@ -0,0 +1,200 @@
+package cycletls
+
+import (
+	"context"
+	"fmt"
+	nhttp "net/http"
+	"strings"
+	"sync"
+
+	http "github.com/Danny-Dasilva/fhttp"
+)
+
+// DefaultMaxRedirects is how many redirects are followed when
+// Options.MaxRedirects is 0. A negative MaxRedirects follows none, returning
+// the first redirect as the response like DisableRedirect.
+const DefaultMaxRedirects = 10
+
+// Redirect is a response that was redirected from, on the way to the final
+// one
+type Redirect struct {
+	Method   string              `json:"method"`   // Method of the request that was redirected
+	URL      string              `json:"url"`      // URL of the request that was redirected
+	Status   int                 `json:"status"`   // 301, 302, 303, 307 or 308
+	Location string              `json:"location"` // Absolute URL the redirect went to
+	Headers  map[string][]string `json:"headers"`  // Every header of the response, Set-Cookie included
+	Cookies  []*nhttp.Cookie     `json:"cookies"`  // Cookies the response set
+}
+
+// redirectPolicy is the redirect options of one request
+type redirectPolicy struct {
+	max        int
+	sameHost   bool
+	sameScheme bool
+}
+
+// redirectTracker applies a request's redirect policy and records the
+// redirects it followed. Clients are shared between requests with different
+// options, so it travels with the request's context rather than the client.
+type redirectTracker struct {
+	policy redirectPolicy
+
+	mu        sync.Mutex
+	redirects []Redirect
+}
+
+type redirectTrackerKey struct{}
+
+// withRedirects returns a context whose redirects follow the options' policy
+// and are recorded on the returned tracker
+func withRedirects(ctx context.Context, options Options) (context.Context, *redirectTracker) {
+	t := &redirectTracker{policy: redirectPolicy{
+		max:        options.MaxRedirects,
+		sameHost:   options.SameHostRedirects,
+		sameScheme: options.SameSchemeRedirects,
+	}}
+	if t.policy.max == 0 {
+		t.policy.max = DefaultMaxRedirects
+	} else if t.policy.max < 0 {
+		t.policy.max = 0
+	}
+	return context.WithValue(ctx, redirectTrackerKey{}, t), t
+}
+
+func redirectsFromContext(ctx context.Context) *redirectTracker {
+	t, _ := ctx.Value(redirectTrackerKey{}).(*redirectTracker)
+	return t
+}
+
+// list returns the redirects followed so far, nil on a nil tracker
+func (t *redirectTracker) list() []Redirect {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Redirect(nil), t.redirects...)
+}
+
+func (t *redirectTracker) add(r Redirect) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.redirects = append(t.redirects, r)
+}
+
+// checkRedirect is the CheckRedirect of clients that follow redirects. It
+// applies the request's policy, stopping at a redirect it does not allow with
+// that redirect as the response, then the hooks, and sets the method and body
+// the way browsers do.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	t := redirectsFromContext(req.Context())
+	policy := redirectPolicy{max: DefaultMaxRedirects}
+	if t != nil {
+		policy = t.policy
+	}
+	if policy.max == 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > policy.max {
+		return fmt.Errorf("stopped after %d redirects", policy.max)
+	}
+	first := via[0]
+	if policy.sameHost && !strings.EqualFold(req.URL.Host, first.URL.Host) {
+		return http.ErrUseLastResponse
+	}
+	if policy.sameScheme && req.URL.Scheme != first.URL.Scheme {
+		return http.ErrUseLastResponse
+	}
+	if err := extensionsFromContext(req.Context()).redirect(req, via); err != nil {
+		return err
+	}
+	if err := redirectMethod(req, via); err != nil {
+		return err
+	}
+	if t != nil && req.Response != nil {
+		t.add(newRedirect(req.Response, req.URL.String()))
+	}
+	return nil
+}
+
+// redirectMethod sets the method and body of the redirect to req per RFC 9110
+// section 15.4: 301 and 302 turn a POST into a GET, 303 turns anything but a
+// HEAD into a GET, and 307 and 308 resend the request as it was. A request
+// turned into a GET loses its body and the headers describing it.
+func redirectMethod(req *http.Request, via []*http.Request) error {
+	prev, first := via[len(via)-1], via[0]
+	method, keepBody := prev.Method, prev.ContentLength != 0
+	switch req.Response.StatusCode {
+	case 301, 302:
+		if method == http.MethodPost {
+			method, keepBody = http.MethodGet, false
+		}
+	case 303:
+		if method != http.MethodHead {
+			method, keepBody = http.MethodGet, false
+		}
+	}
+	req.Method = method
+
+	if !keepBody {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		req.Body, req.GetBody, req.ContentLength = nil, nil, 0
+		for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Content-Language", "Content-Location"} {
+			req.Header.Del(name)
+		}
+		return nil
+	}
+	if req.Body == nil && first.GetBody != nil {
+		body, err := first.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Body, req.ContentLength = body, first.ContentLength
+	}
+	req.GetBody = first.GetBody
+	return nil
+}
+
+// newRedirect records resp, redirected to location
+func newRedirect(resp *http.Response, location string) Redirect {
+	r := Redirect{
+		Status:   resp.StatusCode,
+		Location: location,
+		Headers:  make(map[string][]string, len(resp.Header)),
+		Cookies:  netCookies(resp.Cookies()),
+	}
+	if resp.Request != nil {
+		r.Method = resp.Request.Method
+		if resp.Request.URL != nil {
+			r.URL = resp.Request.URL.String()
+		}
+	}
+	for name, values := range resp.Header {
+		r.Headers[name] = append([]string(nil), values...)
+	}
+	return r
+}
+
+// netCookies converts fhttp cookies to net/http ones
+func netCookies(cookies []*http.Cookie) []*nhttp.Cookie {
+	var netCookies []*nhttp.Cookie
+	for _, cookie := range cookies {
+		netCookies = append(netCookies, &nhttp.Cookie{
+			Name:       cookie.Name,
+			Value:      cookie.Value,
+			Path:       cookie.Path,
+			Domain:     cookie.Domain,
+			Expires:    cookie.Expires,
+			RawExpires: cookie.RawExpires,
+			MaxAge:     cookie.MaxAge,
+			Secure:     cookie.Secure,
+			HttpOnly:   cookie.HttpOnly,
+			SameSite:   nhttp.SameSite(cookie.SameSite),
+			Raw:        cookie.Raw,
+			Unparsed:   cookie.Unparsed,
+		})
+	}
+	return netCookies
+}
@@ -0,0 +1,186 @@
+package cycletls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Request modes for Options.RequestMode, the kind of request a browser
+// would be making
+const (
+	RequestModeDocument = "document" // Top level navigation
+	RequestModeXHR      = "xhr"      // XMLHttpRequest
+	RequestModeFetch    = "fetch"    // fetch(), sent like an XMLHttpRequest
+	RequestModeImage    = "image"    // <img> subresource
+)
+
+// firefoxHeaderOrder is the master order Firefox sends its headers in
+var firefoxHeaderOrder = []string{
+	"host",
+	"user-agent",
+	"accept",
+	"accept-language",
+	"accept-encoding",
+	"content-type",
+	"content-length",
+	"origin",
+	"connection",
+	"referer",
+	"cookie",
+	"upgrade-insecure-requests",
+	"sec-fetch-dest",
+	"sec-fetch-mode",
+	"sec-fetch-site",
+	"sec-fetch-user",
+	"priority",
+	"te",
+}
+
+// safariHeaderOrder is the master order Safari sends its headers in
+var safariHeaderOrder = []string{
+	"host",
+	"content-type",
+	"origin",
+	"content-length",
+	"accept",
+	"sec-fetch-site",
+	"cookie",
+	"sec-fetch-dest",
+	"accept-language",
+	"sec-fetch-mode",
+	"user-agent",
+	"referer",
+	"accept-encoding",
+	"priority",
+	"connection",
+}
+
+// requestModeHeaders are the headers each browser sends per request mode,
+// apart from the client hints applyClientHints adds
+var requestModeHeaders = map[string]map[string][][2]string{
+	chrome: {
+		RequestModeDocument: {
+			{"upgrade-insecure-requests", "1"},
+			{"accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"},
+			{"sec-fetch-site", "none"},
+			{"sec-fetch-mode", "navigate"},
+			{"sec-fetch-user", "?1"},
+			{"sec-fetch-dest", "document"},
+			{"accept-encoding", "gzip, deflate, br, zstd"},
+			{"accept-language", "en-US,en;q=0.9"},
+		},
+		RequestModeXHR: {
+			{"accept", "*/*"},
+			{"sec-fetch-site", "same-origin"},
+			{"sec-fetch-mode", "cors"},
+			{"sec-fetch-dest", "empty"},
+			{"accept-encoding", "gzip, deflate, br, zstd"},
+			{"accept-language", "en-US,en;q=0.9"},
+		},
+		RequestModeImage: {
+			{"accept", "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"},
+			{"sec-fetch-site", "same-origin"},
+			{"sec-fetch-mode", "no-cors"},
+			{"sec-fetch-dest", "image"},
+			{"accept-encoding", "gzip, deflate, br, zstd"},
+			{"accept-language", "en-US,en;q=0.9"},
+		},
+	},
+	safari: {
+		RequestModeDocument: {
+			{"accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			{"sec-fetch-site", "none"},
+			{"sec-fetch-dest", "document"},
+			{"accept-language", "en-US,en;q=0.9"},
+			{"sec-fetch-mode", "navigate"},
+			{"accept-encoding", "gzip, deflate, br"},
+		},
+		RequestModeXHR: {
+			{"accept", "*/*"},
+			{"sec-fetch-site", "same-origin"},
+			{"sec-fetch-dest", "empty"},
+			{"accept-language", "en-US,en;q=0.9"},
+			{"sec-fetch-mode", "cors"},
+			{"accept-encoding", "gzip, deflate, br"},
+		},
+		RequestModeImage: {
+			{"accept", "image/webp,image/avif,image/jxl,image/heic,image/heic-sequence,video/*;q=0.8,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"},
+			{"sec-fetch-site", "same-origin"},
+			{"sec-fetch-dest", "image"},
+			{"accept-language", "en-US,en;q=0.9"},
+			{"sec-fetch-mode", "no-cors"},
+			{"accept-encoding", "gzip, deflate, br"},
+		},
+	},
+	firefox: {
+		RequestModeDocument: {
+			{"accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			{"accept-language", "en-US,en;q=0.5"},
+			{"accept-encoding", "gzip, deflate, br, zstd"},
+			{"upgrade-insecure-requests", "1"},
+			{"sec-fetch-dest", "document"},
+			{"sec-fetch-mode", "navigate"},
+			{"sec-fetch-site", "none"},
+			{"sec-fetch-user", "?1"},
+		},
+		RequestModeXHR: {
+			{"accept", "*/*"},
+			{"accept-language", "en-US,en;q=0.5"},
+			{"accept-encoding", "gzip, deflate, br, zstd"},
+			{"sec-fetch-dest", "empty"},
+			{"sec-fetch-mode", "cors"},
+			{"sec-fetch-site", "same-origin"},
+		},
+		RequestModeImage: {
+			{"accept", "image/avif,image/webp,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"},
+			{"accept-language", "en-US,en;q=0.5"},
+			{"accept-encoding", "gzip, deflate, br, zstd"},
+			{"sec-fetch-dest", "image"},
+			{"sec-fetch-mode", "no-cors"},
+			{"sec-fetch-site", "same-origin"},
+		},
+	},
+}
+
+// requestModeProfile returns the headers browser sends for mode
+func requestModeProfile(browser, mode string) ([][2]string, error) {
+	switch mode = strings.ToLower(mode); mode {
+	case RequestModeFetch:
+		mode = RequestModeXHR
+	case RequestModeDocument, RequestModeXHR, RequestModeImage:
+	default:
+		return nil, fmt.Errorf("unknown request mode %q, expected document, xhr, fetch or image", mode)
+	}
+	return requestModeHeaders[headerProfile(browser)][mode], nil
+}
+
+// headerProfile returns the browser family whose headers family sends
+func headerProfile(family string) string {
+	switch family {
+	case iosSafari:
+		return safari
+	case androidWebView:
+		return chrome
+	}
+	return family
+}
+
+// applyRequestMode adds the headers the user agent's browser sends for
+// options.RequestMode to options.Headers. Headers the caller set, in Headers
+// or OrderedHeaders, are kept as they are.
+func applyRequestMode(options *Options) error {
+	if options.RequestMode == "" {
+		return nil
+	}
+	browser := parseUserAgent(options.UserAgent).UserAgent
+	headers, err := requestModeProfile(browser, options.RequestMode)
+	if err != nil {
+		return err
+	}
+	// Listed so the user agent takes its place in the header order
+	if options.UserAgent != "" {
+		headers = append(headers, [2]string{"user-agent", options.UserAgent})
+	}
+	addDefaultHeaders(options, headers)
+	return nil
+}
@@ -2,18 +2,15 @@ package cycletls
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	http "github.com/Danny-Dasilva/fhttp"
 	http2 "github.com/Danny-Dasilva/fhttp/http2"
-	"github.com/quic-go/quic-go"
-	"github.com/quic-go/quic-go/http3"
+	"github.com/Danny-Dasilva/fhttp/httptrace"
 	uquic "github.com/refraction-networking/uquic"
 	utls "github.com/refraction-networking/utls"
 	"golang.org/x/net/proxy"
 	"net"
-	stdhttp "net/http"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +18,10 @@ import (
 
 var errProtocolNegotiated = errors.New("protocol negotiated")
 
+// errHTTP3Proxy fails HTTP/3 requests of proxied clients, as QUIC cannot go
+// through the HTTP and SOCKS proxies supported
+var errHTTP3Proxy = errors.New("HTTP/3 is not supported through a proxy")
+
 type roundTripper struct {
 	sync.Mutex
 
@@ -30,12 +31,25 @@ type roundTripper struct {
 
 	// TLS fingerprinting options
 	JA3              string
-	JA4r             string // JA4 raw format with explicit cipher/extension values
+	JA4r             string   // JA4 raw format with explicit cipher/extension values
+	ClientHello      string   // Hex-encoded captured ClientHello
+	TLSSpec          *TLSSpec // Full ClientHello description
 	HTTP2Fingerprint string
+	HTTP3Fingerprint string
 	QUICFingerprint  string
 	USpec            *uquic.QUICSpec // UQuic QUIC specification for HTTP3 fingerprinting
 	DisableGrease    bool
 
+	// Dial HTTP/3 with the user agent's QUIC spec when USpec is nil
+	QUICFromUserAgent bool
+
+	// ClientHello shaping applied to JA3/JA4r specs
+	RandomizeExtensionOrder bool
+	ExtensionOrderSeed      int64
+	ExtensionData           map[string]string
+	StrictExtensions        bool
+	GreaseMode              string
+
 	// Browser identification
 	UserAgent   string
 	HeaderOrder []string
@@ -47,18 +61,43 @@ type roundTripper struct {
 	Cookies            []Cookie
 	ForceHTTP1         bool
 	ForceHTTP3         bool
+	IPFamily           string
+	LocalAddr          string
+	EnableAltSvc       bool
+	DNSServer          string
 
 	// TLS 1.3 specific options
 	TLS13AutoRetry bool
 
+	// HTTPS DNS record lookups, nil unless enabled
+	httpsRR *httpsRRResolver
+
 	// Caching
 	cachedConnections map[string]net.Conn
 	cachedTransports  map[string]http.RoundTripper
 
 	dialer proxy.ContextDialer
+
+	// Connections go through a proxy, which HTTP/3 cannot use
+	proxied bool
 }
 
 func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	send := rt.roundTrip
+	// Record the exchange when the request is captured for a HAR
+	if capture := harCaptureFromContext(req.Context()); capture != nil {
+		send = func(req *http.Request) (*http.Response, error) {
+			return capture.roundTrip(req, rt.roundTrip)
+		}
+	}
+	// Through the client's middleware and hooks, if it has any
+	return extensionsFromContext(req.Context()).roundTrip(req, send)
+}
+
+func (rt *roundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	timings := timingsFromContext(req.Context())
+	timings.begin()
+
 	// Apply cookies to the request
 	for _, properties := range rt.Cookies {
 		cookie := &http.Cookie{
@@ -77,8 +116,15 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		req.AddCookie(cookie)
 	}
 
-	// Apply user agent
-	req.Header.Set("User-Agent", rt.UserAgent)
+	// Apply user agent, keeping the name casing of one the request already has
+	userAgentKey := "User-Agent"
+	for k := range req.Header {
+		if strings.EqualFold(k, userAgentKey) {
+			userAgentKey = k
+			break
+		}
+	}
+	req.Header[userAgentKey] = []string{rt.UserAgent}
 
 	// Apply header order if specified (for regular headers, not pseudo-headers)
 	if len(rt.HeaderOrder) > 0 {
@@ -94,67 +140,45 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	// Check if we need HTTP/3 - matches reference implementation pattern
 	if rt.ForceHTTP3 {
-		// Extract host and port from request
-		host := req.URL.Hostname()
-		port := req.URL.Port()
-		if port == "" {
-			port = "443" // Default HTTPS port
-		}
-
-		// Check for USpec (matches reference implementation logic)
-		if rt.USpec != nil {
-			// Use UQuic-based HTTP/3 dialing
-			conn, err := rt.uhttp3Dial(req.Context(), rt.USpec, host, port)
-			if err != nil {
-				return nil, fmt.Errorf("uhttp3 dial failed: %w", err)
-			}
-			defer func() {
-				if conn.RawConn != nil {
-					conn.RawConn.Close()
-				}
-				// Close the QUIC connection based on its type
-				if conn.QuicConn != nil {
-					if conn.IsUQuic {
-						if uquicConn, ok := conn.QuicConn.(interface{ CloseWithError(uint64, string) error }); ok {
-							uquicConn.CloseWithError(0, "request completed")
-						}
-					} else {
-						if quicConn, ok := conn.QuicConn.(interface{ CloseWithError(uint64, string) error }); ok {
-							quicConn.CloseWithError(0, "request completed")
-						}
-					}
-				}
-			}()
-
-			// Use the HTTP/3 connection to make the request
-			return rt.makeHTTP3Request(req, conn)
-		}
+		return rt.roundTripHTTP3(req)
+	}
 
-		// Fall back to standard HTTP/3 dialing
-		conn, err := rt.ghttp3Dial(req.Context(), host, port)
-		if err != nil {
-			return nil, fmt.Errorf("ghttp3 dial failed: %w", err)
-		}
-		defer func() {
-			if conn.RawConn != nil {
-				conn.RawConn.Close()
+	// Switch to HTTP/3 when the origin has advertised it via Alt-Svc or its
+	// HTTPS record, unless that would go around the proxy
+	isHTTPS := !rt.ForceHTTP1 && !rt.proxied && strings.EqualFold(req.URL.Scheme, "https")
+	altSvcEnabled := rt.EnableAltSvc && isHTTPS
+	if isHTTPS && (altSvcEnabled || rt.httpsRR != nil) {
+		if rt.httpsRR != nil && strings.HasSuffix(addr, ":443") {
+			if record := rt.httpsRR.lookup(req.Context(), req.URL.Hostname()); record != nil && record.SupportsALPN("h3") {
+				globalAltSvcCache.advertise(addr, time.Now().Add(time.Duration(record.TTL)*time.Second))
 			}
-			// Close the QUIC connection based on its type
-			if conn.QuicConn != nil {
-				if conn.IsUQuic {
-					if uquicConn, ok := conn.QuicConn.(interface{ CloseWithError(uint64, string) error }); ok {
-						uquicConn.CloseWithError(0, "request completed")
-					}
-				} else {
-					if quicConn, ok := conn.QuicConn.(interface{ CloseWithError(uint64, string) error }); ok {
-						quicConn.CloseWithError(0, "request completed")
-					}
+		}
+		if _, ok := globalAltSvcCache.lookup(addr, time.Now()); ok {
+			resp, err := rt.roundTripHTTP3(req)
+			if err == nil {
+				globalAltSvcCache.markWorking(addr)
+				if altSvcEnabled {
+					globalAltSvcCache.update(addr, resp.Header.Values("Alt-Svc"), time.Now())
 				}
+				return resp, nil
+			}
+			if req.Context().Err() != nil {
+				return nil, err
 			}
-		}()
 
-		// Use the HTTP/3 connection to make the request
-		return rt.makeHTTP3Request(req, conn)
+			// Fall back to TCP, replaying the body if there is one
+			globalAltSvcCache.markBroken(addr, time.Now())
+			if req.Body != nil && req.Body != http.NoBody {
+				if req.GetBody == nil {
+					return nil, err
+				}
+				body, bodyErr := req.GetBody()
+				if bodyErr != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+		}
 	}
 
 	// Use cached transport if available, otherwise create a new one
@@ -164,8 +188,78 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 	}
 
+	// Note the connection the request goes out on for its fingerprints, and
+	// when the response starts for its timings
+	var conn net.Conn
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn:              func(info httptrace.GotConnInfo) { conn = info.Conn },
+		GotFirstResponseByte: timings.firstByte,
+	}))
+
+	// The HTTP/1.1 writer sends header map keys as they are, so the ordered
+	// headers go out under the casing they were given
+	sent := req
+	if _, ok := rt.cachedTransports[addr].(*http.Transport); ok {
+		if lines := headerLinesFromContext(req.Context()); len(lines) > 0 {
+			sent = req.WithContext(req.Context())
+			sent.Header = http1Header(req.Header, lines)
+		}
+	}
+
 	// Perform the request
-	return rt.cachedTransports[addr].RoundTrip(req)
+	resp, err := rt.cachedTransports[addr].RoundTrip(sent)
+	if err == nil {
+		recordFingerprints(sent, resp, conn)
+		if altSvcEnabled {
+			globalAltSvcCache.update(addr, resp.Header.Values("Alt-Svc"), time.Now())
+		}
+	}
+	return resp, err
+}
+
+// roundTripHTTP3 performs the request over QUIC
+func (rt *roundTripper) roundTripHTTP3(req *http.Request) (*http.Response, error) {
+	// QUIC would be dialed directly, revealing the address the proxy hides
+	if rt.proxied {
+		return nil, errHTTP3Proxy
+	}
+
+	// Extract host and port from request
+	host := req.URL.Hostname()
+	port := req.URL.Port()
+	if port == "" {
+		port = "443" // Default HTTPS port
+	}
+
+	spec := rt.USpec
+	if spec == nil && rt.QUICFromUserAgent && rt.QUICFingerprint == "" {
+		// Built for every dial, as the dial mutates the spec it is given
+		var err error
+		if spec, err = QUICSpecForUserAgent(rt.UserAgent); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check for USpec (matches reference implementation logic)
+	if spec != nil {
+		// Use UQuic-based HTTP/3 dialing
+		conn, err := rt.uhttp3Dial(req.Context(), spec, host, port)
+		if err != nil {
+			return nil, fmt.Errorf("uhttp3 dial failed: %w", err)
+		}
+
+		// Use the HTTP/3 connection to make the request
+		return rt.makeHTTP3Request(req, conn)
+	}
+
+	// Fall back to standard HTTP/3 dialing
+	conn, err := rt.ghttp3Dial(req.Context(), host, port)
+	if err != nil {
+		return nil, fmt.Errorf("ghttp3 dial failed: %w", err)
+	}
+
+	// Use the HTTP/3 connection to make the request
+	return rt.makeHTTP3Request(req, conn)
 }
 
 func (rt *roundTripper) getTransport(req *http.Request, addr string) error {
@@ -173,7 +267,7 @@ func (rt *roundTripper) getTransport(req *http.Request, addr string) error {
 	case "http":
 		// Allow connection reuse by removing DisableKeepAlives
 		rt.cachedTransports[addr] = &http.Transport{
-			DialContext: rt.dialer.DialContext,
+			DialContext: rt.dial,
 		}
 		return nil
 	case "https":
@@ -209,17 +303,28 @@ func (rt *roundTripper) getTransport(req *http.Request, addr string) error {
 	return nil
 }
 
+// dial connects to addr, through the proxy if there is one, and tells the
+// request's hooks
+func (rt *roundTripper) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := rt.dialer.DialContext(ctx, network, addr)
+	extensionsFromContext(ctx).dial(ctx, network, addr, err)
+	return conn, err
+}
+
 func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
 	rt.Lock()
 	defer rt.Unlock()
 
-	// Return cached connection if available
+	// Hand the transport the connection it was negotiated on, once: later
+	// dials need a fresh connection, as the transport has closed or still
+	// uses that one
 	if conn := rt.cachedConnections[addr]; conn != nil {
+		delete(rt.cachedConnections, addr)
 		return conn, nil
 	}
 
 	// Establish raw connection
-	rawConn, err := rt.dialer.DialContext(ctx, network, addr)
+	rawConn, err := rt.dial(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -239,9 +344,24 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 	var proactivelyUpgraded bool // Track if we proactively upgraded TLS 1.2 to 1.3
 
 	// Determine which fingerprint to use
-	if rt.QUICFingerprint != "" {
+	if rt.ClientHello != "" {
+		// Replay a captured ClientHello, parsed per dial since ApplyPreset mutates the spec
+		spec, err = ClientHelloHexToSpec(rt.ClientHello, rt.ForceHTTP1)
+		if err != nil {
+			return nil, err
+		}
+	} else if rt.TLSSpec != nil {
+		// Use the full JSON ClientHello description
+		spec, err = rt.TLSSpec.ToClientHelloSpec()
+		if err != nil {
+			return nil, err
+		}
+		if rt.ForceHTTP1 {
+			forceHTTP1ALPN(spec)
+		}
+	} else if rt.QUICFingerprint != "" {
 		// Use QUIC fingerprint
-		spec, err = QUICStringToSpec(rt.QUICFingerprint, rt.UserAgent, rt.ForceHTTP1)
+		spec, err = QUICStringToSpecWithOptions(rt.QUICFingerprint, rt.UserAgent, rt.ForceHTTP1, rt.specOptions())
 		if err != nil {
 			return nil, err
 		}
@@ -249,35 +369,44 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 		// Check if we should proactively upgrade TLS 1.2 to TLS 1.3
 		if rt.TLS13AutoRetry && strings.HasPrefix(rt.JA3, "771,") {
 			// Use TLS 1.3 compatible spec to avoid retry cycle
-			spec, err = StringToTLS13CompatibleSpec(rt.JA3, rt.UserAgent, rt.ForceHTTP1)
+			spec, err = StringToTLS13CompatibleSpecWithOptions(rt.JA3, rt.UserAgent, rt.ForceHTTP1, rt.specOptions())
 			proactivelyUpgraded = true
 		} else {
 			// Use original JA3 fingerprint
-			spec, err = StringToSpec(rt.JA3, rt.UserAgent, rt.ForceHTTP1)
+			spec, err = StringToSpecWithOptions(rt.JA3, rt.UserAgent, rt.ForceHTTP1, rt.specOptions())
 		}
 		if err != nil {
 			return nil, err
 		}
 	} else if rt.JA4r != "" {
 		// Use JA4r (raw) fingerprint
-		spec, err = JA4RStringToSpec(rt.JA4r, rt.UserAgent, rt.ForceHTTP1, rt.DisableGrease, serverName)
+		spec, err = JA4RStringToSpecWithOptions(rt.JA4r, rt.UserAgent, rt.ForceHTTP1, rt.DisableGrease, serverName, rt.specOptions())
 		if err != nil {
 			return nil, err
 		}
 	} else {
 		// Default to Chrome fingerprint
-		spec, err = StringToSpec(DefaultChrome_JA3, rt.UserAgent, rt.ForceHTTP1)
+		spec, err = StringToSpecWithOptions(DefaultChrome_JA3, rt.UserAgent, rt.ForceHTTP1, rt.specOptions())
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	// Create TLS client
-	conn := utls.UClient(rawConn, &utls.Config{
+	tlsConfig := &utls.Config{
 		ServerName:         serverName,
 		OmitEmptyPsk:       true,
 		InsecureSkipVerify: rt.InsecureSkipVerify,
-	}, utls.HelloCustom)
+	}
+
+	// Use real ECH when the HTTPS record publishes a config and the fingerprint carries an ECH extension
+	if rt.httpsRR != nil && strings.HasSuffix(addr, ":443") {
+		if record := rt.httpsRR.lookup(ctx, host); record != nil && len(record.ECHConfigList) > 0 && specHasECH(spec) {
+			tlsConfig.EncryptedClientHelloConfigList = record.ECHConfigList
+		}
+	}
+
+	// Create TLS client
+	conn := utls.UClient(rawConn, tlsConfig, utls.HelloCustom)
 
 	// Apply TLS fingerprint
 	if err := conn.ApplyPreset(spec); err != nil {
@@ -285,9 +414,13 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 	}
 
 	// Perform TLS handshake
-	if err = conn.Handshake(); err != nil {
+	if err = timedHandshake(ctx, conn); err != nil {
 		_ = conn.Close()
 
+		if echErr := rt.echRejected(host, err); echErr != nil {
+			return nil, echErr
+		}
+
 		if err.Error() == "tls: CurvePreferences includes unsupported curve" {
 			// Check if TLS 1.3 retry is enabled
 			if rt.TLS13AutoRetry {
@@ -307,41 +440,18 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 
 	// If transport already exists, return connection
 	if rt.cachedTransports[addr] != nil {
-		return conn, nil
+		return newFingerprintConn(conn), nil
 	}
 
 	// Create appropriate transport based on negotiated protocol
 	switch conn.ConnectionState().NegotiatedProtocol {
 	case http2.NextProtoTLS:
 		// HTTP/2 transport
-		parsedUserAgent := parseUserAgent(rt.UserAgent)
-
-		// Use HTTP/2 fingerprint if specified
-		var http2Transport http2.Transport
-		if rt.HTTP2Fingerprint != "" {
-			// Parse and apply HTTP/2 fingerprint
-			h2Fingerprint, err := NewHTTP2Fingerprint(rt.HTTP2Fingerprint)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse HTTP/2 fingerprint: %v", err)
-			}
-
-			http2Transport = http2.Transport{
-				DialTLS:     rt.dialTLSHTTP2,
-				PushHandler: &http2.DefaultPushHandler{},
-				Navigator:   parsedUserAgent.UserAgent,
-			}
-
-			// Apply HTTP/2 fingerprint settings
-			h2Fingerprint.Apply(&http2Transport)
-		} else {
-			http2Transport = http2.Transport{
-				DialTLS:     rt.dialTLSHTTP2,
-				PushHandler: &http2.DefaultPushHandler{},
-				Navigator:   parsedUserAgent.UserAgent,
-			}
+		http2Transport, err := rt.newHTTP2Transport()
+		if err != nil {
+			return nil, err
 		}
-
-		rt.cachedTransports[addr] = &http2Transport
+		rt.cachedTransports[addr] = http2Transport
 	default:
 		// HTTP/1.x transport - configure to avoid idle channel errors
 		rt.cachedTransports[addr] = &http.Transport{
@@ -351,15 +461,17 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 	}
 
 	// Cache the connection for future use
-	rt.cachedConnections[addr] = conn
+	rt.cachedConnections[addr] = newFingerprintConn(conn)
 
 	return nil, errProtocolNegotiated
 }
 
 // retryWithTLS13CompatibleCurves retries the TLS connection with TLS 1.3 compatible curves
 func (rt *roundTripper) retryWithTLS13CompatibleCurves(ctx context.Context, network, addr, host string) (net.Conn, error) {
+	timingsFromContext(ctx).tlsRetry()
+
 	// Establish raw connection for retry
-	rawConn, err := rt.dialer.DialContext(ctx, network, addr)
+	rawConn, err := rt.dial(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -369,25 +481,25 @@ func (rt *roundTripper) retryWithTLS13CompatibleCurves(ctx context.Context, netw
 	// Use TLS 1.3 compatible spec based on the original fingerprint type
 	if rt.QUICFingerprint != "" {
 		// For QUIC, we'll use the original spec but this could be enhanced
-		spec, err = QUICStringToSpec(rt.QUICFingerprint, rt.UserAgent, rt.ForceHTTP1)
+		spec, err = QUICStringToSpecWithOptions(rt.QUICFingerprint, rt.UserAgent, rt.ForceHTTP1, rt.specOptions())
 		if err != nil {
 			return nil, fmt.Errorf("failed to create QUIC spec for TLS 1.3 retry: %v", err)
 		}
 	} else if rt.JA3 != "" {
 		// Use TLS 1.3 compatible JA3 spec
-		spec, err = StringToTLS13CompatibleSpec(rt.JA3, rt.UserAgent, rt.ForceHTTP1)
+		spec, err = StringToTLS13CompatibleSpecWithOptions(rt.JA3, rt.UserAgent, rt.ForceHTTP1, rt.specOptions())
 		if err != nil {
 			return nil, fmt.Errorf("failed to create TLS 1.3 compatible JA3 spec: %v", err)
 		}
 	} else if rt.JA4r != "" {
 		// For JA4r, we'll use a fallback to default Chrome with TLS 1.3 compatible curves
-		spec, err = StringToTLS13CompatibleSpec(DefaultChrome_JA3, rt.UserAgent, rt.ForceHTTP1)
+		spec, err = StringToTLS13CompatibleSpecWithOptions(DefaultChrome_JA3, rt.UserAgent, rt.ForceHTTP1, rt.specOptions())
 		if err != nil {
 			return nil, fmt.Errorf("failed to create TLS 1.3 compatible JA4 fallback spec: %v", err)
 		}
 	} else {
 		// Default to TLS 1.3 compatible Chrome fingerprint
-		spec, err = StringToTLS13CompatibleSpec(DefaultChrome_JA3, rt.UserAgent, rt.ForceHTTP1)
+		spec, err = StringToTLS13CompatibleSpecWithOptions(DefaultChrome_JA3, rt.UserAgent, rt.ForceHTTP1, rt.specOptions())
 		if err != nil {
 			return nil, fmt.Errorf("failed to create TLS 1.3 compatible default spec: %v", err)
 		}
@@ -406,8 +518,12 @@ func (rt *roundTripper) retryWithTLS13CompatibleCurves(ctx context.Context, netw
 	}
 
 	// Perform TLS handshake for retry
-	if err = conn.Handshake(); err != nil {
+	if err = timedHandshake(ctx, conn); err != nil {
 		_ = conn.Close()
+
+		if echErr := rt.echRejected(host, err); echErr != nil {
+			return nil, echErr
+		}
 		return nil, fmt.Errorf("TLS 1.3 compatible handshake failed: %+v", err)
 	}
 
@@ -415,31 +531,11 @@ func (rt *roundTripper) retryWithTLS13CompatibleCurves(ctx context.Context, netw
 	switch conn.ConnectionState().NegotiatedProtocol {
 	case http2.NextProtoTLS:
 		// HTTP/2 transport
-		parsedUserAgent := parseUserAgent(rt.UserAgent)
-
-		var http2Transport http2.Transport
-		if rt.HTTP2Fingerprint != "" {
-			h2Fingerprint, err := NewHTTP2Fingerprint(rt.HTTP2Fingerprint)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse HTTP/2 fingerprint for TLS 1.3 retry: %v", err)
-			}
-
-			http2Transport = http2.Transport{
-				DialTLS:     rt.dialTLSHTTP2,
-				PushHandler: &http2.DefaultPushHandler{},
-				Navigator:   parsedUserAgent.UserAgent,
-			}
-
-			h2Fingerprint.Apply(&http2Transport)
-		} else {
-			http2Transport = http2.Transport{
-				DialTLS:     rt.dialTLSHTTP2,
-				PushHandler: &http2.DefaultPushHandler{},
-				Navigator:   parsedUserAgent.UserAgent,
-			}
+		http2Transport, err := rt.newHTTP2Transport()
+		if err != nil {
+			return nil, err
 		}
-
-		rt.cachedTransports[addr] = &http2Transport
+		rt.cachedTransports[addr] = http2Transport
 	default:
 		// HTTP/1.x transport
 		rt.cachedTransports[addr] = &http.Transport{
@@ -449,21 +545,35 @@ func (rt *roundTripper) retryWithTLS13CompatibleCurves(ctx context.Context, netw
 	}
 
 	// Cache the successful TLS 1.3 connection
-	rt.cachedConnections[addr] = conn
+	rt.cachedConnections[addr] = newFingerprintConn(conn)
 
 	return nil, errProtocolNegotiated
 }
 
+// echRejected returns an error for a handshake the server failed by
+// rejecting ECH, remembering the retry configs it sent so the next dial can
+// use them, and nil for other failures
+func (rt *roundTripper) echRejected(host string, err error) error {
+	var echErr *utls.ECHRejectionError
+	if !errors.As(err, &echErr) {
+		return nil
+	}
+	rt.httpsRR.updateECH(host, echErr.RetryConfigList)
+	return fmt.Errorf("server rejected ECH: %w", err)
+}
+
 // retryWithOriginalTLS12JA3 retries the TLS connection with the original TLS 1.2 JA3
 func (rt *roundTripper) retryWithOriginalTLS12JA3(ctx context.Context, network, addr, host string) (net.Conn, error) {
+	timingsFromContext(ctx).tlsRetry()
+
 	// Establish raw connection for fallback to original TLS 1.2 JA3
-	rawConn, err := rt.dialer.DialContext(ctx, network, addr)
+	rawConn, err := rt.dial(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
 
 	// Use original TLS 1.2 JA3 spec (no upgrade)
-	spec, err := StringToSpec(rt.JA3, rt.UserAgent, rt.ForceHTTP1)
+	spec, err := StringToSpecWithOptions(rt.JA3, rt.UserAgent, rt.ForceHTTP1, rt.specOptions())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create original TLS 1.2 JA3 spec: %v", err)
 	}
@@ -481,8 +591,12 @@ func (rt *roundTripper) retryWithOriginalTLS12JA3(ctx context.Context, network,
 	}
 
 	// Perform TLS handshake for fallback
-	if err = conn.Handshake(); err != nil {
+	if err = timedHandshake(ctx, conn); err != nil {
 		_ = conn.Close()
+
+		if echErr := rt.echRejected(host, err); echErr != nil {
+			return nil, echErr
+		}
 		return nil, fmt.Errorf("original TLS 1.2 handshake failed: %+v", err)
 	}
 
@@ -490,31 +604,11 @@ func (rt *roundTripper) retryWithOriginalTLS12JA3(ctx context.Context, network,
 	switch conn.ConnectionState().NegotiatedProtocol {
 	case http2.NextProtoTLS:
 		// HTTP/2 transport
-		parsedUserAgent := parseUserAgent(rt.UserAgent)
-
-		var http2Transport http2.Transport
-		if rt.HTTP2Fingerprint != "" {
-			h2Fingerprint, err := NewHTTP2Fingerprint(rt.HTTP2Fingerprint)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse HTTP/2 fingerprint for TLS 1.2 fallback: %v", err)
-			}
-
-			http2Transport = http2.Transport{
-				DialTLS:     rt.dialTLSHTTP2,
-				PushHandler: &http2.DefaultPushHandler{},
-				Navigator:   parsedUserAgent.UserAgent,
-			}
-
-			h2Fingerprint.Apply(&http2Transport)
-		} else {
-			http2Transport = http2.Transport{
-				DialTLS:     rt.dialTLSHTTP2,
-				PushHandler: &http2.DefaultPushHandler{},
-				Navigator:   parsedUserAgent.UserAgent,
-			}
+		http2Transport, err := rt.newHTTP2Transport()
+		if err != nil {
+			return nil, err
 		}
-
-		rt.cachedTransports[addr] = &http2Transport
+		rt.cachedTransports[addr] = http2Transport
 	default:
 		// HTTP/1.x transport
 		rt.cachedTransports[addr] = &http.Transport{
@@ -524,7 +618,7 @@ func (rt *roundTripper) retryWithOriginalTLS12JA3(ctx context.Context, network,
 	}
 
 	// Cache the successful TLS 1.2 fallback connection
-	rt.cachedConnections[addr] = conn
+	rt.cachedConnections[addr] = newFingerprintConn(conn)
 
 	return nil, errProtocolNegotiated
 }
@@ -581,6 +675,12 @@ func (rt *roundTripper) CloseIdleConnections(selectedAddr ...string) {
 			_ = conn.Close()
 			delete(rt.cachedConnections, addr)
 		}
+		// Including those handed to the transports
+		for _, transport := range rt.cachedTransports {
+			if t, ok := transport.(interface{ CloseIdleConnections() }); ok {
+				t.CloseIdleConnections()
+			}
+		}
 	}
 }
 
@@ -589,17 +689,32 @@ func newRoundTripper(browser Browser, dialer ...proxy.ContextDialer) http.RoundT
 	if len(dialer) > 0 {
 		contextDialer = dialer[0]
 	} else {
-		contextDialer = proxy.Direct
+		contextDialer = newDirectDialer(browser)
+	}
+
+	_, direct := contextDialer.(*happyEyeballsDialer)
+
+	// Proxied clients leave name resolution to the proxy, so their DNS
+	// queries do not reveal the hosts they visit
+	var httpsRR *httpsRRResolver
+	if browser.EnableHTTPSRR && direct {
+		httpsRR = getHTTPSRRResolver(browser.DNSServer)
 	}
 
 	return &roundTripper{
 		dialer:             contextDialer,
+		proxied:            !direct,
+		httpsRR:            httpsRR,
 		JA3:                browser.JA3,
 		JA4r:               browser.JA4r,
+		ClientHello:        browser.ClientHello,
+		TLSSpec:            browser.TLSSpec,
 		HTTP2Fingerprint:   browser.HTTP2Fingerprint,
+		HTTP3Fingerprint:   browser.HTTP3Fingerprint,
 		QUICFingerprint:    browser.QUICFingerprint,
 		USpec:              browser.USpec, // Add USpec field initialization
 		DisableGrease:      browser.DisableGrease,
+		QUICFromUserAgent:  browser.QUICFromUserAgent,
 		UserAgent:          browser.UserAgent,
 		HeaderOrder:        browser.HeaderOrder,
 		TLSConfig:          browser.TLSConfig,
@@ -610,91 +725,54 @@ func newRoundTripper(browser Browser, dialer ...proxy.ContextDialer) http.RoundT
 		InsecureSkipVerify: browser.InsecureSkipVerify,
 		ForceHTTP1:         browser.ForceHTTP1,
 		ForceHTTP3:         browser.ForceHTTP3,
+		IPFamily:           browser.IPFamily,
+		LocalAddr:          browser.LocalAddr,
+		EnableAltSvc:       browser.EnableAltSvc,
+		DNSServer:          browser.DNSServer,
+
+		// ClientHello shaping applied to JA3/JA4r specs
+		RandomizeExtensionOrder: browser.RandomizeExtensionOrder,
+		ExtensionOrderSeed:      browser.ExtensionOrderSeed,
+		ExtensionData:           browser.ExtensionData,
+		StrictExtensions:        browser.StrictExtensions,
+		GreaseMode:              browser.GreaseMode,
 
 		// TLS 1.3 specific options
 		TLS13AutoRetry: browser.TLS13AutoRetry,
 	}
 }
 
-// makeHTTP3Request performs an HTTP/3 request using the provided HTTP/3 connection
+// makeHTTP3Request performs an HTTP/3 request on the provided connection,
+// which the response body closes
 func (rt *roundTripper) makeHTTP3Request(req *http.Request, conn *HTTP3Connection) (*http.Response, error) {
-	// Create HTTP/3 RoundTripper with custom dial function that uses our established connection
-	tlsConfig := ConvertUtlsConfig(rt.TLSConfig)
-	if tlsConfig == nil {
-		tlsConfig = &tls.Config{}
+	fingerprint, err := rt.http3Fingerprint()
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
-	// Apply custom SNI if provided
-	if rt.ServerName != "" {
-		tlsConfig.ServerName = rt.ServerName
-	}
-
-	// Create HTTP/3 Transport - let it establish its own connections for now
-	roundTripper := &http3.Transport{
-		TLSClientConfig: tlsConfig,
-		QUICConfig: &quic.Config{
-			HandshakeIdleTimeout:           30 * time.Second,
-			MaxIdleTimeout:                 90 * time.Second,
-			KeepAlivePeriod:                15 * time.Second,
-			InitialStreamReceiveWindow:     512 * 1024,      // 512 KB
-			MaxStreamReceiveWindow:         2 * 1024 * 1024, // 2 MB
-			InitialConnectionReceiveWindow: 1024 * 1024,     // 1 MB
-			MaxConnectionReceiveWindow:     4 * 1024 * 1024, // 4 MB
-			MaxIncomingStreams:             100,
-			MaxIncomingUniStreams:          100,
-			EnableDatagrams:                false,
-			DisablePathMTUDiscovery:        false,
-			Allow0RTT:                      false,
-		},
-	}
-
-	// Convert fhttp.Request to net/http.Request
-	stdReq := &stdhttp.Request{
-		Method:           req.Method,
-		URL:              req.URL,
-		Proto:            req.Proto,
-		ProtoMajor:       req.ProtoMajor,
-		ProtoMinor:       req.ProtoMinor,
-		Header:           ConvertFhttpHeader(req.Header),
-		Body:             req.Body,
-		GetBody:          req.GetBody,
-		ContentLength:    req.ContentLength,
-		TransferEncoding: req.TransferEncoding,
-		Close:            req.Close,
-		Host:             req.Host,
-		Form:             req.Form,
-		PostForm:         req.PostForm,
-		MultipartForm:    req.MultipartForm,
-		Trailer:          ConvertFhttpHeader(req.Trailer),
-		RemoteAddr:       req.RemoteAddr,
-		RequestURI:       req.RequestURI,
-		TLS:              nil,
-		Cancel:           req.Cancel,
-		Response:         nil,
-	}
-
-	// Use the RoundTripper to make the request
-	stdResp, err := roundTripper.RoundTrip(stdReq)
+	client, err := newHTTP3ClientConn(conn, fingerprint)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := client.roundTrip(req)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	timingsFromContext(req.Context()).firstByte()
+	recordFingerprints(req, resp, client)
+	return resp, nil
+}
 
-	// Convert back to fhttp.Response
-	return &http.Response{
-		Status:           stdResp.Status,
-		StatusCode:       stdResp.StatusCode,
-		Proto:            stdResp.Proto,
-		ProtoMajor:       stdResp.ProtoMajor,
-		ProtoMinor:       stdResp.ProtoMinor,
-		Header:           ConvertHttpHeader(stdResp.Header),
-		Body:             stdResp.Body,
-		ContentLength:    stdResp.ContentLength,
-		TransferEncoding: stdResp.TransferEncoding,
-		Close:            stdResp.Close,
-		Uncompressed:     stdResp.Uncompressed,
-		Trailer:          ConvertHttpHeader(stdResp.Trailer),
-		Request:          req,
-		TLS:              nil,
-	}, nil
+// specHasECH reports whether spec includes an ECH extension that uTLS can replace with real ECH
+func specHasECH(spec *utls.ClientHelloSpec) bool {
+	for _, ext := range spec.Extensions {
+		if _, ok := ext.(utls.EncryptedClientHelloExtension); ok {
+			return true
+		}
+	}
+	return false
 }
 
 // Default JA3 fingerprint for Chrome
@@ -1,6 +1,7 @@
 package cycletls
 
 import (
+	"context"
 	"net"
 	"testing"
 
@@ -45,3 +46,35 @@ func TestGetTransport_NoPanicWhenCachedConnPresent(t *testing.T) {
 		t.Fatalf("getTransport returned error: %v", err)
 	}
 }
+
+// idleCloser records whether its idle connections were closed
+type idleCloser struct {
+	http.Transport
+	closed bool
+}
+
+func (c *idleCloser) CloseIdleConnections() { c.closed = true }
+
+// The connection a protocol was negotiated on is handed to the transport
+// once, and closing idle connections reaches it there
+func TestDialTLS_HandsCachedConnOnce(t *testing.T) {
+	rt := newRoundTripper(Browser{}).(*roundTripper)
+	c1, _ := net.Pipe()
+	defer c1.Close()
+	rt.cachedConnections["example.com:443"] = c1
+
+	conn, err := rt.dialTLS(context.Background(), "tcp", "example.com:443")
+	if err != nil || conn != c1 {
+		t.Fatalf("expected the cached connection, got %v %v", conn, err)
+	}
+	if _, ok := rt.cachedConnections["example.com:443"]; ok {
+		t.Fatal("expected the connection to leave the cache once handed out")
+	}
+
+	transport := &idleCloser{}
+	rt.cachedTransports["example.com:443"] = transport
+	rt.CloseIdleConnections()
+	if !transport.closed {
+		t.Fatal("expected the transport's idle connections closed")
+	}
+}
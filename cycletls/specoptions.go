@@ -0,0 +1,166 @@
+package cycletls
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// GreaseMode selects whether the spec builders insert GREASE values
+type GreaseMode string
+
+const (
+	// GreaseAuto keeps each builder's default: JA3 and QUIC specs GREASE for
+	// Chrome user agents, JA4r specs only where the fingerprint lists GREASE
+	GreaseAuto GreaseMode = "auto"
+	// GreaseOn inserts GREASE wherever Chrome does: the first cipher suite,
+	// the first and last extensions, supported_groups, supported_versions
+	// and key_share
+	GreaseOn GreaseMode = "on"
+	// GreaseOff sends no GREASE values, dropping any listed in the fingerprint
+	GreaseOff GreaseMode = "off"
+)
+
+// SpecOptions tunes how fingerprint strings are turned into a ClientHelloSpec
+// by StringToSpecWithOptions and JA4RStringToSpecWithOptions. The zero value
+// reproduces the plain StringToSpec/JA4RStringToSpec behaviour.
+type SpecOptions struct {
+	// RandomizeExtensionOrder permutes the extensions the way Chrome 110+
+	// does: every extension moves except GREASE, padding and pre_shared_key.
+	RandomizeExtensionOrder bool
+
+	// ExtensionOrderSeed makes the permutation deterministic. Zero picks a
+	// fresh order for every spec, and so for every connection.
+	ExtensionOrderSeed int64
+
+	// ExtensionData sends the given hex payload as the body of an extension,
+	// keyed by decimal or 0x-prefixed hex extension ID. It replaces the
+	// generated extension, or fills in one CycleTLS does not know.
+	ExtensionData map[string]string
+
+	// StrictExtensions fails on extension IDs CycleTLS does not know instead
+	// of sending them as empty generic extensions
+	StrictExtensions bool
+
+	// GreaseMode overrides the user agent based GREASE decision. The empty
+	// value behaves like GreaseAuto.
+	GreaseMode GreaseMode
+}
+
+// shuffleExtensions permutes exts in place when randomization is enabled,
+// leaving the positionally fixed extensions where they are
+func shuffleExtensions(exts []utls.TLSExtension, opts SpecOptions) []utls.TLSExtension {
+	if !opts.RandomizeExtensionOrder {
+		return exts
+	}
+
+	var movable []int
+	for i, ext := range exts {
+		if !isFixedPositionExtension(ext) {
+			movable = append(movable, i)
+		}
+	}
+
+	seed := opts.ExtensionOrderSeed
+	if seed == 0 {
+		var b [8]byte
+		_, _ = crand.Read(b[:])
+		seed = int64(binary.LittleEndian.Uint64(b[:]))
+	}
+	rand.New(rand.NewSource(seed)).Shuffle(len(movable), func(i, j int) {
+		exts[movable[i]], exts[movable[j]] = exts[movable[j]], exts[movable[i]]
+	})
+	return exts
+}
+
+// isFixedPositionExtension reports whether Chrome keeps ext in place when
+// shuffling: GREASE stays first and last, padding and pre_shared_key at the end
+func isFixedPositionExtension(ext utls.TLSExtension) bool {
+	switch e := ext.(type) {
+	case *utls.UtlsGREASEExtension, *CustomGREASEExtension, *utls.UtlsPaddingExtension, utls.PreSharedKeyExtension:
+		return true
+	case *utls.GenericExtension:
+		return IsGREASEValue(e.Id)
+	}
+	return false
+}
+
+// specOptions collects the spec builder settings configured on rt
+func (rt *roundTripper) specOptions() SpecOptions {
+	return SpecOptions{
+		RandomizeExtensionOrder: rt.RandomizeExtensionOrder,
+		ExtensionOrderSeed:      rt.ExtensionOrderSeed,
+		ExtensionData:           rt.ExtensionData,
+		StrictExtensions:        rt.StrictExtensions,
+		GreaseMode:              rt.effectiveGreaseMode(),
+	}
+}
+
+// effectiveGreaseMode returns the configured GreaseMode, with DisableGrease
+// standing in for GreaseOff when no mode is set
+func (rt *roundTripper) effectiveGreaseMode() GreaseMode {
+	if rt.GreaseMode == "" && rt.DisableGrease {
+		return GreaseOff
+	}
+	return GreaseMode(rt.GreaseMode)
+}
+
+// grease resolves the GREASE decision for a builder whose auto behaviour is
+// auto
+func (o SpecOptions) grease(auto bool) (bool, error) {
+	switch o.GreaseMode {
+	case "", GreaseAuto:
+		return auto, nil
+	case GreaseOn:
+		return true, nil
+	case GreaseOff:
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid GREASE mode %q, expected auto, on or off", o.GreaseMode)
+}
+
+// extensionOverride returns the raw payload configured in ExtensionData for
+// extension id. Keys are decimal or 0x-prefixed hex IDs, values hex payloads.
+func (o SpecOptions) extensionOverride(id uint16) ([]byte, bool, error) {
+	for key, value := range o.ExtensionData {
+		keyID, err := strconv.ParseUint(key, 0, 16)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid extension ID %q in extension data", key)
+		}
+		if uint16(keyID) != id {
+			continue
+		}
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid hex payload for extension %s: %w", key, err)
+		}
+		return data, true, nil
+	}
+	return nil, false, nil
+}
+
+// addGREASEExtensions places Chrome's two GREASE extensions: one first, and
+// one last ahead of padding and pre_shared_key
+func addGREASEExtensions(exts []utls.TLSExtension) []utls.TLSExtension {
+	out := []utls.TLSExtension{&utls.UtlsGREASEExtension{}}
+	placed := false
+	for _, ext := range exts {
+		switch ext.(type) {
+		case *utls.UtlsPaddingExtension, utls.PreSharedKeyExtension:
+			if !placed {
+				out = append(out, &utls.UtlsGREASEExtension{})
+				placed = true
+			}
+		}
+		out = append(out, ext)
+	}
+	if !placed {
+		out = append(out, &utls.UtlsGREASEExtension{})
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package unit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+)
+
+// connectProxy runs an HTTP CONNECT proxy that waits delay before answering,
+// and counts the tunnels it opens
+func connectProxy(t *testing.T, delay time.Duration) (string, *atomic.Int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	connects := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				connects.Add(1)
+				time.Sleep(delay)
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String(), connects
+}
+
+func TestDo_AltSvcThroughProxy(t *testing.T) {
+	// Advertises h3 on its own port
+	var port string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", `h3=":`+port+`"; ma=3600`)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	port = server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	// Catch any QUIC packet sent to the advertised port
+	udp, err := net.ListenPacket("udp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Skipf("advertised UDP port is taken: %v", err)
+	}
+	defer udp.Close()
+	var quicPackets atomic.Int32
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			if _, _, err := udp.ReadFrom(buf); err != nil {
+				return
+			}
+			quicPackets.Add(1)
+		}
+	}()
+
+	proxyURL, connects := connectProxy(t, 0)
+	client := cycletls.Init()
+	defer client.Close()
+	options := cycletls.Options{
+		UserAgent:             UserAgent,
+		InsecureSkipVerify:    true,
+		Proxy:                 proxyURL,
+		EnableAltSvc:          true,
+		EnableConnectionReuse: false,
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Do(server.URL, options, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.Status, 200)
+	}
+
+	// The advertised HTTP/3 endpoint is never dialed around the proxy
+	time.Sleep(50 * time.Millisecond)
+	assertEqual(t, connects.Load(), int32(2))
+	assertEqual(t, quicPackets.Load(), int32(0))
+
+	// And forcing HTTP/3 fails instead of leaking
+	options.ForceHTTP3 = true
+	resp, err := client.Do(server.URL, options, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status == 200 || !strings.Contains(resp.Body, "HTTP/3 is not supported through a proxy") {
+		t.Fatalf("expected the HTTP/3 request to fail, got %d %q", resp.Status, resp.Body)
+	}
+	assertEqual(t, quicPackets.Load(), int32(0))
+}
@@ -0,0 +1,198 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	utls "github.com/refraction-networking/utls"
+)
+
+// chromeClientHello builds a Chrome ClientHello handshake message with uTLS
+func chromeClientHello(t *testing.T) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	uconn := utls.UClient(client, &utls.Config{ServerName: "example.com"}, utls.HelloChrome_Auto)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatal(err)
+	}
+	return uconn.HandshakeState.Hello.Raw
+}
+
+func extensionTypes(t *testing.T, spec *utls.ClientHelloSpec) []uint16 {
+	t.Helper()
+	var types []uint16
+	for _, ext := range spec.Extensions {
+		if ext.Len() < 2 {
+			continue // padding is sized when the ClientHello is marshaled
+		}
+		data := make([]byte, ext.Len())
+		_, _ = ext.Read(data)
+		types = append(types, binary.BigEndian.Uint16(data))
+	}
+	return types
+}
+
+// tlsRecords wraps a handshake message in TLS records of at most size bytes
+func tlsRecords(handshake []byte, size int) []byte {
+	var out []byte
+	for len(handshake) > 0 {
+		n := min(size, len(handshake))
+		out = append(out, 0x16, 0x03, 0x01, byte(n>>8), byte(n))
+		out = append(out, handshake[:n]...)
+		handshake = handshake[n:]
+	}
+	return out
+}
+
+func TestSpecFromClientHelloBytes(t *testing.T) {
+	hello := chromeClientHello(t)
+
+	fromHandshake, err := cycletls.SpecFromClientHelloBytes(hello)
+	if err != nil {
+		t.Fatalf("bare handshake: %v", err)
+	}
+	// A ClientHello fragmented over several records parses to the same spec
+	fromRecords, err := cycletls.SpecFromClientHelloBytes(tlsRecords(hello, 200))
+	if err != nil {
+		t.Fatalf("fragmented records: %v", err)
+	}
+
+	assertEqual(t, len(fromRecords.CipherSuites), len(fromHandshake.CipherSuites))
+	want := extensionTypes(t, fromHandshake)
+	got := extensionTypes(t, fromRecords)
+	assertEqual(t, len(got), len(want))
+	for i := range want {
+		assertEqual(t, got[i], want[i])
+	}
+
+	if _, err := cycletls.SpecFromClientHelloBytes(hello[:len(hello)-10]); err == nil {
+		t.Fatal("expected error for truncated ClientHello")
+	}
+}
+
+func TestClientHelloHexToSpec_ForceHTTP1(t *testing.T) {
+	spec, err := cycletls.ClientHelloHexToSpec(hex.EncodeToString(chromeClientHello(t)), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			assertEqual(t, len(alpn.AlpnProtocols), 1)
+			assertEqual(t, alpn.AlpnProtocols[0], "http/1.1")
+			return
+		}
+	}
+	t.Fatal("ALPN extension missing")
+}
+
+// writePcap writes Ethernet/IPv4/TCP packets carrying consecutive payloads to
+// a pcap file, in the packet order given by order
+func writePcap(t *testing.T, payloads [][]byte, order ...int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	header := []uint32{0xa1b2c3d4, 0x00040002, 0, 0, 65535, 1}
+	for _, v := range header[:2] {
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+	for _, v := range header[2:] {
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+
+	seqs := make([]uint32, len(payloads))
+	seq := uint32(1000)
+	for i, payload := range payloads {
+		seqs[i] = seq
+		seq += uint32(len(payload))
+	}
+	if len(order) == 0 {
+		for i := range payloads {
+			order = append(order, i)
+		}
+	}
+
+	for _, i := range order {
+		payload := payloads[i]
+		var packet bytes.Buffer
+		packet.Write(make([]byte, 12))   // MAC addresses
+		packet.Write([]byte{0x08, 0x00}) // IPv4
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+20+len(payload)))
+		ip[9] = 6
+		copy(ip[12:], []byte{10, 0, 0, 1})
+		copy(ip[16:], []byte{10, 0, 0, 2})
+		packet.Write(ip)
+		tcp := make([]byte, 20)
+		binary.BigEndian.PutUint16(tcp[0:], 50000)
+		binary.BigEndian.PutUint16(tcp[2:], 443)
+		binary.BigEndian.PutUint32(tcp[4:], seqs[i])
+		tcp[12] = 5 << 4
+		packet.Write(tcp)
+		packet.Write(payload)
+
+		record := []uint32{0, 0, uint32(packet.Len()), uint32(packet.Len())}
+		for _, v := range record {
+			_ = binary.Write(&buf, binary.LittleEndian, v)
+		}
+		buf.Write(packet.Bytes())
+	}
+
+	path := filepath.Join(t.TempDir(), "hello.pcap")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSpecFromPcap_ReassemblesSegments(t *testing.T) {
+	record := tlsRecords(chromeClientHello(t), 1<<14)
+	split := len(record) / 2
+	// Segments arrive out of order
+	path := writePcap(t, [][]byte{record[:split], record[split:]}, 1, 0)
+
+	raw, err := cycletls.ClientHelloFromPcap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, record) {
+		t.Fatalf("reassembled %d bytes, expected %d", len(raw), len(record))
+	}
+	if _, err := cycletls.SpecFromPcap(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first half of the ClientHello was captured
+	if _, err := cycletls.ClientHelloFromPcap(writePcap(t, [][]byte{record[:split]})); err == nil {
+		t.Fatal("expected error for incomplete capture")
+	}
+}
+
+func TestDo_ClientHelloOption(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := cycletls.Init()
+	resp, err := client.Do(server.URL, cycletls.Options{
+		ClientHello:        hex.EncodeToString(chromeClientHello(t)),
+		InsecureSkipVerify: true,
+		ForceHTTP1:         true,
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Status, 200)
+	assertEqual(t, resp.Body, "ok")
+}
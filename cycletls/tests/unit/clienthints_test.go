@@ -0,0 +1,50 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+)
+
+func TestDo_ClientHints(t *testing.T) {
+	var got []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Clone())
+		w.Header().Set("Accept-CH", "Sec-CH-UA-Full-Version-List, Sec-CH-UA-Platform-Version")
+	}))
+	defer server.Close()
+
+	client := cycletls.Init()
+	for _, options := range []cycletls.Options{
+		{UserAgent: chrome131UA, Session: "a"},
+		{UserAgent: chrome131UA, Session: "a"},
+		{UserAgent: chrome131UA, Session: "b"},
+		{UserAgent: chrome131UA, Session: "a", Headers: map[string]string{"sec-ch-ua-platform": `"Linux"`}},
+		{UserAgent: firefoxUA, Session: "a"},
+		{UserAgent: UserAgent, Session: "a"},
+	} {
+		if _, err := client.Do(server.URL, options, "GET"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The low entropy hints are always sent
+	assertEqual(t, got[0].Get("Sec-Ch-Ua"), `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`)
+	assertEqual(t, got[0].Get("Sec-Ch-Ua-Mobile"), "?0")
+	assertEqual(t, got[0].Get("Sec-Ch-Ua-Platform"), `"Windows"`)
+	assertEqual(t, got[0].Get("Sec-Ch-Ua-Full-Version-List"), "")
+
+	// The session remembers what Accept-CH asked for
+	assertEqual(t, got[1].Get("Sec-Ch-Ua-Full-Version-List"), `"Google Chrome";v="131.0.0.0", "Chromium";v="131.0.0.0", "Not_A Brand";v="24.0.0.0"`)
+	assertEqual(t, got[1].Get("Sec-Ch-Ua-Platform-Version"), `"10.0.0"`)
+	assertEqual(t, got[2].Get("Sec-Ch-Ua-Full-Version-List"), "")
+
+	// Headers set by the caller win
+	assertEqual(t, got[3].Get("Sec-Ch-Ua-Platform"), `"Linux"`)
+
+	// Firefox and Chrome before 89 send none
+	assertEqual(t, got[4].Get("Sec-Ch-Ua"), "")
+	assertEqual(t, got[5].Get("Sec-Ch-Ua"), "")
+}
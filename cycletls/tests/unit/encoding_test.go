@@ -0,0 +1,194 @@
+package unit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+const encodingPayload = `{"message": "hello from a compressed body"}`
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func brotliBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := brotli.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	return w.EncodeAll(data, nil)
+}
+
+func TestDecompressBody_Zstd(t *testing.T) {
+	body := cycletls.DecompressBody(zstdBytes(t, []byte(encodingPayload)), []string{"zstd"}, nil)
+	assertEqual(t, string(body), encodingPayload)
+}
+
+func TestDecompressBody_StackedEncodings(t *testing.T) {
+	// "gzip, br" means gzip was applied first, then brotli
+	encoded := brotliBytes(t, gzipBytes(t, []byte(encodingPayload)))
+	body := cycletls.DecompressBody(encoded, []string{"gzip, br"}, nil)
+	assertEqual(t, string(body), encodingPayload)
+
+	// The same codings split across several header values
+	encoded = zstdBytes(t, brotliBytes(t, []byte(encodingPayload)))
+	body = cycletls.DecompressBody(encoded, []string{"br", "zstd"}, nil)
+	assertEqual(t, string(body), encodingPayload)
+}
+
+func TestDecompressBody_UnknownEncoding(t *testing.T) {
+	body := cycletls.DecompressBody([]byte(encodingPayload), []string{"gzip, compress"}, nil)
+	assertEqual(t, string(body), encodingPayload)
+}
+
+func TestNewDecodingReader_Streaming(t *testing.T) {
+	payload := bytes.Repeat([]byte(encodingPayload), 1000)
+	encoded := zstdBytes(t, gzipBytes(t, payload))
+
+	reader, err := cycletls.NewDecodingReader(io.NopCloser(bytes.NewReader(encoded)), []string{"gzip", "zstd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// Read in small chunks the way the WS_PORT dispatcher does
+	var decoded bytes.Buffer
+	chunk := make([]byte, 512)
+	for {
+		n, err := reader.Read(chunk)
+		decoded.Write(chunk[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(decoded.Bytes(), payload) {
+		t.Fatalf("decoded %d bytes, expected %d", decoded.Len(), len(payload))
+	}
+}
+
+func TestDo_DecompressionLimits(t *testing.T) {
+	// 4 MB of zeros compresses to a few KB: a small decompression bomb
+	bomb := gzipBytes(t, make([]byte, 4<<20))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(bomb)
+	}))
+	defer server.Close()
+
+	client := cycletls.Init()
+	headers := map[string]string{"Accept-Encoding": "gzip"}
+
+	_, err := client.Do(server.URL, cycletls.Options{Headers: headers, MaxDecompressedBytes: 1 << 20}, "GET")
+	var tooLarge *cycletls.ResponseTooLargeError
+	if !errors.As(err, &tooLarge) || !tooLarge.Decompressed {
+		t.Fatalf("expected decompressed ResponseTooLargeError, got %v", err)
+	}
+
+	_, err = client.Do(server.URL, cycletls.Options{Headers: headers, MaxResponseBytes: 1024}, "GET")
+	if !errors.As(err, &tooLarge) || tooLarge.Decompressed {
+		t.Fatalf("expected ResponseTooLargeError, got %v", err)
+	}
+
+	resp, err := client.Do(server.URL, cycletls.Options{Headers: headers, MaxResponseBytes: int64(len(bomb)), MaxDecompressedBytes: 4 << 20}, "GET")
+	if err != nil {
+		t.Fatalf("expected body within limits to succeed, got %v", err)
+	}
+	if len(resp.BodyBytes) != 4<<20 {
+		t.Fatalf("expected %d decoded bytes, got %d", 4<<20, len(resp.BodyBytes))
+	}
+}
+
+func TestUndecodableBodyIsReturnedRaw(t *testing.T) {
+	// Bodies that do not match their Content-Encoding, one longer than a
+	// WS_PORT chunk, and a truncated one
+	bodies := map[string][]byte{
+		"gzip": []byte(encodingPayload),
+		"br":   bytes.Repeat([]byte(encodingPayload), 1000),
+		"zstd": zstdBytes(t, bytes.Repeat([]byte(encodingPayload), 100))[:40],
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		coding := r.URL.Path[1:]
+		w.Header().Set("Content-Encoding", coding)
+		w.Write(bodies[coding])
+	}))
+	defer server.Close()
+
+	client := cycletls.Init()
+	defer client.Close()
+	ws := httptest.NewServer(client)
+	defer ws.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ws.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for coding, body := range bodies {
+		resp, err := client.Do(server.URL+"/"+coding, cycletls.Options{Headers: map[string]string{"Accept-Encoding": coding}}, "GET")
+		if err != nil {
+			t.Fatalf("%s: expected the raw body, got %v", coding, err)
+		}
+		if !bytes.Equal(resp.BodyBytes, body) {
+			t.Errorf("%s: got %q, want the raw body %q", coding, resp.BodyBytes, body)
+		}
+
+		// The WS_PORT server sends the same body
+		err = conn.WriteJSON(map[string]interface{}{"requestId": coding, "options": map[string]interface{}{
+			"url": server.URL + "/" + coding, "method": "GET", "headers": map[string]string{"Accept-Encoding": coding},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var streamed []byte
+		for done := false; !done; {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := frameReader(frame)
+			r.string()
+			switch r.string() {
+			case "data":
+				streamed = append(streamed, r[4:]...)
+			case "error":
+				t.Fatalf("%s: expected the raw body over WS_PORT, got error %d %q", coding, r.u16(), r.string())
+			case "end":
+				done = true
+			}
+		}
+		if !bytes.Equal(streamed, body) {
+			t.Errorf("%s: got %q over WS_PORT, want the raw body %q", coding, streamed, body)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package unit
+
+import (
+	"bytes"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	utls "github.com/refraction-networking/utls"
+)
+
+// unknownExtJA3 offers extension 4660 (0x1234), which CycleTLS has no builder for
+const unknownExtJA3 = "771,4865-4866-4867-49195-49199,0-23-65281-10-11-4660-13-51-45-43,29-23-24,0"
+
+// genericExtension returns the generic extension with the given ID from spec
+func genericExtension(spec *utls.ClientHelloSpec, id uint16) *utls.GenericExtension {
+	for _, ext := range spec.Extensions {
+		if generic, ok := ext.(*utls.GenericExtension); ok && generic.Id == id {
+			return generic
+		}
+	}
+	return nil
+}
+
+func TestStringToSpec_UnknownExtensionPassthrough(t *testing.T) {
+	spec, err := cycletls.StringToSpec(unknownExtJA3, UserAgent, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext := genericExtension(spec, 4660)
+	if ext == nil {
+		t.Fatal("unknown extension 4660 was dropped")
+	}
+	assertEqual(t, len(ext.Data), 0)
+}
+
+func TestStringToSpec_StrictExtensions(t *testing.T) {
+	_, err := cycletls.StringToSpecWithOptions(unknownExtJA3, UserAgent, false, cycletls.SpecOptions{StrictExtensions: true})
+	if err == nil {
+		t.Fatal("expected an error for an unknown extension in strict mode")
+	}
+	assertEqual(t, err.Error(), "Extension {{ 4660 }} is not Supported by CycleTLS please raise an issue")
+}
+
+func TestStringToSpec_ExtensionData(t *testing.T) {
+	spec, err := cycletls.StringToSpecWithOptions(unknownExtJA3, UserAgent, false, cycletls.SpecOptions{
+		ExtensionData: map[string]string{"0x1234": "cafe", "23": "00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext := genericExtension(spec, 4660); ext == nil || !bytes.Equal(ext.Data, []byte{0xca, 0xfe}) {
+		t.Fatalf("extension 4660 payload not applied: %+v", ext)
+	}
+	// Known extensions can be overridden too
+	if ext := genericExtension(spec, 23); ext == nil || !bytes.Equal(ext.Data, []byte{0x00}) {
+		t.Fatalf("extension 23 payload not applied: %+v", ext)
+	}
+
+	_, err = cycletls.StringToSpecWithOptions(unknownExtJA3, UserAgent, false, cycletls.SpecOptions{
+		ExtensionData: map[string]string{"4660": "zz"},
+	})
+	if err == nil {
+		t.Fatal("expected an error for an invalid hex payload")
+	}
+}
+
+func TestJA4RStringToSpec_UnknownExtensions(t *testing.T) {
+	ja4r := "t13d0303h2_1301,1302,1303_000a,000d,0033,1234,002b_0403,0804"
+
+	spec, err := cycletls.JA4RStringToSpec(ja4r, UserAgent, false, false, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genericExtension(spec, 0x1234) == nil {
+		t.Fatal("unknown extension 0x1234 was dropped")
+	}
+
+	_, err = cycletls.JA4RStringToSpecWithOptions(ja4r, UserAgent, false, false, "example.com", cycletls.SpecOptions{StrictExtensions: true})
+	if err == nil {
+		t.Fatal("expected an error for an unknown extension in strict mode")
+	}
+
+	spec, err = cycletls.JA4RStringToSpecWithOptions(ja4r, UserAgent, false, false, "example.com", cycletls.SpecOptions{
+		ExtensionData: map[string]string{"0x1234": "0102"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext := genericExtension(spec, 0x1234); ext == nil || !bytes.Equal(ext.Data, []byte{1, 2}) {
+		t.Fatalf("extension 0x1234 payload not applied: %+v", ext)
+	}
+}
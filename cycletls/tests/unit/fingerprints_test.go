@@ -0,0 +1,143 @@
+package unit
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	fhttp "github.com/Danny-Dasilva/fhttp"
+	fhttp2 "github.com/Danny-Dasilva/fhttp/http2"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	utls "github.com/refraction-networking/utls"
+)
+
+// foxIOChromeSpec is the Chrome ClientHello from the FoxIO JA4 examples
+func foxIOChromeSpec() *utls.ClientHelloSpec {
+	return &utls.ClientHelloSpec{
+		TLSVersMin: utls.VersionTLS12,
+		TLSVersMax: utls.VersionTLS13,
+		CipherSuites: []uint16{
+			0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9,
+			0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+		},
+		CompressionMethods: []byte{0},
+		Extensions: []utls.TLSExtension{
+			&utls.SNIExtension{},
+			&utls.ExtendedMasterSecretExtension{},
+			&utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient},
+			&utls.SupportedCurvesExtension{Curves: []utls.CurveID{utls.X25519, utls.CurveP256, utls.CurveP384}},
+			&utls.SupportedPointsExtension{SupportedPoints: []byte{0}},
+			&utls.SessionTicketExtension{},
+			&utls.ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
+			&utls.StatusRequestExtension{},
+			&utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []utls.SignatureScheme{
+				0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601,
+			}},
+			&utls.SCTExtension{},
+			&utls.KeyShareExtension{KeyShares: []utls.KeyShare{{Group: utls.X25519}}},
+			&utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}},
+			&utls.SupportedVersionsExtension{Versions: []uint16{utls.VersionTLS13, utls.VersionTLS12}},
+			&utls.UtlsCompressCertExtension{Algorithms: []utls.CertCompressionAlgo{utls.CertCompressionBrotli}},
+			&utls.ApplicationSettingsExtension{SupportedProtocols: []string{"h2"}},
+			&utls.GenericExtension{Id: 0x0015, Data: []byte{0, 0}},
+		},
+	}
+}
+
+func TestSpecFingerprints_FoxIOChrome(t *testing.T) {
+	fp, err := cycletls.SpecFingerprints(foxIOChromeSpec(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, fp.JA4, "t13d1516h2_8daaf6152771_e5627efa2ab1")
+	assertEqual(t, fp.JA4R, "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_0403,0804,0401,0503,0805,0501,0806,0601")
+}
+
+func TestSpecFingerprints_JA3RoundTrip(t *testing.T) {
+	ja3 := "771,4865-4866-4867-49195-49199,0-23-65281-10-11-35-16-5-13-51-45-43,29-23-24,0"
+	spec, err := cycletls.StringToSpecWithOptions(ja3, firefoxUA, false, cycletls.SpecOptions{GreaseMode: cycletls.GreaseOff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := cycletls.SpecFingerprints(spec, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, fp.JA3, ja3)
+	sum := md5.Sum([]byte(ja3))
+	assertEqual(t, fp.JA3Hash, hex.EncodeToString(sum[:]))
+}
+
+func TestJA4H(t *testing.T) {
+	req, err := fhttp.NewRequest("GET", "https://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = fhttp.Header{
+		"Accept":             {"*/*"},
+		"Accept-Language":    {"en-US,en;q=0.9"},
+		"Cookie":             {"b=2; a=1"},
+		"Referer":            {"https://example.com/"},
+		"User-Agent":         {UserAgent},
+		fhttp.HeaderOrderKey: {"user-agent", "accept", "accept-language", "cookie", "referer"},
+	}
+
+	parts := strings.Split(cycletls.JA4H(req, 2), "_")
+	assertEqual(t, len(parts), 4)
+	// 3 headers counted: cookie and referer are flags
+	assertEqual(t, parts[0], "ge20cr03enus")
+
+	// HTTP/1.1 writes Host from the header map, so it counts there
+	req.Header.Set("Host", "example.com")
+	assertEqual(t, strings.Split(cycletls.JA4H(req, 2), "_")[0], "ge20cr03enus")
+	assertEqual(t, strings.Split(cycletls.JA4H(req, 1), "_")[0], "ge11cr04enus")
+}
+
+func TestDo_ReportsFingerprints(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := cycletls.Init()
+	resp, err := client.Do(server.URL, cycletls.Options{
+		Ja3:                "771,4865-4866-4867-49195-49199,0-23-65281-10-11-35-16-5-13-51-45-43,29-23-24,0",
+		UserAgent:          firefoxUA,
+		InsecureSkipVerify: true,
+		ForceHTTP1:         true,
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Fingerprints == nil {
+		t.Fatal("expected fingerprints on the response")
+	}
+	// No SNI for an IP address, so no server_name extension either
+	assertEqual(t, resp.Fingerprints.JA3, "771,4865-4866-4867-49195-49199,23-65281-10-11-35-16-5-13-51-45-43,29-23-24,0")
+	// A JA3 version of 771 caps supported_versions at TLS 1.2
+	if !strings.HasPrefix(resp.Fingerprints.JA4, "t12i0511h1_") {
+		t.Fatalf("unexpected JA4 %s", resp.Fingerprints.JA4)
+	}
+	if !strings.HasPrefix(resp.Fingerprints.JA4H, "ge11") {
+		t.Fatalf("unexpected JA4H %s", resp.Fingerprints.JA4H)
+	}
+}
+
+func TestDeprecatedJA4Wrappers(t *testing.T) {
+	ja4 := cycletls.GenerateJA4(0x0304, []uint16{4865, 4866, 4867, 49195, 49199}, []uint16{0, 23, 65281, 10, 11, 35, 16, 5, 13, 51, 45, 43}, nil, firefoxUA)
+	if !strings.HasPrefix(ja4, "t13d0512h2_") {
+		t.Errorf("expected the JA4 of the offered ClientHello, got %q", ja4)
+	}
+
+	ja4h := cycletls.GenerateJA4HTTP(http.Header{"Accept": {"*/*"}, "Cookie": {"a=1"}}, firefoxUA)
+	if !strings.HasPrefix(ja4h, "ge11cn02") {
+		t.Errorf("expected the JA4H of a GET with two headers and a cookie, got %q", ja4h)
+	}
+
+	akamai := cycletls.GenerateJA4H2([]fhttp2.Setting{{ID: 1, Val: 65536}, {ID: 4, Val: 6291456}}, 0, true, []string{":method", ":authority", ":scheme", ":path"})
+	assertEqual(t, akamai, "1:65536;4:6291456|00|0|m,a,s,p")
+}
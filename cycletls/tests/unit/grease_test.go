@@ -0,0 +1,171 @@
+package unit
+
+import (
+	"slices"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	utls "github.com/refraction-networking/utls"
+)
+
+const firefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/116.0"
+
+// greaseSlots lists the places spec carries a GREASE value
+func greaseSlots(spec *utls.ClientHelloSpec) []string {
+	var slots []string
+	if len(spec.CipherSuites) > 0 && spec.CipherSuites[0] == utls.GREASE_PLACEHOLDER {
+		slots = append(slots, "cipher")
+	}
+	greaseExts := 0
+	for _, ext := range spec.Extensions {
+		switch e := ext.(type) {
+		case *utls.UtlsGREASEExtension, *cycletls.CustomGREASEExtension:
+			greaseExts++
+		case *utls.SupportedCurvesExtension:
+			if len(e.Curves) > 0 && e.Curves[0] == utls.CurveID(utls.GREASE_PLACEHOLDER) {
+				slots = append(slots, "groups")
+			}
+		case *utls.SupportedVersionsExtension:
+			if len(e.Versions) > 0 && e.Versions[0] == utls.GREASE_PLACEHOLDER {
+				slots = append(slots, "versions")
+			}
+		case *utls.KeyShareExtension:
+			if len(e.KeyShares) > 0 && e.KeyShares[0].Group == utls.CurveID(utls.GREASE_PLACEHOLDER) {
+				slots = append(slots, "key_share")
+			}
+		}
+	}
+	if greaseExts == 2 {
+		slots = append(slots, "extensions")
+	}
+	return slots
+}
+
+var allGREASESlots = []string{"cipher", "groups", "versions", "key_share", "extensions"}
+
+// assertGREASE checks that spec carries GREASE in exactly the want slots
+func assertGREASE(t *testing.T, spec *utls.ClientHelloSpec, want []string) {
+	t.Helper()
+	got := greaseSlots(spec)
+	slices.Sort(got)
+	want = slices.Clone(want)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("GREASE slots = %v, want %v", got, want)
+	}
+}
+
+func TestStringToSpec_GreaseMode(t *testing.T) {
+	build := func(ua string, mode cycletls.GreaseMode) *utls.ClientHelloSpec {
+		t.Helper()
+		spec, err := cycletls.StringToSpecWithOptions(cycletls.DefaultChrome_JA3, ua, false, cycletls.SpecOptions{GreaseMode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return spec
+	}
+
+	// auto keeps the user agent based behaviour
+	assertGREASE(t, build(UserAgent, cycletls.GreaseAuto), allGREASESlots)
+	assertGREASE(t, build(firefoxUA, ""), []string{"versions"})
+
+	// explicit modes give the same shape whatever the user agent
+	assertGREASE(t, build(firefoxUA, cycletls.GreaseOn), allGREASESlots)
+	assertGREASE(t, build(UserAgent, cycletls.GreaseOff), nil)
+	assertGREASE(t, build(firefoxUA, cycletls.GreaseOff), nil)
+}
+
+func TestStringToSpec_GreaseExtensionPositions(t *testing.T) {
+	ja3 := "771,4865-4866-4867,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0"
+	spec, err := cycletls.StringToSpecWithOptions(ja3, firefoxUA, false, cycletls.SpecOptions{GreaseMode: cycletls.GreaseOn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := extensionOrder(spec)
+	assertEqual(t, order[0], "*tls.UtlsGREASEExtension")
+	assertEqual(t, order[len(order)-2], "*tls.UtlsGREASEExtension")
+	assertEqual(t, order[len(order)-1], "*tls.UtlsPaddingExtension")
+
+	// Without padding the second GREASE extension goes last
+	ja3 = "771,4865-4866-4867,0-23-65281-10-11-35-16-5-13-18-51-45-43,29-23-24,0"
+	spec, err = cycletls.StringToSpecWithOptions(ja3, firefoxUA, false, cycletls.SpecOptions{GreaseMode: cycletls.GreaseOn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order = extensionOrder(spec)
+	assertEqual(t, order[len(order)-1], "*tls.UtlsGREASEExtension")
+}
+
+func TestStringToSpec_GreaseOffDropsListedGREASE(t *testing.T) {
+	ja3 := "771,2570-4865-4866,2570-0-10-43-51,2570-29-23,0"
+	spec, err := cycletls.StringToSpecWithOptions(ja3, UserAgent, false, cycletls.SpecOptions{GreaseMode: cycletls.GreaseOff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGREASE(t, spec, nil)
+	for _, suite := range spec.CipherSuites {
+		if suite == 2570 {
+			t.Fatal("GREASE cipher suite from the fingerprint was kept")
+		}
+	}
+	if generic := genericExtension(spec, 2570); generic != nil {
+		t.Fatal("GREASE extension from the fingerprint was kept")
+	}
+}
+
+func TestQUICStringToSpec_GreaseMode(t *testing.T) {
+	spec, err := cycletls.QUICStringToSpecWithOptions(TestQUICFingerprint, firefoxUA, false, cycletls.SpecOptions{GreaseMode: cycletls.GreaseOn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGREASE(t, spec, allGREASESlots)
+
+	spec, err = cycletls.QUICStringToSpecWithOptions(TestQUICFingerprint, TestUserAgent, false, cycletls.SpecOptions{GreaseMode: cycletls.GreaseOff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGREASE(t, spec, nil)
+}
+
+func TestJA4RStringToSpec_GreaseMode(t *testing.T) {
+	build := func(disableGrease bool, mode cycletls.GreaseMode) *utls.ClientHelloSpec {
+		t.Helper()
+		spec, err := cycletls.JA4RStringToSpecWithOptions(chromeJA4r, UserAgent, false, disableGrease, "example.com", cycletls.SpecOptions{GreaseMode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return spec
+	}
+
+	// JA4r strips GREASE, so auto adds none
+	assertGREASE(t, build(false, cycletls.GreaseAuto), nil)
+	assertGREASE(t, build(false, cycletls.GreaseOn), allGREASESlots)
+	// GreaseMode takes precedence over disableGrease
+	assertGREASE(t, build(true, cycletls.GreaseOn), allGREASESlots)
+
+	// GREASE listed in the fingerprint is dropped when off
+	ja4r := "t13d0404h2_0a0a,1301,1302,1303_0a0a,000a,000d,0033,002b_0403,0804"
+	spec, err := cycletls.JA4RStringToSpecWithOptions(ja4r, UserAgent, false, true, "example.com", cycletls.SpecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range extensionOrder(spec) {
+		if name == "generic:2570" || name == "*cycletls.CustomGREASEExtension" {
+			t.Fatalf("GREASE extension kept with disableGrease: %v", extensionOrder(spec))
+		}
+	}
+	if slices.Contains(spec.CipherSuites, 0x0a0a) {
+		t.Fatal("GREASE cipher suite kept with disableGrease")
+	}
+}
+
+func TestGreaseMode_Invalid(t *testing.T) {
+	_, err := cycletls.StringToSpecWithOptions(cycletls.DefaultChrome_JA3, UserAgent, false, cycletls.SpecOptions{GreaseMode: "sometimes"})
+	if err == nil {
+		t.Fatal("expected an error for an invalid GREASE mode")
+	}
+	_, err = cycletls.JA4RStringToSpecWithOptions(chromeJA4r, UserAgent, false, false, "example.com", cycletls.SpecOptions{GreaseMode: "sometimes"})
+	if err == nil {
+		t.Fatal("expected an error for an invalid GREASE mode")
+	}
+}
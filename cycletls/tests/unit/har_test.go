@@ -0,0 +1,332 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	"github.com/gorilla/websocket"
+)
+
+func harServer(t *testing.T) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true})
+			http.Redirect(w, r, "/home?tab=1", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(strings.Repeat("x", 100)))
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDo_HARRecorder(t *testing.T) {
+	server := harServer(t)
+	recorder := cycletls.NewHARRecorder()
+	recorder.MaxBodySize = 10
+
+	client := cycletls.Init(cycletls.WithHARRecorder(recorder))
+	defer client.Close()
+	resp, err := client.Do(server.URL+"/login", cycletls.Options{
+		UserAgent:          chrome131UA,
+		InsecureSkipVerify: true,
+		Body:               `{"user":"me"}`,
+		Headers:            map[string]string{"Content-Type": "application/json"},
+		OrderedHeaders:     [][2]string{{"accept", "*/*"}, {"content-type", "application/json"}},
+		Cookies:            []cycletls.Cookie{{Name: "pref", Value: "dark"}},
+	}, "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Status, 200)
+
+	entries := recorder.HAR().Log.Entries
+	if len(entries) != 2 {
+		t.Fatalf("expected an entry for the redirect and one for its target, got %d", len(entries))
+	}
+
+	login := entries[0]
+	assertEqual(t, login.Request.Method, "POST")
+	assertEqual(t, login.Request.HTTPVersion, "HTTP/2.0")
+	assertEqual(t, login.Response.Status, 302)
+	assertEqual(t, login.Response.RedirectURL, server.URL+"/home?tab=1")
+	if login.Request.PostData == nil || login.Request.PostData.Text != `{"user":"m` || !login.Request.PostData.Truncated {
+		t.Fatalf("expected the request body cut at 10 bytes, got %+v", login.Request.PostData)
+	}
+	if len(login.Response.Cookies) != 1 || login.Response.Cookies[0].Name != "session" || !login.Response.Cookies[0].HTTPOnly {
+		t.Fatalf("expected the session cookie, got %+v", login.Response.Cookies)
+	}
+
+	// Headers as written on the wire: pseudo-headers, then the ordered ones
+	var names []string
+	for _, h := range login.Request.Headers {
+		names = append(names, h.Name)
+	}
+	if len(names) < 6 || !strings.HasPrefix(names[0], ":") || names[4] != "accept" || names[5] != "content-type" {
+		t.Fatalf("expected pseudo-headers then accept and content-type, got %v", names)
+	}
+
+	tls := login.TLS
+	if tls == nil || !strings.HasPrefix(tls.Version, "TLS 1.") || tls.ALPN != "h2" || tls.CipherSuite == "" || tls.JA3 == "" || tls.JA4 == "" {
+		t.Fatalf("expected the TLS details and fingerprints, got %+v", tls)
+	}
+	if login.Timings.Connect <= 0 || login.Timings.SSL <= 0 || login.ServerIPAddress != "127.0.0.1" {
+		t.Fatalf("expected a new connection's timings, got %+v", login.Timings)
+	}
+
+	home := entries[1]
+	assertEqual(t, home.Request.Method, "GET")
+	if len(home.Request.QueryString) != 1 || home.Request.QueryString[0] != (cycletls.HARNameValue{Name: "tab", Value: "1"}) {
+		t.Fatalf("expected the tab parameter, got %+v", home.Request.QueryString)
+	}
+	if len(home.Request.Cookies) != 1 || home.Request.Cookies[0] != (cycletls.HARCookie{Name: "pref", Value: "dark"}) {
+		t.Fatalf("expected the redirect to send the cookie too, got %+v", home.Request.Cookies)
+	}
+	assertEqual(t, home.Response.Content.Size, int64(100))
+	assertEqual(t, home.Response.Content.Text, "xxxxxxxxxx")
+	assertEqual(t, home.Response.Content.MimeType, "text/plain")
+	if !home.Response.Content.Truncated {
+		t.Fatal("expected the response body marked truncated")
+	}
+	if home.Timings.Connect != -1 || home.Timings.Receive < 0 {
+		t.Fatalf("expected the redirect to reuse the connection, got %+v", home.Timings)
+	}
+}
+
+func TestDo_HARSessionAndFile(t *testing.T) {
+	server := harServer(t)
+	recorder := cycletls.NewHARRecorder()
+	cycletls.SetSessionHARRecorder("har-test", recorder)
+	defer cycletls.SetSessionHARRecorder("har-test", nil)
+
+	path := filepath.Join(t.TempDir(), "session.har")
+	client := cycletls.Init()
+	defer client.Close()
+	options := cycletls.Options{UserAgent: firefoxUA, InsecureSkipVerify: true, ForceHTTP1: true, Session: "har-test", HARFile: path}
+	if _, err := client.Do(server.URL+"/page", options, "GET"); err != nil {
+		t.Fatal(err)
+	}
+	options.Session = ""
+	if _, err := client.Do(server.URL+"/other", options, "GET"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first request was in the session
+	entries := recorder.HAR().Log.Entries
+	if len(entries) != 1 {
+		t.Fatalf("expected one session entry, got %d", len(entries))
+	}
+	assertEqual(t, entries[0].Request.HTTPVersion, "HTTP/1.1")
+	assertEqual(t, entries[0].Response.Content.Size, int64(100))
+	if entries[0].Request.Headers[0].Name != "Host" {
+		t.Fatalf("expected the HTTP/1.1 headers as written, got %+v", entries[0].Request.Headers)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var har cycletls.HAR
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, har.Log.Version, "1.2")
+	if len(har.Log.Entries) != 2 || !strings.HasSuffix(har.Log.Entries[1].Request.URL, "/other") {
+		t.Fatalf("expected both requests in the HAR file, got %d entries", len(har.Log.Entries))
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the HAR file only readable by its owner, got %v %v", info.Mode(), err)
+	}
+
+	// Once closed, the next request starts the file over
+	if err := cycletls.CloseHARFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(server.URL+"/last", options, "GET"); err != nil {
+		t.Fatal(err)
+	}
+	if har := readHAR(t, path); len(har.Log.Entries) != 1 || !strings.HasSuffix(har.Log.Entries[0].Request.URL, "/last") {
+		t.Fatalf("expected only the last request in the HAR file, got %d entries", len(har.Log.Entries))
+	}
+	cycletls.CloseHARFile(path)
+}
+
+func TestServeHTTP_HARFileStaysInHARDir(t *testing.T) {
+	server := harServer(t)
+	dir, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(client cycletls.CycleTLS, requests ...map[string]interface{}) map[string]int {
+		ws := httptest.NewServer(client)
+		defer ws.Close()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ws.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		statuses := map[string]int{}
+		for _, request := range requests {
+			if err := conn.WriteJSON(request); err != nil {
+				t.Fatal(err)
+			}
+			// Actions are not answered
+			if _, ok := request["action"]; ok {
+				continue
+			}
+			for {
+				_, frame, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := frameReader(frame)
+				requestID, method := r.string(), r.string()
+				if method == "error" || method == "response" {
+					statuses[requestID] = r.u16()
+				}
+				if method == "error" || method == "end" {
+					break
+				}
+			}
+		}
+		return statuses
+	}
+	request := func(id, harFile string) map[string]interface{} {
+		return map[string]interface{}{"requestId": id, "options": map[string]interface{}{
+			"url": server.URL + "/" + id, "method": "GET", "userAgent": firefoxUA, "insecureSkipVerify": true, "harFile": harFile,
+		}}
+	}
+
+	client := cycletls.Init(cycletls.WithHARDir(dir))
+	defer client.Close()
+	statuses := send(client,
+		request("inside", "session.har"),
+		request("parent", "../escape.har"),
+		request("absolute", filepath.Join(outside, "escape.har")),
+		request("symlink", "link/escape.har"),
+	)
+	assertEqual(t, statuses["inside"], 200)
+	for _, id := range []string{"parent", "absolute", "symlink"} {
+		if statuses[id] != 400 {
+			t.Errorf("expected a 400 error frame for %s, got %v", id, statuses)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Fatalf("expected nothing written outside the HAR directory, got %v", entries)
+	}
+	if har := readHAR(t, filepath.Join(dir, "session.har")); len(har.Log.Entries) != 1 {
+		t.Fatalf("expected the request in the HAR file, got %d entries", len(har.Log.Entries))
+	}
+
+	// Closing the file releases it, and the next request starts it over
+	closeFile := map[string]interface{}{"action": "closeHarFile", "harFile": "session.har"}
+	statuses = send(client, closeFile, request("again", "session.har"))
+	assertEqual(t, statuses["again"], 200)
+	if har := readHAR(t, filepath.Join(dir, "session.har")); len(har.Log.Entries) != 1 || !strings.HasSuffix(har.Log.Entries[0].Request.URL, "/again") {
+		t.Fatalf("expected only the last request in the HAR file, got %d entries", len(har.Log.Entries))
+	}
+	cycletls.CloseHARFile(filepath.Join(dir, "session.har"))
+
+	// Without a HAR directory, the server records no files
+	t.Setenv(cycletls.HARDirEnv, "")
+	noDir := cycletls.Init()
+	defer noDir.Close()
+	assertEqual(t, send(noDir, request("none", "session.har"))["none"], 400)
+}
+
+func TestCloseHARFile_AnySpellingOfThePath(t *testing.T) {
+	server := harServer(t)
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	client := cycletls.Init(cycletls.WithHARDir(dir))
+	defer client.Close()
+	entries := func(name string) int {
+		return len(readHAR(t, filepath.Join(dir, name)).Log.Entries)
+	}
+
+	// Through Do: opened relative to the working directory, closed by its absolute path
+	options := cycletls.Options{UserAgent: firefoxUA, InsecureSkipVerify: true, HARFile: "sub/../do.har"}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Do(server.URL+"/do", options, "GET"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertEqual(t, entries("do.har"), 2)
+	if err := cycletls.CloseHARFile(filepath.Join(dir, "do.har")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(server.URL+"/do", options, "GET"); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, entries("do.har"), 1)
+	cycletls.CloseHARFile("do.har")
+
+	// Through WS_PORT: opened as one path inside the HAR directory, closed as another
+	ws := httptest.NewServer(client)
+	defer ws.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ws.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	send := func(id, harFile string) {
+		t.Helper()
+		err := conn.WriteJSON(map[string]interface{}{"requestId": id, "options": map[string]interface{}{
+			"url": server.URL + "/" + id, "method": "GET", "userAgent": firefoxUA, "insecureSkipVerify": true, "harFile": harFile,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := frameReader(frame)
+			r.string()
+			switch r.string() {
+			case "end":
+				return
+			case "error":
+				t.Fatalf("request %s failed with status %d", id, r.u16())
+			}
+		}
+	}
+	send("first", "sub/../ws.har")
+	send("second", "./ws.har")
+	assertEqual(t, entries("ws.har"), 2)
+	if err := conn.WriteJSON(map[string]interface{}{"action": "closeHarFile", "harFile": "ws.har"}); err != nil {
+		t.Fatal(err)
+	}
+	send("third", "sub/../ws.har")
+	assertEqual(t, entries("ws.har"), 1)
+	cycletls.CloseHARFile(filepath.Join(dir, "ws.har"))
+}
+
+func readHAR(t *testing.T, path string) cycletls.HAR {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var har cycletls.HAR
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("%v in %s", err, data)
+	}
+	return har
+}
@@ -0,0 +1,173 @@
+package unit
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+)
+
+// rawHeaderServer answers one plain HTTP/1.1 request and sends the header
+// lines it received, exactly as written, on the returned channel
+func rawHeaderServer(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "http://" + ln.Addr().String(), rawHeaderLines(t, ln)
+}
+
+// rawHeaderTLSServer is rawHeaderServer over TLS, offering only HTTP/1.1
+func rawHeaderTLSServer(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	config := server.TLS.Clone()
+	server.Close()
+	config.NextProtos = []string{"http/1.1"}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "https://" + ln.Addr().String(), rawHeaderLines(t, ln)
+}
+
+func rawHeaderLines(t *testing.T, ln net.Listener) <-chan []string {
+	t.Cleanup(func() { ln.Close() })
+
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		r.ReadString('\n') // request line
+		var got []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+			got = append(got, strings.TrimRight(line, "\r\n"))
+		}
+		lines <- got
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+	}()
+	return lines
+}
+
+func TestDo_OrderedHeadersHTTP1(t *testing.T) {
+	url, lines := rawHeaderServer(t)
+
+	client := cycletls.Init()
+	_, err := client.Do(url, cycletls.Options{
+		OrderedHeaders: [][2]string{
+			{"Host", strings.TrimPrefix(url, "http://")},
+			{"x-lower", "1"},
+			{"Accept", "text/html"},
+			{"X-MiXeD", "2"},
+			{"accept", "application/json"},
+			{"user-agent", UserAgent},
+		},
+		Headers:   map[string]string{"x-lower": "ignored", "X-Extra": "3"},
+		UserAgent: UserAgent,
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-lines
+	want := []string{
+		"Host: " + strings.TrimPrefix(url, "http://"),
+		"x-lower: 1",
+		// A repeated name is sent together, with the casing of its first line
+		"Accept: text/html",
+		"Accept: application/json",
+		"X-MiXeD: 2",
+		"user-agent: " + UserAgent,
+	}
+	if len(got) < len(want) {
+		t.Fatalf("got %q, want it to start with %q", got, want)
+	}
+	for i := range want {
+		assertEqual(t, got[i], want[i])
+	}
+	// Headers entries not named in OrderedHeaders follow
+	if !strings.Contains(strings.Join(got[len(want):], "\n"), "X-Extra: 3") {
+		t.Fatalf("expected X-Extra after the ordered headers, got %q", got)
+	}
+}
+
+func TestDo_OrderedHeadersHTTP1OverTLS(t *testing.T) {
+	url, lines := rawHeaderTLSServer(t)
+
+	client := cycletls.Init()
+	defer client.Close()
+	_, err := client.Do(url, cycletls.Options{
+		OrderedHeaders: [][2]string{
+			{"Accept", "text/html"},
+			{"x-b", "1"},
+			{"ACCEPT", "application/json"},
+			{"X-b", "2"},
+			{"connection", "close"},
+		},
+		UserAgent:          UserAgent,
+		InsecureSkipVerify: true,
+		ForceHTTP1:         true,
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-lines
+	// Connection keeps its canonical key, which the transport checks before
+	// adding one of its own
+	want := []string{"Accept: text/html", "Accept: application/json", "x-b: 1", "x-b: 2", "Connection: close"}
+	if len(got) < len(want) {
+		t.Fatalf("got %q, want it to start with %q", got, want)
+	}
+	for i := range want {
+		assertEqual(t, got[i], want[i])
+	}
+	if n := strings.Count(strings.ToLower(strings.Join(got, "\n")), "connection:"); n != 1 {
+		t.Errorf("expected one Connection header, got %q", got)
+	}
+}
+
+func TestDo_OrderedHeadersHTTP2(t *testing.T) {
+	var got http.Header
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	client := cycletls.Init()
+	resp, err := client.Do(server.URL, cycletls.Options{
+		OrderedHeaders: [][2]string{
+			{"X-MiXeD", "1"},
+			{"Accept", "text/html"},
+			{"x-other", "2"},
+			{"accept", "application/json"},
+			{"ACCEPT", "text/plain"},
+		},
+		UserAgent:          UserAgent,
+		InsecureSkipVerify: true,
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Body, "HTTP/2.0")
+	assertEqual(t, got.Get("X-Mixed"), "1")
+	// The values of a name are sent together, in the order given
+	assertEqual(t, strings.Join(got.Values("Accept"), ","), "text/html,application/json,text/plain")
+}
@@ -0,0 +1,291 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	"github.com/quic-go/qpack"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// http3Request is what rawHTTP3Server saw of a request
+type http3Request struct {
+	settings     [][2]uint64
+	fields       []qpack.HeaderField
+	cipherSuites []uint16 // Offered in the ClientHello
+}
+
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// rawHTTP3Server answers one HTTP/3 request with "ok" and sends the client's
+// SETTINGS and request header fields, in the order they were encoded, on the
+// returned channel
+func rawHTTP3Server(t *testing.T) (string, <-chan http3Request) {
+	t.Helper()
+	hello := make(chan []uint16, 1)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCertificate(t)},
+		NextProtos:   []string{"h3"},
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			select {
+			case hello <- info.CipherSuites:
+			default:
+			}
+			return nil, nil
+		},
+	}
+	ln, err := quic.ListenAddr("127.0.0.1:0", config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	requests := make(chan http3Request, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			return
+		}
+
+		settings := make(chan [][2]uint64, 1)
+		go func() {
+			for {
+				str, err := conn.AcceptUniStream(ctx)
+				if err != nil {
+					return
+				}
+				r := quicvarint.NewReader(str)
+				if streamType, _ := quicvarint.Read(r); streamType != 0 {
+					continue
+				}
+				payload, _ := readHTTP3Frame(r)
+				var pairs [][2]uint64
+				p := quicvarint.NewReader(bytes.NewReader(payload))
+				for {
+					id, err := quicvarint.Read(p)
+					if err != nil {
+						break
+					}
+					val, _ := quicvarint.Read(p)
+					pairs = append(pairs, [2]uint64{id, val})
+				}
+				settings <- pairs
+			}
+		}()
+
+		str, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		block, _ := readHTTP3Frame(quicvarint.NewReader(str))
+		fields, _ := qpack.NewDecoder(nil).DecodeFull(block)
+
+		var headers bytes.Buffer
+		encoder := qpack.NewEncoder(&headers)
+		encoder.WriteField(qpack.HeaderField{Name: ":status", Value: "200"})
+		encoder.WriteField(qpack.HeaderField{Name: "content-length", Value: "2"})
+		response := quicvarint.Append(nil, 0x1)
+		response = quicvarint.Append(response, uint64(headers.Len()))
+		response = append(response, headers.Bytes()...)
+		response = quicvarint.Append(response, 0x0)
+		response = quicvarint.Append(response, 2)
+		response = append(response, "ok"...)
+		str.Write(response)
+		str.Close()
+
+		select {
+		case s := <-settings:
+			requests <- http3Request{settings: s, fields: fields, cipherSuites: <-hello}
+		case <-ctx.Done():
+		}
+	}()
+	return "https://" + ln.Addr().String(), requests
+}
+
+// readHTTP3Frame reads one frame and returns its payload
+func readHTTP3Frame(r quicvarint.Reader) ([]byte, error) {
+	if _, err := quicvarint.Read(r); err != nil {
+		return nil, err
+	}
+	length, err := quicvarint.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	return payload, err
+}
+
+func TestDo_HTTP3HeaderOrder(t *testing.T) {
+	url, requests := rawHTTP3Server(t)
+
+	resp, err := cycletls.Init().Do(url+"/path?q=1", cycletls.Options{
+		ForceHTTP3:         true,
+		InsecureSkipVerify: true,
+		UserAgent:          UserAgent,
+		HTTP3Fingerprint:   "1:65536;6:262144;7:100;51:1;GREASE|m,s,p,a",
+		OrderedHeaders: [][2]string{
+			{"X-First", "1"},
+			{"Accept", "text/html"},
+			{"x-last", "2"},
+		},
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Status, 200)
+	assertEqual(t, resp.Body, "ok")
+	if resp.Fingerprints == nil {
+		t.Fatal("expected the sent fingerprints")
+	}
+	assertEqual(t, resp.Fingerprints.HTTP3, "1:65536;6:262144;7:100;51:1;GREASE|m,s,p,a")
+
+	req := <-requests
+	var names []string
+	for _, f := range req.fields {
+		names = append(names, f.Name)
+	}
+	want := []string{":method", ":scheme", ":path", ":authority", "x-first", "accept", "x-last"}
+	if len(names) < len(want) || strings.Join(names[:len(want)], ",") != strings.Join(want, ",") {
+		t.Fatalf("got header order %v, want it to start with %v", names, want)
+	}
+	assertEqual(t, req.fields[2].Value, "/path?q=1")
+
+	if len(req.settings) != 5 {
+		t.Fatalf("expected 5 settings, got %v", req.settings)
+	}
+	for i, s := range [][2]uint64{{1, 65536}, {6, 262144}, {7, 100}, {51, 1}} {
+		assertEqual(t, req.settings[i], s)
+	}
+	if grease := req.settings[4][0]; grease < 0x21 || (grease-0x21)%0x1f != 0 {
+		t.Errorf("expected a reserved setting ID, got %#x", grease)
+	}
+}
+
+func TestDo_HTTP3UserAgentPseudoHeaderOrder(t *testing.T) {
+	url, requests := rawHTTP3Server(t)
+
+	// Without a fingerprint the pseudo-headers follow the user agent
+	_, err := cycletls.Init().Do(url, cycletls.Options{
+		ForceHTTP3:         true,
+		InsecureSkipVerify: true,
+		UserAgent:          firefoxUA,
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	var pseudo []string
+	for _, f := range req.fields {
+		if strings.HasPrefix(f.Name, ":") {
+			pseudo = append(pseudo, f.Name)
+		}
+	}
+	assertEqual(t, strings.Join(pseudo, ","), ":method,:path,:authority,:scheme")
+	assertEqual(t, req.settings[0], [2]uint64{1, 65536})
+
+	// While the QUIC ClientHello stays quic-go's unless asked for
+	if got := fmt.Sprintf("%04x", req.cipherSuites); got == firefoxQUICSuites {
+		t.Errorf("expected quic-go's cipher suites without QUICFromUserAgent, got %s", got)
+	}
+}
+
+// firefoxQUICSuites are the cipher suites in Firefox's QUIC ClientHello,
+// which prefers ChaCha20 to AES-256
+const firefoxQUICSuites = "[1301 1303 1302]"
+
+func TestDo_HTTP3QUICFromUserAgent(t *testing.T) {
+	client := cycletls.Init()
+	defer client.Close()
+
+	// Each dial of a reused client sends the user agent's ClientHello
+	for i := 0; i < 2; i++ {
+		url, requests := rawHTTP3Server(t)
+		_, err := client.Do(url, cycletls.Options{
+			ForceHTTP3:            true,
+			InsecureSkipVerify:    true,
+			UserAgent:             firefoxUA,
+			QUICFromUserAgent:     true,
+			EnableConnectionReuse: true,
+		}, "GET")
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		assertEqual(t, fmt.Sprintf("%04x", (<-requests).cipherSuites), firefoxQUICSuites)
+	}
+}
+
+func TestNewHTTP3Fingerprint(t *testing.T) {
+	fp, err := cycletls.NewHTTP3Fingerprint("1:65536,7:100,GREASE|m,a,s,p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(fp.Settings), 3)
+	assertEqual(t, fp.Settings[2].Grease, true)
+	assertEqual(t, fp.String(), "1:65536;7:100;GREASE|m,a,s,p")
+
+	for _, invalid := range []string{
+		"2:0",           // HTTP/2 only setting
+		"1:1;1:2",       // Duplicate
+		"1:65536|m,a,x", // Unknown pseudo-header
+		"1=65536",
+		"1:1|m|a",
+	} {
+		if _, err := cycletls.NewHTTP3Fingerprint(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestValidateFingerprint_HTTP3(t *testing.T) {
+	diags := cycletls.ValidateFingerprint(cycletls.Options{HTTP3Fingerprint: "3:100"})
+	if _, ok := findDiagnostic(diags, "http3Fingerprint", "reserved for HTTP/2"); !ok {
+		t.Fatalf("expected a reserved setting error, got %v", diags)
+	}
+
+	diags = cycletls.ValidateFingerprint(cycletls.Options{HTTP3Fingerprint: "1:65536|m,p,a,s", UserAgent: UserAgent})
+	if _, ok := findDiagnostic(diags, "http3Fingerprint", "does not match the chrome user agent"); !ok {
+		t.Fatalf("expected a pseudo-header order warning, got %v", diags)
+	}
+	if _, ok := findDiagnostic(diags, "http3Fingerprint", "only applies to HTTP/3"); !ok {
+		t.Fatalf("expected an HTTP/3 only warning, got %v", diags)
+	}
+
+	diags = cycletls.ValidateFingerprint(cycletls.Options{HTTP3Fingerprint: "1:65536|m,a,s,p", UserAgent: UserAgent, ForceHTTP3: true})
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics %v", diags)
+	}
+}
@@ -0,0 +1,80 @@
+package unit
+
+import (
+	"errors"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+)
+
+func TestJA4FromJA4r(t *testing.T) {
+	// SNI and ALPN listed in the raw form do not change the hash
+	ja4, err := cycletls.JA4FromJA4r(chromeJA4r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutSNI, err := cycletls.JA4FromJA4r("t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0017,001b,0023,002b,002d,0033,44cd,fe0d,ff01_0403,0804,0401,0503,0805,0501,0806,0601")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ja4, withoutSNI)
+}
+
+func TestLookupJA4(t *testing.T) {
+	known, err := cycletls.LookupJA4("t13d1516h2_8daaf6152771_02713d6af862")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, known.Client, "Chrome 117-132 (ALPS 17513, ECH)")
+
+	_, err = cycletls.LookupJA4("t13d1516h2_8daaf6152771_000000000000")
+	if !errors.Is(err, cycletls.ErrUnknownJA4) {
+		t.Fatalf("expected ErrUnknownJA4, got %v", err)
+	}
+}
+
+// Every bundled JA4_r must build a spec that hashes back to its JA4
+func TestKnownJA4s_Reproduce(t *testing.T) {
+	for _, known := range cycletls.KnownJA4s() {
+		t.Run(known.Client, func(t *testing.T) {
+			spec, err := cycletls.JA4RStringToSpec(known.JA4r, UserAgent, false, false, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fp, err := cycletls.SpecFingerprints(spec, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(t, fp.JA4, known.JA4)
+		})
+	}
+}
+
+func TestRegisterJA4r(t *testing.T) {
+	ja4r := "t12d1209h2_002f,0035,009c,009d,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0017,0023,ff01_0403,0804,0401,0503,0805,0501,0806,0601,0201"
+	if err := cycletls.RegisterJA4r("TLS 1.2 client", ja4r); err != nil {
+		t.Fatal(err)
+	}
+	ja4, _ := cycletls.JA4FromJA4r(ja4r)
+	known, err := cycletls.LookupJA4(ja4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, known.JA4r, ja4r)
+
+	if err := cycletls.RegisterJA4r("broken", "x13_0000"); err == nil {
+		t.Fatal("expected an error for an invalid JA4_r")
+	}
+}
+
+func TestValidateFingerprint_JA4(t *testing.T) {
+	diags := cycletls.ValidateFingerprint(cycletls.Options{Ja4: "t13d1516h2_8daaf6152771_000000000000"})
+	if _, ok := findDiagnostic(diags, "ja4", "no JA4_r in the database"); !ok {
+		t.Fatalf("expected an unknown JA4 error, got %v", diags)
+	}
+
+	diags = cycletls.ValidateFingerprint(cycletls.Options{Ja4: "t13d1516h2_8daaf6152771_02713d6af862", UserAgent: UserAgent})
+	if cycletls.HasErrors(diags) {
+		t.Fatalf("unexpected errors %v", diags)
+	}
+}
@@ -0,0 +1,120 @@
+package unit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+)
+
+func TestParseJA4HString_Hashed(t *testing.T) {
+	c, err := cycletls.ParseJA4HString("ge11cr11enus_974ebe531c03_b66fa821d02c_e97928733c74")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.Method, "ge")
+	assertEqual(t, c.HTTPVersion, "11")
+	assertEqual(t, c.HasCookie, true)
+	assertEqual(t, c.HasReferer, true)
+	assertEqual(t, c.HeaderCount, 11)
+	assertEqual(t, c.Language, "enus")
+	assertEqual(t, len(c.HeaderNames), 0)
+}
+
+func TestParseJA4HString_Raw(t *testing.T) {
+	c, err := cycletls.ParseJA4HString("po20nn03fr00_user-agent,accept,content-type_000000000000_000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.Method, "po")
+	assertEqual(t, c.HasCookie, false)
+	assertEqual(t, len(c.HeaderNames), 3)
+	assertEqual(t, c.HeaderNames[2], "content-type")
+}
+
+func TestParseJA4HString_Invalid(t *testing.T) {
+	for _, ja4h := range []string{"ge99nn00enus_a_b_c", "ge11xx_a", "ge11nnxxenus_974ebe531c03_000000000000"} {
+		if _, err := cycletls.ParseJA4HString(ja4h); err == nil {
+			t.Errorf("expected an error for %q", ja4h)
+		}
+	}
+}
+
+func TestDo_ShapesRequestFromJA4H(t *testing.T) {
+	var got http.Header
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(r.Method))
+	}))
+	defer server.Close()
+
+	names := "Host,User-Agent,Accept,Accept-Language"
+	client := cycletls.Init()
+	resp, err := client.Do(server.URL, cycletls.Options{
+		Ja4h: "ge11nn04enus_" + names + "_000000000000_000000000000",
+		Headers: map[string]string{
+			"Accept":          "*/*",
+			"Accept-Language": "de-DE,de;q=0.9",
+			"Referer":         "https://example.com/",
+		},
+		UserAgent:          firefoxUA,
+		InsecureSkipVerify: true,
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The method and HTTP/1.1 come from the target
+	assertEqual(t, resp.Body, "GET")
+	assertEqual(t, got.Get("Referer"), "")
+	assertEqual(t, got.Get("Accept-Language"), "en-US")
+
+	sum := sha256.Sum256([]byte(names))
+	assertEqual(t, resp.Fingerprints.JA4H, "ge11nn04enus_"+hex.EncodeToString(sum[:])[:12]+"_000000000000_000000000000")
+}
+
+func TestDo_RejectsMismatchingJA4H(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := cycletls.Init()
+	tests := []struct {
+		ja4h, method, want string
+	}{
+		{"ge11nn04enus_974ebe531c03_000000000000_000000000000", "POST", `method code "ge" does not match the POST request`},
+		{"po11nn04enus_974ebe531c03_000000000000_000000000000", "get", `method code "po" does not match the GET request`},
+		{"ge11nn05enus_974ebe531c03_000000000000_000000000000", "GET", "counts 5 headers but the request sends 4"},
+		{"ge20nn04enus_974ebe531c03_000000000000_000000000000", "GET", "counts 4 headers but the request sends 3"},
+	}
+	for _, tt := range tests {
+		_, err := client.Do(server.URL, cycletls.Options{
+			Ja4h:               tt.ja4h,
+			Headers:            map[string]string{"Accept": "*/*"},
+			UserAgent:          firefoxUA,
+			InsecureSkipVerify: true,
+		}, tt.method)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s with %q: expected %q, got %v", tt.ja4h, tt.method, tt.want, err)
+		}
+	}
+}
+
+func TestValidateFingerprint_JA4H(t *testing.T) {
+	diags := cycletls.ValidateFingerprint(cycletls.Options{
+		Ja4h:       "ge20cr02enus_user-agent,x-token_000000000000_000000000000",
+		Method:     "POST",
+		ForceHTTP1: true,
+	})
+	if !cycletls.HasErrors(diags) {
+		t.Fatalf("expected an error for HTTP/2 with forceHTTP1, got %v", diags)
+	}
+	for _, want := range []string{"does not match the POST request", "target sends cookies", "target sends a Referer", `"x-token" is listed but not set`} {
+		if _, ok := findDiagnostic(diags, "ja4h", want); !ok {
+			t.Errorf("missing diagnostic %q in %v", want, diags)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	utls "github.com/refraction-networking/utls"
+)
+
+// Chrome 131 JA3 offering X25519MLKEM768 (4588) first
+const pqChromeJA3 = "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-65037,4588-29-23-24,0"
+
+func keyShareGroups(t *testing.T, spec *utls.ClientHelloSpec) []utls.KeyShare {
+	t.Helper()
+	for _, ext := range spec.Extensions {
+		if keyShare, ok := ext.(*utls.KeyShareExtension); ok {
+			return keyShare.KeyShares
+		}
+	}
+	t.Fatal("key_share extension missing")
+	return nil
+}
+
+func TestStringToSpec_PostQuantumKeyShares(t *testing.T) {
+	spec, err := cycletls.StringToSpec(pqChromeJA3, UserAgent, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares := keyShareGroups(t, spec)
+	assertEqual(t, len(shares), 3)
+	assertEqual(t, shares[0].Group, utls.CurveID(utls.GREASE_PLACEHOLDER))
+	assertEqual(t, shares[1].Group, utls.X25519MLKEM768)
+	assertEqual(t, shares[2].Group, utls.X25519)
+
+	// uTLS fills in the hybrid key: 1184 byte ML-KEM-768 key + 32 byte X25519 key
+	uconn := utls.UClient(nil, &utls.Config{ServerName: "example.com"}, utls.HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatal(err)
+	}
+	shares = keyShareGroups(t, &utls.ClientHelloSpec{Extensions: uconn.Extensions})
+	assertEqual(t, len(shares[1].Data), 1184+32)
+	assertEqual(t, len(shares[2].Data), 32)
+
+	// Firefox also sends a P-256 share
+	firefox := "Mozilla/5.0 (X11; Linux x86_64; rv:133.0) Gecko/20100101 Firefox/133.0"
+	spec, err = cycletls.StringToSpec(pqChromeJA3, firefox, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares = keyShareGroups(t, spec)
+	assertEqual(t, len(shares), 3)
+	assertEqual(t, shares[0].Group, utls.X25519MLKEM768)
+	assertEqual(t, shares[2].Group, utls.CurveP256)
+}
+
+func TestJA4RStringToSpec_PostQuantumKeyShares(t *testing.T) {
+	spec, err := cycletls.JA4RStringToSpec(chromeJA4r, UserAgent, false, false, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares := keyShareGroups(t, spec)
+	assertEqual(t, shares[0].Group, utls.X25519MLKEM768)
+	assertEqual(t, shares[1].Group, utls.X25519)
+}
+
+func TestDo_PostQuantumHandshake(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := cycletls.Init()
+	resp, err := client.Do(server.URL, cycletls.Options{
+		Ja3:                pqChromeJA3,
+		UserAgent:          UserAgent,
+		InsecureSkipVerify: true,
+		ForceHTTP1:         true,
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Status, 200)
+}
+
+func TestStringToSpec_NISTHybridKeyShares(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// ECDH point followed by the ML-KEM encapsulation key
+	for group, size := range map[utls.CurveID]int{4587: 65 + 1184, 4589: 97 + 1568} {
+		ja3 := strings.Replace(pqChromeJA3, ",4588-", ","+strconv.Itoa(int(group))+"-", 1)
+		spec, err := cycletls.StringToSpec(ja3, UserAgent, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares := keyShareGroups(t, spec)
+		assertEqual(t, len(shares), 3)
+		assertEqual(t, shares[1].Group, group)
+		assertEqual(t, len(shares[1].Data), size)
+		assertEqual(t, shares[2].Group, utls.X25519)
+
+		// The handshake goes ahead on the X25519 share
+		client := cycletls.Init()
+		resp, err := client.Do(server.URL, cycletls.Options{
+			Ja3:                ja3,
+			UserAgent:          UserAgent,
+			InsecureSkipVerify: true,
+			ForceHTTP1:         true,
+		}, "GET")
+		if err != nil {
+			t.Fatalf("group %d: %v", group, err)
+		}
+		assertEqual(t, resp.Status, 200)
+	}
+}
@@ -0,0 +1,91 @@
+package unit
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	"github.com/gorilla/websocket"
+)
+
+// syncBuffer is a bytes.Buffer safe for the server's goroutines to log to
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServeHTTP_InvalidRequestsFailAlone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	logs := &syncBuffer{}
+	client := cycletls.Init(cycletls.WithLogHandler(slog.NewTextHandler(logs, nil)))
+	defer client.Close()
+	ws := httptest.NewServer(client)
+	defer ws.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ws.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	requests := []interface{}{
+		map[string]interface{}{"requestId": "bad-url", "options": map[string]interface{}{"url": "http://[::1", "method": "GET", "userAgent": UserAgent}},
+		map[string]interface{}{"requestId": "bad-method", "options": map[string]interface{}{"url": server.URL, "method": "GE T", "userAgent": UserAgent}},
+		map[string]interface{}{"requestId": "bad-proxy", "options": map[string]interface{}{"url": server.URL, "method": "GET", "userAgent": UserAgent, "proxy": "not-a-proxy"}},
+		map[string]interface{}{"requestId": "bad-options", "options": map[string]interface{}{"url": server.URL, "timeout": "soon"}},
+		map[string]interface{}{"requestId": "good", "options": map[string]interface{}{"url": server.URL, "method": "GET", "userAgent": UserAgent}},
+	}
+	for _, request := range requests {
+		if err := conn.WriteJSON(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every bad request gets its own error frame, and the good one still runs
+	failed := map[string]int{}
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := frameReader(frame)
+		requestID, method := r.string(), r.string()
+		if method == "error" {
+			failed[requestID] = r.u16()
+		} else if requestID == "good" && method == "end" {
+			break
+		}
+	}
+	for _, id := range []string{"bad-url", "bad-method", "bad-proxy", "bad-options"} {
+		if failed[id] != 400 {
+			t.Errorf("expected a 400 error frame for %s, got %v", id, failed)
+		}
+	}
+	if _, ok := failed["good"]; ok {
+		t.Fatal("expected the good request to succeed")
+	}
+	if !strings.Contains(logs.String(), "level=WARN msg=\"Invalid request\" requestId=bad-proxy") {
+		t.Fatalf("expected the failures logged with their request ID, got:\n%s", logs)
+	}
+}
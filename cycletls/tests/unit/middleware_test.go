@@ -0,0 +1,186 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	fhttp "github.com/Danny-Dasilva/fhttp"
+	"github.com/gorilla/websocket"
+	utls "github.com/refraction-networking/utls"
+)
+
+// hookLog records the hooks called, in order
+type hookLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *hookLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *hookLog) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+func (l *hookLog) hooks() cycletls.Hooks {
+	return cycletls.Hooks{
+		OnDial: func(ctx context.Context, network, addr string, err error) {
+			l.add("dial " + network)
+		},
+		OnTLSHandshake: func(ctx context.Context, state utls.ConnectionState, err error) {
+			if err == nil && state.HandshakeComplete {
+				l.add("tls")
+			}
+		},
+		OnRequest:  func(req *fhttp.Request) { l.add("request " + req.URL.Path) },
+		OnResponse: func(req *fhttp.Request, resp *fhttp.Response) { l.add("response " + resp.Status[:3]) },
+		OnRedirect: func(req *fhttp.Request, via []*fhttp.Request) error {
+			l.add("redirect " + req.URL.Path)
+			return nil
+		},
+		OnError: func(req *fhttp.Request, err error) { l.add("error") },
+	}
+}
+
+// signer adds a header computed from the request, the way auth signing does
+func signer(next cycletls.RoundTripFunc) cycletls.RoundTripFunc {
+	return func(req *fhttp.Request) (*fhttp.Response, error) {
+		req.Header.Set("X-Signature", "signed:"+req.Method+" "+req.URL.Path)
+		return next(req)
+	}
+}
+
+func redirectServer(t *testing.T, seen *hookLog) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen.add(r.URL.Path + " " + r.Header.Get("X-Signature"))
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/end", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDo_MiddlewareAndHooks(t *testing.T) {
+	seen, events := &hookLog{}, &hookLog{}
+	server := redirectServer(t, seen)
+
+	client := cycletls.Init(cycletls.WithMiddleware(signer), cycletls.WithHooks(events.hooks()))
+	defer client.Close()
+	resp, err := client.Do(server.URL+"/start", cycletls.Options{UserAgent: UserAgent, InsecureSkipVerify: true}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Body, "ok")
+
+	// The middleware signs every request of the chain
+	assertEqual(t, strings.Join(seen.list(), ","), "/start signed:GET /start,/end signed:GET /end")
+	assertEqual(t, strings.Join(events.list(), ","), "request /start,dial tcp,tls,response 302,redirect /end,request /end,response 200")
+}
+
+func TestDo_HooksStopRedirect(t *testing.T) {
+	seen, events := &hookLog{}, &hookLog{}
+	server := redirectServer(t, seen)
+
+	stop := errors.New("no redirects to /end")
+	hooks := events.hooks()
+	hooks.OnRedirect = func(req *fhttp.Request, via []*fhttp.Request) error { return stop }
+	client := cycletls.Init(cycletls.WithHooks(hooks))
+	defer client.Close()
+	resp, err := client.Do(server.URL+"/start", cycletls.Options{UserAgent: UserAgent, InsecureSkipVerify: true}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp.Body, stop.Error()) {
+		t.Fatalf("expected the hook's error, got %q", resp.Body)
+	}
+	assertEqual(t, len(seen.list()), 1)
+	assertEqual(t, events.list()[len(events.list())-1], "error")
+}
+
+func TestDo_MiddlewareAnswers(t *testing.T) {
+	// Middleware that answers from a cache never reaches the network
+	cached := func(next cycletls.RoundTripFunc) cycletls.RoundTripFunc {
+		return func(req *fhttp.Request) (*fhttp.Response, error) {
+			return &fhttp.Response{
+				StatusCode: 203,
+				Header:     fhttp.Header{"X-Cache": {"hit"}},
+				Body:       io.NopCloser(strings.NewReader("cached")),
+				Request:    req,
+			}, nil
+		}
+	}
+	events := &hookLog{}
+	client := cycletls.Init(cycletls.WithHooks(events.hooks()), cycletls.WithMiddleware(cached))
+	defer client.Close()
+	resp, err := client.Do("https://cycletls.invalid/page", cycletls.Options{UserAgent: UserAgent}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Status, 203)
+	assertEqual(t, resp.Body, "cached")
+	assertEqual(t, resp.Headers["X-Cache"], "hit")
+	assertEqual(t, strings.Join(events.list(), ","), "")
+}
+
+func TestServeHTTP_MiddlewareAndHooks(t *testing.T) {
+	seen, events := &hookLog{}, &hookLog{}
+	server := redirectServer(t, seen)
+
+	client := cycletls.Init(cycletls.WithMiddleware(signer), cycletls.WithHooks(events.hooks()))
+	defer client.Close()
+	ws := httptest.NewServer(client)
+	defer ws.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ws.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = conn.WriteJSON(map[string]interface{}{
+		"requestId": "hooks",
+		"options": map[string]interface{}{
+			"url":                server.URL + "/start",
+			"method":             "GET",
+			"userAgent":          UserAgent,
+			"insecureSkipVerify": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read frames until the request ends
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		idLength := int(frame[0])<<8 | int(frame[1])
+		frame = frame[2+idLength:]
+		methodLength := int(frame[0])<<8 | int(frame[1])
+		if method := string(frame[2 : 2+methodLength]); method == "end" {
+			break
+		} else if method == "error" {
+			t.Fatalf("request failed: %q", frame[2+methodLength:])
+		}
+	}
+	assertEqual(t, strings.Join(seen.list(), ","), "/start signed:GET /start,/end signed:GET /end")
+	assertEqual(t, strings.Join(events.list(), ","), "request /start,dial tcp,tls,response 302,redirect /end,request /end,response 200")
+}
@@ -150,3 +150,33 @@ func TestQUICStringToSpecValidatesTLS13(t *testing.T) {
 		t.Errorf("QUIC spec should use TLS 1.3, got max version %d", spec.TLSVersMax)
 	}
 }
+
+func TestQUICSpecForUserAgent(t *testing.T) {
+	const safariUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15"
+	const oldSafariUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.2 Safari/605.1.15"
+
+	for _, ua := range []string{TestUserAgent, firefoxUA, safariUA, oldSafariUA} {
+		spec, err := cycletls.QUICSpecForUserAgent(ua)
+		if err != nil {
+			t.Fatalf("%s: %v", ua, err)
+		}
+		if spec.ClientHelloSpec == nil || spec.ClientHelloSpec.TLSVersMax != utls.VersionTLS13 {
+			t.Fatalf("%s: expected a TLS 1.3 ClientHello", ua)
+		}
+		hasQUICTransport := false
+		for _, ext := range spec.ClientHelloSpec.Extensions {
+			if _, ok := ext.(*utls.QUICTransportParametersExtension); ok {
+				hasQUICTransport = true
+			}
+		}
+		if !hasQUICTransport {
+			t.Errorf("%s: expected QUIC transport parameters", ua)
+		}
+	}
+
+	spec, _ := cycletls.QUICSpecForUserAgent(safariUA)
+	assertGREASE(t, spec.ClientHelloSpec, allGREASESlots)
+	// Safari only added GREASE in version 14
+	spec, _ = cycletls.QUICSpecForUserAgent(oldSafariUA)
+	assertGREASE(t, spec.ClientHelloSpec, nil)
+}
@@ -0,0 +1,268 @@
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	"github.com/gorilla/websocket"
+)
+
+// redirectingServer redirects /{status} to /echo with that status, and
+// /loop/{n} to /loop/{n+1}. /echo answers with the method, content type and
+// body it received.
+func redirectingServer(t *testing.T, http2 bool) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		if status, err := strconv.Atoi(path); err == nil {
+			http.SetCookie(w, &http.Cookie{Name: "hop" + path, Value: r.Method, Path: "/"})
+			http.Redirect(w, r, "/echo", status)
+			return
+		}
+		if n, ok := strings.CutPrefix(path, "loop/"); ok {
+			next, _ := strconv.Atoi(n)
+			http.Redirect(w, r, fmt.Sprintf("/loop/%d", next+1), http.StatusFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s|%s|%s", r.Method, r.Header.Get("Content-Type"), body)
+	}))
+	server.EnableHTTP2 = http2
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDo_RedirectMethods(t *testing.T) {
+	server := redirectingServer(t, true)
+	client := cycletls.Init()
+	defer client.Close()
+
+	tests := []struct {
+		method, path, want string
+	}{
+		{"POST", "/301", "GET||"},
+		{"POST", "/302", "GET||"},
+		{"PUT", "/302", `PUT|application/json|{"a":1}`},
+		{"POST", "/303", "GET||"},
+		{"HEAD", "/303", "HEAD"},
+		{"POST", "/307", `POST|application/json|{"a":1}`},
+		{"DELETE", "/308", `DELETE|application/json|{"a":1}`},
+	}
+	for _, tt := range tests {
+		resp, err := client.Do(server.URL+tt.path, cycletls.Options{
+			UserAgent:          UserAgent,
+			InsecureSkipVerify: true,
+			Body:               `{"a":1}`,
+			Headers:            map[string]string{"Content-Type": "application/json"},
+		}, tt.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.method == "HEAD" {
+			assertEqual(t, resp.Status, 200)
+			assertEqual(t, resp.FinalUrl, server.URL+"/echo")
+		} else if resp.Body != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, resp.Body, tt.want)
+		}
+		if len(resp.Redirects) != 1 || resp.Redirects[0].Method != tt.method || resp.Redirects[0].Location != server.URL+"/echo" {
+			t.Errorf("%s %s: expected the redirect in the chain, got %+v", tt.method, tt.path, resp.Redirects)
+		}
+	}
+}
+
+func TestDo_RedirectChain(t *testing.T) {
+	// Over HTTP/1.1, where each request of the chain needs its own connection
+	server := redirectingServer(t, false)
+	first := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "start", Value: "1", HttpOnly: true})
+		http.Redirect(w, r, server.URL+"/308", http.StatusMovedPermanently)
+	}))
+	first.StartTLS()
+	defer first.Close()
+
+	client := cycletls.Init()
+	defer client.Close()
+	resp, err := client.Do(first.URL+"/start", cycletls.Options{UserAgent: UserAgent, InsecureSkipVerify: true, ForceHTTP1: true}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Body, "GET||")
+	if len(resp.Redirects) != 2 {
+		t.Fatalf("expected two redirects, got %+v", resp.Redirects)
+	}
+
+	start, moved := resp.Redirects[0], resp.Redirects[1]
+	assertEqual(t, start.URL, first.URL+"/start")
+	assertEqual(t, start.Status, 301)
+	assertEqual(t, start.Location, server.URL+"/308")
+	if len(start.Cookies) != 1 || start.Cookies[0].Name != "start" || !start.Cookies[0].HttpOnly {
+		t.Fatalf("expected the start cookie, got %+v", start.Cookies)
+	}
+	assertEqual(t, moved.Status, 308)
+	assertEqual(t, moved.Location, server.URL+"/echo")
+	if len(moved.Headers["Set-Cookie"]) != 1 || !strings.HasPrefix(moved.Headers["Set-Cookie"][0], "hop308=GET") {
+		t.Fatalf("expected the redirect's Set-Cookie header, got %v", moved.Headers)
+	}
+}
+
+func TestDo_MaxRedirects(t *testing.T) {
+	server := redirectingServer(t, true)
+	client := cycletls.Init()
+	defer client.Close()
+
+	options := cycletls.Options{UserAgent: UserAgent, InsecureSkipVerify: true, MaxRedirects: 2}
+	resp, err := client.Do(server.URL+"/loop/0", options, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp.Body, "stopped after 2 redirects") {
+		t.Fatalf("expected the request to stop after 2 redirects, got %d %q", resp.Status, resp.Body)
+	}
+	assertEqual(t, len(resp.Redirects), 2)
+
+	options.MaxRedirects = 0
+	resp, err = client.Do(server.URL+"/loop/0", options, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(resp.Redirects), cycletls.DefaultMaxRedirects)
+
+	// A negative limit follows none
+	options.MaxRedirects = -1
+	resp, err = client.Do(server.URL+"/loop/0", options, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Status, 302)
+	assertEqual(t, len(resp.Redirects), 0)
+}
+
+func TestDo_RedirectPolicies(t *testing.T) {
+	server := redirectingServer(t, true)
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	}))
+	defer plain.Close()
+	target := map[string]string{
+		// The same server, under another name
+		"/host":   strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/echo",
+		"/scheme": plain.URL + "/page",
+	}
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target[r.URL.Path], http.StatusFound)
+	}))
+	origin.EnableHTTP2 = true
+	origin.StartTLS()
+	defer origin.Close()
+
+	client := cycletls.Init()
+	defer client.Close()
+	tests := []struct {
+		path       string
+		options    cycletls.Options
+		wantStatus int
+	}{
+		{"/host", cycletls.Options{}, 200},
+		{"/host", cycletls.Options{SameHostRedirects: true}, 302},
+		{"/host", cycletls.Options{SameSchemeRedirects: true}, 200},
+		{"/scheme", cycletls.Options{SameHostRedirects: true}, 302},
+		{"/scheme", cycletls.Options{SameSchemeRedirects: true}, 302},
+	}
+	for _, tt := range tests {
+		tt.options.UserAgent, tt.options.InsecureSkipVerify = UserAgent, true
+		resp, err := client.Do(origin.URL+tt.path, tt.options, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != tt.wantStatus {
+			t.Errorf("%s with %+v: got status %d, want %d", tt.path, tt.options, resp.Status, tt.wantStatus)
+		}
+		if tt.wantStatus == 302 && (len(resp.Redirects) != 0 || resp.Headers["Location"] != target[tt.path]) {
+			t.Errorf("%s: expected the redirect as the response, got %+v", tt.path, resp)
+		}
+	}
+}
+
+func TestServeHTTP_Redirects(t *testing.T) {
+	server := redirectingServer(t, true)
+	client := cycletls.Init()
+	defer client.Close()
+	ws := httptest.NewServer(client)
+	defer ws.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ws.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = conn.WriteJSON(map[string]interface{}{
+		"requestId": "redirects",
+		"options": map[string]interface{}{
+			"url":                server.URL + "/303",
+			"method":             "POST",
+			"body":               "data",
+			"userAgent":          UserAgent,
+			"insecureSkipVerify": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := frameReader(frame)
+		r.string()
+		method := r.string()
+		if method == "error" {
+			t.Fatalf("request failed: %q", frame)
+		} else if method != "response" {
+			continue
+		}
+
+		assertEqual(t, r.u16(), 200)
+		r.string() // final URL
+		for headers := r.u16(); headers > 0; headers-- {
+			r.string()
+			for values := r.u16(); values > 0; values-- {
+				r.string()
+			}
+		}
+		r.string() // fingerprints
+		length := int(r[0])<<24 | int(r[1])<<16 | int(r[2])<<8 | int(r[3])
+		var redirects []cycletls.Redirect
+		if err := json.Unmarshal(r[4:4+length], &redirects); err != nil {
+			t.Fatal(err)
+		}
+		if len(redirects) != 1 || redirects[0].Status != 303 || redirects[0].Method != "POST" || redirects[0].Cookies[0].Value != "POST" {
+			t.Fatalf("expected the 303 redirect, got %+v", redirects)
+		}
+		return
+	}
+}
+
+// frameReader reads the length-prefixed fields of a WS_PORT frame
+type frameReader []byte
+
+func (r *frameReader) u16() int {
+	v := int((*r)[0])<<8 | int((*r)[1])
+	*r = (*r)[2:]
+	return v
+}
+
+func (r *frameReader) string() string {
+	n := r.u16()
+	s := string((*r)[:n])
+	*r = (*r)[n:]
+	return s
+}
@@ -0,0 +1,122 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+)
+
+const chrome131UA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36"
+
+// headerNames returns the lowercased names of raw header lines
+func headerNames(lines []string) []string {
+	var names []string
+	for _, line := range lines {
+		name, _, _ := strings.Cut(line, ":")
+		names = append(names, strings.ToLower(name))
+	}
+	return names
+}
+
+// headerValue returns the value of the first raw header line named name
+func headerValue(lines []string, name string) string {
+	for _, line := range lines {
+		if k, v, ok := strings.Cut(line, ": "); ok && strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestDo_RequestModeDocument(t *testing.T) {
+	url, lines := rawHeaderServer(t)
+
+	_, err := cycletls.Init().Do(url, cycletls.Options{
+		UserAgent:   chrome131UA,
+		RequestMode: "document",
+		Headers:     map[string]string{"Accept-Language": "de-DE,de;q=0.9"},
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-lines
+	want := []string{
+		"sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform", "upgrade-insecure-requests",
+		"user-agent", "accept", "sec-fetch-site", "sec-fetch-mode", "sec-fetch-user", "sec-fetch-dest",
+		"accept-encoding", "accept-language",
+	}
+	var names []string
+	for _, name := range headerNames(got) {
+		if name != "host" {
+			names = append(names, name)
+		}
+	}
+	assertEqual(t, strings.Join(names, ","), strings.Join(want, ","))
+	assertEqual(t, headerValue(got, "sec-ch-ua"), `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`)
+	assertEqual(t, headerValue(got, "sec-ch-ua-platform"), `"Windows"`)
+	assertEqual(t, headerValue(got, "sec-fetch-mode"), "navigate")
+	// The caller's header wins over the default
+	assertEqual(t, headerValue(got, "accept-language"), "de-DE,de;q=0.9")
+}
+
+func TestDo_RequestModeFirefoxXHR(t *testing.T) {
+	url, lines := rawHeaderServer(t)
+
+	_, err := cycletls.Init().Do(url, cycletls.Options{
+		UserAgent:   firefoxUA,
+		RequestMode: "fetch",
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-lines
+	var names []string
+	for _, name := range headerNames(got) {
+		if name != "host" {
+			names = append(names, name)
+		}
+	}
+	want := []string{"user-agent", "accept", "accept-language", "accept-encoding", "sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site"}
+	assertEqual(t, strings.Join(names, ","), strings.Join(want, ","))
+	assertEqual(t, headerValue(got, "accept"), "*/*")
+	assertEqual(t, headerValue(got, "sec-fetch-mode"), "cors")
+}
+
+func TestDo_RequestModeSafari(t *testing.T) {
+	url, lines := rawHeaderServer(t)
+
+	_, err := cycletls.Init().Do(url, cycletls.Options{
+		UserAgent:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+		RequestMode: "document",
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-lines
+	var names []string
+	for _, name := range headerNames(got) {
+		if name != "host" {
+			names = append(names, name)
+		}
+	}
+	// iOS sends Safari's headers, in Safari's order and without client hints
+	want := []string{"accept", "sec-fetch-site", "sec-fetch-dest", "accept-language", "sec-fetch-mode", "user-agent", "accept-encoding"}
+	assertEqual(t, strings.Join(names, ","), strings.Join(want, ","))
+	assertEqual(t, headerValue(got, "accept-encoding"), "gzip, deflate, br")
+}
+
+func TestDo_RequestModeInvalid(t *testing.T) {
+	_, err := cycletls.Init().Do("http://127.0.0.1:1", cycletls.Options{RequestMode: "script"}, "GET")
+	if err == nil || !strings.Contains(err.Error(), "unknown request mode") {
+		t.Fatalf("expected an unknown request mode error, got %v", err)
+	}
+
+	diags := cycletls.ValidateFingerprint(cycletls.Options{RequestMode: "script"})
+	if _, ok := findDiagnostic(diags, "requestMode", "unknown request mode"); !ok {
+		t.Fatalf("expected a request mode error, got %v", diags)
+	}
+}
@@ -1,8 +1,11 @@
 package unit
 
 import (
-	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
 )
 
 const (
@@ -31,3 +34,25 @@ func TestInvalidSpec(t *testing.T) {
 	_ = spec
 
 }
+
+func TestDo_ReusedClientRedialsClosedHTTP1Connections(t *testing.T) {
+	// The server closes every connection after its response
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	client := cycletls.Init()
+	defer client.Close()
+	options := cycletls.Options{UserAgent: UserAgent, InsecureSkipVerify: true, ForceHTTP1: true, EnableConnectionReuse: true}
+	for _, path := range []string{"/first", "/second", "/third"} {
+		resp, err := client.Do(server.URL+path, options, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Body != path {
+			t.Fatalf("%s: got %d %q", path, resp.Status, resp.Body)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package unit
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	utls "github.com/refraction-networking/utls"
+)
+
+const chromeJA4r = "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0000,0005,000a,000b,000d,0012,0017,001b,0023,002b,002d,0033,44cd,fe0d,ff01_0403,0804,0401,0503,0805,0501,0806,0601"
+
+// extensionOrder names each extension by type, and by ID for generic ones
+func extensionOrder(spec *utls.ClientHelloSpec) []string {
+	var order []string
+	for _, ext := range spec.Extensions {
+		if generic, ok := ext.(*utls.GenericExtension); ok {
+			order = append(order, fmt.Sprintf("generic:%d", generic.Id))
+			continue
+		}
+		order = append(order, fmt.Sprintf("%T", ext))
+	}
+	return order
+}
+
+func TestStringToSpec_RandomizeExtensionOrder(t *testing.T) {
+	static, err := cycletls.StringToSpec(cycletls.DefaultChrome_JA3, UserAgent, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := extensionOrder(static)
+
+	seeded := func(seed int64) []string {
+		spec, err := cycletls.StringToSpecWithOptions(cycletls.DefaultChrome_JA3, UserAgent, false, cycletls.SpecOptions{
+			RandomizeExtensionOrder: true,
+			ExtensionOrderSeed:      seed,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return extensionOrder(spec)
+	}
+
+	first := seeded(42)
+	if !slices.Equal(first, seeded(42)) {
+		t.Fatal("the same seed should produce the same order")
+	}
+	if slices.Equal(first, want) && slices.Equal(seeded(7), want) {
+		t.Fatal("extension order was not shuffled")
+	}
+
+	// GREASE and padding keep their positions
+	for i, name := range want {
+		if name == "*tls.UtlsGREASEExtension" || name == "*tls.UtlsPaddingExtension" {
+			assertEqual(t, first[i], name)
+		}
+	}
+	sortedWant, sortedGot := slices.Clone(want), slices.Clone(first)
+	slices.Sort(sortedWant)
+	slices.Sort(sortedGot)
+	if !slices.Equal(sortedWant, sortedGot) {
+		t.Fatalf("shuffle changed the extension set: %v vs %v", sortedGot, sortedWant)
+	}
+}
+
+func TestJA4RStringToSpec_RandomizeExtensionOrder(t *testing.T) {
+	opts := cycletls.SpecOptions{RandomizeExtensionOrder: true, ExtensionOrderSeed: 1}
+	a, err := cycletls.JA4RStringToSpecWithOptions(chromeJA4r, UserAgent, false, false, "example.com", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := cycletls.JA4RStringToSpecWithOptions(chromeJA4r, UserAgent, false, false, "example.com", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(extensionOrder(a), extensionOrder(b)) {
+		t.Fatal("the same seed should produce the same order")
+	}
+
+	static, err := cycletls.JA4RStringToSpec(chromeJA4r, UserAgent, false, false, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(a.Extensions), len(static.Extensions))
+}
@@ -0,0 +1,91 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+)
+
+func TestDo_Timings(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	client := cycletls.Init()
+	defer client.Close()
+	options := cycletls.Options{UserAgent: UserAgent, InsecureSkipVerify: true, EnableConnectionReuse: true}
+
+	resp, err := client.Do(server.URL, options, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timings := resp.Timings
+	if timings == nil {
+		t.Fatal("expected timings")
+	}
+	if timings.ConnectionReused || timings.TLS <= 0 || timings.Connect <= 0 {
+		t.Fatalf("expected a new connection with TCP and TLS times, got %+v", timings)
+	}
+	if timings.FirstByte < 20*time.Millisecond || timings.Total < timings.FirstByte {
+		t.Fatalf("expected the server's delay before the first byte, got %+v", timings)
+	}
+	assertEqual(t, timings.Transfer, timings.Total-timings.FirstByte)
+
+	// The second request goes out on the same connection
+	resp, err = client.Do(server.URL, options, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Timings.ConnectionReused || resp.Timings.TLS != 0 || resp.Timings.DNS != 0 {
+		t.Fatalf("expected a reused connection without dial times, got %+v", resp.Timings)
+	}
+
+	data, err := json.Marshal(resp.Timings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if ms, ok := fields["firstByte"].(float64); !ok || ms < 20 {
+		t.Fatalf("expected firstByte in milliseconds, got %s", data)
+	}
+}
+
+func TestDo_TimingsProxyConnect(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// A CONNECT proxy that takes its time to answer
+	proxyURL, _ := connectProxy(t, 20*time.Millisecond)
+
+	client := cycletls.Init()
+	defer client.Close()
+	resp, err := client.Do(server.URL, cycletls.Options{
+		UserAgent:             UserAgent,
+		InsecureSkipVerify:    true,
+		Proxy:                 proxyURL,
+		EnableConnectionReuse: false,
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Status, 200)
+	if resp.Timings.ProxyConnect < 20*time.Millisecond || resp.Timings.Connect <= 0 {
+		t.Fatalf("expected the proxy connect and handshake times, got %+v", resp.Timings)
+	}
+	if resp.Timings.ProxyConnect > resp.Timings.Total {
+		t.Fatalf("proxy time exceeds the total, got %+v", resp.Timings)
+	}
+}
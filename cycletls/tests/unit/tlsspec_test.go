@@ -0,0 +1,150 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+	utls "github.com/refraction-networking/utls"
+)
+
+const tlsSpecYAML = `
+tlsVersionMin: "1.2"
+tlsVersionMax: "1.3"
+cipherSuites: [GREASE, TLS_AES_128_GCM_SHA256, TLS_AES_256_GCM_SHA384, 0xc02b, 49199]
+extensions:
+  - name: GREASE
+  - name: server_name
+  - name: supported_groups
+    groups: [GREASE, X25519MLKEM768, X25519, P-256]
+  - name: ec_point_formats
+  - name: signature_algorithms
+    signatureAlgorithms: [ecdsa_secp256r1_sha256, rsa_pss_rsae_sha256, 0x0401]
+  - name: alpn
+    protocols: [h2, http/1.1]
+  - name: compress_certificate
+    algorithms: [brotli, zstd]
+  - name: record_size_limit
+    limit: 16385
+  - name: delegated_credentials
+    signatureAlgorithms: [ecdsa_secp256r1_sha256]
+  - name: key_share
+    keyShares: [GREASE, X25519MLKEM768, X25519]
+  - name: psk_key_exchange_modes
+  - name: supported_versions
+    versions: [GREASE, "1.3", "1.2"]
+  - name: application_settings
+    codepoint: 17513
+    protocols: [h2]
+  - name: encrypted_client_hello
+    payloadLengths: [160]
+  - id: 65000
+    data: "0001"
+  - name: padding
+    style: padTo
+    length: 2048
+`
+
+func TestParseTLSSpec_YAML(t *testing.T) {
+	tlsSpec, err := cycletls.ParseTLSSpec([]byte(tlsSpecYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := tlsSpec.ToClientHelloSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, spec.TLSVersMin, uint16(utls.VersionTLS12))
+	assertEqual(t, spec.TLSVersMax, uint16(utls.VersionTLS13))
+	assertEqual(t, len(spec.CipherSuites), 5)
+	assertEqual(t, spec.CipherSuites[3], uint16(0xc02b))
+	assertEqual(t, len(spec.Extensions), len(tlsSpec.Extensions))
+
+	for _, ext := range spec.Extensions {
+		switch x := ext.(type) {
+		case *utls.KeyShareExtension:
+			assertEqual(t, len(x.KeyShares), 3)
+			assertEqual(t, x.KeyShares[1].Group, utls.X25519MLKEM768)
+		case *utls.SignatureAlgorithmsExtension:
+			assertEqual(t, x.SupportedSignatureAlgorithms[2], utls.PKCS1WithSHA256)
+		case *utls.UtlsCompressCertExtension:
+			assertEqual(t, x.Algorithms[1], utls.CertCompressionZstd)
+		case *utls.FakeRecordSizeLimitExtension:
+			assertEqual(t, x.Limit, uint16(16385))
+		case *utls.GREASEEncryptedClientHelloExtension:
+			assertEqual(t, x.CandidatePayloadLens[0], uint16(160))
+		case *utls.GenericExtension:
+			assertEqual(t, x.Id, uint16(65000))
+		}
+	}
+
+	// The spec must produce a ClientHello uTLS can build
+	uconn := utls.UClient(nil, &utls.Config{ServerName: "example.com"}, utls.HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(uconn.HandshakeState.Hello.Raw), 2048)
+}
+
+func TestTLSSpec_RoundTrip(t *testing.T) {
+	captured, err := cycletls.SpecFromClientHelloBytes(chromeClientHello(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsSpec, err := cycletls.NewTLSSpec(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(tlsSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := cycletls.ParseTLSSpec(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := parsed.ToClientHelloSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, len(spec.CipherSuites), len(captured.CipherSuites))
+	for i := range captured.CipherSuites {
+		assertEqual(t, spec.CipherSuites[i], captured.CipherSuites[i])
+	}
+	want := extensionTypes(t, captured)
+	got := extensionTypes(t, spec)
+	assertEqual(t, len(got), len(want))
+	for i := range want {
+		assertEqual(t, got[i], want[i])
+	}
+}
+
+func TestDo_TLSSpecOption(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	tlsSpec, err := cycletls.ParseTLSSpec([]byte(tlsSpecYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := cycletls.Init()
+	resp, err := client.Do(server.URL, cycletls.Options{
+		TLSSpec:            tlsSpec,
+		InsecureSkipVerify: true,
+		ForceHTTP1:         true,
+	}, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, resp.Status, 200)
+	assertEqual(t, resp.Body, "ok")
+}
@@ -0,0 +1,162 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+
+	cycletls "github.com/Danny-Dasilva/CycleTLS/cycletls"
+)
+
+const (
+	chromeHTTP2  = "1:65536,2:0,4:6291456,6:262144|15663105|0|m,a,s,p"
+	firefoxHTTP2 = "1:65536,4:131072,5:16384|12517377|0|m,p,a,s"
+)
+
+// findDiagnostic returns the first diagnostic for field whose message contains substr
+func findDiagnostic(diags []cycletls.Diagnostic, field, substr string) (cycletls.Diagnostic, bool) {
+	for _, d := range diags {
+		if d.Field == field && strings.Contains(d.Message, substr) {
+			return d, true
+		}
+	}
+	return cycletls.Diagnostic{}, false
+}
+
+func TestValidateFingerprint_Valid(t *testing.T) {
+	diags := cycletls.ValidateFingerprint(cycletls.Options{
+		Ja3:              cycletls.DefaultChrome_JA3,
+		HTTP2Fingerprint: chromeHTTP2,
+		UserAgent:        UserAgent,
+	})
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+}
+
+func TestValidateFingerprint_MalformedJA3(t *testing.T) {
+	for _, ja3 := range []string{"771,4865-4866,0-23", "771,4865-abc,0-23,29,0", "1,4865,0,29,0"} {
+		diags := cycletls.ValidateFingerprint(cycletls.Options{Ja3: ja3})
+		if !cycletls.HasErrors(diags) {
+			t.Errorf("%q: expected an error, got %v", ja3, diags)
+		}
+	}
+
+	// The spec builder reports a truncated JA3 instead of panicking
+	if _, err := cycletls.StringToSpec("771,4865-4866,0-23", UserAgent, false); err == nil {
+		t.Fatal("expected an error for a truncated JA3")
+	}
+}
+
+func TestValidateFingerprint_TLS13CiphersWithTLS12(t *testing.T) {
+	diags := cycletls.ValidateFingerprint(cycletls.Options{Ja3: "771,4865-49195,0-10-11-13,29-23,0"})
+	d, ok := findDiagnostic(diags, "ja3", "TLS 1.3 cipher suite")
+	if !ok {
+		t.Fatalf("expected a TLS 1.3 cipher warning, got %v", diags)
+	}
+	assertEqual(t, d.Severity, cycletls.SeverityWarning)
+
+	diags = cycletls.ValidateFingerprint(cycletls.Options{Ja4r: "t12d0202h2_1301,c02b_000a,000d_0403"})
+	if _, ok := findDiagnostic(diags, "ja4r", "TLS 1.3 cipher suite"); !ok {
+		t.Fatalf("expected a TLS 1.3 cipher warning, got %v", diags)
+	}
+}
+
+func TestValidateFingerprint_PseudoHeaderOrder(t *testing.T) {
+	diags := cycletls.ValidateFingerprint(cycletls.Options{HTTP2Fingerprint: chromeHTTP2, UserAgent: firefoxUA})
+	if _, ok := findDiagnostic(diags, "http2Fingerprint", "does not match the firefox user agent"); !ok {
+		t.Fatalf("expected a pseudo-header order warning, got %v", diags)
+	}
+
+	diags = cycletls.ValidateFingerprint(cycletls.Options{HTTP2Fingerprint: firefoxHTTP2, UserAgent: firefoxUA})
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+
+	diags = cycletls.ValidateFingerprint(cycletls.Options{HTTP2Fingerprint: "1:65536|0|0|m,x,s,p"})
+	if !cycletls.HasErrors(diags) {
+		t.Fatalf("expected an error for an unknown pseudo-header, got %v", diags)
+	}
+}
+
+func TestValidateFingerprint_HTTP2WithoutALPN(t *testing.T) {
+	diags := cycletls.ValidateFingerprint(cycletls.Options{
+		Ja3:              "771,4865-4866,0-10-11-13-43-51,29-23,0",
+		HTTP2Fingerprint: chromeHTTP2,
+		UserAgent:        UserAgent,
+	})
+	if _, ok := findDiagnostic(diags, "http2Fingerprint", "no ALPN"); !ok {
+		t.Fatalf("expected a missing ALPN warning, got %v", diags)
+	}
+}
+
+func TestValidateFingerprint_UnsupportedExtensions(t *testing.T) {
+	d, ok := findDiagnostic(cycletls.ValidateFingerprint(cycletls.Options{Ja3: unknownExtJA3}), "ja3", "extension 4660")
+	if !ok {
+		t.Fatal("expected a diagnostic for extension 4660")
+	}
+	assertEqual(t, d.Severity, cycletls.SeverityWarning)
+
+	diags := cycletls.ValidateFingerprint(cycletls.Options{Ja3: unknownExtJA3, StrictExtensions: true})
+	if d, ok := findDiagnostic(diags, "ja3", "extension 4660"); !ok || d.Severity != cycletls.SeverityError {
+		t.Fatalf("expected an error in strict mode, got %v", diags)
+	}
+
+	// A configured payload makes the extension supported
+	diags = cycletls.ValidateFingerprint(cycletls.Options{Ja3: unknownExtJA3, ExtensionData: map[string]string{"4660": "00"}})
+	if _, ok := findDiagnostic(diags, "ja3", "extension 4660"); ok {
+		t.Fatalf("unexpected diagnostic with extension data: %v", diags)
+	}
+
+	diags = cycletls.ValidateFingerprint(cycletls.Options{Ja4r: "t13d0303h2_1301,1302,1303_000a,000d,1234_0403"})
+	if _, ok := findDiagnostic(diags, "ja4r", "extension 4660"); !ok {
+		t.Fatalf("expected a diagnostic for extension 0x1234, got %v", diags)
+	}
+}
+
+func TestValidateFingerprint_Other(t *testing.T) {
+	cases := []struct {
+		name    string
+		options cycletls.Options
+		field   string
+	}{
+		{"bad ja4r", cycletls.Options{Ja4r: "x13d0303h2_1301_000a"}, "ja4r"},
+		{"bad http2", cycletls.Options{HTTP2Fingerprint: "1:65536|0"}, "http2Fingerprint"},
+		{"short quic", cycletls.Options{QUICFingerprint: "771,4865"}, "quicFingerprint"},
+		{"bad grease mode", cycletls.Options{GreaseMode: "sometimes"}, "greaseMode"},
+		{"bad extension data", cycletls.Options{ExtensionData: map[string]string{"0x1234": "zz"}}, "extensionData"},
+		{"bad client hello", cycletls.Options{ClientHello: "1603"}, "clientHello"},
+	}
+	for _, c := range cases {
+		diags := cycletls.ValidateFingerprint(c.options)
+		if _, ok := findDiagnostic(diags, c.field, ""); !ok || !cycletls.HasErrors(diags) {
+			t.Errorf("%s: expected an error for %s, got %v", c.name, c.field, diags)
+		}
+	}
+
+	// Fingerprints dialTLS never uses are flagged
+	diags := cycletls.ValidateFingerprint(cycletls.Options{Ja3: cycletls.DefaultChrome_JA3, Ja4r: chromeJA4r, UserAgent: UserAgent})
+	if _, ok := findDiagnostic(diags, "ja4r", "ja3 takes precedence"); !ok {
+		t.Fatalf("expected a precedence warning, got %v", diags)
+	}
+}
+
+func TestValidateFingerprint_QUIC(t *testing.T) {
+	// The error is the one the dialer would fail with
+	short := "771,4865"
+	_, err := cycletls.QUICStringToSpec(short, UserAgent, false)
+	if err == nil {
+		t.Fatal("expected the QUIC parser to reject a short fingerprint")
+	}
+	diags := cycletls.ValidateFingerprint(cycletls.Options{QUICFingerprint: short, UserAgent: UserAgent})
+	if _, ok := findDiagnostic(diags, "quicFingerprint", err.Error()); !ok {
+		t.Fatalf("expected %q, got %v", err, diags)
+	}
+
+	quic := strings.Repeat("16030106f2010006ee03039a2b98d811", 4)
+	if _, err := cycletls.QUICStringToSpec(quic, UserAgent, false); err != nil {
+		t.Fatal(err)
+	}
+	if diags := cycletls.ValidateFingerprint(cycletls.Options{QUICFingerprint: quic, UserAgent: UserAgent}); len(diags) > 0 {
+		t.Fatalf("unexpected diagnostics for a QUIC fingerprint the dialer accepts: %v", diags)
+	}
+}
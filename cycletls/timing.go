@@ -0,0 +1,197 @@
+package cycletls
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// Timings break the time a request took down by phase, like the timing tab
+// of a browser's network panel. Phases the request skipped, such as
+// everything before sending it on a reused connection, are zero. Phases of
+// a redirect chain add up, and FirstByte and Total count from its first
+// request.
+type Timings struct {
+	DNS              time.Duration // Resolving the host, or the proxy's host
+	Connect          time.Duration // TCP connect, to the proxy when there is one
+	ProxyConnect     time.Duration // Proxy CONNECT or SOCKS handshake
+	TLS              time.Duration // TLS handshake with retries, or the QUIC handshake on HTTP/3
+	TLSRetries       int           // Handshakes retried with another ClientHello
+	FirstByte        time.Duration // Until the response headers started to arrive
+	Transfer         time.Duration // Reading the body
+	Total            time.Duration
+	ConnectionReused bool // The request went out on an open connection
+}
+
+// MarshalJSON writes durations as fractional milliseconds
+func (t Timings) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return json.Marshal(struct {
+		DNS              float64 `json:"dns"`
+		Connect          float64 `json:"connect"`
+		ProxyConnect     float64 `json:"proxyConnect"`
+		TLS              float64 `json:"tls"`
+		TLSRetries       int     `json:"tlsRetries"`
+		FirstByte        float64 `json:"firstByte"`
+		Transfer         float64 `json:"transfer"`
+		Total            float64 `json:"total"`
+		ConnectionReused bool    `json:"connectionReused"`
+	}{ms(t.DNS), ms(t.Connect), ms(t.ProxyConnect), ms(t.TLS), t.TLSRetries, ms(t.FirstByte), ms(t.Transfer), ms(t.Total), t.ConnectionReused})
+}
+
+// Request phases the dialers time
+type timingPhase int
+
+const (
+	phaseDNS timingPhase = iota
+	phaseConnect
+	phaseProxyConnect
+	phaseTLS
+)
+
+// timingRecorder collects a request's Timings. The dialers report to it
+// through the request context, some from their own goroutines. Its methods
+// do nothing on a nil recorder, so requests without one are not timed.
+type timingRecorder struct {
+	mu      sync.Mutex
+	start   time.Time
+	dialed  bool
+	timings Timings
+}
+
+type timingsKey struct{}
+
+// withTimings returns a context that times the request it is used for
+func withTimings(ctx context.Context) (context.Context, *timingRecorder) {
+	r := &timingRecorder{}
+	return context.WithValue(ctx, timingsKey{}, r), r
+}
+
+func timingsFromContext(ctx context.Context) *timingRecorder {
+	r, _ := ctx.Value(timingsKey{}).(*timingRecorder)
+	return r
+}
+
+// begin starts the clock, unless an earlier request of the chain has
+func (r *timingRecorder) begin() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+}
+
+// add adds d to phase
+func (r *timingRecorder) add(phase timingPhase, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dialed = true
+	switch phase {
+	case phaseDNS:
+		r.timings.DNS += d
+	case phaseConnect:
+		r.timings.Connect += d
+	case phaseProxyConnect:
+		r.timings.ProxyConnect += d
+	case phaseTLS:
+		r.timings.TLS += d
+	}
+}
+
+// tlsRetry notes a handshake retried with another ClientHello
+func (r *timingRecorder) tlsRetry() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timings.TLSRetries++
+}
+
+// firstByte notes that response headers started to arrive
+func (r *timingRecorder) firstByte() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.start.IsZero() {
+		r.timings.FirstByte = time.Since(r.start)
+	}
+}
+
+// dialTime is the DNS and connect time recorded so far
+func (r *timingRecorder) dialTime() time.Duration {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.timings.DNS + r.timings.Connect
+}
+
+// phases returns the phase times recorded so far
+func (r *timingRecorder) phases() Timings {
+	if r == nil {
+		return Timings{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.timings
+}
+
+// finish stops the clock once the body has been read and returns the Timings
+func (r *timingRecorder) finish() *Timings {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.timings
+	if !r.start.IsZero() {
+		t.Total = time.Since(r.start)
+		if t.FirstByte > 0 {
+			t.Transfer = t.Total - t.FirstByte
+		}
+	}
+	t.ConnectionReused = !r.dialed
+	return &t
+}
+
+// timedHandshake runs conn's TLS handshake, adding its time to the TLS phase
+// of ctx's request and telling the request's hooks
+func timedHandshake(ctx context.Context, conn *utls.UConn) error {
+	start := time.Now()
+	err := conn.Handshake()
+	timingsFromContext(ctx).add(phaseTLS, time.Since(start))
+	extensionsFromContext(ctx).tlsHandshake(ctx, conn.ConnectionState(), err)
+	return err
+}
+
+// timeResolve passes batches through, calling resolved when the first one
+// arrives, which ends the DNS phase of a dial. Like resolve's channel, the
+// returned one holds every batch, so forwarding never blocks.
+func timeResolve(batches <-chan addrBatch, resolved func()) <-chan addrBatch {
+	out := make(chan addrBatch, 3)
+	go func() {
+		defer close(out)
+		first := true
+		for batch := range batches {
+			if first {
+				resolved()
+				first = false
+			}
+			out <- batch
+		}
+	}()
+	return out
+}
@@ -0,0 +1,702 @@
+package cycletls
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+	"gopkg.in/yaml.v3"
+)
+
+// TLSSpec is a JSON/YAML description of a ClientHello. Unlike a JA3 string it
+// lists every extension in order together with its parameters, so nothing is
+// filled in from genMap defaults. It is accepted through Options.TLSSpec and
+// converts to and from a utls.ClientHelloSpec.
+//
+// Numeric values (cipher suites, groups, signature algorithms, versions) may
+// be given as decimal numbers, "0x" hex strings, names such as
+// "TLS_AES_128_GCM_SHA256", "X25519MLKEM768" or "ecdsa_secp256r1_sha256", or
+// "GREASE" for a GREASE placeholder.
+//
+//	{
+//	  "cipherSuites": ["GREASE", "TLS_AES_128_GCM_SHA256", "0xc02b"],
+//	  "extensions": [
+//	    {"name": "GREASE"},
+//	    {"name": "server_name"},
+//	    {"name": "supported_groups", "groups": ["GREASE", "X25519MLKEM768", "X25519", "P-256"]},
+//	    {"name": "key_share", "keyShares": ["GREASE", "X25519MLKEM768", "X25519"]},
+//	    {"name": "application_settings", "codepoint": 17613, "protocols": ["h2"]},
+//	    {"name": "encrypted_client_hello", "payloadLengths": [128, 160, 192, 224]},
+//	    {"name": "padding", "style": "boringssl"},
+//	    {"id": 65000, "data": "0001"}
+//	  ]
+//	}
+type TLSSpec struct {
+	TLSVersionMin      TLSSpecValue       `json:"tlsVersionMin,omitempty" yaml:"tlsVersionMin,omitempty"`
+	TLSVersionMax      TLSSpecValue       `json:"tlsVersionMax,omitempty" yaml:"tlsVersionMax,omitempty"`
+	CipherSuites       []TLSSpecValue     `json:"cipherSuites" yaml:"cipherSuites"`
+	CompressionMethods []int              `json:"compressionMethods,omitempty" yaml:"compressionMethods,omitempty"`
+	Extensions         []TLSSpecExtension `json:"extensions" yaml:"extensions"`
+}
+
+// TLSSpecExtension is a single ClientHello extension. Known extensions are
+// identified by Name; anything else by ID with its raw Data. Only the
+// parameter fields relevant to the extension are used.
+type TLSSpecExtension struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	ID   uint16 `json:"id,omitempty" yaml:"id,omitempty"`
+	Data string `json:"data,omitempty" yaml:"data,omitempty"` // Hex-encoded extension body
+
+	// supported_groups and key_share
+	Groups    []TLSSpecValue `json:"groups,omitempty" yaml:"groups,omitempty"`
+	KeyShares []TLSSpecValue `json:"keyShares,omitempty" yaml:"keyShares,omitempty"`
+
+	// signature_algorithms, signature_algorithms_cert and delegated_credentials
+	SignatureAlgorithms []TLSSpecValue `json:"signatureAlgorithms,omitempty" yaml:"signatureAlgorithms,omitempty"`
+
+	// application_layer_protocol_negotiation, application_settings and next_protocol_negotiation
+	Protocols []string `json:"protocols,omitempty" yaml:"protocols,omitempty"`
+	Codepoint uint16   `json:"codepoint,omitempty" yaml:"codepoint,omitempty"` // ALPS codepoint, 17513 (old) or 17613 (default)
+
+	// supported_versions
+	Versions []TLSSpecValue `json:"versions,omitempty" yaml:"versions,omitempty"`
+
+	// ec_point_formats and psk_key_exchange_modes
+	PointFormats []int `json:"pointFormats,omitempty" yaml:"pointFormats,omitempty"`
+	PSKModes     []int `json:"pskModes,omitempty" yaml:"pskModes,omitempty"`
+
+	// compress_certificate: "zlib", "brotli" or "zstd"
+	Algorithms []TLSSpecValue `json:"algorithms,omitempty" yaml:"algorithms,omitempty"`
+
+	// record_size_limit
+	Limit uint16 `json:"limit,omitempty" yaml:"limit,omitempty"`
+
+	// encrypted_client_hello (GREASE ECH)
+	PayloadLengths  []uint16                `json:"payloadLengths,omitempty" yaml:"payloadLengths,omitempty"`
+	ECHCipherSuites []TLSSpecECHCipherSuite `json:"echCipherSuites,omitempty" yaml:"echCipherSuites,omitempty"`
+
+	// padding: "boringssl" (default), "fixed" (Length bytes of padding) or
+	// "padTo" (pad the ClientHello to Length bytes)
+	Style  string `json:"style,omitempty" yaml:"style,omitempty"`
+	Length int    `json:"length,omitempty" yaml:"length,omitempty"`
+}
+
+// TLSSpecECHCipherSuite is an HPKE KDF/AEAD pair offered in a GREASE ECH extension
+type TLSSpecECHCipherSuite struct {
+	KDF  uint16 `json:"kdf" yaml:"kdf"`
+	AEAD uint16 `json:"aead" yaml:"aead"`
+}
+
+// TLSSpecValue is a number or a name. It unmarshals from JSON numbers and
+// strings alike and marshals back to a number unless it holds a name.
+type TLSSpecValue string
+
+func (v *TLSSpecValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = TLSSpecValue(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("tls spec value must be a number or string: %s", b)
+	}
+	*v = TLSSpecValue(n.String())
+	return nil
+}
+
+func (v TLSSpecValue) MarshalJSON() ([]byte, error) {
+	if _, err := strconv.ParseUint(string(v), 10, 16); err == nil {
+		return []byte(v), nil
+	}
+	return json.Marshal(string(v))
+}
+
+func (v *TLSSpecValue) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("tls spec value must be a scalar at line %d", node.Line)
+	}
+	*v = TLSSpecValue(node.Value)
+	return nil
+}
+
+// ParseTLSSpec parses a TLSSpec from JSON or YAML
+func ParseTLSSpec(data []byte) (*TLSSpec, error) {
+	var spec TLSSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		if yamlErr := yaml.Unmarshal(data, &spec); yamlErr != nil {
+			return nil, fmt.Errorf("invalid tls spec: %w", yamlErr)
+		}
+	}
+	return &spec, nil
+}
+
+// TLS extension names, following the IANA registry
+var tlsExtensionNames = map[uint16]string{
+	0:     "server_name",
+	5:     "status_request",
+	10:    "supported_groups",
+	11:    "ec_point_formats",
+	13:    "signature_algorithms",
+	16:    "application_layer_protocol_negotiation",
+	17:    "status_request_v2",
+	18:    "signed_certificate_timestamp",
+	21:    "padding",
+	22:    "encrypt_then_mac",
+	23:    "extended_master_secret",
+	24:    "token_binding",
+	27:    "compress_certificate",
+	28:    "record_size_limit",
+	34:    "delegated_credentials",
+	35:    "session_ticket",
+	41:    "pre_shared_key",
+	43:    "supported_versions",
+	44:    "cookie",
+	45:    "psk_key_exchange_modes",
+	49:    "post_handshake_auth",
+	50:    "signature_algorithms_cert",
+	51:    "key_share",
+	57:    "quic_transport_parameters",
+	13172: "next_protocol_negotiation",
+	17513: "application_settings",
+	17613: "application_settings",
+	30032: "channel_id",
+	65037: "encrypted_client_hello",
+	65281: "renegotiation_info",
+}
+
+var tlsExtensionIDs = func() map[string]uint16 {
+	ids := map[string]uint16{"alpn": 16, "sni": 0, "alps": 17613}
+	for id, name := range tlsExtensionNames {
+		if _, ok := ids[name]; !ok || id == 17613 {
+			ids[name] = id
+		}
+	}
+	return ids
+}()
+
+var tlsGroupNames = map[string]utls.CurveID{
+	"P-256":                 utls.CurveP256,
+	"secp256r1":             utls.CurveP256,
+	"P-384":                 utls.CurveP384,
+	"secp384r1":             utls.CurveP384,
+	"P-521":                 utls.CurveP521,
+	"secp521r1":             utls.CurveP521,
+	"X25519":                utls.X25519,
+	"x25519":                utls.X25519,
+	"X448":                  utls.CurveID(30),
+	"ffdhe2048":             utls.CurveID(256),
+	"ffdhe3072":             utls.CurveID(257),
+	"ffdhe4096":             utls.CurveID(258),
+	"ffdhe6144":             utls.CurveID(259),
+	"ffdhe8192":             utls.CurveID(260),
+	"SecP256r1MLKEM768":     curveSecP256r1MLKEM768,
+	"X25519MLKEM768":        utls.X25519MLKEM768,
+	"SecP384r1MLKEM1024":    curveSecP384r1MLKEM1024,
+	"X25519Kyber768Draft00": utls.X25519Kyber768Draft00,
+}
+
+var tlsVersionNames = map[string]uint16{
+	"1.0": utls.VersionTLS10,
+	"1.1": utls.VersionTLS11,
+	"1.2": utls.VersionTLS12,
+	"1.3": utls.VersionTLS13,
+}
+
+var certCompressionNames = map[string]utls.CertCompressionAlgo{
+	"zlib":   utls.CertCompressionZlib,
+	"brotli": utls.CertCompressionBrotli,
+	"zstd":   utls.CertCompressionZstd,
+}
+
+var tlsCipherSuiteNames = func() map[string]uint16 {
+	names := make(map[string]uint16)
+	for _, suite := range append(utls.CipherSuites(), utls.InsecureCipherSuites()...) {
+		names[suite.Name] = suite.ID
+	}
+	return names
+}()
+
+// reverseNames picks one name per value, preferring the first in sorted order
+// among names that pass prefer
+func reverseNames[T comparable](names map[string]T, prefer func(string) bool) map[T]string {
+	keys := make([]string, 0, len(names))
+	for name := range names {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	reverse := make(map[T]string)
+	for _, name := range keys {
+		if existing, ok := reverse[names[name]]; !ok || (!prefer(existing) && prefer(name)) {
+			reverse[names[name]] = name
+		}
+	}
+	return reverse
+}
+
+var (
+	tlsGroupIDNames = reverseNames(tlsGroupNames, func(name string) bool {
+		return !strings.HasPrefix(name, "secp") && name != "x25519"
+	})
+	tlsVersionIDNames         = reverseNames(tlsVersionNames, func(string) bool { return true })
+	certCompressionIDNames    = reverseNames(certCompressionNames, func(string) bool { return true })
+	tlsCipherSuiteIDNames     = reverseNames(tlsCipherSuiteNames, func(string) bool { return true })
+	signatureAlgorithmIDNames = reverseNames(supportedSignatureAlgorithmsExtensions, func(name string) bool {
+		return strings.ToLower(name) == name
+	})
+)
+
+// resolve turns a TLSSpecValue into its numeric value using the given names
+func resolve[T ~uint16](v TLSSpecValue, names map[string]T, kind string) (T, error) {
+	s := strings.TrimSpace(string(v))
+	if strings.EqualFold(s, "GREASE") {
+		return T(utls.GREASE_PLACEHOLDER), nil
+	}
+	if id, ok := names[s]; ok {
+		return id, nil
+	}
+	base := 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s, base = s[2:], 16
+	}
+	n, err := strconv.ParseUint(s, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown %s %q", kind, string(v))
+	}
+	return T(n), nil
+}
+
+func resolveAll[T ~uint16](values []TLSSpecValue, names map[string]T, kind string) ([]T, error) {
+	out := make([]T, 0, len(values))
+	for _, v := range values {
+		id, err := resolve(v, names, kind)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// specName is the inverse of resolve
+func specName[T ~uint16](id T, names map[T]string) TLSSpecValue {
+	if isGREASE(uint16(id)) {
+		return "GREASE"
+	}
+	if n, ok := names[id]; ok {
+		return TLSSpecValue(n)
+	}
+	return TLSSpecValue(strconv.Itoa(int(id)))
+}
+
+func specNames[T ~uint16](ids []T, names map[T]string) []TLSSpecValue {
+	out := make([]TLSSpecValue, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, specName(id, names))
+	}
+	return out
+}
+
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func toBytes(values []int, kind string) ([]uint8, error) {
+	out := make([]uint8, 0, len(values))
+	for _, v := range values {
+		if v < 0 || v > 0xff {
+			return nil, fmt.Errorf("%s %d out of range", kind, v)
+		}
+		out = append(out, uint8(v))
+	}
+	return out, nil
+}
+
+func toInts(values []uint8) []int {
+	out := make([]int, 0, len(values))
+	for _, v := range values {
+		out = append(out, int(v))
+	}
+	return out
+}
+
+// ToClientHelloSpec builds a fresh utls.ClientHelloSpec. The result is
+// mutated by ApplyPreset, so call this once per connection.
+func (s *TLSSpec) ToClientHelloSpec() (*utls.ClientHelloSpec, error) {
+	suites, err := resolveAll(s.CipherSuites, tlsCipherSuiteNames, "cipher suite")
+	if err != nil {
+		return nil, err
+	}
+	compression, err := toBytes(s.CompressionMethods, "compression method")
+	if err != nil {
+		return nil, err
+	}
+	if len(compression) == 0 {
+		compression = []uint8{0}
+	}
+
+	spec := &utls.ClientHelloSpec{
+		CipherSuites:       suites,
+		CompressionMethods: compression,
+		GetSessionID:       sha256.Sum256,
+	}
+	for i := range s.Extensions {
+		ext, err := s.Extensions[i].toExtension()
+		if err != nil {
+			return nil, fmt.Errorf("extension %d: %w", i, err)
+		}
+		spec.Extensions = append(spec.Extensions, ext)
+	}
+
+	// Versions default to the range offered in supported_versions
+	for _, ext := range spec.Extensions {
+		if versions, ok := ext.(*utls.SupportedVersionsExtension); ok {
+			for _, v := range versions.Versions {
+				if isGREASE(v) {
+					continue
+				}
+				if spec.TLSVersMin == 0 || v < spec.TLSVersMin {
+					spec.TLSVersMin = v
+				}
+				if v > spec.TLSVersMax {
+					spec.TLSVersMax = v
+				}
+			}
+		}
+	}
+	if s.TLSVersionMin != "" {
+		if spec.TLSVersMin, err = resolve(s.TLSVersionMin, tlsVersionNames, "TLS version"); err != nil {
+			return nil, err
+		}
+	}
+	if s.TLSVersionMax != "" {
+		if spec.TLSVersMax, err = resolve(s.TLSVersionMax, tlsVersionNames, "TLS version"); err != nil {
+			return nil, err
+		}
+	}
+	if spec.TLSVersMax == 0 {
+		spec.TLSVersMin, spec.TLSVersMax = utls.VersionTLS12, utls.VersionTLS12
+	}
+	return spec, nil
+}
+
+func (e *TLSSpecExtension) toExtension() (utls.TLSExtension, error) {
+	data, err := hex.DecodeString(e.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data hex: %w", err)
+	}
+
+	id := e.ID
+	if e.Name != "" {
+		if strings.EqualFold(e.Name, "GREASE") {
+			return &utls.UtlsGREASEExtension{Value: utls.GREASE_PLACEHOLDER, Body: data}, nil
+		}
+		known, ok := tlsExtensionIDs[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown extension %q, use id and data instead", e.Name)
+		}
+		id = known
+	}
+	if isGREASE(id) {
+		return &utls.UtlsGREASEExtension{Value: id, Body: data}, nil
+	}
+	if e.Name == "" && e.Data != "" {
+		return &utls.GenericExtension{Id: id, Data: data}, nil
+	}
+
+	switch id {
+	case 0:
+		return &utls.SNIExtension{}, nil
+	case 5:
+		return &utls.StatusRequestExtension{}, nil
+	case 10:
+		groups, err := resolveAll(e.Groups, tlsGroupNames, "group")
+		if err != nil {
+			return nil, err
+		}
+		return &utls.SupportedCurvesExtension{Curves: groups}, nil
+	case 11:
+		formats, err := toBytes(e.PointFormats, "point format")
+		if err != nil {
+			return nil, err
+		}
+		if e.PointFormats == nil {
+			formats = []uint8{0}
+		}
+		return &utls.SupportedPointsExtension{SupportedPoints: formats}, nil
+	case 13, 50, 34:
+		algorithms, err := resolveAll(e.SignatureAlgorithms, supportedSignatureAlgorithmsExtensions, "signature algorithm")
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case 13:
+			return &utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: algorithms}, nil
+		case 50:
+			return &utls.SignatureAlgorithmsCertExtension{SupportedSignatureAlgorithms: algorithms}, nil
+		}
+		return &utls.DelegatedCredentialsExtension{SupportedSignatureAlgorithms: algorithms}, nil
+	case 16:
+		return &utls.ALPNExtension{AlpnProtocols: e.Protocols}, nil
+	case 18:
+		return &utls.SCTExtension{}, nil
+	case 21:
+		return e.paddingExtension()
+	case 23:
+		return &utls.ExtendedMasterSecretExtension{}, nil
+	case 24:
+		return &utls.FakeTokenBindingExtension{}, nil
+	case 27:
+		algorithms, err := resolveAll(e.Algorithms, certCompressionNames, "certificate compression algorithm")
+		if err != nil {
+			return nil, err
+		}
+		return &utls.UtlsCompressCertExtension{Algorithms: algorithms}, nil
+	case 28:
+		limit := e.Limit
+		if limit == 0 {
+			limit = 0x4001
+		}
+		return &utls.FakeRecordSizeLimitExtension{Limit: limit}, nil
+	case 35:
+		return &utls.SessionTicketExtension{}, nil
+	case 41:
+		return &utls.UtlsPreSharedKeyExtension{}, nil
+	case 43:
+		versions, err := resolveAll(e.Versions, tlsVersionNames, "TLS version")
+		if err != nil {
+			return nil, err
+		}
+		return &utls.SupportedVersionsExtension{Versions: versions}, nil
+	case 44:
+		return &utls.CookieExtension{}, nil
+	case 45:
+		modes, err := toBytes(e.PSKModes, "psk mode")
+		if err != nil {
+			return nil, err
+		}
+		if e.PSKModes == nil {
+			modes = []uint8{utls.PskModeDHE}
+		}
+		return &utls.PSKKeyExchangeModesExtension{Modes: modes}, nil
+	case 51:
+		groups, err := resolveAll(e.KeyShares, tlsGroupNames, "key share group")
+		if err != nil {
+			return nil, err
+		}
+		shares := make([]utls.KeyShare, 0, len(groups))
+		for _, group := range groups {
+			share := utls.KeyShare{Group: group, Data: hybridKeyShareData(group)}
+			if isGREASE(uint16(group)) {
+				share.Data = []byte{0}
+			}
+			shares = append(shares, share)
+		}
+		return &utls.KeyShareExtension{KeyShares: shares}, nil
+	case 57:
+		return &utls.QUICTransportParametersExtension{}, nil
+	case 13172:
+		return &utls.NPNExtension{NextProtos: e.Protocols}, nil
+	case 17513, 17613:
+		codepoint := e.Codepoint
+		if codepoint == 0 {
+			codepoint = id
+		}
+		switch codepoint {
+		case 17513:
+			return &utls.ApplicationSettingsExtension{SupportedProtocols: e.Protocols}, nil
+		case 17613:
+			return &utls.ApplicationSettingsExtensionNew{SupportedProtocols: e.Protocols}, nil
+		}
+		return nil, fmt.Errorf("unsupported ALPS codepoint %d", codepoint)
+	case 30032:
+		return &utls.FakeChannelIDExtension{}, nil
+	case 65037:
+		ech := utls.BoringGREASEECH()
+		if len(e.PayloadLengths) > 0 {
+			ech.CandidatePayloadLens = e.PayloadLengths
+		}
+		if len(e.ECHCipherSuites) > 0 {
+			ech.CandidateCipherSuites = nil
+			for _, suite := range e.ECHCipherSuites {
+				ech.CandidateCipherSuites = append(ech.CandidateCipherSuites, utls.HPKESymmetricCipherSuite{KdfId: suite.KDF, AeadId: suite.AEAD})
+			}
+		}
+		return ech, nil
+	case 65281:
+		return &utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient}, nil
+	}
+	// Extensions without parameters (encrypt_then_mac, post_handshake_auth, ...)
+	return &utls.GenericExtension{Id: id, Data: data}, nil
+}
+
+func (e *TLSSpecExtension) paddingExtension() (utls.TLSExtension, error) {
+	switch e.Style {
+	case "", "boringssl":
+		return &utls.UtlsPaddingExtension{GetPaddingLen: utls.BoringPaddingStyle}, nil
+	case "fixed":
+		return &utls.UtlsPaddingExtension{PaddingLen: e.Length, WillPad: true}, nil
+	case "padTo":
+		return &utls.UtlsPaddingExtension{GetPaddingLen: utls.AlwaysPadToLen(e.Length)}, nil
+	}
+	return nil, fmt.Errorf("unknown padding style %q", e.Style)
+}
+
+// NewTLSSpec describes an existing utls.ClientHelloSpec as a TLSSpec, for
+// example one parsed from a captured ClientHello by SpecFromClientHelloBytes.
+// Extensions without a dedicated representation are kept as id and data.
+func NewTLSSpec(spec *utls.ClientHelloSpec) (*TLSSpec, error) {
+	s := &TLSSpec{
+		TLSVersionMin:      specName(spec.TLSVersMin, tlsVersionIDNames),
+		TLSVersionMax:      specName(spec.TLSVersMax, tlsVersionIDNames),
+		CipherSuites:       specNames(spec.CipherSuites, tlsCipherSuiteIDNames),
+		CompressionMethods: toInts(spec.CompressionMethods),
+	}
+	for _, ext := range spec.Extensions {
+		e, err := newTLSSpecExtension(ext)
+		if err != nil {
+			return nil, err
+		}
+		s.Extensions = append(s.Extensions, e)
+	}
+	return s, nil
+}
+
+func newTLSSpecExtension(ext utls.TLSExtension) (TLSSpecExtension, error) {
+	named := func(id uint16) TLSSpecExtension {
+		return TLSSpecExtension{Name: tlsExtensionNames[id]}
+	}
+
+	switch x := ext.(type) {
+	case *utls.UtlsGREASEExtension:
+		return TLSSpecExtension{Name: "GREASE", Data: hex.EncodeToString(x.Body)}, nil
+	case *utls.SNIExtension:
+		return named(0), nil
+	case *utls.StatusRequestExtension:
+		return named(5), nil
+	case *utls.SupportedCurvesExtension:
+		e := named(10)
+		e.Groups = specNames(x.Curves, tlsGroupIDNames)
+		return e, nil
+	case *utls.SupportedPointsExtension:
+		e := named(11)
+		e.PointFormats = toInts(x.SupportedPoints)
+		return e, nil
+	case *utls.SignatureAlgorithmsExtension:
+		e := named(13)
+		e.SignatureAlgorithms = specNames(x.SupportedSignatureAlgorithms, signatureAlgorithmIDNames)
+		return e, nil
+	case *utls.SignatureAlgorithmsCertExtension:
+		e := named(50)
+		e.SignatureAlgorithms = specNames(x.SupportedSignatureAlgorithms, signatureAlgorithmIDNames)
+		return e, nil
+	case *utls.DelegatedCredentialsExtension:
+		e := named(34)
+		e.SignatureAlgorithms = specNames(x.SupportedSignatureAlgorithms, signatureAlgorithmIDNames)
+		return e, nil
+	case *utls.ALPNExtension:
+		e := named(16)
+		e.Protocols = x.AlpnProtocols
+		return e, nil
+	case *utls.SCTExtension:
+		return named(18), nil
+	case *utls.UtlsPaddingExtension:
+		return newPaddingSpecExtension(x), nil
+	case *utls.ExtendedMasterSecretExtension:
+		return named(23), nil
+	case *utls.UtlsCompressCertExtension:
+		e := named(27)
+		e.Algorithms = specNames(x.Algorithms, certCompressionIDNames)
+		return e, nil
+	case *utls.FakeRecordSizeLimitExtension:
+		e := named(28)
+		e.Limit = x.Limit
+		return e, nil
+	case *utls.SessionTicketExtension:
+		return named(35), nil
+	case *utls.UtlsPreSharedKeyExtension:
+		return named(41), nil
+	case *utls.SupportedVersionsExtension:
+		e := named(43)
+		e.Versions = specNames(x.Versions, tlsVersionIDNames)
+		return e, nil
+	case *utls.PSKKeyExchangeModesExtension:
+		e := named(45)
+		e.PSKModes = toInts(x.Modes)
+		return e, nil
+	case *utls.KeyShareExtension:
+		e := named(51)
+		for _, share := range x.KeyShares {
+			e.KeyShares = append(e.KeyShares, specName(share.Group, tlsGroupIDNames))
+		}
+		return e, nil
+	case *utls.ApplicationSettingsExtension:
+		e := named(17513)
+		e.Codepoint, e.Protocols = 17513, x.SupportedProtocols
+		return e, nil
+	case *utls.ApplicationSettingsExtensionNew:
+		e := named(17613)
+		e.Codepoint, e.Protocols = 17613, x.SupportedProtocols
+		return e, nil
+	case *utls.NPNExtension:
+		e := named(13172)
+		e.Protocols = x.NextProtos
+		return e, nil
+	case *utls.GREASEEncryptedClientHelloExtension:
+		e := named(65037)
+		e.PayloadLengths = x.CandidatePayloadLens
+		for _, suite := range x.CandidateCipherSuites {
+			e.ECHCipherSuites = append(e.ECHCipherSuites, TLSSpecECHCipherSuite{KDF: suite.KdfId, AEAD: suite.AeadId})
+		}
+		return e, nil
+	case *utls.RenegotiationInfoExtension:
+		return named(65281), nil
+	}
+
+	// Anything else is carried as raw bytes
+	raw, err := readExtension(ext)
+	if err != nil {
+		return TLSSpecExtension{}, err
+	}
+	if len(raw) < 4 {
+		return TLSSpecExtension{}, fmt.Errorf("cannot describe extension %T", ext)
+	}
+	id := uint16(raw[0])<<8 | uint16(raw[1])
+	return TLSSpecExtension{ID: id, Data: hex.EncodeToString(raw[4:])}, nil
+}
+
+func newPaddingSpecExtension(x *utls.UtlsPaddingExtension) TLSSpecExtension {
+	e := TLSSpecExtension{Name: tlsExtensionNames[21]}
+	switch {
+	case x.GetPaddingLen == nil:
+		e.Style, e.Length = "fixed", x.PaddingLen
+	case reflect.ValueOf(x.GetPaddingLen).Pointer() == reflect.ValueOf(utls.BoringPaddingStyle).Pointer():
+		e.Style = "boringssl"
+	default:
+		// AlwaysPadToLen(n) pads an empty ClientHello with n-4 bytes
+		if padding, ok := x.GetPaddingLen(0); ok {
+			e.Style, e.Length = "padTo", padding+4
+		} else {
+			e.Style = "boringssl"
+		}
+	}
+	return e
+}
+
+// readExtension returns the wire encoding of ext, header included
+func readExtension(ext utls.TLSExtension) ([]byte, error) {
+	buf := make([]byte, ext.Len())
+	n, err := ext.Read(buf)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
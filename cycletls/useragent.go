@@ -0,0 +1,239 @@
+package cycletls
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	http2 "github.com/Danny-Dasilva/fhttp/http2"
+)
+
+// UserAgent is what CycleTLS reads from a User-Agent string
+type UserAgent struct {
+	UserAgent   string   // Browser family: chrome, firefox, safari, ios or webview
+	HeaderOrder []string // Pseudo-header order for HTTP/2 and HTTP/3
+
+	// Chromium based browsers, the ones that send client hints
+	Chromium        bool
+	ChromiumVersion string // Full Chromium version, e.g. "131.0.0.0"
+	Brand           string // e.g. "Google Chrome", "Microsoft Edge" or "Safari"
+	BrandVersion    string // Full version of the brand
+
+	// Device, as the sec-ch-ua-* client hints describe it
+	Platform        string // e.g. "Windows", "macOS" or "Android"
+	PlatformVersion string
+	Mobile          bool
+	Model           string
+	Arch            string // "x86", "arm" or empty when unknown
+	Bitness         string // "64", "32" or empty when unknown
+	WOW64           bool
+
+	known bool // The family was recognized rather than defaulted to chrome
+}
+
+var (
+	chromiumToken = regexp.MustCompile(`Chrome/(\d[\d.]*)`)
+	safariToken   = regexp.MustCompile(`Version/(\d[\d.]*).* Safari/`)
+
+	// Chromium based browsers with their own brand, checked in order
+	chromiumBrands = []struct {
+		brand string
+		token *regexp.Regexp
+	}{
+		{"Microsoft Edge", regexp.MustCompile(`Edg(?:A|iOS)?/(\d[\d.]*)`)},
+		{"Opera", regexp.MustCompile(`OPR/(\d[\d.]*)`)},
+		{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d[\d.]*)`)},
+		{"YaBrowser", regexp.MustCompile(`YaBrowser/(\d[\d.]*)`)},
+	}
+
+	windowsToken  = regexp.MustCompile(`Windows NT (\d+\.\d+)`)
+	androidToken  = regexp.MustCompile(`Android (\d[\d.]*)(?:; ([^;)]+?))?(?: Build/[^;)]*)?\)`)
+	chromeOSToken = regexp.MustCompile(`CrOS \S+ (\d[\d.]*)`)
+	macOSToken    = regexp.MustCompile(`Mac OS X (\d+[_.\d]*)`)
+	iOSToken      = regexp.MustCompile(`OS (\d+[_\d]*) like Mac OS X`)
+)
+
+// windowsPlatformVersions maps Windows NT versions to the platform version
+// Chromium reports, which is the UAP version from Windows 10 on
+var windowsPlatformVersions = map[string]string{
+	"6.1":  "0.1.0",
+	"6.2":  "0.2.0",
+	"6.3":  "0.3.0",
+	"10.0": "10.0.0",
+}
+
+// parseUserAgent returns the browser family, pseudo-header order and, for
+// Chromium, the brand and device details the client hints are made of.
+// User agents of no known family are treated as Chrome.
+func parseUserAgent(userAgent string) UserAgent {
+	ua := UserAgent{known: true}
+	switch lower := strings.ToLower(userAgent); {
+	case strings.Contains(lower, "iphone"), strings.Contains(lower, "ipad"), strings.Contains(lower, "ipod"):
+		ua.UserAgent = iosSafari
+	case strings.Contains(lower, "chrome") && strings.Contains(lower, "; wv)"):
+		ua.UserAgent = androidWebView
+	case strings.Contains(lower, "chrome"):
+		ua.UserAgent = chrome
+	case strings.Contains(lower, "firefox"):
+		ua.UserAgent = firefox
+	case safariToken.MatchString(userAgent):
+		ua.UserAgent = safari
+	default:
+		ua.UserAgent, ua.known = chrome, false
+	}
+	switch ua.UserAgent {
+	case firefox:
+		ua.HeaderOrder = []string{":method", ":path", ":authority", ":scheme"}
+	case safari, iosSafari:
+		ua.HeaderOrder = []string{":method", ":scheme", ":path", ":authority"}
+	default:
+		ua.HeaderOrder = []string{":method", ":authority", ":scheme", ":path"}
+	}
+	if m := safariToken.FindStringSubmatch(userAgent); m != nil && (ua.UserAgent == safari || ua.UserAgent == iosSafari) {
+		ua.Brand, ua.BrandVersion = "Safari", m[1]
+	}
+
+	if m := chromiumToken.FindStringSubmatch(userAgent); m != nil {
+		ua.Chromium = true
+		ua.ChromiumVersion = m[1]
+		ua.Brand, ua.BrandVersion = "Google Chrome", m[1]
+		for _, b := range chromiumBrands {
+			if m := b.token.FindStringSubmatch(userAgent); m != nil {
+				ua.Brand, ua.BrandVersion = b.brand, m[1]
+				break
+			}
+		}
+		if ua.Brand == "Google Chrome" && ua.UserAgent == androidWebView {
+			ua.Brand = "Android WebView"
+		}
+	}
+
+	ua.Mobile = strings.Contains(userAgent, "Mobile")
+	ua.WOW64 = strings.Contains(userAgent, "WOW64")
+	switch {
+	case strings.Contains(userAgent, "Windows"):
+		ua.Platform = "Windows"
+		if m := windowsToken.FindStringSubmatch(userAgent); m != nil {
+			ua.PlatformVersion = windowsPlatformVersions[m[1]]
+		}
+		switch {
+		case strings.Contains(userAgent, "ARM64"):
+			ua.Arch, ua.Bitness = "arm", "64"
+		case strings.Contains(userAgent, "Win64"), strings.Contains(userAgent, "x64"), ua.WOW64:
+			ua.Arch, ua.Bitness = "x86", "64"
+		default:
+			ua.Arch, ua.Bitness = "x86", "32"
+		}
+	case strings.Contains(userAgent, "Android"):
+		ua.Platform = "Android"
+		if m := androidToken.FindStringSubmatch(userAgent); m != nil {
+			ua.PlatformVersion = fullVersion(m[1])
+			// Reduced user agents replace the model with "K"
+			if model := strings.TrimSpace(m[2]); model != "K" {
+				ua.Model = model
+			}
+		}
+	case strings.Contains(userAgent, "CrOS"):
+		ua.Platform = "Chrome OS"
+		if m := chromeOSToken.FindStringSubmatch(userAgent); m != nil {
+			ua.PlatformVersion = fullVersion(m[1])
+		}
+		ua.Arch, ua.Bitness = linuxArch(userAgent)
+	case ua.UserAgent == iosSafari:
+		ua.Platform = "iOS"
+		if m := iOSToken.FindStringSubmatch(userAgent); m != nil {
+			ua.PlatformVersion = fullVersion(strings.ReplaceAll(m[1], "_", "."))
+		}
+		ua.Mobile = !strings.Contains(userAgent, "iPad")
+	case strings.Contains(userAgent, "Macintosh"):
+		ua.Platform = "macOS"
+		if m := macOSToken.FindStringSubmatch(userAgent); m != nil {
+			ua.PlatformVersion = fullVersion(strings.ReplaceAll(m[1], "_", "."))
+		}
+		ua.Arch, ua.Bitness = "x86", "64"
+	case strings.Contains(userAgent, "Linux"):
+		ua.Platform = "Linux"
+		ua.Arch, ua.Bitness = linuxArch(userAgent)
+	default:
+		ua.Platform = "Unknown"
+	}
+	return ua
+}
+
+// minSafariGreaseVersion is the first Safari and iOS release whose
+// ClientHello carries GREASE
+const minSafariGreaseVersion = 14
+
+// greases reports whether ua's browser sends GREASE values in its ClientHello
+func (ua UserAgent) greases() bool {
+	switch ua.UserAgent {
+	case firefox:
+		return false
+	case safari:
+		return ua.BrandVersion == "" || majorVersion(ua.BrandVersion) >= minSafariGreaseVersion
+	case iosSafari:
+		return ua.PlatformVersion == "" || majorVersion(ua.PlatformVersion) >= minSafariGreaseVersion
+	}
+	return true
+}
+
+// navigator returns the fhttp HTTP/2 preset closest to ua's browser, which
+// fills in what an HTTP/2 fingerprint leaves out
+func (ua UserAgent) navigator() string {
+	if ua.UserAgent == firefox {
+		return http2.Firefox
+	}
+	return http2.Chrome
+}
+
+// http2Fingerprint returns the HTTP/2 fingerprint ua's browser sends when
+// fhttp has no preset for it, empty otherwise
+func (ua UserAgent) http2Fingerprint() string {
+	switch ua.UserAgent {
+	case safari:
+		return safariHTTP2Fingerprint
+	case iosSafari:
+		return iosHTTP2Fingerprint
+	}
+	return ""
+}
+
+// http3Settings returns the HTTP/3 SETTINGS ua's browser sends
+func (ua UserAgent) http3Settings() string {
+	switch ua.UserAgent {
+	case safari, iosSafari:
+		return safariHTTP3Settings
+	}
+	return chromeHTTP3Settings
+}
+
+// linuxArch returns the architecture and bitness of a Linux user agent
+func linuxArch(userAgent string) (arch, bitness string) {
+	switch {
+	case strings.Contains(userAgent, "x86_64"):
+		return "x86", "64"
+	case strings.Contains(userAgent, "aarch64"):
+		return "arm", "64"
+	case strings.Contains(userAgent, "i686"):
+		return "x86", "32"
+	case strings.Contains(userAgent, "armv"):
+		return "arm", "32"
+	}
+	return "", ""
+}
+
+// fullVersion pads a version to at least three components, like "10.0.0"
+func fullVersion(version string) string {
+	parts := strings.Split(strings.Trim(version, "."), ".")
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	return strings.Join(parts, ".")
+}
+
+// majorVersion returns the first component of a version, 0 if there is none
+func majorVersion(version string) int {
+	major, _, _ := strings.Cut(version, ".")
+	n, _ := strconv.Atoi(major)
+	return n
+}
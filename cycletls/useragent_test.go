@@ -0,0 +1,171 @@
+package cycletls
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseUserAgent_ClientHints(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		hints     map[string]string
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36 Edg/131.0.2903.86",
+			map[string]string{
+				"sec-ch-ua":                   `"Microsoft Edge";v="131", "Chromium";v="131", "Not_A Brand";v="24"`,
+				"sec-ch-ua-full-version-list": `"Microsoft Edge";v="131.0.2903.86", "Chromium";v="131.0.0.0", "Not_A Brand";v="24.0.0.0"`,
+				"sec-ch-ua-full-version":      `"131.0.2903.86"`,
+				"sec-ch-ua-platform":          `"Windows"`,
+				"sec-ch-ua-platform-version":  `"10.0.0"`,
+				"sec-ch-ua-arch":              `"x86"`,
+				"sec-ch-ua-bitness":           `"64"`,
+				"sec-ch-ua-mobile":            "?0",
+				"sec-ch-ua-wow64":             "?0",
+			},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			map[string]string{
+				"sec-ch-ua":                  `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`,
+				"sec-ch-ua-platform":         `"Android"`,
+				"sec-ch-ua-platform-version": `"13.0.0"`,
+				"sec-ch-ua-model":            `"Pixel 7"`,
+				"sec-ch-ua-arch":             `""`,
+				"sec-ch-ua-mobile":           "?1",
+			},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36",
+			map[string]string{
+				"sec-ch-ua":                  `"Not A(Brand";v="99", "Google Chrome";v="121", "Chromium";v="121"`,
+				"sec-ch-ua-platform":         `"macOS"`,
+				"sec-ch-ua-platform-version": `"10.15.7"`,
+				"sec-ch-ua-model":            `""`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		ua := parseUserAgent(tt.userAgent)
+		if !ua.sendsClientHints() {
+			t.Errorf("%s: expected client hints", tt.userAgent)
+		}
+		for name, want := range tt.hints {
+			if got, _ := ua.clientHint(name); got != want {
+				t.Errorf("%s: %s = %s, want %s", tt.userAgent, name, got, want)
+			}
+		}
+	}
+
+	for _, userAgent := range []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:133.0) Gecko/20100101 Firefox/133.0",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.106 Safari/537.36",
+	} {
+		if parseUserAgent(userAgent).sendsClientHints() {
+			t.Errorf("%s: expected no client hints", userAgent)
+		}
+	}
+}
+
+func TestClientHintCache(t *testing.T) {
+	c := newClientHintCache()
+	c.update("a", "https://example.com", []string{"Sec-CH-UA-Arch, sec-ch-ua-model", "Sec-CH-UA-Bitness"})
+	if got := c.lookup("a", "https://example.com"); len(got) != 3 || got[0] != "sec-ch-ua-arch" {
+		t.Fatalf("unexpected hints %v", got)
+	}
+	if got := c.lookup("b", "https://example.com"); got != nil {
+		t.Fatalf("expected sessions to be separate, got %v", got)
+	}
+
+	// No Accept-CH keeps the hints, an empty one forgets them
+	c.update("a", "https://example.com", nil)
+	if len(c.lookup("a", "https://example.com")) != 3 {
+		t.Fatal("expected a response without Accept-CH to keep the hints")
+	}
+	c.update("a", "https://example.com", []string{""})
+	if got := c.lookup("a", "https://example.com"); got != nil {
+		t.Fatalf("expected an empty Accept-CH to forget the hints, got %v", got)
+	}
+}
+
+func TestParseUserAgent_Families(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		family    string
+		order     string
+		greases   bool
+		platform  string
+	}{
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15", safari, ":method,:scheme,:path,:authority", true, "macOS"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.2 Safari/605.1.15", safari, ":method,:scheme,:path,:authority", false, "macOS"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", iosSafari, ":method,:scheme,:path,:authority", true, "iOS"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 13_7 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148", iosSafari, ":method,:scheme,:path,:authority", false, "iOS"},
+		// Chrome on iOS is WebKit underneath
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/123.0.6312.52 Mobile/15E148 Safari/604.1", iosSafari, ":method,:scheme,:path,:authority", true, "iOS"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/123.0.6312.40 Mobile Safari/537.36", androidWebView, ":method,:authority,:scheme,:path", true, "Android"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:133.0) Gecko/20100101 Firefox/133.0", firefox, ":method,:path,:authority,:scheme", false, "Windows"},
+		{"curl/8.4.0", chrome, ":method,:authority,:scheme,:path", true, "Unknown"},
+	}
+	for _, tt := range tests {
+		ua := parseUserAgent(tt.userAgent)
+		if ua.UserAgent != tt.family {
+			t.Errorf("%s: family = %s, want %s", tt.userAgent, ua.UserAgent, tt.family)
+		}
+		if got := strings.Join(ua.HeaderOrder, ","); got != tt.order {
+			t.Errorf("%s: pseudo-header order = %s, want %s", tt.userAgent, got, tt.order)
+		}
+		if ua.greases() != tt.greases {
+			t.Errorf("%s: greases = %v, want %v", tt.userAgent, ua.greases(), tt.greases)
+		}
+		if ua.Platform != tt.platform {
+			t.Errorf("%s: platform = %s, want %s", tt.userAgent, ua.Platform, tt.platform)
+		}
+	}
+
+	ios := parseUserAgent("Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1")
+	if ios.PlatformVersion != "16.6.0" || ios.Mobile {
+		t.Errorf("iPad: platform version %s, mobile %v", ios.PlatformVersion, ios.Mobile)
+	}
+	if webView := parseUserAgent(tests[5].userAgent); webView.Brand != "Android WebView" || !webView.sendsClientHints() {
+		t.Errorf("WebView: brand %s, client hints %v", webView.Brand, webView.sendsClientHints())
+	}
+}
+
+func TestNewHTTP2Transport_UserAgent(t *testing.T) {
+	settings := func(userAgent, fingerprint string) string {
+		t.Helper()
+		rt := &roundTripper{UserAgent: userAgent, HTTP2Fingerprint: fingerprint}
+		transport, err := rt.newHTTP2Transport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transport.HTTP2Settings == nil {
+			return transport.Navigator
+		}
+		var parts []string
+		for _, s := range transport.HTTP2Settings.Settings {
+			parts = append(parts, fmt.Sprintf("%d:%d", s.ID, s.Val))
+		}
+		return fmt.Sprintf("%s|%d", strings.Join(parts, ","), transport.HTTP2Settings.ConnectionFlow)
+	}
+
+	safariUA := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15"
+	iosUA := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+	firefoxUA := "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:133.0) Gecko/20100101 Firefox/133.0"
+
+	if got := settings(safariUA, ""); got != "2:0,4:4194304,3:100|10485760" {
+		t.Errorf("Safari settings = %s", got)
+	}
+	if got := settings(iosUA, ""); got != "2:0,4:2097152,3:100|10485760" {
+		t.Errorf("iOS settings = %s", got)
+	}
+	// Browsers fhttp has a preset for keep it
+	if got := settings(firefoxUA, ""); got != "firefox" {
+		t.Errorf("Firefox navigator = %s", got)
+	}
+	// An explicit fingerprint wins over the user agent's
+	if got := settings(safariUA, "1:65536,4:131072,5:16384|12517377|0|m,a,s,p"); got != "1:65536,4:131072,5:16384|12517377" {
+		t.Errorf("fingerprint settings = %s", got)
+	}
+}
@@ -2,10 +2,9 @@ package cycletls
 
 import (
 	"bytes"
-	"compress/gzip"
-	"compress/zlib"
 	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,14 +14,16 @@ import (
 	"strings"
 
 	fhttp "github.com/Danny-Dasilva/fhttp"
-	"github.com/andybalholm/brotli"
 	uquic "github.com/refraction-networking/uquic"
 	utls "github.com/refraction-networking/utls"
 )
 
 const (
-	chrome  = "chrome"  //chrome User agent enum
-	firefox = "firefox" //firefox User agent enum
+	chrome         = "chrome"  //chrome User agent enum
+	firefox        = "firefox" //firefox User agent enum
+	safari         = "safari"  //macOS Safari User agent enum
+	iosSafari      = "ios"     //iOS and iPadOS User agent enum, every iOS browser is WebKit
+	androidWebView = "webview" //Android WebView User agent enum
 )
 
 // Cipher suite mappings from hex to uTLS constants
@@ -52,95 +53,49 @@ var cipherSuiteMap = map[uint16]uint16{
 	0xc027: utls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
 }
 
-type UserAgent struct {
-	UserAgent   string
-	HeaderOrder []string
-}
-
-// ParseUserAgent returns the pseudo header order and user agent string for chrome/firefox
-func parseUserAgent(userAgent string) UserAgent {
-	switch {
-	case strings.Contains(strings.ToLower(userAgent), "chrome"):
-		return UserAgent{chrome, []string{":method", ":authority", ":scheme", ":path"}}
-	case strings.Contains(strings.ToLower(userAgent), "firefox"):
-		return UserAgent{firefox, []string{":method", ":path", ":authority", ":scheme"}}
-	default:
-		return UserAgent{chrome, []string{":method", ":authority", ":scheme", ":path"}}
-	}
-
-}
-
-// DecompressBody unzips compressed data following axios-style automatic decompression
+// DecompressBody unzips compressed data following axios-style automatic decompression.
+// Every listed encoding is undone, last applied first; on any failure the
+// original body is returned.
 func DecompressBody(Body []byte, encoding []string, content []string) (parsedBody []byte) {
 	// If no encoding specified, return original body
 	if len(encoding) == 0 {
 		return Body
 	}
 
-	// Handle multiple encodings (e.g., "gzip, deflate") - process first encoding
-	encodingType := strings.ToLower(strings.TrimSpace(encoding[0]))
-
-	switch encodingType {
-	case "gzip":
-		unz, err := gUnzipData(Body)
-		if err != nil {
-			// Return original body on decompression failure (axios behavior)
-			return Body
-		}
-		return unz
-	case "deflate":
-		unz, err := enflateData(Body)
-		if err != nil {
-			// Return original body on decompression failure (axios behavior)
-			return Body
-		}
-		return unz
-	case "br", "brotli":
-		unz, err := unBrotliData(Body)
-		if err != nil {
-			// Return original body on decompression failure (axios behavior)
-			return Body
-		}
-		return unz
-	default:
+	reader, err := NewDecodingReader(io.NopCloser(bytes.NewReader(Body)), encoding)
+	if err != nil {
 		// Unknown encoding, return original body
 		return Body
 	}
-}
+	defer reader.Close()
 
-func gUnzipData(data []byte) (resData []byte, err error) {
-	gz, err := gzip.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return []byte{}, err
-	}
-	defer gz.Close()
-	respBody, err := io.ReadAll(gz)
-	return respBody, err
-}
-func enflateData(data []byte) (resData []byte, err error) {
-	zr, err := zlib.NewReader(bytes.NewReader(data))
+	decoded, err := io.ReadAll(reader)
 	if err != nil {
-		return []byte{}, err
+		// Return original body on decompression failure (axios behavior)
+		return Body
 	}
-	defer zr.Close()
-	enflated, err := io.ReadAll(zr)
-	return enflated, err
-}
-func unBrotliData(data []byte) (resData []byte, err error) {
-	br := brotli.NewReader(bytes.NewReader(data))
-	respBody, err := io.ReadAll(br)
-	return respBody, err
+	return decoded
 }
 
 // StringToSpec creates a ClientHelloSpec based on a JA3 string
 func StringToSpec(ja3 string, userAgent string, forceHTTP1 bool) (*utls.ClientHelloSpec, error) {
+	return StringToSpecWithOptions(ja3, userAgent, forceHTTP1, SpecOptions{})
+}
+
+// StringToSpecWithOptions creates a ClientHelloSpec based on a JA3 string, applying opts
+func StringToSpecWithOptions(ja3 string, userAgent string, forceHTTP1 bool, opts SpecOptions) (*utls.ClientHelloSpec, error) {
 	parsedUserAgent := parseUserAgent(userAgent)
-	// if tlsExtensions == nil {
-	// 	tlsExtensions = &TLSExtensions{}
-	// }
-	// ext := tlsExtensions
+	useGrease, err := opts.grease(parsedUserAgent.greases())
+	if err != nil {
+		return nil, err
+	}
+	// supported_versions has always carried GREASE for JA3 specs
+	greaseVersions, _ := opts.grease(true)
 	extMap := genMap(false)
 	tokens := strings.Split(ja3, ",")
+	if len(tokens) != 5 {
+		return nil, fmt.Errorf("invalid JA3 string: expected 5 comma separated fields, got %d", len(tokens))
+	}
 
 	version := tokens[0]
 	ciphers := strings.Split(tokens[1], "-")
@@ -155,31 +110,18 @@ func StringToSpec(ja3 string, userAgent string, forceHTTP1 bool) (*utls.ClientHe
 	}
 	// parse curves
 	var targetCurves []utls.CurveID
-	// if parsedUserAgent == chrome && !tlsExtensions.UseGREASE {
-	if parsedUserAgent.UserAgent == chrome {
-		targetCurves = append(targetCurves, utls.CurveID(utls.GREASE_PLACEHOLDER)) //append grease for Chrome browsers
-		if supportedVersionsExt, ok := extMap["43"]; ok {
-			if supportedVersions, ok := supportedVersionsExt.(*utls.SupportedVersionsExtension); ok {
-				supportedVersions.Versions = append([]uint16{utls.GREASE_PLACEHOLDER}, supportedVersions.Versions...)
-			}
-		}
-		if keyShareExt, ok := extMap["51"]; ok {
-			if keyShare, ok := keyShareExt.(*utls.KeyShareExtension); ok {
-				keyShare.KeyShares = append([]utls.KeyShare{{Group: utls.CurveID(utls.GREASE_PLACEHOLDER), Data: []byte{0}}}, keyShare.KeyShares...)
-			}
-		}
-	} else {
-		if keyShareExt, ok := extMap["51"]; ok {
-			if keyShare, ok := keyShareExt.(*utls.KeyShareExtension); ok {
-				keyShare.KeyShares = append(keyShare.KeyShares, utls.KeyShare{Group: utls.CurveP256})
-			}
-		}
+	if useGrease {
+		targetCurves = append(targetCurves, utls.CurveID(utls.GREASE_PLACEHOLDER))
 	}
 	for _, c := range curves {
 		cid, err := strconv.ParseUint(c, 10, 16)
 		if err != nil {
 			return nil, err
 		}
+		if IsGREASEValue(uint16(cid)) {
+			// GREASE listed in the fingerprint is replaced by the GreaseMode decision
+			continue
+		}
 
 		// For TLS 1.3 (version 772), validate curve compatibility
 		ver, _ := strconv.ParseUint(version, 10, 16)
@@ -193,16 +135,15 @@ func StringToSpec(ja3 string, userAgent string, forceHTTP1 bool) (*utls.ClientHe
 
 	// Ensure TLS 1.3 has at least basic compatible curves if all were filtered out
 	ver, _ := strconv.ParseUint(version, 10, 16)
-	if uint16(ver) == utls.VersionTLS13 && len(targetCurves) == 0 {
+	if uint16(ver) == utls.VersionTLS13 && (len(targetCurves) == 0 || useGrease && len(targetCurves) == 1) {
 		// Add default TLS 1.3 compatible curves: X25519 and secp256r1
-		if parsedUserAgent.UserAgent == chrome {
-			targetCurves = append(targetCurves, utls.CurveID(utls.GREASE_PLACEHOLDER))
-		}
 		targetCurves = append(targetCurves, utls.CurveID(29)) // X25519
 		targetCurves = append(targetCurves, utls.CurveID(23)) // secp256r1
 	}
 
 	extMap["10"] = &utls.SupportedCurvesExtension{Curves: targetCurves}
+	// Key shares follow the offered groups, including hybrid post-quantum ones
+	extMap["51"] = &utls.KeyShareExtension{KeyShares: keySharesForGroups(targetCurves, parsedUserAgent.UserAgent == firefox)}
 
 	// parse point formats
 	var targetPointFormats []byte
@@ -223,38 +164,47 @@ func StringToSpec(ja3 string, userAgent string, forceHTTP1 bool) (*utls.ClientHe
 	}
 
 	// set extension 43
-	ver, err := strconv.ParseUint(version, 10, 16)
+	ver, err = strconv.ParseUint(version, 10, 16)
 	if err != nil {
 		return nil, err
 	}
-	tlsMaxVersion, tlsMinVersion, tlsExtension, err := createTlsVersion(uint16(ver), false)
+	tlsMaxVersion, tlsMinVersion, tlsExtension, err := createTlsVersion(uint16(ver), !greaseVersions)
 	extMap["43"] = tlsExtension
 
 	// build extenions list
 	var exts []utls.TLSExtension
-	//Optionally Add Chrome Grease Extension
-	// if parsedUserAgent == chrome && !tlsExtensions.UseGREASE {
-	if parsedUserAgent.UserAgent == chrome {
-		exts = append(exts, &utls.UtlsGREASEExtension{})
-	}
 	for _, e := range extensions {
-		te, ok := extMap[e]
-		if !ok {
+		id, err := strconv.ParseUint(e, 10, 16)
+		if err != nil {
 			return nil, raiseExtensionError(e)
 		}
-		// //Optionally add Chrome Grease Extension
-		// if e == "21" && parsedUserAgent == chrome && !tlsExtensions.UseGREASE {
-		if e == "21" && parsedUserAgent.UserAgent == chrome {
-			exts = append(exts, &utls.UtlsGREASEExtension{})
+		if IsGREASEValue(uint16(id)) {
+			continue
+		}
+		te, ok := extMap[e]
+		data, override, err := opts.extensionOverride(uint16(id))
+		if err != nil {
+			return nil, err
+		}
+		if override {
+			te, ok = &utls.GenericExtension{Id: uint16(id), Data: data}, true
+		}
+		if !ok {
+			// Unknown extensions are sent empty unless StrictExtensions is set
+			if opts.StrictExtensions {
+				return nil, raiseExtensionError(e)
+			}
+			te = &utls.GenericExtension{Id: uint16(id)}
 		}
 		exts = append(exts, te)
 	}
+	if useGrease {
+		exts = addGREASEExtensions(exts)
+	}
 
 	// build CipherSuites
 	var suites []uint16
-	//Optionally Add Chrome Grease Extension
-	// if parsedUserAgent == chrome && !tlsExtensions.UseGREASE {
-	if parsedUserAgent.UserAgent == chrome {
+	if useGrease {
 		suites = append(suites, utls.GREASE_PLACEHOLDER)
 	}
 	for _, c := range ciphers {
@@ -262,6 +212,9 @@ func StringToSpec(ja3 string, userAgent string, forceHTTP1 bool) (*utls.ClientHe
 		if err != nil {
 			return nil, err
 		}
+		if IsGREASEValue(uint16(cid)) {
+			continue
+		}
 		suites = append(suites, uint16(cid))
 	}
 	return &utls.ClientHelloSpec{
@@ -269,16 +222,21 @@ func StringToSpec(ja3 string, userAgent string, forceHTTP1 bool) (*utls.ClientHe
 		TLSVersMax:         tlsMaxVersion,
 		CipherSuites:       suites,
 		CompressionMethods: []byte{0},
-		Extensions:         exts,
+		Extensions:         shuffleExtensions(exts, opts),
 		GetSessionID:       sha256.Sum256,
 	}, nil
 }
 
 // StringToTLS13CompatibleSpec creates a TLS 1.3 compatible ClientHelloSpec by filtering curves
 func StringToTLS13CompatibleSpec(ja3 string, userAgent string, forceHTTP1 bool) (*utls.ClientHelloSpec, error) {
+	return StringToTLS13CompatibleSpecWithOptions(ja3, userAgent, forceHTTP1, SpecOptions{})
+}
+
+// StringToTLS13CompatibleSpecWithOptions is StringToTLS13CompatibleSpec with SpecOptions
+func StringToTLS13CompatibleSpecWithOptions(ja3 string, userAgent string, forceHTTP1 bool, opts SpecOptions) (*utls.ClientHelloSpec, error) {
 	// For TLS 1.3 compatibility, we use only widely supported curves: X25519 (29) and secp256r1 (23)
 	tls13CompatibleJA3 := convertJA3ForTLS13(ja3)
-	return StringToSpec(tls13CompatibleJA3, userAgent, forceHTTP1)
+	return StringToSpecWithOptions(tls13CompatibleJA3, userAgent, forceHTTP1, opts)
 }
 
 // convertJA3ForTLS13 converts a JA3 string to use TLS 1.3 compatible curves
@@ -293,8 +251,15 @@ func convertJA3ForTLS13(ja3 string) string {
 		tokens[0] = "772" // Upgrade TLS 1.2 to TLS 1.3
 	}
 
-	// Replace curves (position 3) with TLS 1.3 compatible ones: X25519 (29) and secp256r1 (23)
-	tokens[3] = "29-23" // X25519 and secp256r1
+	// Replace curves (position 3) with TLS 1.3 compatible ones: X25519 (29) and secp256r1 (23),
+	// keeping any hybrid post-quantum groups in front
+	var curves []string
+	for _, c := range strings.Split(tokens[3], "-") {
+		if cid, err := strconv.ParseUint(c, 10, 16); err == nil && isPostQuantumGroup(utls.CurveID(cid)) {
+			curves = append(curves, c)
+		}
+	}
+	tokens[3] = strings.Join(append(curves, "29", "23"), "-") // X25519 and secp256r1
 
 	return strings.Join(tokens, ",")
 }
@@ -321,6 +286,8 @@ func isTLS13CompatibleCurve(curveID uint16) bool {
 		return true
 	case 4589: // 0x11ED - SecP384r1MLKEM1024 (P-384 + MLKEM1024)
 		return true
+	case 25497: // 0x6399 - X25519Kyber768Draft00 (pre-standard X25519 + Kyber768)
+		return true
 
 	default:
 		return false
@@ -356,6 +323,18 @@ type JA4HComponents struct {
 	HTTPMethodVersion string
 	HeadersHash       string
 	CookiesHash       string
+
+	// Decoded from the first section
+	Method      string // Two letter method code, e.g. "ge"
+	HTTPVersion string // "10", "11", "20" or "30"
+	HasCookie   bool
+	HasReferer  bool
+	HeaderCount int    // -1 when the first section carries no count
+	Language    string // Four character Accept-Language code, "0000" for none
+
+	// Only set for raw JA4H_r strings, which list names instead of hashes
+	HeaderNames []string
+	CookieNames []string
 }
 
 // ParseJA4String parses a JA4 string into its components
@@ -631,9 +610,9 @@ func ParseJA4RString(ja4r string) (*JA4RComponents, error) {
 	}, nil
 }
 
-// ParseJA4HString parses a JA4H (HTTP Client) string into its components
-// JA4H format: <method_version>_<headers_hash>_<cookies_hash>
-// Example: po11_73a4f1e_8b3fce7
+// ParseJA4HString parses a JA4H (HTTP Client) string into its components.
+// It accepts the FoxIO a_b_c_d form, hashed or raw (JA4H_r, with comma
+// separated header and cookie names), and the older a_b_c form.
 func ParseJA4HString(ja4h string) (*JA4HComponents, error) {
 	if len(ja4h) < 8 { // minimum reasonable length for JA4H
 		return nil, errors.New("invalid JA4H string: too short")
@@ -641,8 +620,8 @@ func ParseJA4HString(ja4h string) (*JA4HComponents, error) {
 
 	// Split by underscores
 	parts := strings.Split(ja4h, "_")
-	if len(parts) != 3 {
-		return nil, errors.New("invalid JA4H string: incorrect format - expected 3 parts separated by underscores")
+	if len(parts) != 3 && len(parts) != 4 {
+		return nil, errors.New("invalid JA4H string: incorrect format - expected 3 or 4 parts separated by underscores")
 	}
 
 	// Validate method version format (e.g., "po11" for POST HTTP/1.1, "ge20" for GET HTTP/2.0)
@@ -651,14 +630,50 @@ func ParseJA4HString(ja4h string) (*JA4HComponents, error) {
 		return nil, errors.New("invalid JA4H string: HTTP method/version too short")
 	}
 
-	headersHash := parts[1]
-	cookiesHash := parts[2]
-
-	return &JA4HComponents{
+	components := &JA4HComponents{
 		HTTPMethodVersion: httpMethodVersion,
-		HeadersHash:       headersHash,
-		CookiesHash:       cookiesHash,
-	}, nil
+		HeadersHash:       parts[1],
+		CookiesHash:       parts[2],
+		Method:            httpMethodVersion[:2],
+		HTTPVersion:       httpMethodVersion[2:4],
+		HeaderCount:       -1,
+	}
+	switch components.HTTPVersion {
+	case "10", "11", "20", "30":
+	default:
+		return nil, fmt.Errorf("invalid JA4H string: unknown HTTP version %q", components.HTTPVersion)
+	}
+
+	// <method:2><version:2><cookie:1><referer:1><header count:2><language:4>
+	if len(httpMethodVersion) >= 12 {
+		components.HasCookie = httpMethodVersion[4] == 'c'
+		components.HasReferer = httpMethodVersion[5] == 'r'
+		count, err := strconv.Atoi(httpMethodVersion[6:8])
+		if err != nil {
+			return nil, fmt.Errorf("invalid JA4H string: header count %q", httpMethodVersion[6:8])
+		}
+		components.HeaderCount = count
+		components.Language = httpMethodVersion[8:12]
+	}
+
+	// Hashes are 12 hex characters, anything else is a raw name list
+	if !isJA4Hash(parts[1]) {
+		components.HeaderNames = strings.Split(parts[1], ",")
+	}
+	if len(parts) == 4 && !isJA4Hash(parts[2]) {
+		components.CookieNames = strings.Split(parts[2], ",")
+	}
+
+	return components, nil
+}
+
+// isJA4Hash reports whether s looks like a truncated JA4 SHA-256 hash
+func isJA4Hash(s string) bool {
+	if len(s) != 12 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
 }
 
 // JA4StringToSpec creates a ClientHelloSpec based on a JA4 string
@@ -667,11 +682,28 @@ func ParseJA4HString(ja4h string) (*JA4HComponents, error) {
 
 // JA4RStringToSpec creates a ClientHelloSpec from a JA4_r (raw) string
 func JA4RStringToSpec(ja4r string, userAgent string, forceHTTP1 bool, disableGrease bool, serverName string) (*utls.ClientHelloSpec, error) {
+	return JA4RStringToSpecWithOptions(ja4r, userAgent, forceHTTP1, disableGrease, serverName, SpecOptions{})
+}
+
+// JA4RStringToSpecWithOptions creates a ClientHelloSpec from a JA4_r (raw) string, applying opts
+func JA4RStringToSpecWithOptions(ja4r string, userAgent string, forceHTTP1 bool, disableGrease bool, serverName string, opts SpecOptions) (*utls.ClientHelloSpec, error) {
 	components, err := ParseJA4RString(ja4r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse JA4_r: %w", err)
 	}
 
+	// JA4r strips GREASE, so auto only keeps GREASE the fingerprint lists
+	if _, err := opts.grease(false); err != nil {
+		return nil, err
+	}
+	grease := opts.GreaseMode
+	if grease == "" || grease == GreaseAuto {
+		grease = GreaseAuto
+		if disableGrease {
+			grease = GreaseOff
+		}
+	}
+
 	// Map TLS version string to actual version
 	var tlsVersion uint16
 	var tlsMinVersion uint16
@@ -700,7 +732,13 @@ func JA4RStringToSpec(ja4r string, userAgent string, forceHTTP1 bool, disableGre
 
 	// Map cipher suites from raw values
 	cipherSuites := []uint16{}
+	if grease == GreaseOn {
+		cipherSuites = append(cipherSuites, utls.GREASE_PLACEHOLDER)
+	}
 	for _, rawCipher := range components.CipherSuites {
+		if IsGREASEValue(rawCipher) && grease != GreaseAuto {
+			continue
+		}
 		// Check if we have a mapping for this cipher
 		if mappedCipher, exists := cipherSuiteMap[rawCipher]; exists {
 			cipherSuites = append(cipherSuites, mappedCipher)
@@ -760,8 +798,27 @@ func JA4RStringToSpec(ja4r string, userAgent string, forceHTTP1 bool, disableGre
 	}
 
 	// Process extensions from JA4_r AFTER SNI to maintain proper numerical order
+	listsGREASE := false
 	for _, extCode := range components.Extensions {
-		if ext := CreateExtensionFromID(extCode, tlsVersion, components, disableGrease, serverName); ext != nil {
+		if IsGREASEValue(extCode) {
+			listsGREASE = true
+			if grease == GreaseOff {
+				continue
+			}
+		}
+		data, override, err := opts.extensionOverride(extCode)
+		if err != nil {
+			return nil, err
+		}
+		if override {
+			extensions = append(extensions, &utls.GenericExtension{Id: extCode, Data: data})
+			continue
+		}
+		ext, known := createExtensionFromID(extCode, tlsVersion, components, grease, serverName)
+		if !known && opts.StrictExtensions {
+			return nil, raiseExtensionError(fmt.Sprint(extCode))
+		}
+		if ext != nil {
 			extensions = append(extensions, ext)
 		}
 	}
@@ -788,13 +845,16 @@ func JA4RStringToSpec(ja4r string, userAgent string, forceHTTP1 bool, disableGre
 		}
 		extensions = append(extensions, alpnExt)
 	}
+	if grease == GreaseOn && !listsGREASE {
+		extensions = addGREASEExtensions(extensions)
+	}
 
 	return &utls.ClientHelloSpec{
 		TLSVersMin:         tlsMinVersion,
 		TLSVersMax:         tlsMaxVersion,
 		CipherSuites:       cipherSuites,
 		CompressionMethods: []byte{0}, // no compression
-		Extensions:         extensions,
+		Extensions:         shuffleExtensions(extensions, opts),
 		GetSessionID:       sha256.Sum256,
 	}, nil
 }
@@ -1058,8 +1118,106 @@ func CreateUQuicSpecFromJA4(ja4r string) (*uquic.QUICSpec, error) {
 	return &spec, nil
 }
 
+// QUICSpecForUserAgent returns the QUIC specification of userAgent's browser,
+// for Browser.USpec. Chrome and Android WebView use uquic's Chrome parrot,
+// Firefox its Firefox parrot, and Safari and iOS a WebKit specification that
+// only carries GREASE from Safari 14 on.
+func QUICSpecForUserAgent(userAgent string) (*uquic.QUICSpec, error) {
+	ua := parseUserAgent(userAgent)
+	var id uquic.QUICID
+	switch ua.UserAgent {
+	case safari, iosSafari:
+		return safariQUICSpec(ua.greases()), nil
+	case firefox:
+		id = uquic.QUICFirefox_116
+	default:
+		id = uquic.QUICChrome_115
+	}
+	spec, err := uquic.QUICID2Spec(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create QUIC spec: %w", err)
+	}
+	return &spec, nil
+}
+
+// safariQUICSpec is the Initial packet and ClientHello WebKit's QUIC stack
+// sends, with or without GREASE
+func safariQUICSpec(grease bool) *uquic.QUICSpec {
+	var suites []uint16
+	curves := []utls.CurveID{utls.X25519, utls.CurveP256, utls.CurveP384, utls.CurveP521}
+	keyShares := []utls.KeyShare{{Group: utls.X25519}}
+	versions := []uint16{utls.VersionTLS13}
+	if grease {
+		suites = append(suites, utls.GREASE_PLACEHOLDER)
+		curves = append([]utls.CurveID{utls.GREASE_PLACEHOLDER}, curves...)
+		keyShares = append([]utls.KeyShare{{Group: utls.GREASE_PLACEHOLDER, Data: []byte{0}}}, keyShares...)
+		versions = append([]uint16{utls.GREASE_PLACEHOLDER}, versions...)
+	}
+	suites = append(suites, utls.TLS_AES_128_GCM_SHA256, utls.TLS_AES_256_GCM_SHA384, utls.TLS_CHACHA20_POLY1305_SHA256)
+
+	var extensions []utls.TLSExtension
+	if grease {
+		extensions = append(extensions, &utls.UtlsGREASEExtension{})
+	}
+	extensions = append(extensions,
+		&utls.SNIExtension{},
+		&utls.SupportedCurvesExtension{Curves: curves},
+		&utls.ALPNExtension{AlpnProtocols: []string{"h3"}},
+		&utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []utls.SignatureScheme{
+			utls.ECDSAWithP256AndSHA256,
+			utls.PSSWithSHA256,
+			utls.PKCS1WithSHA256,
+			utls.ECDSAWithP384AndSHA384,
+			utls.PSSWithSHA384,
+			utls.PKCS1WithSHA384,
+			utls.PSSWithSHA512,
+			utls.PKCS1WithSHA512,
+		}},
+		&utls.KeyShareExtension{KeyShares: keyShares},
+		&utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}},
+		&utls.SupportedVersionsExtension{Versions: versions},
+		&utls.UtlsCompressCertExtension{Algorithms: []utls.CertCompressionAlgo{utls.CertCompressionZlib}},
+		&utls.QUICTransportParametersExtension{TransportParameters: utls.TransportParameters{
+			utls.MaxIdleTimeout(30000),
+			utls.MaxUDPPayloadSize(1472),
+			utls.InitialMaxData(2097152),
+			utls.InitialMaxStreamDataBidiLocal(2097152),
+			utls.InitialMaxStreamDataBidiRemote(2097152),
+			utls.InitialMaxStreamDataUni(2097152),
+			utls.InitialMaxStreamsBidi(100),
+			utls.InitialMaxStreamsUni(100),
+			utls.InitialSourceConnectionID([]byte{}),
+		}},
+	)
+	if grease {
+		extensions = append(extensions, &utls.UtlsGREASEExtension{})
+	}
+
+	return &uquic.QUICSpec{
+		InitialPacketSpec: uquic.InitialPacketSpec{
+			SrcConnIDLength:        8,
+			DestConnIDLength:       8,
+			InitPacketNumberLength: 1,
+			InitPacketNumber:       0,
+			FrameBuilder:           uquic.QUICFrames{}, // empty = single crypto
+		},
+		ClientHelloSpec: &utls.ClientHelloSpec{
+			TLSVersMin:         utls.VersionTLS13,
+			TLSVersMax:         utls.VersionTLS13,
+			CipherSuites:       suites,
+			CompressionMethods: []uint8{0},
+			Extensions:         extensions,
+		},
+	}
+}
+
 // QUICStringToSpec creates a ClientHelloSpec based on a QUIC fingerprint string
 func QUICStringToSpec(quicFingerprint string, userAgent string, forceHTTP1 bool) (*utls.ClientHelloSpec, error) {
+	return QUICStringToSpecWithOptions(quicFingerprint, userAgent, forceHTTP1, SpecOptions{})
+}
+
+// QUICStringToSpecWithOptions creates a ClientHelloSpec based on a QUIC fingerprint string, applying opts
+func QUICStringToSpecWithOptions(quicFingerprint string, userAgent string, forceHTTP1 bool, opts SpecOptions) (*utls.ClientHelloSpec, error) {
 	if quicFingerprint == "" {
 		return nil, errors.New("empty QUIC fingerprint")
 	}
@@ -1070,13 +1228,18 @@ func QUICStringToSpec(quicFingerprint string, userAgent string, forceHTTP1 bool)
 	}
 
 	parsedUserAgent := parseUserAgent(userAgent)
+	useGrease, err := opts.grease(parsedUserAgent.greases())
+	if err != nil {
+		return nil, err
+	}
+	greaseVersions, _ := opts.grease(true)
 	extMap := genMap(false)
 
 	// Default to TLS 1.3 for QUIC (as QUIC typically uses TLS 1.3)
 	var tlsVersion uint16 = utls.VersionTLS13
 
 	// Create TLS configuration for QUIC
-	tlsMaxVersion, tlsMinVersion, tlsExtension, err := createTlsVersion(tlsVersion, false)
+	tlsMaxVersion, tlsMinVersion, tlsExtension, err := createTlsVersion(tlsVersion, !greaseVersions)
 	if err != nil {
 		return nil, err
 	}
@@ -1084,7 +1247,7 @@ func QUICStringToSpec(quicFingerprint string, userAgent string, forceHTTP1 bool)
 
 	// QUIC-specific cipher suites (TLS 1.3 only)
 	var suites []uint16
-	if parsedUserAgent.UserAgent == chrome {
+	if useGrease {
 		suites = append(suites, utls.GREASE_PLACEHOLDER)
 	}
 
@@ -1101,7 +1264,7 @@ func QUICStringToSpec(quicFingerprint string, userAgent string, forceHTTP1 bool)
 
 	// Set up curves for QUIC
 	var targetCurves []utls.CurveID
-	if parsedUserAgent.UserAgent == chrome {
+	if useGrease {
 		targetCurves = append(targetCurves, utls.CurveID(utls.GREASE_PLACEHOLDER))
 	}
 
@@ -1113,6 +1276,7 @@ func QUICStringToSpec(quicFingerprint string, userAgent string, forceHTTP1 bool)
 		utls.CurveP521,
 	}...)
 	extMap["10"] = &utls.SupportedCurvesExtension{Curves: targetCurves}
+	extMap["51"] = &utls.KeyShareExtension{KeyShares: keySharesForGroups(targetCurves, parsedUserAgent.UserAgent == firefox)}
 
 	// Set point formats
 	extMap["11"] = &utls.SupportedPointsExtension{SupportedPoints: []byte{0}}
@@ -1134,20 +1298,17 @@ func QUICStringToSpec(quicFingerprint string, userAgent string, forceHTTP1 bool)
 
 	// Build extensions list with QUIC-appropriate extensions
 	var exts []utls.TLSExtension
-	if parsedUserAgent.UserAgent == chrome {
-		exts = append(exts, &utls.UtlsGREASEExtension{})
-	}
 
 	// QUIC-specific extension order
 	quicExtensions := []string{"0", "23", "65281", "10", "11", "35", "16", "5", "51", "43", "13", "45", "28", "57", "21"}
 	for _, e := range quicExtensions {
 		if te, ok := extMap[e]; ok {
-			if e == "21" && parsedUserAgent.UserAgent == chrome {
-				exts = append(exts, &utls.UtlsGREASEExtension{})
-			}
 			exts = append(exts, te)
 		}
 	}
+	if useGrease {
+		exts = addGREASEExtensions(exts)
+	}
 
 	return &utls.ClientHelloSpec{
 		TLSVersMin:         tlsMinVersion,
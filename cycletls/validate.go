@@ -0,0 +1,488 @@
+package cycletls
+
+import (
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// DiagnosticSeverity grades a Diagnostic
+type DiagnosticSeverity string
+
+const (
+	// SeverityError marks options the request cannot be sent with
+	SeverityError DiagnosticSeverity = "error"
+	// SeverityWarning marks options that work but likely not as intended
+	SeverityWarning DiagnosticSeverity = "warning"
+)
+
+// Diagnostic is a single finding from ValidateFingerprint
+type Diagnostic struct {
+	Severity DiagnosticSeverity `json:"severity"`
+	Field    string             `json:"field"` // Options JSON field the finding is about
+	Message  string             `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Field, d.Message)
+}
+
+// HasErrors reports whether any of diags is an error
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFingerprint checks the fingerprint options for syntax errors,
+// inconsistencies between the TLS, HTTP/2, HTTP/3 and User-Agent settings, and
+// extensions CycleTLS cannot build. It does not dial.
+func ValidateFingerprint(options Options) []Diagnostic {
+	v := &fingerprintValidator{options: options, explicitJA4r: options.Ja4r != ""}
+	v.checkGreaseMode()
+	v.checkExtensionData()
+	v.checkJA3()
+	v.checkJA4()
+	v.checkJA4r()
+	v.checkHTTP2()
+	v.checkHTTP3()
+	v.checkJA4H()
+	v.checkRequestMode()
+	v.checkQUIC()
+	v.checkClientHello()
+	v.checkPrecedence()
+	return v.diags
+}
+
+type fingerprintValidator struct {
+	options Options
+	diags   []Diagnostic
+
+	// Collected from the TLS fingerprint for the cross checks
+	offersALPN bool
+	hasTLS     bool
+
+	explicitJA4r bool // Ja4r was set rather than resolved from Ja4
+}
+
+func (v *fingerprintValidator) errorf(field, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{Severity: SeverityError, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *fingerprintValidator) warnf(field, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{Severity: SeverityWarning, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// unknownExtension reports an extension ID no builder knows, as an error in
+// strict mode and a warning otherwise
+func (v *fingerprintValidator) unknownExtension(field string, id uint16) {
+	if _, ok, _ := v.specOptions().extensionOverride(id); ok {
+		return
+	}
+	if v.options.StrictExtensions {
+		v.errorf(field, "extension %d is not supported by CycleTLS", id)
+		return
+	}
+	v.warnf(field, "extension %d is not supported by CycleTLS and will be sent empty", id)
+}
+
+func (v *fingerprintValidator) specOptions() SpecOptions {
+	return SpecOptions{
+		ExtensionData:    v.options.ExtensionData,
+		StrictExtensions: v.options.StrictExtensions,
+		GreaseMode:       GreaseMode(v.options.GreaseMode),
+	}
+}
+
+func (v *fingerprintValidator) checkGreaseMode() {
+	if _, err := v.specOptions().grease(false); err != nil {
+		v.errorf("greaseMode", "%v", err)
+	}
+}
+
+func (v *fingerprintValidator) checkExtensionData() {
+	for key, value := range v.options.ExtensionData {
+		if _, err := strconv.ParseUint(key, 0, 16); err != nil {
+			v.errorf("extensionData", "invalid extension ID %q, expected a decimal or 0x-prefixed hex number", key)
+		}
+		if _, err := hex.DecodeString(value); err != nil {
+			v.errorf("extensionData", "invalid hex payload for extension %s: %v", key, err)
+		}
+	}
+}
+
+// tls13CipherSuites are the suites only TLS 1.3 can negotiate
+var tls13CipherSuites = map[uint16]bool{
+	utls.TLS_AES_128_GCM_SHA256:       true,
+	utls.TLS_AES_256_GCM_SHA384:       true,
+	utls.TLS_CHACHA20_POLY1305_SHA256: true,
+	0x1304:                            true, // TLS_AES_128_CCM_SHA256
+	0x1305:                            true, // TLS_AES_128_CCM_8_SHA256
+}
+
+func (v *fingerprintValidator) checkJA3() {
+	ja3 := v.options.Ja3
+	if ja3 == "" {
+		return
+	}
+	v.hasTLS = true
+
+	tokens := strings.Split(ja3, ",")
+	if len(tokens) != 5 {
+		v.errorf("ja3", "expected 5 comma separated fields (version,ciphers,extensions,curves,point formats), got %d", len(tokens))
+		return
+	}
+
+	version, err := strconv.ParseUint(tokens[0], 10, 16)
+	if err != nil {
+		v.errorf("ja3", "invalid TLS version %q", tokens[0])
+	} else if version < utls.VersionTLS10 || version > utls.VersionTLS13 {
+		v.errorf("ja3", "unsupported TLS version %d, expected 769 to 772", version)
+	}
+
+	ciphers, ok := v.parseList("ja3", "cipher suite", tokens[1], 16)
+	if ok && len(ciphers) == 0 {
+		v.errorf("ja3", "no cipher suites")
+	}
+	extensions, _ := v.parseList("ja3", "extension", tokens[2], 16)
+	v.parseList("ja3", "curve", tokens[3], 16)
+	v.parseList("ja3", "point format", tokens[4], 8)
+
+	known := genMap(false)
+	offersVersions := false
+	for _, id := range extensions {
+		key := strconv.FormatUint(id, 10)
+		switch {
+		case key == "10" || key == "11":
+			// Built from the curves and point formats fields
+		case key == "43":
+			offersVersions = true
+		case key == "16":
+			v.offersALPN = true
+		case IsGREASEValue(uint16(id)):
+		default:
+			if _, ok := known[key]; !ok {
+				v.unknownExtension("ja3", uint16(id))
+			}
+		}
+	}
+
+	// JA3 records the legacy version, so TLS 1.3 shows up as supported_versions
+	if version == utls.VersionTLS12 && !offersVersions {
+		for _, c := range ciphers {
+			if tls13CipherSuites[uint16(c)] {
+				v.warnf("ja3", "TLS 1.3 cipher suite %d is offered with TLS 1.2 and no supported_versions extension (43), so it can never be negotiated", c)
+				break
+			}
+		}
+	}
+}
+
+// parseList parses a dash separated JA3 field of unsigned bitSize-bit numbers
+func (v *fingerprintValidator) parseList(field, what, token string, bitSize int) ([]uint64, bool) {
+	if token == "" {
+		return nil, true
+	}
+	var values []uint64
+	ok := true
+	for _, s := range strings.Split(token, "-") {
+		n, err := strconv.ParseUint(s, 10, bitSize)
+		if err != nil {
+			v.errorf(field, "invalid %s %q", what, s)
+			ok = false
+			continue
+		}
+		values = append(values, n)
+	}
+	return values, ok
+}
+
+func (v *fingerprintValidator) checkJA4() {
+	if v.options.Ja4 == "" || v.explicitJA4r {
+		return
+	}
+	known, err := LookupJA4(v.options.Ja4)
+	if err != nil {
+		v.errorf("ja4", "%v", err)
+		return
+	}
+	// The resolved JA4_r gets the same checks as one passed directly
+	v.options.Ja4r = known.JA4r
+}
+
+func (v *fingerprintValidator) checkJA4r() {
+	if v.options.Ja4r == "" {
+		return
+	}
+	v.hasTLS = true
+
+	components, err := ParseJA4RString(v.options.Ja4r)
+	if err != nil {
+		v.errorf("ja4r", "%v", err)
+		return
+	}
+
+	switch components.TLSVersion {
+	case "t10", "t11", "t12", "t13":
+	default:
+		v.errorf("ja4r", "unsupported TLS version %q, expected t10 to t13", components.TLSVersion)
+	}
+	if components.CipherCount != len(components.CipherSuites) {
+		v.warnf("ja4r", "header claims %d cipher suites but %d are listed", components.CipherCount, len(components.CipherSuites))
+	}
+	if components.TLSVersion == "t12" {
+		for _, c := range components.CipherSuites {
+			if tls13CipherSuites[c] {
+				v.warnf("ja4r", "TLS 1.3 cipher suite 0x%04x is offered with TLS 1.2", c)
+				break
+			}
+		}
+	}
+
+	offersALPN := components.ALPN != "" && components.ALPN != "00"
+	for _, id := range components.Extensions {
+		if id == 0x0010 {
+			offersALPN = true
+		}
+		if _, known := createExtensionFromID(id, utls.VersionTLS13, components, GreaseAuto, ""); !known {
+			v.unknownExtension("ja4r", id)
+		}
+	}
+	// A JA3 takes precedence and has already set offersALPN
+	if v.options.Ja3 == "" {
+		v.offersALPN = offersALPN
+	}
+}
+
+// pseudoHeaderLetters maps the Akamai HTTP/2 pseudo-header letters to headers
+var pseudoHeaderLetters = map[string]string{"m": ":method", "a": ":authority", "s": ":scheme", "p": ":path"}
+
+func (v *fingerprintValidator) checkHTTP2() {
+	if v.options.HTTP2Fingerprint == "" {
+		return
+	}
+	fp, err := NewHTTP2Fingerprint(v.options.HTTP2Fingerprint)
+	if err != nil {
+		v.errorf("http2Fingerprint", "%v", err)
+		return
+	}
+
+	var order []string
+	for _, letter := range fp.PriorityOrder {
+		header, ok := pseudoHeaderLetters[letter]
+		if !ok {
+			v.errorf("http2Fingerprint", "unknown pseudo-header %q in priority order, expected m, a, s or p", letter)
+			return
+		}
+		order = append(order, header)
+	}
+
+	// User agents of no known family get Chrome order, so they are not compared
+	if expected := parseUserAgent(v.options.UserAgent); expected.known {
+		if strings.Join(order, ",") != strings.Join(expected.HeaderOrder, ",") {
+			v.warnf("http2Fingerprint", "pseudo-header order %s does not match the %s user agent, which sends %s",
+				strings.Join(fp.PriorityOrder, ","), expected.UserAgent, strings.Join(expected.HeaderOrder, ","))
+		}
+	}
+
+	if v.options.ForceHTTP1 {
+		v.warnf("http2Fingerprint", "HTTP/2 fingerprint is ignored because forceHTTP1 is set")
+	} else if v.hasTLS && !v.offersALPN {
+		v.warnf("http2Fingerprint", "the TLS fingerprint offers no ALPN, so servers will not negotiate HTTP/2")
+	}
+}
+
+func (v *fingerprintValidator) checkHTTP3() {
+	if v.options.HTTP3Fingerprint == "" {
+		return
+	}
+	fp, err := NewHTTP3Fingerprint(v.options.HTTP3Fingerprint)
+	if err != nil {
+		v.errorf("http3Fingerprint", "%v", err)
+		return
+	}
+
+	if capacity, ok := fp.setting(http3SettingQPACKMaxTableCapacity); ok && capacity > 1<<20 {
+		v.warnf("http3Fingerprint", "QPACK table capacity %d lets servers use over 1 MiB of memory per connection", capacity)
+	}
+	if len(fp.PseudoHeaderOrder) > 0 {
+		expected := parseUserAgent(v.options.UserAgent)
+		order := strings.Join(fp.pseudoHeaders(), ",")
+		if expected.known && order != strings.Join(expected.HeaderOrder, ",") {
+			v.warnf("http3Fingerprint", "pseudo-header order %s does not match the %s user agent, which sends %s",
+				strings.Join(fp.PseudoHeaderOrder, ","), expected.UserAgent, strings.Join(expected.HeaderOrder, ","))
+		}
+	}
+
+	if v.options.ForceHTTP1 {
+		v.warnf("http3Fingerprint", "HTTP/3 fingerprint is ignored because forceHTTP1 is set")
+	} else if !v.options.ForceHTTP3 && v.options.Protocol != "http3" && !v.options.EnableAltSvc {
+		v.warnf("http3Fingerprint", "HTTP/3 fingerprint only applies to HTTP/3, which needs forceHTTP3, protocol \"http3\" or enableAltSvc")
+	}
+}
+
+func (v *fingerprintValidator) checkJA4H() {
+	if v.options.Ja4h == "" {
+		return
+	}
+	components, err := ParseJA4HString(v.options.Ja4h)
+	if err != nil {
+		v.errorf("ja4h", "%v", err)
+		return
+	}
+
+	switch components.HTTPVersion {
+	case "10", "11":
+		if v.options.HTTP2Fingerprint != "" {
+			v.warnf("ja4h", "HTTP/1 target forces HTTP/1.1, so the HTTP/2 fingerprint is ignored")
+		}
+		if v.options.ForceHTTP3 || v.options.Protocol == "http3" {
+			v.errorf("ja4h", "HTTP/1 target conflicts with HTTP/3")
+		}
+	case "20":
+		if v.options.ForceHTTP1 || v.options.ForceHTTP3 || v.options.Protocol == "http3" {
+			v.errorf("ja4h", "HTTP/2 target conflicts with the forced protocol")
+		} else if v.hasTLS && !v.offersALPN {
+			v.warnf("ja4h", "HTTP/2 target but the TLS fingerprint offers no ALPN")
+		}
+	case "30":
+		if v.options.ForceHTTP1 {
+			v.errorf("ja4h", "HTTP/3 target conflicts with forceHTTP1")
+		}
+	}
+
+	if _, known := ja4hMethods[components.Method]; !known && v.options.Method == "" {
+		v.warnf("ja4h", "unknown method code %q", components.Method)
+	} else if v.options.Method != "" && ja4hMethod(v.options.Method) != components.Method {
+		v.errorf("ja4h", "method code %q does not match the %s request", components.Method, strings.ToUpper(v.options.Method))
+	}
+
+	if components.HeaderCount < 0 {
+		return
+	}
+	// Cookies and referers cannot be invented, only removed
+	if components.HasCookie && len(v.options.Cookies) == 0 && v.header("Cookie") == "" {
+		v.warnf("ja4h", "target sends cookies but the request has none")
+	}
+	if components.HasReferer && v.header("Referer") == "" {
+		v.warnf("ja4h", "target sends a Referer but the request has none")
+	}
+
+	if len(components.HeaderNames) == 0 {
+		return
+	}
+	if len(components.HeaderNames) != components.HeaderCount {
+		v.warnf("ja4h", "header count %d does not match the %d listed headers", components.HeaderCount, len(components.HeaderNames))
+	}
+	listed := make(map[string]bool)
+	for _, name := range components.HeaderNames {
+		listed[strings.ToLower(name)] = true
+		switch strings.ToLower(name) {
+		case "host", "user-agent", "accept-language":
+			// Always set by CycleTLS or from the language code
+		default:
+			if v.header(name) == "" {
+				v.warnf("ja4h", "header %q is listed but not set, so it will not be sent", name)
+			}
+		}
+	}
+	names := slices.Sorted(maps.Keys(v.options.Headers))
+	for _, h := range v.options.OrderedHeaders {
+		names = append(names, h[0])
+	}
+	warned := make(map[string]bool)
+	for _, name := range names {
+		switch lower := strings.ToLower(name); {
+		case lower == "cookie" || lower == "referer" || listed[lower] || warned[lower]:
+		default:
+			warned[lower] = true
+			v.warnf("ja4h", "header %q is not listed and will be sent after the listed headers", name)
+		}
+	}
+}
+
+func (v *fingerprintValidator) checkRequestMode() {
+	if v.options.RequestMode == "" {
+		return
+	}
+	if _, err := requestModeProfile(chrome, v.options.RequestMode); err != nil {
+		v.errorf("requestMode", "%v", err)
+		return
+	}
+	if v.options.UserAgent == "" {
+		v.warnf("requestMode", "no user agent, so the defaults are Chrome's without client hints")
+	}
+}
+
+// header looks up a request header case-insensitively
+func (v *fingerprintValidator) header(name string) string {
+	for _, h := range v.options.OrderedHeaders {
+		if strings.EqualFold(h[0], name) {
+			return h[1]
+		}
+	}
+	for k, value := range v.options.Headers {
+		if strings.EqualFold(k, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+func (v *fingerprintValidator) checkQUIC() {
+	if v.options.QUICFingerprint == "" {
+		return
+	}
+	// Build the spec dialTLS would, leaving the GREASE mode to checkGreaseMode
+	opts := v.specOptions()
+	opts.GreaseMode = ""
+	if _, err := QUICStringToSpecWithOptions(v.options.QUICFingerprint, v.options.UserAgent, v.options.ForceHTTP1, opts); err != nil {
+		v.errorf("quicFingerprint", "%v", err)
+	}
+}
+
+func (v *fingerprintValidator) checkClientHello() {
+	if v.options.ClientHello != "" {
+		if _, err := ClientHelloHexToSpec(v.options.ClientHello, false); err != nil {
+			v.errorf("clientHello", "%v", err)
+		}
+	}
+	if v.options.TLSSpec != nil {
+		if _, err := v.options.TLSSpec.ToClientHelloSpec(); err != nil {
+			v.errorf("tlsSpec", "%v", err)
+		}
+	}
+}
+
+// checkPrecedence flags TLS fingerprints dialTLS will never use
+func (v *fingerprintValidator) checkPrecedence() {
+	sources := []struct {
+		field string
+		set   bool
+	}{
+		{"clientHello", v.options.ClientHello != ""},
+		{"tlsSpec", v.options.TLSSpec != nil},
+		{"ja3", v.options.Ja3 != ""},
+		{"ja4r", v.explicitJA4r},
+		{"ja4", v.options.Ja4 != ""},
+	}
+	winner := ""
+	for _, s := range sources {
+		if !s.set {
+			continue
+		}
+		if winner == "" {
+			winner = s.field
+			continue
+		}
+		v.warnf(s.field, "ignored because %s takes precedence", winner)
+	}
+}
@@ -30,6 +30,37 @@ export interface TimeoutOptions {
   acknowledgementTimeout?: number
 }
 
+// A number, a "0x" hex string, a name such as "X25519MLKEM768", or "GREASE"
+export type TLSSpecValue = number | string;
+
+export interface TLSSpecExtension {
+  name?: string;   // IANA extension name, e.g. "key_share"; use id/data for anything else
+  id?: number;
+  data?: string;   // Hex-encoded extension body
+  groups?: TLSSpecValue[];
+  keyShares?: TLSSpecValue[];
+  signatureAlgorithms?: TLSSpecValue[];
+  protocols?: string[];
+  codepoint?: number;  // ALPS codepoint, 17513 or 17613 (default)
+  versions?: TLSSpecValue[];
+  pointFormats?: number[];
+  pskModes?: number[];
+  algorithms?: TLSSpecValue[];  // Certificate compression: "zlib", "brotli", "zstd"
+  limit?: number;
+  payloadLengths?: number[];
+  echCipherSuites?: { kdf: number; aead: number }[];
+  style?: 'boringssl' | 'fixed' | 'padTo';
+  length?: number;
+}
+
+export interface TLSSpec {
+  tlsVersionMin?: TLSSpecValue;
+  tlsVersionMax?: TLSSpecValue;
+  cipherSuites: TLSSpecValue[];
+  compressionMethods?: number[];
+  extensions: TLSSpecExtension[];
+}
+
 export interface CycleTLSRequestOptions {
   headers?: {
     [key: string]: any;
@@ -46,10 +77,23 @@ export interface CycleTLSRequestOptions {
   
   // TLS fingerprinting options
   ja3?: string;
-  ja4r?: string;         // JA4 raw format (JA4R) with explicit cipher/extension values. Pass raw JA4 (JA4R) values. The JA4 hash is not accepted for configuration.
+  ja4r?: string;         // JA4 raw format (JA4R) with explicit cipher/extension values. Pass raw JA4 (JA4R) values.
+  ja4?: string;          // Hashed JA4, looked up in the known JA4_r database when ja4r is unset (unknown hashes fail with 400)
+  clientHello?: string;  // Hex-encoded captured ClientHello, replayed byte for byte (takes precedence over ja3/ja4r)
+  tlsSpec?: TLSSpec;     // Full ClientHello description with every extension and its parameters (takes precedence over ja3/ja4r)
   http2Fingerprint?: string;
+  http3Fingerprint?: string; // HTTP/3 SETTINGS and pseudo-header order, e.g. '1:65536;6:262144;7:100;51:1;GREASE|m,a,s,p'
   quicFingerprint?: string;
+  quicFromUserAgent?: boolean; // Without a quicFingerprint, send the user agent's browser QUIC ClientHello over HTTP/3 instead of quic-go's
   disableGrease?: boolean; // Disable GREASE for exact JA4 matching
+  randomizeExtensionOrder?: boolean; // Shuffle ClientHello extensions per connection like Chrome 110+ (ja3/ja4r)
+  extensionOrderSeed?: number;       // Fixed seed for a deterministic extension order, 0 for random
+  extensionData?: { [extensionId: string]: string }; // Hex payloads by extension ID (decimal or 0x-hex)
+  strictExtensions?: boolean;        // Fail on unknown extension IDs instead of sending them empty
+  greaseMode?: 'auto' | 'on' | 'off'; // GREASE for ja3/ja4r/QUIC specs; 'auto' follows the user agent (ja3/QUIC) or the fingerprint (ja4r)
+  ja4h?: string;         // JA4H or raw JA4H_r target: HTTP version, method, cookie/referer flags, Accept-Language and (raw only) header order
+  session?: string;      // Requests in the same session remember the client hints origins ask for with Accept-CH
+  requestMode?: 'document' | 'xhr' | 'fetch' | 'image'; // Add the user agent's default headers (client hints, Accept*, sec-fetch-*) for this kind of request
   
   // Browser identification
   userAgent?: string;
@@ -59,18 +103,69 @@ export interface CycleTLSRequestOptions {
   proxy?: string;
   timeout?: number;
   disableRedirect?: boolean;
+  maxRedirects?: number;          // Redirects to follow before failing, 10 when unset or 0, none when -1
+  sameHostRedirects?: boolean;    // Stop at redirects to another host, resolving with the redirect
+  sameSchemeRedirects?: boolean;  // Stop at redirects to another scheme, resolving with the redirect
   headerOrder?: string[];
+  orderedHeaders?: [string, string][]; // Sent in this order, duplicates kept and grouped per name; casing kept on HTTP/1.1, lowercased on HTTP/2 and HTTP/3
   orderAsProvided?: boolean;
   insecureSkipVerify?: boolean;
+  ipFamily?: 'auto' | 'ipv4' | 'ipv6'; // Address family to dial; "auto" races both (Happy Eyeballs v2)
+  localAddr?: string;      // Local IP address to bind outgoing connections to
+  enableHttpsRR?: boolean; // Consult HTTPS DNS records for ALPN, port, address hints and ECH
+  dnsServer?: string;      // DNS server ("host:port") for lookups, system resolver when unset
   
   // Protocol options
   forceHTTP1?: boolean;
   forceHTTP3?: boolean;
   protocol?: string; // "http1", "http2", "http3", "websocket", "sse"
+  enableAltSvc?: boolean; // Upgrade to HTTP/3 after the origin advertises it via Alt-Svc
+  
+  // Response size limits (0 or unset = unlimited), exceeding them fails the request with status 413
+  maxResponseBytes?: number;
+  maxDecompressedBytes?: number;
+
+  timings?: boolean; // Add a timing breakdown (response.timings) once the body has been read
+  harFile?: string; // Append the request to this HAR file, a path inside CYCLETLS_HAR_DIR
   
 
 }
 
+export interface CycleTLSFingerprints {
+  ja3: string;
+  ja3Hash: string;
+  ja4: string;
+  ja4r: string;
+  ja4h: string;
+  akamai?: string;     // HTTP/2 only
+  akamaiHash?: string;
+  http3?: string;      // HTTP/3 only: SETTINGS|pseudo-header order
+}
+
+// Where a request's time went, in milliseconds. Phases the request skipped,
+// such as dialing on a reused connection, are 0.
+export interface CycleTLSTimings {
+  dns: number;
+  connect: number;       // TCP connect, to the proxy when there is one
+  proxyConnect: number;  // Proxy CONNECT or SOCKS handshake
+  tls: number;           // TLS handshake with retries, or the QUIC handshake on HTTP/3
+  tlsRetries: number;
+  firstByte: number;     // Until the response headers started to arrive
+  transfer: number;      // Reading the body
+  total: number;
+  connectionReused: boolean;
+}
+
+// A response that was redirected from, on the way to the final one
+export interface CycleTLSRedirect {
+  method: string;   // Method of the request that was redirected
+  url: string;      // URL of the request that was redirected
+  status: number;
+  location: string; // Absolute URL the redirect went to
+  headers: { [key: string]: string[] };
+  cookies: Cookie[]; // Cookies the response set
+}
+
 export interface CycleTLSResponse {
   status: number;
   headers: {
@@ -78,6 +173,9 @@ export interface CycleTLSResponse {
   };
   data: any; // Axios-style data property
   finalUrl: string;
+  fingerprints?: CycleTLSFingerprints; // Fingerprints the request was sent with, unset for HTTP/3
+  redirects?: CycleTLSRedirect[]; // Redirects followed on the way to this response, in order
+  timings?: CycleTLSTimings; // With the timings option; set once the body has been read for stream responses
   // Axios/Fetch-like response methods
   json(): Promise<any>;
   text(): Promise<string>;
@@ -264,7 +362,13 @@ class SharedInstance extends EventEmitter {
       }
 
       const spawnOptions: SpawnOptionsWithoutStdio = {
-        env: { WS_PORT: this.port.toString() },
+        env: {
+          WS_PORT: this.port.toString(),
+          // debug: true logs each request's progress from the Go side too
+          CYCLETLS_LOG_LEVEL: process.env.CYCLETLS_LOG_LEVEL || (this.debug ? "debug" : "info"),
+          // harFile paths are only recorded inside this directory
+          ...(process.env.CYCLETLS_HAR_DIR ? { CYCLETLS_HAR_DIR: path.resolve(process.env.CYCLETLS_HAR_DIR) } : {}),
+        },
         shell: process.platform !== "win32", // false for Windows, true for others
         windowsHide: true,
         detached: process.platform !== "win32",
@@ -359,12 +463,24 @@ class SharedInstance extends EventEmitter {
                 headers.push([headerName, headerValues]);
               }
 
+              // Fingerprints follow the headers when the request recorded them,
+              // empty when only redirects follow
+              const fingerprintsJSON = packetBuffer.hasMore() ? packetBuffer.readString() : "";
+              const fingerprints = fingerprintsJSON ? JSON.parse(fingerprintsJSON) : undefined;
+
+              // Then the redirects followed on the way, if any
+              const redirects = packetBuffer.hasMore()
+                ? JSON.parse(packetBuffer.readBytes(false).toString()).map(toRedirect)
+                : undefined;
+
               client.emit(requestID, {
                 method,
                 data: {
                   statusCode,
                   finalUrl,
                   headers: Object.fromEntries(headers),
+                  fingerprints,
+                  redirects,
                 },
               });
             }
@@ -388,6 +504,13 @@ class SharedInstance extends EventEmitter {
               });
             }
 
+            if (method === "timings") {
+              client.emit(requestID, {
+                method,
+                data: JSON.parse(packetBuffer.readString()),
+              });
+            }
+
             if (method === "end") {
               client.emit(requestID, { method });
             }
@@ -483,6 +606,12 @@ class SharedInstance extends EventEmitter {
     }
   }
 
+  async closeHarFile(harFile: string): Promise<void> {
+    if (this.server) {
+      this.server.send(JSON.stringify({ action: "closeHarFile", harFile }));
+    }
+  }
+
   private async cleanExit(message?: string | Error): Promise<void> {
     if (message) console.log(message);
     if (this.isShuttingDown) return;
@@ -604,7 +733,7 @@ class CycleTLSClientImpl extends EventEmitter {
     options ??= {}
 
     // Set default fingerprinting options - prefer JA3 if multiple options are provided
-    if (!options?.ja3 && !options?.ja4r && !options?.http2Fingerprint && !options?.quicFingerprint) {
+    if (!options?.ja3 && !options?.ja4r && !options?.ja4 && !options?.clientHello && !options?.tlsSpec && !options?.http2Fingerprint && !options?.http3Fingerprint && !options?.quicFingerprint) {
       options.ja3 = "771,4865-4867-4866-49195-49199-52393-52392-49196-49200-49162-49161-49171-49172-51-57-47-53-10,0-23-65281-10-11-35-16-5-51-43-13-45-28-21,29-23-24-25-256-257,0";
     }
     
@@ -658,6 +787,8 @@ class CycleTLSClientImpl extends EventEmitter {
 
     return new Promise((resolveRequest, rejectRequest) => {
       let responseMetadata: any = null;
+      let timings: CycleTLSTimings | undefined;
+      let streamResponse: any = null;
 
       const handleMessage = async (response: any) => {
         if (response.method === "error") {
@@ -679,6 +810,9 @@ class CycleTLSClientImpl extends EventEmitter {
         } else if (response.method === "response") {
           // Store response metadata but don't resolve yet
           responseMetadata = response.data;
+        } else if (response.method === "timings") {
+          // Sent before "end", so it can precede an empty body
+          timings = response.data;
         } else if (response.method === "data" || response.method === "end") {
           // Now we have response metadata, set up stream handling
           if (!responseMetadata) return;
@@ -697,6 +831,11 @@ class CycleTLSClientImpl extends EventEmitter {
           const handleData = (response: any) => {
             if (response.method === "data") {
               stream.push(Buffer.from(response.data));
+            } else if (response.method === "timings") {
+              timings = response.data;
+              if (streamResponse) {
+                streamResponse.timings = timings;
+              }
             } else if (response.method === "error") {
               // Handle error that occurred during body read - store it and close the stream
               bodyReadError = {
@@ -746,13 +885,17 @@ class CycleTLSClientImpl extends EventEmitter {
                             // Return response immediately with live stream
               const streamMethods = createStreamResponseMethods(stream);
 
-              resolveRequest({
+              streamResponse = {
                 status: responseMetadata.statusCode,
                 headers: responseMetadata.headers,
                 finalUrl: responseMetadata.finalUrl,
+                fingerprints: responseMetadata.fingerprints,
+                redirects: responseMetadata.redirects,
+                timings,
                 data: stream, // Return live stream directly
                 ...streamMethods
-              });
+              };
+              resolveRequest(streamResponse);
             } else {
               // Get raw buffer first for response methods (existing behavior)
               const rawBuffer = await streamToBuffer(stream);
@@ -793,6 +936,9 @@ class CycleTLSClientImpl extends EventEmitter {
                 status: responseMetadata.statusCode,
                 headers: responseMetadata.headers,
                 finalUrl: responseMetadata.finalUrl,
+                fingerprints: responseMetadata.fingerprints,
+                redirects: responseMetadata.redirects,
+                timings,
                 data: parsedData,
                 ...responseMethods
               });
@@ -866,6 +1012,11 @@ class CycleTLSClientImpl extends EventEmitter {
     return this.sse(url, options);
   }
 
+  // Stop recording into a harFile, so the next request with it starts the file over
+  closeHarFile(harFile: string): Promise<void> {
+    return this.sharedInstance.closeHarFile(harFile);
+  }
+
   async exit(): Promise<undefined> {
     // Remove this client from the shared instance
     this.sharedInstance.removeClient(this.clientId);
@@ -896,6 +1047,30 @@ process.once("SIGINT", globalCleanup);
 process.once("SIGTERM", globalCleanup);
 process.once("beforeExit", globalCleanup);
 
+// Converts a redirect from the response frame, whose cookies are Go's
+// net/http cookies, to a CycleTLSRedirect
+const sameSiteNames = ["", "", "Lax", "Strict", "None"];
+function toRedirect(redirect: any): CycleTLSRedirect {
+  return {
+    method: redirect.method,
+    url: redirect.url,
+    status: redirect.status,
+    location: redirect.location,
+    headers: redirect.headers || {},
+    cookies: (redirect.cookies || []).map((cookie: any): Cookie => ({
+      name: cookie.Name,
+      value: cookie.Value,
+      path: cookie.Path || undefined,
+      domain: cookie.Domain || undefined,
+      expires: cookie.RawExpires || undefined,
+      maxAge: cookie.MaxAge || undefined,
+      secure: cookie.Secure,
+      httpOnly: cookie.HttpOnly,
+      sameSite: sameSiteNames[cookie.SameSite] || undefined,
+    })),
+  };
+}
+
 // Function to convert a stream into a string
 async function streamToString(stream: Readable): Promise<string> {
   const chunks: Buffer[] = [];
@@ -998,6 +1173,10 @@ class PacketBuffer {
     return this._data[this._index++];
   }
 
+  hasMore(): boolean {
+    return this._index < this._data.length;
+  }
+
   readU16(): number {
     return this.readU8() << 8
       | this.readU8();
@@ -1067,6 +1246,7 @@ export interface CycleTLSClient {
   eventSource(url: string, options: CycleTLSRequestOptions): Promise<CycleTLSSSEResponse>;
   
   // Utility methods
+  closeHarFile(harFile: string): Promise<void>;
   exit(): Promise<undefined>;
 }
 
@@ -1150,6 +1330,9 @@ const initCycleTLS = async (
       };
 
       // Utility methods
+      CycleTLS.closeHarFile = (harFile: string): Promise<void> => {
+        return client.closeHarFile(harFile);
+      };
       CycleTLS.exit = async (): Promise<undefined> => {
         return client.exit();
       };
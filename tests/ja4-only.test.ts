@@ -0,0 +1,55 @@
+import initCycleTLS from "../dist/index.js";
+import * as https from "https";
+import * as fs from "fs";
+import * as path from "path";
+
+jest.setTimeout(30000);
+
+// The JA3 the client falls back to when no fingerprint option is set
+const defaultJA3 = "771,4865-4867-4866-49195-49199-52393-52392-49196-49200-49162-49161-49171-49172-51-57-47-53-10,0-23-65281-10-11-35-16-5-51-43-13-45-28-21,29-23-24-25-256-257,0";
+
+describe("Hashed JA4 without other fingerprint options", () => {
+  let server: https.Server;
+  let port: number;
+
+  beforeAll((done) => {
+    server = https.createServer({
+      key: fs.readFileSync(path.join(__dirname, "key.pem"), "utf8"),
+      cert: fs.readFileSync(path.join(__dirname, "cert.pem"), "utf8"),
+    }, (req, res) => {
+      res.writeHead(200, { "Content-Type": "text/plain" });
+      res.end("OK");
+    });
+    server.listen(0, () => {
+      const addressInfo = server.address();
+      if (typeof addressInfo === "object" && addressInfo && typeof addressInfo.port === "number") {
+        port = addressInfo.port;
+      } else {
+        throw new Error("Failed to acquire test server port");
+      }
+      done();
+    });
+  });
+
+  afterAll((done) => {
+    server.close(() => done());
+  });
+
+  test("Should send the JA4 instead of the default JA3", async () => {
+    const cycleTLS = await initCycleTLS();
+    const ja4 = "t13d1516h2_8daaf6152771_02713d6af862"; // Chrome 117-132
+
+    try {
+      const options = { ja4, insecureSkipVerify: true } as any;
+      const resp = await cycleTLS.get(`https://localhost:${port}/`, options);
+      expect(resp.status).toBe(200);
+
+      // The request options were left without a JA3, and the ClientHello is the JA4's
+      expect(options.ja3).toBeUndefined();
+      expect(resp.fingerprints?.ja3).not.toBe(defaultJA3);
+      expect(resp.fingerprints?.ja4).toBe(ja4);
+    } finally {
+      await cycleTLS.exit();
+    }
+  });
+});